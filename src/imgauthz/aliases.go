@@ -0,0 +1,129 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returns the registry alias table of the alias entries, given as <alias>=<registry>, e.g. hub=docker.io,
+// with the aliases of aliases resolved to their final registry. Aliases defined twice with different
+// registries and alias cycles are errors.
+func parseRegistryAliases(entries []string) (map[string]string, error) {
+	defined := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || len(strings.TrimSpace(fields[0])) == 0 || len(strings.TrimSpace(fields[1])) == 0 {
+			return nil, fmt.Errorf("invalid registry alias: %s (expected <alias>=<registry>, e.g. hub=docker.io)", entry)
+		}
+		alias := normalizeRegistryHost(strings.TrimSpace(fields[0]))
+		registry := normalizeRegistryHost(strings.TrimSpace(fields[1]))
+		if isPattern(alias) || isPattern(registry) {
+			return nil, fmt.Errorf("invalid registry alias: %s (patterns are not supported)", entry)
+		}
+		if previous, ok := defined[alias]; ok && previous != registry {
+			return nil, fmt.Errorf("conflicting registry aliases: %s is both %s and %s", alias, previous, registry)
+		}
+		defined[alias] = registry
+	}
+
+	aliases := make(map[string]string, len(defined))
+	for alias := range defined {
+		chain := []string{alias}
+		registry := alias
+		for {
+			next, ok := defined[registry]
+			if !ok || next == registry {
+				break
+			}
+			for _, seen := range chain {
+				if seen == next {
+					return nil, fmt.Errorf("registry alias cycle: %s", strings.Join(append(chain, next), " -> "))
+				}
+			}
+			chain = append(chain, next)
+			registry = next
+		}
+		aliases[alias] = registry
+	}
+	return aliases, nil
+}
+
+// Returns the registry entry with its alias resolved, if it is an exact alias
+func aliasedRegistry(aliases map[string]string, entry string) string {
+	if isPattern(entry) {
+		return entry
+	}
+	if registry, ok := aliases[normalizeRegistryHost(entry)]; ok {
+		return registry
+	}
+	return entry
+}
+
+// Returns the image entry with the alias of its first component resolved, if it is an exact alias,
+// e.g. hub/library/* is docker.io/library/* with the hub=docker.io alias
+func aliasedImage(aliases map[string]string, entry string) string {
+	fields := strings.SplitN(entry, "/", 2)
+	if len(fields) != 2 || isPattern(fields[0]) || strings.HasPrefix(entry, regexPrefix) {
+		return entry
+	}
+	if registry, ok := aliases[normalizeRegistryHost(fields[0])]; ok {
+		return registry + "/" + fields[1]
+	}
+	return entry
+}
+
+// Resolves the registry aliases of the policy rule entries in place, so that they keep their sources,
+// and records the alias table. The aliases are resolved before the legacy entries are migrated, so that
+// an alias is never mistaken for a dockerhub namespace. The image references are not resolved: the
+// docker daemon pulls from the registry host as written, which is matched as such.
+func (config *pluginConfig) resolveRegistryAliases() error {
+	aliases, err := parseRegistryAliases(config.registryAliases)
+	if err != nil {
+		return err
+	}
+	config.aliases = aliases
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	for _, list := range [][]string{config.registries, config.denyRegistries, config.registryAuth} {
+		for i, entry := range list {
+			list[i] = aliasedRegistry(aliases, entry)
+		}
+	}
+	for _, list := range [][]string{config.images, config.denyImages, config.alwaysAllow, config.repositoryPrefixes} {
+		for i, entry := range list {
+			list[i] = aliasedImage(aliases, entry)
+		}
+	}
+	for i, entry := range config.restrictSave {
+		if strings.Contains(entry, "/") {
+			config.restrictSave[i] = aliasedImage(aliases, entry)
+		} else {
+			config.restrictSave[i] = aliasedRegistry(aliases, entry)
+		}
+	}
+	for i, window := range config.registryWindows {
+		fields := strings.SplitN(window, ",", 2)
+		fields[0] = aliasedRegistry(aliases, fields[0])
+		config.registryWindows[i] = strings.Join(fields, ",")
+	}
+	// The mirrors are pulled from as written, and only their upstream registries are policy entries
+	for i, entry := range config.mirrorPrefixes {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		upstream := strings.TrimSpace(fields[1])
+		if strings.Contains(upstream, "/") {
+			fields[1] = aliasedImage(aliases, upstream)
+		} else {
+			fields[1] = aliasedRegistry(aliases, upstream)
+		}
+		config.mirrorPrefixes[i] = strings.Join(fields, "=")
+	}
+	return nil
+}
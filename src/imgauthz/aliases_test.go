@@ -0,0 +1,31 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"testing"
+)
+
+func TestRegistryAliasesApplyToThePolicyEntriesOnly(t *testing.T) {
+	policy := testPolicy(t, Config{
+		RegistryAliases: []string{"internal=registry.corp.net", "registry-lb.corp.net=internal", "hub=docker.io"},
+		Registries:      []string{"internal", "hub", "mirror.corp.net"},
+		Images:          []string{"internal/team/*", "hub/library/alpine", "mirror.corp.net/library/alpine"},
+		MirrorPrefixes:  []string{"mirror.corp.net=hub"}})
+	for image, allowed := range map[string]bool{
+		"registry.corp.net/team/app:1.0": true,
+		"registry.corp.net/other/app":    false,
+		"alpine:3.19":                    true,
+		"mirror.corp.net/library/alpine": true,
+		// The daemon pulls from the alias host as written, which is not authorized
+		"registry-lb.corp.net/team/app:1.0": false,
+		"hub/library/alpine":                false,
+		// A short alias is a dockerhub namespace in the references
+		"internal/team/app": false,
+	} {
+		if response := policy.AuthorizePull(image); response.Allow != allowed {
+			t.Errorf("pull of %s: allowed %v, expected %v (%s)", image, response.Allow, allowed, response.Msg)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+)
+
+// Policy rules, as passed to the plugin with the command line options of the same name.
+// Only the rules which need neither the docker daemon nor the registries are supported,
+// so that the policies can be tested offline.
+type Config struct {
+	// Authorized registries, images (registry/repository) and repository path prefixes
+	Registries         []string
+	Images             []string
+	RepositoryPrefixes []string
+	// Denied registries and images (registry/repository[:tag]), overriding the authorized ones
+	DeniedRegistries []string
+	DeniedImages     []string
+	// Images allowed regardless of any other rule, in addition to the defaults
+	AlwaysAllow []string
+	// Pseudo-images, always allowed, in addition to the defaults (scratch)
+	PseudoImages []string
+	// Clear the default always allowed images and pseudo-images
+	NoDefaultAlwaysAllow  bool
+	NoDefaultPseudoImages bool
+	// Host paths which cannot be bound into containers, and capabilities which cannot be added
+	DeniedHostMounts   []string
+	DeniedCapabilities []string
+	// Time windows during which the registries can be used
+	RegistryWindows []string
+	// Short names of the registries as <alias>=<registry>, e.g. hub=docker.io
+	RegistryAliases []string
+	// Canonicalization of the exact registry and image entries: off (default), merge or reject
+	CanonicalizeEntries string
+	// Registry resolving the image names without a registry host (dockerhub if empty)
+	DefaultRegistry string
+	// Mirrors rewritten to their upstream registry before matching, as <mirror>=<upstream>
+	MirrorPrefixes []string
+	// Deny the image references which are not requested through a mirror
+	RequireMirror bool
+	// Registry entries without a port match their host on any port
+	AnyRegistryPort bool
+	// Deny the image references without an explicit tag or digest
+	RequireExplicitTag bool
+	// Deny the registry commands of clients without an authentication method
+	RequireAuth bool
+	// Restriction of docker commit: off (default), deny or allowlist
+	RestrictCommit string
+	// OSes of the platforms which can be requested, e.g. linux (any if empty)
+	AllowedOS []string
+	// Deny the pulls of all the tags of a repository (i.e. docker pull --all-tags)
+	DenyAllTags bool
+	// Authorize the registry queries (i.e. /distribution/{name}/json) as the pulls
+	RestrictDistribution bool
+	// Deny the runs of the images which were not pulled with the policy, in memory
+	RequirePriorPull bool
+	// Allow rules written as CEL expressions over the registry command, e.g. registry == "docker.io" && tag != "latest"
+	CELRules []string
+	// JSON or YAML policy files, merged in order as with --config and --config-dir
+	PolicyFiles []string
+}
+
+// Returns the plugin configuration of the policy rules, with the policy files merged
+func (config Config) pluginConfig() (pluginConfig, error) {
+	plugin := pluginConfig{
+		pseudoImages:       append([]string{}, config.PseudoImages...),
+		defaultRegistry:    normalizeRegistryHost(config.DefaultRegistry),
+		mirrorPrefixes:     append([]string{}, config.MirrorPrefixes...),
+		requireMirror:      config.RequireMirror,
+		anyRegistryPort:    config.AnyRegistryPort,
+		requireExplicitTag: config.RequireExplicitTag,
+		requireAuth:        config.RequireAuth,
+		restrictCommit:     config.RestrictCommit,
+		canonicalEntries:   config.CanonicalizeEntries,
+		allowedOS:          normalizeEntries(config.AllowedOS, platformOS),
+		denyAllTags:        config.DenyAllTags,
+		restrictDist:       config.RestrictDistribution,
+		requirePriorPull:   config.RequirePriorPull,
+		celRules:           append([]string{}, config.CELRules...)}
+	if !config.NoDefaultPseudoImages {
+		plugin.pseudoImages = append(append([]string{}, defaultPseudoImages...), plugin.pseudoImages...)
+	}
+
+	// Policy rule sources, in order of increasing precedence, as for the plugin
+	var sources []ruleSource
+	if !config.NoDefaultAlwaysAllow {
+		sources = append(sources, ruleSource{name: "defaults", rules: &configFile{AlwaysAllow: defaultAlwaysAllow}})
+	}
+	sources = append(sources, ruleSource{name: "config", rules: &configFile{
+		Registries:         config.Registries,
+		Images:             config.Images,
+		RepositoryPrefixes: config.RepositoryPrefixes,
+		DeniedRegistries:   config.DeniedRegistries,
+		DeniedImages:       config.DeniedImages,
+		RegistryWindows:    config.RegistryWindows,
+		AlwaysAllow:        config.AlwaysAllow,
+		DeniedHostMounts:   config.DeniedHostMounts,
+		DeniedCapabilities: config.DeniedCapabilities,
+		RegistryAliases:    config.RegistryAliases}})
+	for _, path := range config.PolicyFiles {
+		file, err := readConfigFile(path, nil)
+		if err != nil {
+			return pluginConfig{}, err
+		}
+		sources = append(sources, ruleSource{name: path, rules: file})
+	}
+	if err := mergeRuleSources(&plugin, sources); err != nil {
+		return pluginConfig{}, err
+	}
+	return plugin, nil
+}
+
+// Policy of the image authorization plugin, deciding on the docker client commands as the plugin does.
+// Decisions are logged as by the plugin, to the standard logger.
+type Policy struct {
+	plugin *ImgAuthZPlugin
+}
+
+// Create a new policy from the policy rules
+func NewPolicy(config Config) (*Policy, error) {
+	pluginConfig, err := config.pluginConfig()
+	if err != nil {
+		return nil, err
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, pluginConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{plugin: plugin}, nil
+}
+
+// Authorizes a docker pull of the image, e.g. alpine:3.19 or my.docker.registry/team/app@sha256:<digest>.
+// Denied responses hold the denial message returned to the docker client.
+func (policy *Policy) AuthorizePull(image string) authorization.Response {
+	return policy.Authorize(pullRequest(image))
+}
+
+// Returns the request of a docker pull of the image, as sent by the docker daemon
+func pullRequest(image string) authorization.Request {
+	return authorization.Request{
+		RequestMethod: "POST",
+		RequestURI:    "/images/create?fromImage=" + url.QueryEscape(image)}
+}
+
+// Authorizes a docker run of the image, creating a container without any host mount or added capability.
+// Denied responses hold the denial message returned to the docker client.
+func (policy *Policy) AuthorizeRun(image string) authorization.Response {
+	body, _ := json.Marshal(map[string]string{"Image": image})
+	return policy.Authorize(authorization.Request{
+		RequestMethod: "POST",
+		RequestURI:    "/containers/create",
+		RequestBody:   body})
+}
+
+// Authorizes a docker client request, as sent by the docker daemon to the plugin
+func (policy *Policy) Authorize(req authorization.Request) authorization.Response {
+	return policy.plugin.AuthZReq(req)
+}
+
+// Image reference, normalized as by the plugin before matching
+type Reference struct {
+	Registry   string
+	Repository string
+	// Tag and digest, if any
+	Tag    string
+	Digest string
+}
+
+// Parses an image reference of the form [registry/]repository[:tag][@digest], resolving the image names
+// without a registry host against the default registry (dockerhub if empty), e.g. alpine is
+// docker.io/library/alpine.
+func ParseReference(image string, defaultRegistry string) Reference {
+	ref := parseImageReference(image, normalizeRegistryHost(defaultRegistry))
+	return Reference{Registry: ref.registry, Repository: ref.repository, Tag: ref.tag, Digest: ref.digest}
+}
+
+// Returns the reference as registry/repository[:tag][@digest], defaulting to the latest tag
+func (ref Reference) String() string {
+	return imageReference{registry: ref.Registry, repository: ref.Repository, tag: ref.Tag, digest: ref.Digest}.String()
+}
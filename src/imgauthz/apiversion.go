@@ -0,0 +1,75 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Matches the API version prefix of a request path, e.g. /v1.24/images/create
+var apiVersionPath = regexp.MustCompile(`^/v(\d+)\.(\d+)/`)
+
+// Matches an API version, e.g. 1.24
+var apiVersionValue = regexp.MustCompile(`^(\d+)\.(\d+)$`)
+
+// Docker API version, as major.minor
+type apiVersion struct {
+	major int
+	minor int
+}
+
+// Parses an API version, e.g. 1.24
+func parseAPIVersion(version string) (apiVersion, error) {
+	match := apiVersionValue.FindStringSubmatch(version)
+	if match == nil {
+		return apiVersion{}, fmt.Errorf("invalid API version: %s (expected major.minor, e.g. 1.24)", version)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return apiVersion{major: major, minor: minor}, nil
+}
+
+// Returns the API version of the request, and false if the request path is not versioned.
+// Requests without a version get the current API version of the docker daemon.
+func requestAPIVersion(reqURL *url.URL) (apiVersion, bool) {
+	if reqURL == nil {
+		return apiVersion{}, false
+	}
+	match := apiVersionPath.FindStringSubmatch(reqURL.Path)
+	if match == nil {
+		return apiVersion{}, false
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return apiVersion{major: major, minor: minor}, true
+}
+
+// Returns true if the version is older than the other one
+func (version apiVersion) olderThan(other apiVersion) bool {
+	return version.major < other.major || (version.major == other.major && version.minor < other.minor)
+}
+
+// Returns the version as major.minor
+func (version apiVersion) String() string {
+	return strconv.Itoa(version.major) + "." + strconv.Itoa(version.minor)
+}
+
+// Authorizes the API version of a registry command: requests of clients using an API version older
+// than the minimum are denied, since they may lack the fields the policy depends on.
+func (plugin *ImgAuthZPlugin) authorizeAPIVersion(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.minAPIVersion == nil {
+		return authorization.Response{Allow: true}
+	}
+
+	version, versioned := requestAPIVersion(reqURL)
+	if versioned && version.olderThan(*plugin.minAPIVersion) {
+		request.logln("[DENIED] API version:", version, request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The Docker API version " + version.String() + " is older than the minimum API version " + plugin.minAPIVersion.String() + ", please upgrade the docker client")}
+	}
+	return authorization.Response{Allow: true}
+}
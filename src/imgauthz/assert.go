@@ -0,0 +1,90 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Expected decision on the pull of an image, as asserted in an assertion file
+type assertion struct {
+	// Line of the assertion in the assertion file
+	line  int
+	image string
+	allow bool
+}
+
+// Reads the assertions of an assertion file, one reference,expected line per assertion, e.g.
+// alpine:3.19,allow or my.docker.registry/app:1.0,deny. Empty lines and lines starting with # are ignored.
+func readAssertions(path string) ([]assertion, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var assertions []assertion
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		index := strings.LastIndex(text, ",")
+		if index < 0 {
+			return nil, fmt.Errorf("invalid assertion file %s, line %d: expected reference,expected", path, line)
+		}
+		image := strings.TrimSpace(text[:index])
+		expected := strings.ToLower(strings.TrimSpace(text[index+1:]))
+		if len(image) == 0 {
+			return nil, fmt.Errorf("invalid assertion file %s, line %d: missing reference", path, line)
+		}
+		switch expected {
+		case "allow", "allowed":
+			assertions = append(assertions, assertion{line: line, image: image, allow: true})
+		case "deny", "denied":
+			assertions = append(assertions, assertion{line: line, image: image, allow: false})
+		default:
+			return nil, fmt.Errorf("invalid assertion file %s, line %d: invalid expected decision %s (expected allow or deny)", path, line, expected)
+		}
+	}
+	return assertions, scanner.Err()
+}
+
+// Returns the decision as asserted
+func decisionName(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Decides on the pull of the image of every assertion of the assertion file, as the plugin does,
+// and writes the mismatches to out. Returns the number of mismatches.
+func checkAssertions(plugin *ImgAuthZPlugin, path string, out io.Writer) (int, error) {
+	assertions, err := readAssertions(path)
+	if err != nil {
+		return 0, err
+	}
+
+	mismatches := 0
+	for _, assertion := range assertions {
+		response := plugin.AuthZReq(pullRequest(assertion.image))
+		if response.Allow == assertion.allow {
+			continue
+		}
+		mismatches++
+		fmt.Fprintf(out, "FAIL line %d: %s: expected %s, got %s", assertion.line, assertion.image, decisionName(assertion.allow), decisionName(response.Allow))
+		if len(response.Msg) > 0 {
+			fmt.Fprintf(out, " (%s)", response.Msg)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "%d of %d assertions passed\n", len(assertions)-mismatches, len(assertions))
+	return mismatches, nil
+}
@@ -0,0 +1,112 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Interval at which the buffered audit records are flushed to the audit log file
+const auditFlushInterval = time.Second
+
+// Audit log of the registry command decisions, appended to a file as one JSON record per line.
+// The records are buffered, flushed periodically and on close, so that a clean shutdown
+// does not drop any record.
+type auditLog struct {
+	sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	closed  bool
+	done    chan struct{}
+}
+
+// Create a new audit log appending to the given file, created if needed
+func newAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	audit := &auditLog{file: file, writer: writer, encoder: json.NewEncoder(writer), done: make(chan struct{})}
+	go audit.flushPeriodically()
+	return audit, nil
+}
+
+// Appends the decision of a registry command to the audit log.
+// Decisions recorded after the audit log is closed are logged and dropped.
+func (audit *auditLog) record(decision decisionRecord) {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.closed {
+		log.Println("[AUDIT] Audit log closed, dropping the decision:", decision.ID)
+		return
+	}
+	if err := audit.encoder.Encode(decision); err != nil {
+		log.Println("[AUDIT] Cannot write the decision:", decision.ID, err)
+	}
+}
+
+// Returns the name of the audit log, as a notifier
+func (audit *auditLog) Name() string {
+	return "audit"
+}
+
+// Appends the decision event to the audit log, as a notifier
+func (audit *auditLog) Notify(event DecisionEvent) error {
+	audit.record(decisionRecord(event))
+	return nil
+}
+
+// Flushes the buffered records every auditFlushInterval, until the audit log is closed
+func (audit *auditLog) flushPeriodically() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			audit.flush()
+		case <-audit.done:
+			return
+		}
+	}
+}
+
+// Flushes the buffered records to the audit log file
+func (audit *auditLog) flush() {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.closed {
+		return
+	}
+	if err := audit.writer.Flush(); err != nil {
+		log.Println("[AUDIT] Cannot flush the audit log:", err)
+	}
+}
+
+// Flushes the buffered records, syncs and closes the audit log file
+func (audit *auditLog) close() error {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.closed {
+		return nil
+	}
+	audit.closed = true
+	close(audit.done)
+
+	err := audit.writer.Flush()
+	if syncErr := audit.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := audit.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Maximum size of a logged request body, after redaction
+	maxLoggedBodySize = 4096
+	// Replaces the redacted values
+	redacted = "<redacted>"
+)
+
+// Keys whose values are redacted from the logged request bodies (lower case substrings)
+var sensitiveKeys = []string{"auth", "password", "secret", "token", "credential", "key"}
+
+// Returns true if the values of the key are redacted
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacts the sensitive values of a decoded JSON value: the values of the sensitive keys and
+// the values of the environment variables (i.e. NAME=value entries of Env)
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			switch {
+			case isSensitiveKey(key):
+				value[key] = redacted
+			case key == "Env":
+				value[key] = redactEnv(nested)
+			default:
+				value[key] = redactValue(nested)
+			}
+		}
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = redactValue(nested)
+		}
+	}
+	return value
+}
+
+// Redacts the values of a list of NAME=value environment variables, keeping their names
+func redactEnv(value interface{}) interface{} {
+	env, ok := value.([]interface{})
+	if !ok {
+		return redacted
+	}
+	for i, entry := range env {
+		variable, ok := entry.(string)
+		if !ok {
+			env[i] = redacted
+			continue
+		}
+		env[i] = strings.SplitN(variable, "=", 2)[0] + "=" + redacted
+	}
+	return env
+}
+
+// Returns the request body as logged: JSON bodies are redacted and capped to maxLoggedBodySize,
+// other bodies are never logged, only their size.
+func loggedBody(body []byte) string {
+	if len(body) == 0 {
+		return "<empty>"
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("<non-JSON body of %d bytes>", len(body))
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redactValue(value)); err != nil {
+		return fmt.Sprintf("<body of %d bytes>", len(body))
+	}
+	logged := bytes.TrimSpace(buffer.Bytes())
+	if len(logged) > maxLoggedBodySize {
+		return fmt.Sprintf("%s... (%d bytes truncated)", logged[:maxLoggedBodySize], len(logged)-maxLoggedBodySize)
+	}
+	return string(logged)
+}
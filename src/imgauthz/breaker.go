@@ -0,0 +1,138 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"errors"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Returned by the registry client for the registries whose circuit breaker is open
+var errCircuitOpen = errors.New("registry unavailable, circuit breaker open")
+
+// Circuit breaker state of a registry host
+type breakerState struct {
+	// Number of consecutive failures
+	failures int
+	// Time until which the circuit is open, zero if closed
+	openUntil time.Time
+	// A trial request is in flight, once the circuit has been open for the cooldown
+	probing bool
+}
+
+// Circuit breakers of the registries contacted by the plugin. After a number of consecutive failures to
+// contact a registry (i.e. network errors or server errors), its circuit opens: the requests to the
+// registry fail right away, rather than after a timeout, and the checks contacting it are degraded to
+// name-based matching, if configured. After the cooldown, a single trial request is let through, which
+// closes the circuit if it succeeds, or opens it again otherwise.
+type registryBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	// Skip the checks contacting the registries whose circuit is open, rather than failing them
+	degrade bool
+	hosts   map[string]*breakerState
+	now     func() time.Time
+}
+
+// Create new registry circuit breakers, opening after the number of consecutive failures for the cooldown
+func newRegistryBreaker(threshold int, cooldown time.Duration, degrade bool) *registryBreaker {
+	return &registryBreaker{threshold: threshold, cooldown: cooldown, degrade: degrade,
+		hosts: make(map[string]*breakerState), now: time.Now}
+}
+
+// Returns the state of the registry host, created if needed
+func (breaker *registryBreaker) state(host string) *breakerState {
+	state, ok := breaker.hosts[host]
+	if !ok {
+		state = &breakerState{}
+		breaker.hosts[host] = state
+	}
+	return state
+}
+
+// Returns true if a request can be sent to the registry host: its circuit is closed, or it is the trial
+// request once the circuit has been open for the cooldown
+func (breaker *registryBreaker) allow(host string) bool {
+	if breaker == nil {
+		return true
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+	state := breaker.state(host)
+	if state.openUntil.IsZero() {
+		return true
+	}
+	if state.probing || breaker.now().Before(state.openUntil) {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+// Records the outcome of a request to the registry host. Only the network errors and the server errors
+// are failures: the other responses, e.g. unauthorized or not found, show that the registry is available.
+func (breaker *registryBreaker) record(host string, resp *http.Response, err error) {
+	if breaker == nil {
+		return
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+	state := breaker.state(host)
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		if !state.openUntil.IsZero() {
+			log.Println("[BREAKER] Registry available again, circuit closed:", host)
+		}
+		*state = breakerState{}
+		return
+	}
+
+	state.failures++
+	state.probing = false
+	if state.failures >= breaker.threshold {
+		if state.openUntil.IsZero() {
+			log.Println("[BREAKER] !!! Registry unavailable, circuit open:", host, "after", state.failures, "consecutive failures !!!")
+		}
+		state.openUntil = breaker.now().Add(breaker.cooldown)
+	}
+}
+
+// Returns true if the circuit of the registry host is open
+func (breaker *registryBreaker) isOpen(host string) bool {
+	if breaker == nil {
+		return false
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+	state, ok := breaker.hosts[host]
+	return ok && !state.openUntil.IsZero()
+}
+
+// Returns true if a check of the request failed to contact its registry, whose circuit is open, and the
+// checks are degraded to name-based matching: the request is allowed by its registry and image rules only.
+// Every degraded check is logged.
+func (plugin *ImgAuthZPlugin) degradedCheck(request registryRequest, reqURL *url.URL, check string, err error) bool {
+	if plugin.breaker == nil || !plugin.breaker.degrade {
+		return false
+	}
+	host, _ := request.image.registryHost()
+	if !plugin.breaker.isOpen(host) {
+		return false
+	}
+	request.logln("[BREAKER] [ALLOWED] !!! Registry unavailable, "+check+" skipped !!!", request.image.String(), reqURL.String(), err)
+	return true
+}
+
+// Returns an allowed response if a check of the request is degraded, see degradedCheck
+func (plugin *ImgAuthZPlugin) degradedResponse(request registryRequest, reqURL *url.URL, check string, err error) (authorization.Response, bool) {
+	if plugin.degradedCheck(request, reqURL, check, err) {
+		return authorization.Response{Allow: true}, true
+	}
+	return authorization.Response{}, false
+}
@@ -0,0 +1,47 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto/subtle"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// HTTP header carrying the break-glass token on docker pull, the only place it is read from.
+// It can be set with the HttpHeaders option of the docker client config.json.
+const breakGlassHeader = "X-Img-Authz-Breakglass"
+
+// Returns the break-glass token presented in the request headers, if any
+func breakGlassToken(req authorization.Request) string {
+	for name, value := range req.RequestHeaders {
+		if strings.EqualFold(name, breakGlassHeader) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Returns true if the request is a pull presenting the configured break-glass token. The other
+// registry commands and the container labels are not trusted for the override.
+// Every break-glass attempt is logged, whether the token is valid or not.
+func (plugin *ImgAuthZPlugin) isBreakGlass(req authorization.Request, reqURL *url.URL, request registryRequest) bool {
+	if len(plugin.breakGlassToken) == 0 || request.command != pullCommand {
+		return false
+	}
+
+	token := breakGlassToken(req)
+	if len(token) == 0 {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(plugin.breakGlassToken)) != 1 {
+		request.logln("[BREAKGLASS] [DENIED] Invalid break-glass token: User:", req.User, "Image:", request.image.name(), req.RequestMethod, reqURL.String())
+		return false
+	}
+
+	request.logln("[BREAKGLASS] [ALLOWED] !!! Break-glass override of a denied request !!! User:", req.User, "Image:", request.image.name(), req.RequestMethod, reqURL.String())
+	return true
+}
@@ -0,0 +1,96 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"strings"
+	"testing"
+)
+
+func TestBreakGlassTokenIsOnlyReadFromThePullHeader(t *testing.T) {
+	config := pluginConfig{registries: []string{"my.docker.registry"}, breakGlassToken: "s3cr3t"}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{plugin: plugin}
+
+	withHeader := func(req authorization.Request, token string) authorization.Request {
+		req.RequestHeaders = map[string]string{"x-img-authz-breakglass": token}
+		return req
+	}
+	if response := policy.Authorize(withHeader(pullRequest("alpine:3.19"), "s3cr3t")); !response.Allow {
+		t.Errorf("pull with a valid token: %s", response.Msg)
+	}
+	if response := policy.Authorize(withHeader(pullRequest("alpine:3.19"), "wrong")); response.Allow {
+		t.Error("pull with an invalid token allowed")
+	}
+	if response := policy.AuthorizePull("alpine:3.19"); response.Allow {
+		t.Error("pull without a token allowed")
+	}
+
+	// The container labels are set by whoever runs the container, and runs are never overridden
+	labeled := authorization.Request{
+		RequestMethod: "POST",
+		RequestURI:    "/containers/create",
+		RequestBody:   []byte(`{"Image":"alpine:3.19","Labels":{"img-authz.breakglass":"s3cr3t"}}`)}
+	if response := policy.Authorize(labeled); response.Allow {
+		t.Error("run with a token label allowed")
+	}
+	if response := policy.Authorize(withHeader(labeled, "s3cr3t")); response.Allow {
+		t.Error("run with a valid token allowed")
+	}
+}
+
+// Notifier keeping the decision events
+type recordingNotifier struct {
+	events []DecisionEvent
+}
+
+// Returns the name of the notifier
+func (notifier *recordingNotifier) Name() string {
+	return "recording"
+}
+
+// Keeps the decision event
+func (notifier *recordingNotifier) Notify(event DecisionEvent) error {
+	notifier.events = append(notifier.events, event)
+	return nil
+}
+
+func TestBreakGlassOverridesAreMarkedInTheDecisions(t *testing.T) {
+	config := pluginConfig{registries: []string{"my.docker.registry"}, breakGlassToken: "s3cr3t"}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordingNotifier{}
+	status := newPluginStatus(0)
+	status.notifiers = []Notifier{notifier}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), status, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{plugin: plugin}
+
+	req := pullRequest("alpine:3.19")
+	req.RequestHeaders = map[string]string{"x-img-authz-breakglass": "s3cr3t"}
+	if response := policy.Authorize(req); !response.Allow {
+		t.Fatalf("pull with a valid token: %s", response.Msg)
+	}
+	policy.AuthorizePull("my.docker.registry/app:1.0")
+	if len(notifier.events) != 2 {
+		t.Fatalf("%d decisions notified, expected 2", len(notifier.events))
+	}
+	override := notifier.events[0]
+	if !override.Allowed || !strings.HasPrefix(override.Reason, "Break-glass override of: docker pull denied:") {
+		t.Errorf("override notified as allowed %v, reason %q", override.Allowed, override.Reason)
+	}
+	if allowed := notifier.events[1]; !allowed.Allowed || strings.Contains(allowed.Reason, "Break-glass") {
+		t.Errorf("authorized pull notified as allowed %v, reason %q", allowed.Allowed, allowed.Reason)
+	}
+}
@@ -0,0 +1,98 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Matches the bare digests of the cache manifest, e.g. sha256:<64 hex digits>
+var bareDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Curated set of images which are the only ones allowed, as listed in the cache manifest
+type cacheManifest struct {
+	// Full references, as registry/repository:tag
+	references map[string]bool
+	// Digests of a given image, as registry/repository@digest
+	digests map[string]bool
+	// Digests of any image
+	anyDigests map[string]bool
+}
+
+// Reads the cache manifest: one image reference per line, as [registry/]repository[:tag][@digest]
+// or as a bare digest. Blank lines and lines starting with # are ignored.
+func readCacheManifest(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read cache manifest %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// Create a new cache manifest from its entries, resolved against the default registry
+// as the requested references
+func newCacheManifest(entries []string, defaultRegistry string) (*cacheManifest, error) {
+	manifest := &cacheManifest{references: make(map[string]bool), digests: make(map[string]bool), anyDigests: make(map[string]bool)}
+	for _, entry := range entries {
+		if bareDigest.MatchString(entry) {
+			manifest.anyDigests[entry] = true
+			continue
+		}
+
+		ref := parseImageReference(entry, defaultRegistry)
+		if len(ref.repository) == 0 {
+			return nil, fmt.Errorf("invalid cache manifest entry: %s", entry)
+		}
+		// Entries with both a tag and a digest allow either
+		if len(ref.digest) > 0 {
+			manifest.digests[ref.name()+"@"+ref.digest] = true
+		}
+		if len(ref.digest) == 0 {
+			manifest.references[ref.String()] = true
+		} else if len(ref.tag) > 0 {
+			manifest.references[ref.name()+":"+ref.tag] = true
+		}
+	}
+	return manifest, nil
+}
+
+// Returns true if the image is listed in the cache manifest.
+// References with a digest are matched by digest, the other ones by tag.
+func (manifest *cacheManifest) contains(ref imageReference) bool {
+	if len(ref.digest) > 0 {
+		return manifest.anyDigests[ref.digest] || manifest.digests[ref.name()+"@"+ref.digest]
+	}
+	return manifest.references[ref.String()]
+}
+
+// Authorizes a registry command against the cache manifest only, bypassing the registry and image rules
+func (plugin *ImgAuthZPlugin) authorizeCachedImage(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.approvedImages.contains(request.image) {
+		request.logln("[ALLOWED] Cache manifest:", request.image.String(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: true}
+	}
+
+	request.logln("[DENIED] Not in the cache manifest:", request.image.String(), req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg("The image " + request.image.String() + " is not in the cache manifest")}
+}
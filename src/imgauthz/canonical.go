@@ -0,0 +1,101 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Canonicalization of the policy entries: off, canonicalized with the ambiguous entries merged,
+// or canonicalized with the ambiguous entries rejected
+const (
+	canonicalOff    = "off"
+	canonicalMerge  = "merge"
+	canonicalReject = "reject"
+)
+
+// Returns the canonical form of an exact image entry, as the image references are parsed: the registry
+// resolved and normalized, and the official images in the official namespace, e.g. ubuntu and
+// docker.io/ubuntu are docker.io/library/ubuntu. The tag and digest, if any, are kept.
+func canonicalImageEntry(entry string, defaultRegistry string) string {
+	ref := parseImageReference(entry, defaultRegistry)
+	canonical := ref.name()
+	if len(ref.tag) > 0 {
+		canonical += ":" + ref.tag
+	}
+	if len(ref.digest) > 0 {
+		canonical += "@" + ref.digest
+	}
+	return canonical
+}
+
+// Resolves the exact image entries listing an official image by its bare name, i.e. without a registry
+// host nor a namespace, e.g. ubuntu or ubuntu:22.04, as the image references are parsed: to
+// docker.io/library/ubuntu, or to library/ubuntu on the default registry if set, so that they match
+// the references to the image whichever way it is written.
+func (config *pluginConfig) resolveBareImages() {
+	for _, list := range [][]string{config.images, config.denyImages, config.alwaysAllow} {
+		for i, entry := range list {
+			if !isPattern(entry) && !strings.Contains(entry, "/") {
+				list[i] = canonicalImageEntry(entry, config.defaultRegistry)
+			}
+		}
+	}
+}
+
+// Canonicalizes the exact registry and image entries of the policy rule lists in place, so that they keep
+// their sources, if enabled. Glob patterns and regular expressions are left unchanged. Entries written
+// differently but canonicalizing to the same entry of a list, e.g. --image ubuntu and
+// --image docker.io/library/ubuntu, are ambiguous: they are merged and logged, or rejected.
+// The bare images are canonicalized as written, so that they are part of the ambiguity check. If the
+// canonicalization is off, they are resolved only (see resolveBareImages).
+func (config *pluginConfig) canonicalizeEntries() error {
+	if canonicalization(config.canonicalEntries) == canonicalOff {
+		config.resolveBareImages()
+		return nil
+	}
+
+	canonicalImage := func(entry string) string {
+		return canonicalImageEntry(entry, config.defaultRegistry)
+	}
+	lists := []struct {
+		option    string
+		entries   []string
+		canonical func(string) string
+	}{
+		{"--registry", config.registries, normalizeRegistryHost},
+		{"--deny-registry", config.denyRegistries, normalizeRegistryHost},
+		{"--image", config.images, canonicalImage},
+		{"--deny-image", config.denyImages, canonicalImage},
+		{"--always-allow", config.alwaysAllow, canonicalImage},
+	}
+
+	var ambiguous []string
+	for _, list := range lists {
+		// First entry written for each canonical entry
+		written := make(map[string]string)
+		for i, entry := range list.entries {
+			if isPattern(entry) {
+				continue
+			}
+			canonical := list.canonical(entry)
+			list.entries[i] = canonical
+			if first, ok := written[canonical]; !ok {
+				written[canonical] = entry
+			} else if first != entry {
+				ambiguous = append(ambiguous, fmt.Sprintf("%s %s and %s are both %s", list.option, first, entry, canonical))
+			}
+		}
+	}
+
+	if config.canonicalEntries == canonicalReject && len(ambiguous) > 0 {
+		return fmt.Errorf("ambiguous policy entries: %s", strings.Join(ambiguous, "; "))
+	}
+	for _, entries := range ambiguous {
+		log.Println("[CANONICAL]", entries+": merged")
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBareImageEntriesAreAmbiguous(t *testing.T) {
+	for _, images := range [][]string{
+		{"ubuntu", "docker.io/library/ubuntu"},
+		{"docker.io/library/ubuntu", "ubuntu"},
+		{"ubuntu:22.04", "docker.io/ubuntu:22.04"},
+		{"ubuntu", "library/ubuntu"},
+	} {
+		_, err := NewPolicy(Config{Registries: []string{"docker.io"}, Images: images, CanonicalizeEntries: canonicalReject})
+		if err == nil || !strings.Contains(err.Error(), "ambiguous policy entries") {
+			t.Errorf("%v rejected with: %v", images, err)
+		}
+	}
+
+	// The bare images are resolved whether they are canonicalized or not
+	for _, canonicalization := range []string{canonicalOff, canonicalMerge} {
+		policy := testPolicy(t, Config{Registries: []string{"docker.io"}, Images: []string{"ubuntu", "docker.io/library/ubuntu"}, CanonicalizeEntries: canonicalization})
+		if response := policy.AuthorizePull("docker.io/library/ubuntu:22.04"); !response.Allow {
+			t.Errorf("pull with %s canonicalization: %s", canonicalization, response.Msg)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"strings"
+)
+
+// Capability added by --cap-add ALL, which adds every capability
+const allCapabilities = "ALL"
+
+// Returns the capability name as accepted by the docker client, in upper case without
+// the CAP_ prefix, e.g. cap_net_admin is NET_ADMIN
+func normalizeCapability(capability string) string {
+	capability = strings.ToUpper(strings.TrimSpace(capability))
+	return strings.TrimPrefix(capability, "CAP_")
+}
+
+// Returns the normalized capabilities added to the container (i.e. HostConfig.CapAdd).
+// A privileged container (i.e. docker run --privileged) gets all the capabilities, as with --cap-add ALL.
+// Dropped capabilities (i.e. HostConfig.CapDrop) only restrict the container and are not returned.
+func (body containerCreateBody) addedCapabilities() []string {
+	var capabilities []string
+	for _, capability := range body.HostConfig.CapAdd {
+		capabilities = append(capabilities, normalizeCapability(capability))
+	}
+	if body.HostConfig.Privileged {
+		capabilities = append(capabilities, allCapabilities)
+	}
+	return capabilities
+}
+
+// Returns the set of denied capabilities, normalized
+func capabilitySet(entries []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, entry := range entries {
+		set[normalizeCapability(entry)] = true
+	}
+	return set
+}
+
+// Returns the first capability added by the request which is denied, if any.
+// Adding all the capabilities adds the denied ones too. A denied capability is denied
+// even if it is also dropped, as the docker daemon versions differ on which one wins.
+func (plugin *ImgAuthZPlugin) deniedCapability(request registryRequest) (string, bool) {
+	if len(plugin.deniedCapabilities) == 0 {
+		return "", false
+	}
+	for _, capability := range request.capAdd {
+		if capability == allCapabilities || plugin.deniedCapabilities[capability] {
+			return capability, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,33 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"testing"
+)
+
+func TestDeniedCapabilitiesDenyPrivilegedContainers(t *testing.T) {
+	policy := testPolicy(t, Config{Registries: []string{"docker.io"}, DeniedCapabilities: []string{"SYS_ADMIN"}})
+	for body, allowed := range map[string]bool{
+		`{"Image":"alpine:3.19"}`: true,
+		`{"Image":"alpine:3.19","HostConfig":{"CapAdd":["NET_ADMIN"]}}`:              true,
+		`{"Image":"alpine:3.19","HostConfig":{"Privileged":false}}`:                  true,
+		`{"Image":"alpine:3.19","HostConfig":{"CapAdd":["cap_sys_admin"]}}`:          false,
+		`{"Image":"alpine:3.19","HostConfig":{"Privileged":true}}`:                   false,
+		`{"Image":"alpine:3.19","HostConfig":{"Privileged":true,"CapDrop":["ALL"]}}`: false,
+	} {
+		response := policy.Authorize(authorization.Request{RequestMethod: "POST", RequestURI: "/containers/create", RequestBody: []byte(body)})
+		if response.Allow != allowed {
+			t.Errorf("create %s: allowed %v, expected %v (%s)", body, response.Allow, allowed, response.Msg)
+		}
+	}
+
+	// Privileged containers are not restricted without denied capabilities
+	policy = testPolicy(t, Config{Registries: []string{"docker.io"}})
+	response := policy.Authorize(authorization.Request{RequestMethod: "POST", RequestURI: "/containers/create", RequestBody: []byte(`{"Image":"alpine:3.19","HostConfig":{"Privileged":true}}`)})
+	if !response.Allow {
+		t.Errorf("privileged create without denied capabilities: %s", response.Msg)
+	}
+}
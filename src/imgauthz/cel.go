@@ -0,0 +1,115 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"github.com/google/cel-go/cel"
+)
+
+// Maximum cost of the evaluation of a CEL rule, so that no rule can hold a request for long
+const celCostLimit = 100000
+
+// Allow rule written as a CEL expression over the registry command, e.g.
+// registry == "docker.io" && repository.startsWith("library/") && tag != "latest"
+type celRule struct {
+	expression string
+	program    cel.Program
+}
+
+// Returns the CEL environment of the rules: the variables describing the registry command, all strings
+// (empty if unknown), as for the matchers (see MatchRequest)
+func celEnvironment() (*cel.Env, error) {
+	return cel.NewEnv(
+		// Type of the command: pull, run, commit, distribution or save
+		cel.Variable("command", cel.StringType),
+		// Normalized image reference, e.g. alpine is registry docker.io, repository library/alpine,
+		// image docker.io/library/alpine and tag latest
+		cel.Variable("registry", cel.StringType),
+		cel.Variable("repository", cel.StringType),
+		cel.Variable("image", cel.StringType),
+		cel.Variable("tag", cel.StringType),
+		cel.Variable("digest", cel.StringType),
+		// User of the docker client, if authenticated
+		cel.Variable("user", cel.StringType),
+		// Requested platform as os[/arch[/variant]], if any
+		cel.Variable("platform", cel.StringType))
+}
+
+// Returns the CEL rules of the expressions, compiled. Expressions which do not compile or which do not
+// evaluate to a boolean are errors.
+func newCELRules(expressions []string) ([]celRule, error) {
+	if len(expressions) == 0 {
+		return nil, nil
+	}
+	env, err := celEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]celRule, 0, len(expressions))
+	for _, expression := range expressions {
+		ast, issues := env.Compile(expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("invalid --cel-rule %q: %v", expression, issues.Err())
+		}
+		if !ast.OutputType().IsExactType(cel.BoolType) {
+			return nil, fmt.Errorf("invalid --cel-rule %q: evaluates to %s (expected bool)", expression, ast.OutputType())
+		}
+		program, err := env.Program(ast, cel.CostLimit(celCostLimit))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --cel-rule %q: %v", expression, err)
+		}
+		rules = append(rules, celRule{expression: expression, program: program})
+	}
+	return rules, nil
+}
+
+// Returns true if the rule allows the registry command
+func (rule celRule) allows(request MatchRequest) (bool, error) {
+	image := request.request.image
+	result, _, err := rule.program.Eval(map[string]interface{}{
+		"command":    request.Command,
+		"registry":   request.Registry,
+		"repository": request.Repository,
+		"image":      image.name(),
+		"tag":        request.Tag,
+		"digest":     request.Digest,
+		"user":       request.User,
+		"platform":   request.request.platform})
+	if err != nil {
+		return false, err
+	}
+	allowed, ok := result.Value().(bool)
+	return ok && allowed, nil
+}
+
+// Built-in matcher allowing the registry commands which any of the CEL rules allows. Consulted after the
+// allowlist matcher, so that the deny-lists and the other explicit rules still deny. The commands which
+// no rule allows are left to the next matchers.
+type celMatcher struct {
+	plugin *ImgAuthZPlugin
+}
+
+// Returns the name of the CEL matcher
+func (matcher *celMatcher) Name() string {
+	return "cel"
+}
+
+// Allows the registry command if any of the CEL rules allows it, in order. The rules which fail to
+// evaluate (e.g. over their cost limit) are logged and do not allow.
+func (matcher *celMatcher) Match(request MatchRequest) MatchResult {
+	for _, rule := range matcher.plugin.cel {
+		allowed, err := rule.allows(request)
+		if err != nil {
+			request.request.logln("[CEL] Rule", rule.expression, "failed:", err)
+			continue
+		}
+		if allowed {
+			request.request.logln("[ALLOWED] CEL rule:", rule.expression, request.request.image, request.req.RequestMethod, request.reqURL.String())
+			return decided(authorization.Response{Allow: true})
+		}
+	}
+	return MatchResult{Verdict: Abstain}
+}
@@ -0,0 +1,66 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"strings"
+	"testing"
+)
+
+func TestCELRulesAllowWhatTheAllowlistDoesNot(t *testing.T) {
+	policy := testPolicy(t, Config{
+		Registries:   []string{"my.docker.registry"},
+		DeniedImages: []string{"docker.io/library/busybox"},
+		CELRules: []string{
+			`registry == "docker.io" && repository.startsWith("library/") && tag != "latest"`,
+			`image.matches("^ghcr\\.io/team/") && user == "alice"`,
+			`digest != "" && registry == "quay.io"`,
+			`command == "run" && registry == "gcr.io"`,
+			`platform.startsWith("linux/") && registry == "arm.example"`,
+		}})
+	digest := "sha256:" + strings.Repeat("a", 64)
+	for _, test := range []struct {
+		method  string
+		uri     string
+		body    string
+		user    string
+		allowed bool
+	}{
+		{"POST", "/images/create?fromImage=alpine&tag=3.19", "", "", true},
+		{"POST", "/images/create?fromImage=alpine&tag=latest", "", "", false},
+		{"POST", "/images/create?fromImage=user/app&tag=1.0", "", "", false},
+		// The deny-lists still deny
+		{"POST", "/images/create?fromImage=busybox&tag=1.36", "", "", false},
+		// The allowlist still allows
+		{"POST", "/images/create?fromImage=my.docker.registry/app&tag=latest", "", "", true},
+		{"POST", "/images/create?fromImage=ghcr.io/team/app&tag=1.0", "", "alice", true},
+		{"POST", "/images/create?fromImage=ghcr.io/team/app&tag=1.0", "", "bob", false},
+		{"POST", "/images/create?fromImage=ghcr.io/other/app&tag=1.0", "", "alice", false},
+		{"POST", "/images/create?fromImage=quay.io/app&tag=" + digest, "", "", true},
+		{"POST", "/images/create?fromImage=quay.io/app&tag=1.0", "", "", false},
+		{"POST", "/images/create?fromImage=gcr.io/app&tag=1.0", "", "", false},
+		{"POST", "/containers/create", `{"Image": "gcr.io/app:1.0"}`, "", true},
+		{"POST", "/images/create?fromImage=arm.example/app&tag=1.0&platform=linux/arm64", "", "", true},
+		{"POST", "/images/create?fromImage=arm.example/app&tag=1.0", "", "", false},
+	} {
+		request := authorization.Request{RequestMethod: test.method, RequestURI: test.uri, RequestBody: []byte(test.body), User: test.user}
+		if response := policy.Authorize(request); response.Allow != test.allowed {
+			t.Errorf("%s %s %s by %q: allowed %v (%s)", test.method, test.uri, test.body, test.user, response.Allow, response.Msg)
+		}
+	}
+
+	// The denials not allowed by any rule are the denials of the allowlist
+	if response := policy.AuthorizePull("alpine:latest"); !strings.Contains(response.Msg, "authorized registries: my.docker.registry") {
+		t.Errorf("denial message: %s", response.Msg)
+	}
+}
+
+func TestInvalidCELRules(t *testing.T) {
+	for _, expression := range []string{`registry ==`, `registry`, `unknown == "docker.io"`, `tag + 1`} {
+		if _, err := NewPolicy(Config{CELRules: []string{expression}}); err == nil || !strings.Contains(err.Error(), "invalid --cel-rule") {
+			t.Errorf("%s: %v", expression, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"context"
+	"errors"
+	dockertypes "github.com/docker/docker/api/types"
+	dockerclient "github.com/docker/docker/client"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Interval between the docker daemon health checks
+	clientPingInterval = 30 * time.Second
+	// Timeout for a single docker daemon health check
+	clientPingTimeout = 5 * time.Second
+	// Initial and maximum delay between the reconnect attempts
+	clientMinBackoff = 1 * time.Second
+	clientMaxBackoff = 1 * time.Minute
+	// Timeout for a single image inspect
+	clientInspectTimeout = 10 * time.Second
+)
+
+// Returned to the client dependent features while the docker daemon is unreachable
+var errClientUnavailable = errors.New("docker daemon connection is unavailable")
+
+// Docker client operations used by the plugin
+type dockerAPI interface {
+	Ping(ctx context.Context) (dockertypes.Ping, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (dockertypes.ImageInspect, []byte, error)
+	Info(ctx context.Context) (dockertypes.Info, error)
+}
+
+// Docker client connection which detects the loss of the docker daemon
+// (e.g. on a daemon restart) and reconnects in the background.
+type dockerConnection struct {
+	sync.RWMutex
+	// Creates a new docker client
+	connect func() (dockerAPI, error)
+	// Current docker client
+	client dockerAPI
+	// True while the docker daemon is unreachable
+	lost bool
+	// Insecure registries of the docker daemon, if fetched, and their fetch time
+	infoLock        sync.Mutex
+	insecure        *insecureRegistries
+	insecureFetched time.Time
+}
+
+// Create a new docker client connection
+func newDockerConnection(connect func() (dockerAPI, error)) (*dockerConnection, error) {
+	client, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return &dockerConnection{connect: connect, client: client}, nil
+}
+
+// Returns the docker client.
+// Returns errClientUnavailable while the docker daemon connection is lost.
+func (conn *dockerConnection) get() (dockerAPI, error) {
+	conn.RLock()
+	defer conn.RUnlock()
+	if conn.lost {
+		return nil, errClientUnavailable
+	}
+	return conn.client, nil
+}
+
+// Reports an error returned by a docker client call.
+// If the error is due to a lost daemon connection, a reconnect is started in the background.
+func (conn *dockerConnection) reportError(err error) {
+	if err == nil || !dockerclient.IsErrConnectionFailed(err) {
+		return
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.lost {
+		// Already reconnecting
+		return
+	}
+	log.Println("[CLIENT] Docker daemon connection lost:", err)
+	conn.lost = true
+	go conn.reconnect()
+}
+
+// Tries to reconnect to the docker daemon with an exponential backoff until it succeeds
+func (conn *dockerConnection) reconnect() {
+	backoff := clientMinBackoff
+	for {
+		time.Sleep(backoff)
+
+		client, err := conn.connect()
+		if err == nil {
+			err = ping(client)
+		}
+		if err == nil {
+			conn.Lock()
+			conn.client = client
+			conn.lost = false
+			conn.Unlock()
+			log.Println("[CLIENT] Docker daemon connection restored")
+			return
+		}
+
+		log.Println("[CLIENT] Docker daemon reconnect failed, retrying in", backoff, ":", err)
+		backoff *= 2
+		if backoff > clientMaxBackoff {
+			backoff = clientMaxBackoff
+		}
+	}
+}
+
+// Replaces the docker client with a new one, e.g. to use the rotated TLS certificates of the docker daemon
+// connection. The new client must reach the docker daemon, or the current client is kept.
+// Requests in flight complete with the client they started with.
+func (conn *dockerConnection) refresh() error {
+	client, err := conn.connect()
+	if err == nil {
+		err = ping(client)
+	}
+	if err != nil {
+		return err
+	}
+	conn.Lock()
+	defer conn.Unlock()
+	conn.client = client
+	conn.lost = false
+	return nil
+}
+
+// Periodically checks the docker daemon connection, so that a lost connection is detected
+// before a request depends on it.
+func (conn *dockerConnection) monitor() {
+	for range time.Tick(clientPingInterval) {
+		client, err := conn.get()
+		if err != nil {
+			continue
+		}
+		conn.reportError(ping(client))
+	}
+}
+
+// Checks that the docker daemon responds
+func ping(client dockerAPI) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clientPingTimeout)
+	defer cancel()
+	_, err := client.Ping(ctx)
+	return err
+}
+
+// Inspects a local image, and returns true if the image exists locally
+func (conn *dockerConnection) inspectImage(image string) (*dockertypes.ImageInspect, bool, error) {
+	client, err := conn.get()
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientInspectTimeout)
+	defer cancel()
+	inspect, _, err := client.ImageInspectWithRaw(ctx, image)
+	if dockerclient.IsErrImageNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		conn.reportError(err)
+		return nil, false, err
+	}
+	return &inspect, true, nil
+}
+
+// Returns the repo tags and digests of a local image, and true if the image exists locally
+func (conn *dockerConnection) imageReferences(image string) ([]string, bool, error) {
+	inspect, found, err := conn.inspectImage(image)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return append(append([]string{}, inspect.RepoTags...), inspect.RepoDigests...), true, nil
+}
+
+// Returns the number of layers of a local image, and true if the image exists locally
+func (conn *dockerConnection) imageLayerCount(image string) (int, bool, error) {
+	inspect, found, err := conn.inspectImage(image)
+	if !found || err != nil {
+		return 0, found, err
+	}
+	return len(inspect.RootFS.Layers), true, nil
+}
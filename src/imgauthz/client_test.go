@@ -0,0 +1,115 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"context"
+	"errors"
+	dockertypes "github.com/docker/docker/api/types"
+	dockerclient "github.com/docker/docker/client"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+// Docker daemon which can be stopped and restarted, with a single local image
+type restartingDocker struct {
+	sync.Mutex
+	down bool
+	tags []string
+}
+
+// Stops or restarts the docker daemon
+func (docker *restartingDocker) setDown(down bool) {
+	docker.Lock()
+	defer docker.Unlock()
+	docker.down = down
+}
+
+// Returns the connection error of a stopped docker daemon
+func (docker *restartingDocker) unavailable() error {
+	docker.Lock()
+	defer docker.Unlock()
+	if docker.down {
+		return dockerclient.ErrorConnectionFailed("unix:///var/run/docker.sock")
+	}
+	return nil
+}
+
+func (docker *restartingDocker) Ping(ctx context.Context) (dockertypes.Ping, error) {
+	return dockertypes.Ping{}, docker.unavailable()
+}
+
+func (docker *restartingDocker) ImageInspectWithRaw(ctx context.Context, imageID string) (dockertypes.ImageInspect, []byte, error) {
+	if err := docker.unavailable(); err != nil {
+		return dockertypes.ImageInspect{}, nil, err
+	}
+	return dockertypes.ImageInspect{RepoTags: docker.tags}, nil, nil
+}
+
+func (docker *restartingDocker) Info(ctx context.Context) (dockertypes.Info, error) {
+	return dockertypes.Info{}, docker.unavailable()
+}
+
+func TestDockerConnectionLossAndRecovery(t *testing.T) {
+	docker := &restartingDocker{}
+	conn, err := newDockerConnection(func() (dockerAPI, error) { return docker, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := conn.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors unrelated to the daemon connection do not start a reconnect
+	conn.reportError(errors.New("no such image"))
+	if _, err := conn.get(); err != nil {
+		t.Fatalf("connection reported lost on an image error: %v", err)
+	}
+
+	// The connection is reported lost once a call fails to connect
+	docker.setDown(true)
+	conn.reportError(ping(client))
+	if _, err := conn.get(); err != errClientUnavailable {
+		t.Fatalf("connection not reported lost: %v", err)
+	}
+
+	// The connection is restored in the background once the daemon is back
+	docker.setDown(false)
+	deadline := time.Now().Add(5 * clientMinBackoff)
+	for {
+		if _, err := conn.get(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not restored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestErrorResponseAppliesTheOnErrorBehavior(t *testing.T) {
+	reqURL, _ := url.Parse("/images/create?fromImage=alpine&tag=3.19")
+	request := registryRequest{command: pullCommand, image: parseImageReference("alpine:3.19", "")}
+
+	plugin := &ImgAuthZPlugin{}
+	response := plugin.errorResponse(request, reqURL, errClientUnavailable)
+	if response.Allow || !strings.Contains(response.Msg, "Authorization could not be verified: "+errClientUnavailable.Error()) {
+		t.Fatalf("error with --on-error deny: allowed %v (%s)", response.Allow, response.Msg)
+	}
+
+	plugin.allowOnError = true
+	if response := plugin.errorResponse(request, reqURL, errClientUnavailable); !response.Allow {
+		t.Fatalf("error with --on-error allow: %s", response.Msg)
+	}
+}
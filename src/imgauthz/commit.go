@@ -0,0 +1,43 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+)
+
+// Restrictions of docker commit (i.e. /commit), which creates images from containers rather than from a registry
+const (
+	// Commits are allowed, as any command without a registry (default)
+	commitOff = "off"
+	// Commits are denied
+	commitDeny = "deny"
+	// Commits are allowed to the authorized registries and images only
+	commitAllowlist = "allowlist"
+)
+
+// Authorizes a docker commit command as per the commit restriction: commits are denied, or authorized
+// against the authorized registries and images as if the committed image was pulled. The checks of
+// the image on the registry (e.g. its size or provenance) do not apply, as the image is not there yet.
+func (plugin *ImgAuthZPlugin) authorizeCommit(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.restrictCommit == commitDeny {
+		request.logln("[DENIED] Commit:", request.rawImage, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("Committing containers to images is not allowed")}
+	}
+	if len(request.rawImage) == 0 {
+		request.logln("[DENIED] Commit without repository:", req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("Commits must name an authorized image repository")}
+	}
+	if len(request.image.repository) == 0 {
+		request.logln("[DENIED] Invalid image reference:", request.rawImage, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image reference " + request.rawImage + " is invalid")}
+	}
+	return plugin.authorizeRegistryRequest(req, reqURL, request)
+}
+
+// Returns true if docker commit commands are restricted, i.e. authorized as registry commands
+func (config pluginConfig) restrictsCommits() bool {
+	return config.restrictCommit == commitDeny || config.restrictCommit == commitAllowlist
+}
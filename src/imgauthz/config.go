@@ -0,0 +1,108 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Policy file, as passed with --config or found in --config-dir.
+// Its lists are merged with the ones passed on the command line and the ones of the earlier policy files.
+// The policy schema (see policySchema) must be updated along with the fields.
+type configFile struct {
+	Registries         []string `json:"registries" yaml:"registries"`
+	Images             []string `json:"images" yaml:"images"`
+	RepositoryPrefixes []string `json:"repositoryPrefixes" yaml:"repositoryPrefixes"`
+	DeniedRegistries   []string `json:"deniedRegistries" yaml:"deniedRegistries"`
+	DeniedImages       []string `json:"deniedImages" yaml:"deniedImages"`
+	RegistryWindows    []string `json:"registryWindows" yaml:"registryWindows"`
+	AlwaysAllow        []string `json:"alwaysAllow" yaml:"alwaysAllow"`
+	DeniedHostMounts   []string `json:"deniedHostMounts" yaml:"deniedHostMounts"`
+	DeniedCapabilities []string `json:"deniedCapabilities" yaml:"deniedCapabilities"`
+	RegistryAliases    []string `json:"registryAliases" yaml:"registryAliases"`
+	// Replace the earlier lists by the non-empty lists of the file, instead of adding to them
+	Override bool `json:"override" yaml:"override"`
+	// Command line options keyed by name, applied at startup to the --config file options not set on
+	// the command line (see applySettings), and ignored in the other policy files
+	Settings map[string]interface{} `json:"settings" yaml:"settings"`
+}
+
+// Returns true if the policy file is a YAML file, by its extension
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Reads a JSON or YAML policy file.
+// If a verifier is given, the policy file is verified against its signature first.
+func readConfigFile(path string, verifier *policyVerifier) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		if err := verifier.verify(data); err != nil {
+			return nil, fmt.Errorf("policy file %s not loaded: %v", path, err)
+		}
+	}
+
+	if err := validatePolicy(data, isYAMLFile(path)); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+
+	var file configFile
+	if isYAMLFile(path) {
+		err = yaml.Unmarshal(data, &file)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// Returns the JSON and YAML policy files of a directory, sorted by name
+func configDirFiles(dir string) ([]string, error) {
+	var paths []string
+	for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Returns the number of policy rules, i.e. the number of entries of all the lists
+func (config pluginConfig) numRules() int {
+	return len(config.registries) + len(config.images) + len(config.repositoryPrefixes) +
+		len(config.denyRegistries) + len(config.denyImages) + len(config.registryWindows) +
+		len(config.alwaysAllow) + len(config.denyHostMounts) + len(config.denyCapabilities) +
+		len(config.registryAliases) + len(config.cachedImages)
+}
+
+// Returns the number of policy rules per list, keyed as in the policy file
+func (config pluginConfig) ruleCounts() map[string]int {
+	return map[string]int{
+		"registries":         len(config.registries),
+		"images":             len(config.images),
+		"repositoryPrefixes": len(config.repositoryPrefixes),
+		"deniedRegistries":   len(config.denyRegistries),
+		"deniedImages":       len(config.denyImages),
+		"registryWindows":    len(config.registryWindows),
+		"alwaysAllow":        len(config.alwaysAllow),
+		"deniedHostMounts":   len(config.denyHostMounts),
+		"deniedCapabilities": len(config.denyCapabilities),
+		"registryAliases":    len(config.registryAliases),
+		"cachedImages":       len(config.cachedImages)}
+}
@@ -0,0 +1,69 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyDirectoryFilesAreMergedInNameOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-authz-config-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{
+		"10-base.json":  `{"registries": ["docker.io", "quay.io"], "images": ["quay.io/team/app"]}`,
+		"20-team.yaml":  "registries:\n  - my.docker.registry\ndeniedImages:\n  - docker.io/library/busybox\n",
+		"30-lock.yml":   "override: true\nimages:\n  - docker.io/library/alpine\n",
+		"README.txt":    "not a policy file",
+		"99-draft.json": "{invalid",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := configDirFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+	if expected := []string{"10-base.json", "20-team.yaml", "30-lock.yml", "99-draft.json"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("policy files %v, expected %v", names, expected)
+	}
+
+	// Any invalid policy file fails the whole policy
+	if _, err := NewPolicy(Config{PolicyFiles: paths}); err == nil {
+		t.Error("invalid policy file accepted")
+	}
+
+	// The lists of the command line and of the files are merged in order, except for the overridden ones
+	policy := testPolicy(t, Config{Registries: []string{"ghcr.io"}, PolicyFiles: paths[0:3]})
+	if expected := []string{"ghcr.io", "docker.io", "quay.io", "my.docker.registry"}; !reflect.DeepEqual(policy.plugin.registries, expected) {
+		t.Errorf("registries %v, expected %v", policy.plugin.registries, expected)
+	}
+	if expected := []string{"docker.io/library/alpine"}; !reflect.DeepEqual(policy.plugin.images, expected) {
+		t.Errorf("images %v, expected %v", policy.plugin.images, expected)
+	}
+	for _, test := range []struct {
+		image   string
+		allowed bool
+	}{
+		{"alpine:3.19", true},
+		{"quay.io/team/app:1.0", false},
+		{"busybox:1.36", false},
+	} {
+		if response := policy.AuthorizePull(test.image); response.Allow != test.allowed {
+			t.Errorf("pull of %s: allowed %v (%s)", test.image, response.Allow, response.Msg)
+		}
+	}
+}
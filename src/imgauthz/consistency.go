@@ -0,0 +1,29 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+// Returns the warnings about the inconsistencies of the policy, which are most likely configuration
+// errors: authorized images whose registry is not authorized can never be used.
+// Glob patterns and regular expressions are not checked, and neither is the policy when the cache
+// manifest replaces the registry and image rules. Registries which the policy backend may authorize
+// are not checked either.
+func (plugin *ImgAuthZPlugin) policyWarnings() []string {
+	var warnings []string
+	if plugin.approvedImages != nil {
+		return warnings
+	}
+
+	for _, image := range plugin.images {
+		if isPattern(image) {
+			continue
+		}
+		registry := parseImageReference(image, plugin.defaultRegistry).registry
+		if plugin.deniedRegistries.matches(plugin.registryNames(registry)...) {
+			warnings = append(warnings, "Authorized image "+image+" is on the denied registry "+registry+" and can never be used")
+		} else if plugin.backend == nil && !plugin.authorizedRegistries.matches(plugin.registryNames(registry)...) {
+			warnings = append(warnings, "Authorized image "+image+" is on the registry "+registry+" which is not authorized and can never be used")
+		}
+	}
+	return warnings
+}
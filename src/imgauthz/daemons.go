@@ -0,0 +1,143 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Valid daemon names, as the docker plugin names: lowercase alphanumerics separated by . _ or -
+var daemonNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// Policy of the requests of one docker daemon, applied over the policy of all the daemons.
+// The authorization requests do not identify the daemon which sends them, so each daemon policy is
+// served on its own plugin socket, named after the daemon: the daemon is configured with its own
+// plugin name (i.e. dockerd --authorization-plugin=<name>), which identifies it.
+type daemonPolicy struct {
+	// Name of the daemon, i.e. of the authorization plugin it is configured with
+	name string
+	// Policy file whose rules are merged after the other policy sources
+	file string
+}
+
+// Returns the daemon policies of the entries given as <name>=<policy file>, e.g. build=/etc/img-authz/build.yaml,
+// in name order. Invalid names, the name of the plugin itself and names given twice are errors.
+func parseDaemonPolicies(entries []string) ([]daemonPolicy, error) {
+	defaultName := strings.TrimSuffix(filepath.Base(pluginSocket), ".sock")
+	policies := make([]daemonPolicy, 0, len(entries))
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || !daemonNamePattern.MatchString(fields[0]) || len(fields[1]) == 0 {
+			return nil, fmt.Errorf("invalid daemon policy: %s (expected <name>=<policy file> with a lowercase name, e.g. build=/etc/img-authz/build.yaml)", entry)
+		}
+		if fields[0] == defaultName {
+			return nil, fmt.Errorf("invalid daemon policy: %s (%s is the socket of the policy of all the daemons)", entry, defaultName)
+		}
+		if names[fields[0]] {
+			return nil, fmt.Errorf("duplicate daemon policy: %s", fields[0])
+		}
+		names[fields[0]] = true
+		policies = append(policies, daemonPolicy{name: fields[0], file: fields[1]})
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].name < policies[j].name })
+	return policies, nil
+}
+
+// Returns the plugin socket of the daemon, next to the socket of the plugin
+func (daemon daemonPolicy) socket() string {
+	return filepath.Join(filepath.Dir(pluginSocket), daemon.name+".sock")
+}
+
+// Returns the state file of the daemon (e.g. of the prior pulls), derived from the file of all the
+// daemons, so that the pulls and pins of a daemon never apply to another one
+func (daemon daemonPolicy) stateFile(path string) string {
+	if len(path) == 0 {
+		return path
+	}
+	return path + "." + daemon.name
+}
+
+// Reloadable plugins of the policy of all the daemons and of the daemon policies, each served on its
+// own socket. They are reloaded and drained together.
+type daemonPlugins struct {
+	// Plugin of the policy of all the daemons, served on the plugin socket
+	common *reloadablePlugin
+	// Plugins of the daemon policies, by daemon name
+	daemons map[string]*reloadablePlugin
+	// Daemon policies, in name order
+	policies []daemonPolicy
+}
+
+// Create new daemon plugins, loading the initial policy of each daemon policy with the load function
+func newDaemonPlugins(common *reloadablePlugin, policies []daemonPolicy, load func(daemon daemonPolicy) (*reloadablePlugin, error)) (*daemonPlugins, error) {
+	plugins := &daemonPlugins{common: common, daemons: make(map[string]*reloadablePlugin, len(policies)), policies: policies}
+	for _, daemon := range policies {
+		reloadable, err := load(daemon)
+		if err != nil {
+			return nil, fmt.Errorf("daemon policy %s: %v", daemon.name, err)
+		}
+		reloadable.recorder = common.recorder
+		plugins.daemons[daemon.name] = reloadable
+	}
+	return plugins, nil
+}
+
+// Returns the plugin deciding on the requests of the daemon: the plugin of its daemon policy,
+// or the plugin of the policy of all the daemons if it has none
+func (plugins *daemonPlugins) forDaemon(name string) *reloadablePlugin {
+	if reloadable, ok := plugins.daemons[name]; ok {
+		return reloadable
+	}
+	return plugins.common
+}
+
+// Reloads the policies of all the plugins. Each plugin keeps its current policy if its new one cannot be loaded.
+func (plugins *daemonPlugins) reload() {
+	plugins.common.reload()
+	for _, daemon := range plugins.policies {
+		if err := plugins.daemons[daemon.name].reload(); err != nil {
+			log.Println("[RELOAD] Daemon policy", daemon.name, "not reloaded:", err)
+		}
+	}
+}
+
+// Waits for the requests being authorized by all the plugins to complete, up to the timeout (0 for unlimited).
+// Returns false if the timeout expired first.
+func (plugins *daemonPlugins) drain(timeout time.Duration) bool {
+	results := make(chan bool, len(plugins.policies)+1)
+	go func() { results <- plugins.common.drain(timeout) }()
+	for _, daemon := range plugins.policies {
+		go func(reloadable *reloadablePlugin) { results <- reloadable.drain(timeout) }(plugins.daemons[daemon.name])
+	}
+	drained := true
+	for i := 0; i < len(plugins.policies)+1; i++ {
+		drained = <-results && drained
+	}
+	return drained
+}
+
+// Serves the plugins of the daemon policies on their sockets, then the plugin of all the daemons
+// on the plugin socket, until one of them fails
+func (plugins *daemonPlugins) serve(gid int) error {
+	failed := make(chan error, len(plugins.policies)+1)
+	for _, daemon := range plugins.policies {
+		go func(daemon daemonPolicy) {
+			log.Println("Daemon policy", daemon.name, "served on:", daemon.socket())
+			handler := authorization.NewHandler(plugins.forDaemon(daemon.name))
+			failed <- fmt.Errorf("daemon policy %s: %v", daemon.name, handler.ServeUnix(daemon.socket(), gid))
+		}(daemon)
+	}
+	go func() {
+		failed <- authorization.NewHandler(plugins.common).ServeUnix(pluginSocket, gid)
+	}()
+	return <-failed
+}
@@ -0,0 +1,124 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDaemonPolicyEntries(t *testing.T) {
+	policies, err := parseDaemonPolicies([]string{"ci=/etc/img-authz/ci.yaml", "build=/etc/img-authz/build.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 || policies[0] != (daemonPolicy{name: "build", file: "/etc/img-authz/build.yaml"}) || policies[1].name != "ci" {
+		t.Errorf("daemon policies %+v", policies)
+	}
+	if socket := policies[0].socket(); socket != "/run/docker/plugins/build.sock" {
+		t.Errorf("socket %s", socket)
+	}
+	if path := policies[0].stateFile("/var/lib/img-authz/pulls.json"); path != "/var/lib/img-authz/pulls.json.build" {
+		t.Errorf("state file %s", path)
+	}
+	if path := policies[0].stateFile(""); len(path) != 0 {
+		t.Errorf("state file %s", path)
+	}
+
+	for _, entries := range [][]string{
+		{"Build=/etc/img-authz/build.yaml"},
+		{"build"},
+		{"build="},
+		{"../build=/etc/img-authz/build.yaml"},
+		{"img-authz-plugin=/etc/img-authz/build.yaml"},
+		{"build=/etc/img-authz/build.yaml", "build=/etc/img-authz/other.yaml"},
+	} {
+		if _, err := parseDaemonPolicies(entries); err == nil {
+			t.Errorf("daemon policies %v accepted", entries)
+		}
+	}
+}
+
+func TestDaemonPoliciesApplyToTheirDaemonOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-authz-daemons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "build.json")
+	if err := ioutil.WriteFile(file, []byte(`{"registries": ["build.registry"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon policy files are merged over the policy of all the daemons
+	load := func(files ...string) func() (*ImgAuthZPlugin, error) {
+		return func() (*ImgAuthZPlugin, error) {
+			policy, err := NewPolicy(Config{Registries: []string{"docker.io"}, PolicyFiles: files})
+			if err != nil {
+				return nil, err
+			}
+			return policy.plugin, nil
+		}
+	}
+	common, err := newReloadablePlugin(load(), newPluginMetrics("", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := parseDaemonPolicies([]string{"build=" + file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDaemonPlugin := func(daemon daemonPolicy) (*reloadablePlugin, error) {
+		return newReloadablePlugin(load(daemon.file), newPluginMetrics("", ""))
+	}
+	plugins, err := newDaemonPlugins(common, policies, newDaemonPlugin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		policy string
+		pulls  map[string]map[string]bool
+	}{
+		{"", map[string]map[string]bool{
+			"build":            {"build.registry/app:1.0": true, "alpine:3.19": true, "other.registry/app:1.0": false},
+			"img-authz-plugin": {"build.registry/app:1.0": false, "alpine:3.19": true},
+			"ci":               {"build.registry/app:1.0": false, "alpine:3.19": true},
+		}},
+		{`{"registries": ["other.registry"]}`, map[string]map[string]bool{
+			"build": {"build.registry/app:1.0": false, "other.registry/app:1.0": true},
+		}},
+		// An invalid daemon policy is not reloaded, the daemon keeps its current policy
+		{`{`, map[string]map[string]bool{
+			"build": {"other.registry/app:1.0": true},
+		}},
+	} {
+		if len(step.policy) > 0 {
+			if err := ioutil.WriteFile(file, []byte(step.policy), 0600); err != nil {
+				t.Fatal(err)
+			}
+			plugins.reload()
+		}
+		for daemon, pulls := range step.pulls {
+			for image, allowed := range pulls {
+				if response := plugins.forDaemon(daemon).AuthZReq(pullRequest(image)); response.Allow != allowed {
+					t.Errorf("pull of %s on the %s daemon after the policy %q: allowed %v (%s)", image, daemon, step.policy, response.Allow, response.Msg)
+				}
+			}
+		}
+	}
+	if !plugins.drain(0) {
+		t.Error("daemon plugins not drained")
+	}
+
+	// The daemon policies must load at startup
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDaemonPlugins(common, policies, newDaemonPlugin); err == nil {
+		t.Error("missing daemon policy file accepted")
+	}
+}
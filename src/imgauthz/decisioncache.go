@@ -0,0 +1,141 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Maximum number of decisions cached, beyond which new decisions are not cached until some expire
+const maxCachedDecisions = 10000
+
+// Decision of the image checks, cached until it expires
+type cachedDecision struct {
+	response authorization.Response
+	expires  time.Time
+	// Policy generation the decision was made with
+	generation uint64
+}
+
+// Cache of the decisions of the image checks, e.g. the image size or provenance, which fetch from the
+// registry. The cache is shared by the reloaded plugins, and each decision is tagged with the policy
+// generation of the plugin which made it: every plugin starts a new generation, and the decisions of the
+// older generations are misses, so that they never leak into a reloaded policy.
+type decisionCache struct {
+	sync.Mutex
+	entries map[string]cachedDecision
+	// Current policy generation
+	generation uint64
+}
+
+// Create a new decision cache
+func newDecisionCache() *decisionCache {
+	return &decisionCache{entries: make(map[string]cachedDecision)}
+}
+
+// Starts a new policy generation and returns it. The decisions of the older generations are misses from now on.
+func (cache *decisionCache) newGeneration() uint64 {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.generation++
+	return cache.generation
+}
+
+// Returns the cache key of the request: the image by digest if it is pinned to one, as the same digest
+// is the same image whatever its tag, otherwise by reference, along with the command and the platform
+func decisionCacheKey(request registryRequest) string {
+	image := request.image.String()
+	if len(request.image.digest) > 0 {
+		image = request.image.name() + "@" + request.image.digest
+	}
+	return request.command + " " + request.platform + " " + image
+}
+
+// Returns the cached decision of the key, if made with the policy generation and not expired
+func (cache *decisionCache) get(key string, generation uint64, now time.Time) (authorization.Response, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	cached, ok := cache.entries[key]
+	if !ok || cached.generation != generation || !now.Before(cached.expires) {
+		return authorization.Response{}, false
+	}
+	return cached.response, true
+}
+
+// Caches the decision of the key, made with the policy generation, for the TTL (uncached if 0).
+// The decisions of the older generations, e.g. of the requests in flight during a reload, are not cached.
+func (cache *decisionCache) put(key string, response authorization.Response, generation uint64, ttl time.Duration, now time.Time) {
+	if ttl <= 0 {
+		return
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+	if generation != cache.generation {
+		return
+	}
+	if len(cache.entries) >= maxCachedDecisions {
+		for cachedKey, cached := range cache.entries {
+			if cached.generation != cache.generation || !now.Before(cached.expires) {
+				delete(cache.entries, cachedKey)
+			}
+		}
+		if len(cache.entries) >= maxCachedDecisions {
+			return
+		}
+	}
+	cache.entries[key] = cachedDecision{response: response, expires: now.Add(ttl), generation: generation}
+}
+
+// Authorizes the image itself, as found on the registry: its size, layer count, provenance, SBOM and
+// labels. The decisions are cached, if configured, so that the repeated requests of the same image do not
+// fetch from the registry again. Only the decisions of the checks which all completed are cached: the
+// decisions due to an error (e.g. an unreachable registry) are not, so that the image is checked again
+// once the registry is back.
+func (plugin *ImgAuthZPlugin) authorizeImageContent(reqURL *url.URL, request registryRequest) authorization.Response {
+	key := decisionCacheKey(request)
+	if response, ok := plugin.decisions.get(key, plugin.generation, plugin.now()); ok {
+		if request.cacheHit != nil {
+			atomic.StoreInt32(request.cacheHit, 1)
+		}
+		if !response.Allow {
+			request.logln("[DENIED] Cached denial:", request.image.name(), reqURL.String())
+		} else {
+			plugin.debugln(request, "[CACHE] Cached image checks:", request.image.name(), reqURL.String())
+		}
+		return response
+	}
+
+	// Tracks the failures of the checks, on a copy of the request
+	request.failed = new(int32)
+	response := plugin.authorizeImageSize(reqURL, request)
+	if response.Allow {
+		response = plugin.authorizeLayerCount(reqURL, request)
+	}
+	if response.Allow {
+		response = plugin.authorizeProvenance(reqURL, request)
+	}
+	if response.Allow {
+		response = plugin.authorizeSBOM(reqURL, request)
+	}
+	if response.Allow {
+		response = plugin.authorizeRequiredLabels(reqURL, request)
+	}
+	// Neither the failed checks nor the checks skipped while the registry is unavailable are cached
+	if atomic.LoadInt32(request.failed) == 1 {
+		return response
+	}
+	if host, _ := request.image.registryHost(); !plugin.breaker.isOpen(host) {
+		ttl := plugin.negativeTTL
+		if response.Allow {
+			ttl = plugin.cacheTTL
+		}
+		plugin.decisions.put(key, response, plugin.generation, ttl, plugin.now())
+	}
+	return response
+}
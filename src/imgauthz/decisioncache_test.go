@@ -0,0 +1,98 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Registry serving the manifests of images with the given number of layers, or failing
+type flakyRegistry struct {
+	layers  int
+	err     error
+	fetches int
+}
+
+func (registry *flakyRegistry) getManifest(ref imageReference) (*imageManifest, error) {
+	registry.fetches++
+	if registry.err != nil {
+		return nil, registry.err
+	}
+	return &imageManifest{Layers: make([]descriptor, registry.layers)}, nil
+}
+
+// Returns the policy checking the layer count of the images on the registry, with the decisions cached
+func flakyRegistryPolicy(t *testing.T, registry *flakyRegistry, config pluginConfig) *Policy {
+	t.Helper()
+	config.registries = []string{"docker.io"}
+	config.maxLayers = 5
+	config.cacheTTL = time.Minute
+	config.negativeTTL = time.Minute
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin.manifests = registry
+	return &Policy{plugin: plugin}
+}
+
+func TestDecisionCacheSkipsTheErrors(t *testing.T) {
+	registry := &flakyRegistry{layers: 3, err: errors.New("connection refused")}
+	policy := flakyRegistryPolicy(t, registry, pluginConfig{})
+
+	response := policy.AuthorizePull("alpine:3.19")
+	if response.Allow || !strings.Contains(response.Msg, "could not be determined") {
+		t.Fatalf("pull with the registry down: allowed %v (%s)", response.Allow, response.Msg)
+	}
+
+	// The registry is back: the denial due to the error was not cached
+	registry.err = nil
+	if response := policy.AuthorizePull("alpine:3.19"); !response.Allow {
+		t.Fatalf("pull with the registry back: %s", response.Msg)
+	}
+	if response := policy.AuthorizePull("alpine:3.19"); !response.Allow {
+		t.Fatalf("cached pull: %s", response.Msg)
+	}
+	if registry.fetches != 2 {
+		t.Errorf("%d manifest fetches, expected 2", registry.fetches)
+	}
+
+	// The denials of the completed checks are cached
+	registry.layers = 8
+	for i := 0; i < 2; i++ {
+		if response := policy.AuthorizePull("alpine:3.20"); response.Allow {
+			t.Fatal("pull over the layer count allowed")
+		}
+	}
+	if registry.fetches != 3 {
+		t.Errorf("%d manifest fetches, expected 3", registry.fetches)
+	}
+}
+
+func TestDecisionCacheSkipsTheFailOpenDecisions(t *testing.T) {
+	registry := &flakyRegistry{layers: 8, err: errors.New("connection refused")}
+	policy := flakyRegistryPolicy(t, registry, pluginConfig{allowUnknownLayers: true, allowOnError: true})
+
+	// Allowed on the error, as configured, but not cached
+	if response := policy.AuthorizePull("alpine:3.19"); !response.Allow {
+		t.Fatalf("pull with the registry down: %s", response.Msg)
+	}
+	registry.err = nil
+	if response := policy.AuthorizePull("alpine:3.19"); response.Allow {
+		t.Fatal("fail-open decision cached")
+	}
+
+	// The on-error allowances of the other checks are not cached either
+	request := registryRequest{command: pullCommand, image: policy.plugin.parseReference("alpine:3.19"), failed: new(int32)}
+	if response := policy.plugin.errorResponse(request, &url.URL{Path: "/images/create"}, errors.New("SBOM lookup failed")); !response.Allow || *request.failed != 1 {
+		t.Errorf("on-error allowance: allowed %v, failed %d", response.Allow, *request.failed)
+	}
+}
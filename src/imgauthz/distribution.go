@@ -0,0 +1,29 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// Returns the image queried on the registry by a distribution request, i.e. /distribution/{name}/json,
+// and true, or false for any other request. The image name is part of the path, and may contain slashes.
+func distributionImage(path string) (string, bool) {
+	index := strings.Index(path, "/distribution/")
+	if index < 0 || !strings.HasSuffix(path, "/json") {
+		return "", false
+	}
+	image := strings.TrimSuffix(path[index+len("/distribution/"):], "/json")
+	return image, len(image) > 0
+}
+
+// Authorizes a distribution request (e.g. docker manifest inspect, or the digest resolution of
+// docker service create) against the registry and image rules, so that the registries and images
+// which cannot be pulled cannot be queried either. The checks of the pulled images (e.g. their size
+// or provenance) do not apply, as nothing is pulled.
+func (plugin *ImgAuthZPlugin) authorizeDistribution(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	return plugin.authorizeRegistryRequest(req, reqURL, request)
+}
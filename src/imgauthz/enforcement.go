@@ -0,0 +1,58 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"log"
+	"time"
+)
+
+// Parses the enforcement cutover time, as an RFC 3339 timestamp (e.g. 2024-07-01T00:00:00Z).
+// An empty value enforces the policy right away.
+func parseEnforceAfter(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	cutover, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --enforce-after value: %s (expected an RFC 3339 timestamp, e.g. 2024-07-01T00:00:00Z)", value)
+	}
+	return cutover, nil
+}
+
+// Returns true if the policy is audited rather than enforced at the given time,
+// i.e. before the enforcement cutover
+func (config pluginConfig) isAuditMode(now time.Time) bool {
+	return !config.enforceAfter.IsZero() && now.Before(config.enforceAfter)
+}
+
+// Returns an error if the policy authorizes fewer registries than the minimum, e.g. an empty policy
+// deployed by mistake, which would deny most of the pulls and break the hosts. Policies which are
+// audited rather than enforced are not refused, as they deny nothing yet.
+func (config pluginConfig) checkMinRegistries(now time.Time) error {
+	registries := len(sortedSet(config.registries))
+	if registries >= config.minRegistries {
+		return nil
+	}
+	if config.isAuditMode(now) {
+		log.Println("[WARNING] Only", registries, "authorized registries, fewer than the --min-registries minimum of", config.minRegistries,
+			"- allowed in audit mode, until", config.enforceAfter.Format(time.RFC3339))
+		return nil
+	}
+	return fmt.Errorf("policy refused: only %d authorized registries, fewer than the --min-registries minimum of %d "+
+		"(check the policy sources, or audit the policy first with --enforce-after)", registries, config.minRegistries)
+}
+
+// Applies the enforcement mode to the decision: before the cutover, denied requests are logged
+// as such and allowed. Returns the effective response and the reason recorded for the decision.
+func (plugin *ImgAuthZPlugin) enforce(request registryRequest, response authorization.Response) (authorization.Response, string) {
+	if response.Allow || !plugin.isAuditMode(plugin.now()) {
+		return response, response.Msg
+	}
+
+	request.logln("[AUDIT] Would be denied after", plugin.enforceAfter.Format(time.RFC3339)+":", response.Msg)
+	return authorization.Response{Allow: true}, "Audit mode, would be denied: " + response.Msg
+}
@@ -0,0 +1,37 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz_test
+
+import (
+	"fmt"
+	"imgauthz"
+	"io/ioutil"
+	"log"
+)
+
+// Tests a policy as it would be passed to the plugin with --registry, --image and --deny-image
+func Example() {
+	log.SetOutput(ioutil.Discard)
+
+	policy, err := imgauthz.NewPolicy(imgauthz.Config{
+		Registries:   []string{"docker.io", "my.docker.registry:5000"},
+		Images:       []string{"docker.io/library/*", "my.docker.registry:5000/team/*"},
+		DeniedImages: []string{"*:latest"}})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(policy.AuthorizePull("alpine:3.19").Allow)
+	fmt.Println(policy.AuthorizeRun("my.docker.registry:5000/team/app:1.0").Allow)
+	fmt.Println(policy.AuthorizePull("alpine:latest").Msg)
+	fmt.Println(policy.AuthorizePull("quay.io/team/app:1.0").Allow)
+	fmt.Println(imgauthz.ParseReference("user/app", ""))
+	// Output:
+	// true
+	// true
+	// docker pull denied: cannot pull image docker.io/library/alpine. The image is denied
+	// false
+	// docker.io/user/app:latest
+}
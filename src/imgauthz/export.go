@@ -0,0 +1,85 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Writes the effective policy of the plugin as JSON to the export path, replacing the previous
+// export atomically so that a backup is never left half-written. Returns the exported policy.
+func exportPolicy(plugin *ImgAuthZPlugin, path string) ([]byte, error) {
+	dump, err := plugin.dumpPolicy()
+	if err != nil {
+		return nil, err
+	}
+	dump = append(dump, '\n')
+	if err := writeFileAtomic(path, dump); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// Writes the file through a temporary file renamed over it, so that it is never left half-written
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// Exports the effective policy of the current plugin, logging the outcome
+func exportCurrentPolicy(reloadable *reloadablePlugin, path string) ([]byte, error) {
+	dump, err := exportPolicy(reloadable.plugin(), path)
+	if err != nil {
+		log.Println("[EXPORT] Policy not exported to", path+":", err)
+		return nil, err
+	}
+	log.Println("[EXPORT] Policy exported to", path)
+	return dump, nil
+}
+
+// Admin endpoint exporting the effective policy, e.g. GET /policy/export
+type policyExportHandler struct {
+	reloadable *reloadablePlugin
+	adminToken string
+	// Path the policy is exported to
+	path string
+}
+
+// Exports the effective policy to the export path and responds with it
+func (handler *policyExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizedAdmin(w, r, handler.adminToken) {
+		return
+	}
+
+	dump, err := exportCurrentPolicy(handler.reloadable, handler.path)
+	if err != nil {
+		http.Error(w, "policy not exported: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(dump)
+}
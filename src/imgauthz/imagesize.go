@@ -0,0 +1,48 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	units "github.com/docker/go-units"
+	"net/url"
+)
+
+// Authorizes the size of a pulled image, as per the image manifest on the registry.
+// Images over the maximum image size are denied. Images of unknown size (e.g. the registry
+// is unreachable) are allowed or denied as configured.
+func (plugin *ImgAuthZPlugin) authorizeImageSize(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.maxImageSize <= 0 || request.command != pullCommand {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		return plugin.authorizeManifestSize(reqURL, request)
+	})
+}
+
+// Authorizes the size of a pulled image against the image manifest
+func (plugin *ImgAuthZPlugin) authorizeManifestSize(reqURL *url.URL, request registryRequest) authorization.Response {
+	manifest, err := plugin.manifests.getManifest(request.image)
+	if err != nil {
+		if response, ok := plugin.degradedResponse(request, reqURL, "image size check", err); ok {
+			return response
+		}
+		request.markFailed()
+		if plugin.allowUnknownSize {
+			request.logln("[ALLOWED] Unknown image size:", request.image.name(), reqURL.String(), err)
+			return authorization.Response{Allow: true}
+		}
+		request.logln("[DENIED] Unknown image size:", request.image.name(), reqURL.String(), err)
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image size could not be determined: " + err.Error())}
+	}
+
+	size := manifest.size()
+	if size > plugin.maxImageSize {
+		request.logln("[DENIED] Image size:", request.image.name(), units.HumanSize(float64(size)), reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image size " + units.HumanSize(float64(size)) + " exceeds the maximum image size " + units.HumanSize(float64(plugin.maxImageSize)))}
+	}
+
+	return authorization.Response{Allow: true}
+}
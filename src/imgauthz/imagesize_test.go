@@ -0,0 +1,76 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Registry serving the manifests of the images by name, and failing for the other images
+type staticRegistry map[string]*imageManifest
+
+func (registry staticRegistry) getManifest(ref imageReference) (*imageManifest, error) {
+	if manifest, ok := registry[ref.name()]; ok {
+		return manifest, nil
+	}
+	return nil, errors.New("manifest unknown")
+}
+
+// Returns the manifest of an image with the given config and layer sizes
+func sizedManifest(config int64, layers ...int64) *imageManifest {
+	manifest := &imageManifest{Config: descriptor{Size: config}}
+	for _, size := range layers {
+		manifest.Layers = append(manifest.Layers, descriptor{Size: size})
+	}
+	return manifest
+}
+
+// Returns the policy of the plugin configuration, with the manifests served by the registry
+func registryPolicy(t *testing.T, registry manifestClient, config pluginConfig) *Policy {
+	t.Helper()
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin.manifests = registry
+	return &Policy{plugin: plugin}
+}
+
+func TestMaximumImageSize(t *testing.T) {
+	registry := staticRegistry{
+		"docker.io/library/small": sizedManifest(100, 400, 500),
+		"docker.io/library/exact": sizedManifest(24, 1000),
+		"docker.io/library/large": sizedManifest(1, 1024),
+	}
+	for _, test := range []struct {
+		image            string
+		allowUnknownSize bool
+		allowed          bool
+		msg              string
+	}{
+		{"small:1.0", false, true, ""},
+		// The config counts along with the layers
+		{"exact:1.0", false, true, ""},
+		{"large:1.0", false, false, "exceeds the maximum image size"},
+		{"unknown:1.0", false, false, "The image size could not be determined: manifest unknown"},
+		{"unknown:1.0", true, true, ""},
+	} {
+		policy := registryPolicy(t, registry, pluginConfig{registries: []string{"docker.io"}, maxImageSize: 1024, allowUnknownSize: test.allowUnknownSize})
+		response := policy.AuthorizePull(test.image)
+		if response.Allow != test.allowed || !strings.Contains(response.Msg, test.msg) {
+			t.Errorf("pull of %s (allow unknown size %v): allowed %v (%s)", test.image, test.allowUnknownSize, response.Allow, response.Msg)
+		}
+	}
+
+	// Runs are not checked, the images were checked when pulled
+	policy := registryPolicy(t, registry, pluginConfig{registries: []string{"docker.io"}, maxImageSize: 1024})
+	if response := policy.AuthorizeRun("large:1.0"); !response.Allow {
+		t.Errorf("run of large:1.0: %s", response.Msg)
+	}
+}
@@ -0,0 +1,129 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"context"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Duration for which the insecure registries of the docker daemon are cached, as they only change
+	// when the docker daemon is reconfigured
+	insecureRegistriesTTL = time.Minute
+	// Timeout for a single docker daemon info query
+	clientInfoTimeout = 10 * time.Second
+)
+
+// Insecure registries of the docker daemon, i.e. reached over plain HTTP or with unverified certificates,
+// as configured with the insecure-registries option of the daemon
+type insecureRegistries struct {
+	// Registries configured as insecure by name, as registry[:port]
+	registries map[string]bool
+	// Networks whose registries are all insecure, e.g. 127.0.0.0/8 by default
+	networks []*net.IPNet
+}
+
+// Returns the insecure registries of the docker daemon info
+func newInsecureRegistries(info dockertypes.Info) insecureRegistries {
+	insecure := insecureRegistries{registries: map[string]bool{}}
+	if info.RegistryConfig == nil {
+		return insecure
+	}
+	for name, index := range info.RegistryConfig.IndexConfigs {
+		if index != nil && !index.Secure {
+			insecure.registries[normalizeRegistryHost(name)] = true
+		}
+	}
+	for _, network := range info.RegistryConfig.InsecureRegistryCIDRs {
+		if network != nil {
+			insecure.networks = append(insecure.networks, (*net.IPNet)(network))
+		}
+	}
+	return insecure
+}
+
+// Returns true if the registry is insecure: configured as insecure by name, or with an IP address
+// (or a host resolving to an IP address) within an insecure network, as for the docker daemon
+func (insecure insecureRegistries) contains(registry string) bool {
+	if insecure.registries[registry] {
+		return true
+	}
+	if len(insecure.networks) == 0 {
+		return false
+	}
+
+	host, _ := splitRegistryPort(registry)
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		// As for the docker daemon, hosts which cannot be resolved are not insecure
+		ips, _ = net.LookupIP(host)
+	}
+	for _, ip := range ips {
+		for _, network := range insecure.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the insecure registries of the docker daemon, cached for insecureRegistriesTTL
+func (conn *dockerConnection) insecureRegistries() (insecureRegistries, error) {
+	conn.infoLock.Lock()
+	defer conn.infoLock.Unlock()
+	if conn.insecure != nil && time.Since(conn.insecureFetched) < insecureRegistriesTTL {
+		return *conn.insecure, nil
+	}
+
+	client, err := conn.get()
+	if err != nil {
+		return insecureRegistries{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clientInfoTimeout)
+	defer cancel()
+	info, err := client.Info(ctx)
+	if err != nil {
+		conn.reportError(err)
+		return insecureRegistries{}, err
+	}
+	insecure := newInsecureRegistries(info)
+	conn.insecure = &insecure
+	conn.insecureFetched = time.Now()
+	return insecure, nil
+}
+
+// Returns the registry the image is actually pulled from: the mirror, if the image is requested
+// through a mirror prefix, rather than its upstream registry
+func (ref imageReference) pulledRegistry() string {
+	if len(ref.mirror) > 0 {
+		return strings.SplitN(ref.mirror, "/", 2)[0]
+	}
+	return ref.registry
+}
+
+// Authorizes the registry of a pull or a run against the insecure registries of the docker daemon,
+// if they are denied
+func (plugin *ImgAuthZPlugin) authorizeSecureRegistry(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if !plugin.denyInsecure {
+		return authorization.Response{Allow: true}
+	}
+	insecure, err := plugin.docker.insecureRegistries()
+	if err != nil {
+		return plugin.errorResponse(request, reqURL, err)
+	}
+	registry := request.image.pulledRegistry()
+	if insecure.contains(registry) {
+		request.logln("[DENIED] Insecure registry:", registry, request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The registry " + registry + " is configured as insecure on the docker daemon")}
+	}
+	return authorization.Response{Allow: true}
+}
@@ -0,0 +1,49 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// Authorizes a docker run command against the repo tags and digests of the local image, as resolved
+// by the docker daemon, rather than against the requested reference. The command is allowed if any of
+// them is authorized, or only if all of them are as configured. If the image is not local (yet),
+// the requested reference is authorized instead.
+func (plugin *ImgAuthZPlugin) authorizeResolvedImage(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	references, found, err := plugin.docker.imageReferences(request.rawImage)
+	if err != nil {
+		return plugin.errorResponse(request, reqURL, err)
+	}
+	if !found {
+		plugin.debugln(request, "[INSPECT] Image not found locally:", request.rawImage)
+		return plugin.authorizeRegistryRequest(req, reqURL, request)
+	}
+	if len(references) == 0 {
+		request.logln("[DENIED] Image without repo tags or digests:", request.rawImage, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image has no repo tags or digests to authorize")}
+	}
+
+	for _, reference := range references {
+		resolved := request
+		resolved.image = plugin.parseReference(reference)
+		plugin.debugln(request, "[INSPECT] Resolved image:", request.rawImage, "as", resolved.image)
+		response := plugin.authorizeRegistryRequest(req, reqURL, resolved)
+		if response.Allow && !plugin.inspectMatchAll {
+			return response
+		}
+		if !response.Allow && plugin.inspectMatchAll {
+			request.logln("[DENIED] Resolved image not authorized:", request.rawImage, "as", resolved.image, req.RequestMethod, reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("All the image tags and digests must be authorized, " + reference + " is not")}
+		}
+	}
+	if plugin.inspectMatchAll {
+		return authorization.Response{Allow: true}
+	}
+
+	request.logln("[DENIED] None of the resolved images is authorized:", request.rawImage, strings.Join(references, ", "), req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg("None of the image tags or digests is authorized: " + strings.Join(references, ", "))}
+}
@@ -0,0 +1,108 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// Label required in the image config, with any value if the value is empty
+type requiredLabel struct {
+	key   string
+	value string
+}
+
+// Returns the required label as <key>[=<value>]
+func (label requiredLabel) String() string {
+	if len(label.value) == 0 {
+		return label.key
+	}
+	return label.key + "=" + label.value
+}
+
+// Returns the required labels, given as <key> or <key>=<value>, e.g. org.opencontainers.image.source
+// or team=payments
+func parseRequiredLabels(specs []string) ([]requiredLabel, error) {
+	labels := make([]requiredLabel, 0, len(specs))
+	for _, spec := range specs {
+		fields := strings.SplitN(spec, "=", 2)
+		label := requiredLabel{key: strings.TrimSpace(fields[0])}
+		if len(fields) == 2 {
+			label.value = fields[1]
+		}
+		if len(label.key) == 0 || (len(fields) == 2 && len(label.value) == 0) {
+			return nil, fmt.Errorf("invalid --require-label value: %s (expected <key> or <key>=<value>)", spec)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// Fetches the labels of the images from the registries
+type labelFetcher interface {
+	// Returns the labels of the image config for the platform of the host
+	getLabels(ref imageReference) (map[string]string, error)
+}
+
+// Returns the labels of the image config, as per the manifest of the image for the platform of the host
+func (registry *registryClient) getLabels(ref imageReference) (map[string]string, error) {
+	manifest, err := registry.getManifest(ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Config.Digest) == 0 {
+		return nil, fmt.Errorf("the manifest has no image config")
+	}
+
+	host, repository := ref.registryHost()
+	data, _, err := registry.fetchRaw(registryBlobURL(host, repository, manifest.Config.Digest), manifest.Config.MediaType)
+	if err != nil {
+		return nil, fmt.Errorf("fetching image config %s: %v", manifest.Config.Digest, err)
+	}
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("decoding image config %s: %v", manifest.Config.Digest, err)
+	}
+	return config.Config.Labels, nil
+}
+
+// Authorizes a pulled image by the labels of its image config on the registry, if labels are required,
+// so that the labeling policy is enforced before the image is on disk. Images missing any required
+// label are denied. Images whose labels could not be fetched (e.g. the registry is unreachable or
+// requires credentials) are allowed or denied as per the on-error behavior.
+func (plugin *ImgAuthZPlugin) authorizeRequiredLabels(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.imageLabels == nil || request.command != pullCommand {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		labels, err := plugin.imageLabels.getLabels(request.image)
+		if err != nil {
+			if response, ok := plugin.degradedResponse(request, reqURL, "label check", err); ok {
+				return response
+			}
+			return plugin.errorResponse(request, reqURL, fmt.Errorf("label lookup failed: %v", err))
+		}
+
+		var missing []string
+		for _, label := range plugin.labelRules {
+			if value, ok := labels[label.key]; !ok || (len(label.value) > 0 && value != label.value) {
+				missing = append(missing, label.String())
+			}
+		}
+		if len(missing) > 0 {
+			request.logln("[DENIED] Missing labels:", request.image.String(), strings.Join(missing, ", "), reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The image is missing the required labels: " + strings.Join(missing, ", "))}
+		}
+		return authorization.Response{Allow: true}
+	})
+}
@@ -0,0 +1,61 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strconv"
+)
+
+// Authorizes the number of layers of an image, as per the image manifest on the registry for pulls
+// and the local image for runs. Images with more layers than the maximum are denied. Images whose
+// layer count is unknown (e.g. the registry is unreachable) are allowed or denied as configured.
+// Runs of images which are not local yet are authorized when they are pulled.
+func (plugin *ImgAuthZPlugin) authorizeLayerCount(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.maxLayers <= 0 {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		layers, known, err := plugin.layerCount(request)
+		if err != nil {
+			if request.command == pullCommand {
+				if response, ok := plugin.degradedResponse(request, reqURL, "layer count check", err); ok {
+					return response
+				}
+			}
+			request.markFailed()
+			if plugin.allowUnknownLayers {
+				request.logln("[ALLOWED] Unknown layer count:", request.image.name(), reqURL.String(), err)
+				return authorization.Response{Allow: true}
+			}
+			request.logln("[DENIED] Unknown layer count:", request.image.name(), reqURL.String(), err)
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The image layer count could not be determined: " + err.Error())}
+		}
+		if !known {
+			plugin.debugln(request, "[LAYERS] Image not found locally:", request.rawImage)
+			return authorization.Response{Allow: true}
+		}
+
+		if layers > plugin.maxLayers {
+			request.logln("[DENIED] Layer count:", request.image.name(), layers, reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The image has " + strconv.Itoa(layers) + " layers, exceeding the maximum of " + strconv.Itoa(plugin.maxLayers))}
+		}
+		return authorization.Response{Allow: true}
+	})
+}
+
+// Returns the number of layers of the image of the request, and false if a run image is not local
+func (plugin *ImgAuthZPlugin) layerCount(request registryRequest) (int, bool, error) {
+	if request.command == runCommand {
+		return plugin.docker.imageLayerCount(request.rawImage)
+	}
+
+	manifest, err := plugin.manifests.getManifest(request.image)
+	if err != nil {
+		return 0, false, err
+	}
+	return len(manifest.Layers), true, nil
+}
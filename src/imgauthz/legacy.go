@@ -0,0 +1,58 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"log"
+	"strings"
+)
+
+// Migrates the policy entries written for the legacy image name parsing, where the image names
+// without a registry host had the library registry (e.g. library/alpine) or their dockerhub
+// namespace as registry (e.g. user/app). Such names are now in the dockerhub registry
+// (e.g. docker.io/library/alpine and docker.io/user/app).
+func (config *pluginConfig) migrateLegacyEntries() {
+	config.registries = migrateLegacyRegistries(config.registries, "--registry")
+	config.denyRegistries = migrateLegacyRegistries(config.denyRegistries, "--deny-registry")
+	config.images = migrateLegacyImages(config.images, "--image")
+	config.denyImages = migrateLegacyImages(config.denyImages, "--deny-image")
+	config.alwaysAllow = migrateLegacyImages(config.alwaysAllow, "--always-allow")
+
+	for i, window := range config.registryWindows {
+		fields := strings.SplitN(window, ",", 2)
+		if migrated := migrateLegacyRegistries(fields[:1], "--registry-window"); migrated[0] != fields[0] {
+			config.registryWindows[i] = strings.Join(append(migrated, fields[1:]...), ",")
+		}
+	}
+}
+
+// Migrates the exact registry entries which are dockerhub namespaces (e.g. library) to the dockerhub registry.
+// The migrated entries match all the dockerhub images, not only the ones of the namespace.
+func migrateLegacyRegistries(entries []string, option string) []string {
+	migrated := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !isPattern(entry) && !isRegistryHost(entry) {
+			log.Println("[DEPRECATED]", option, entry, "is a dockerhub namespace, not a registry: migrated to",
+				dockerHubRegistry, "which matches all the dockerhub images. Use --image "+dockerHubRegistry+"/"+entry+"/* to restrict to the namespace.")
+			entry = dockerHubRegistry
+		}
+		migrated = append(migrated, entry)
+	}
+	return migrated
+}
+
+// Migrates the image entries without a registry host (e.g. library/alpine) to the dockerhub registry
+// (e.g. docker.io/library/alpine). Entries whose first component is a pattern are not migrated.
+func migrateLegacyImages(entries []string, option string) []string {
+	migrated := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		idx := strings.Index(entry, "/")
+		if idx != -1 && !strings.HasPrefix(entry, regexPrefix) && !isPattern(entry[0:idx]) && !isRegistryHost(entry[0:idx]) {
+			log.Println("[DEPRECATED]", option, entry, "has no registry host: migrated to", dockerHubRegistry+"/"+entry)
+			entry = dockerHubRegistry + "/" + entry
+		}
+		migrated = append(migrated, entry)
+	}
+	return migrated
+}
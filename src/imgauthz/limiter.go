@@ -0,0 +1,64 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+)
+
+// Limits the number of concurrent expensive checks (e.g. registry manifest fetches or image inspects),
+// so that a burst of docker commands does not overwhelm the plugin and the services it calls.
+// Static matching is never limited.
+type checkLimiter struct {
+	// Semaphore, holding one token per running check
+	slots chan struct{}
+	// Wait for a free slot when the limit is reached, instead of denying the request
+	queue bool
+}
+
+// Create a new check limiter. Returns nil, i.e. no limit, if the maximum is not positive.
+func newCheckLimiter(max int, queue bool) *checkLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &checkLimiter{slots: make(chan struct{}, max), queue: queue}
+}
+
+// Acquires a slot for a check, waiting for a free slot if queueing.
+// Returns false if the limit is reached and the check must not run.
+func (limiter *checkLimiter) acquire() bool {
+	if limiter == nil {
+		return true
+	}
+	if limiter.queue {
+		limiter.slots <- struct{}{}
+		return true
+	}
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Releases the slot of a check
+func (limiter *checkLimiter) release() {
+	if limiter != nil {
+		<-limiter.slots
+	}
+}
+
+// Runs an expensive check within the concurrency limit.
+// If the limit is reached and requests are not queued, the request is denied without running the check.
+func (plugin *ImgAuthZPlugin) limitedCheck(reqURL *url.URL, request registryRequest, check func() authorization.Response) authorization.Response {
+	if !plugin.checks.acquire() {
+		request.markFailed()
+		request.logln("[DENIED] Too many concurrent checks:", request.image.name(), reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("Too many concurrent authorization checks, please retry later")}
+	}
+	defer plugin.checks.release()
+	return check()
+}
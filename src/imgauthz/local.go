@@ -0,0 +1,135 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"context"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeout for resolving a registry host to its addresses
+const registryLookupTimeout = 5 * time.Second
+
+// Host names and addresses of the cloud instance metadata services which are not link-local,
+// e.g. the IPv6 endpoint of the AWS instance metadata service
+var metadataHosts = map[string]bool{
+	"metadata.google.internal": true,
+	"fd00:ec2::254":            true,
+}
+
+// Returns true if the address is an address of the host itself (loopback or unspecified, i.e. 0.0.0.0
+// which reaches the host on Linux) or of its link (link-local, e.g. the 169.254.169.254 instance
+// metadata service), including the IPv4-mapped IPv6 addresses of these
+func isLocalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		metadataHosts[ip.String()]
+}
+
+// Returns the IPv4 address of a host given in one of the legacy numeric forms accepted by inet_aton,
+// e.g. 127.1, 2130706433, 0x7f000001 or 0177.0.0.1, or nil for other hosts
+func parseLegacyIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseUint(part, 0, 32)
+		if err != nil || len(part) == 0 {
+			return nil
+		}
+		values[i] = value
+	}
+
+	// The last part fills the remaining bytes, e.g. 127.1 is 127.0.0.1
+	var address uint64
+	for i, value := range values[:len(values)-1] {
+		if value > 0xff {
+			return nil
+		}
+		address |= value << uint(24-8*i)
+	}
+	last := values[len(values)-1]
+	if last >= 1<<uint(32-8*(len(values)-1)) {
+		return nil
+	}
+	address |= last
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address))
+}
+
+// Returns the address of a registry host given as an IP address, in any form accepted by the resolvers,
+// e.g. [::1], [fe80::1%eth0] or 127.1, or nil for a host name
+func registryIP(host string) net.IP {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if idx := strings.Index(host, "%"); idx != -1 {
+		host = host[0:idx]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	return parseLegacyIPv4(host)
+}
+
+// Returns true if the registry host is the docker host itself or on its link: a loopback, unspecified or
+// link-local address, localhost, or a host name resolving to any such address. Host names which cannot
+// be resolved are not local, as the docker daemon cannot pull from them either.
+func (plugin *ImgAuthZPlugin) isLocalRegistry(registry string) bool {
+	host, _ := splitRegistryPort(registry)
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := registryIP(host); ip != nil {
+		return isLocalAddress(ip)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || metadataHosts[host] {
+		return true
+	}
+
+	ips, _ := plugin.lookupHost(host)
+	for _, ip := range ips {
+		if isLocalAddress(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the addresses of the host, as resolved by the system resolver
+func lookupRegistryHost(host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addresses))
+	for i, address := range addresses {
+		ips[i] = address.IP
+	}
+	return ips, nil
+}
+
+// Authorizes the registry a pull, a run or a registry query contacts against the addresses of the docker
+// host, if local registries are denied, so that crafted references cannot probe the services of the host
+// (e.g. localhost:2375) or the instance metadata service through the docker daemon. The exact registry
+// entries and the mirrors are explicitly allowed, e.g. --registry localhost:5000.
+func (plugin *ImgAuthZPlugin) authorizeLocalRegistry(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if !plugin.denyLocal || len(request.image.mirror) > 0 {
+		return authorization.Response{Allow: true}
+	}
+	registry := request.image.pulledRegistry()
+	for _, name := range plugin.registryNames(registry) {
+		if plugin.authorizedRegistries.exact[name] {
+			return authorization.Response{Allow: true}
+		}
+	}
+	if !plugin.isLocalRegistry(registry) {
+		return authorization.Response{Allow: true}
+	}
+	request.logln("[DENIED] Local registry:", registry, request.image.name(), req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg("The registry " + registry + " is a local address of the docker host")}
+}
@@ -0,0 +1,167 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+)
+
+// Verdict of a matcher on a registry command
+type Verdict int
+
+const (
+	// No opinion: the next matcher decides
+	Abstain Verdict = iota
+	// The registry command is allowed, and the next matchers are not consulted
+	Allow
+	// The registry command is denied, and the next matchers are not consulted
+	Deny
+)
+
+// Registry command, as seen by the matchers. The image reference is normalized,
+// e.g. alpine is Registry docker.io, Repository library/alpine and Tag latest.
+type MatchRequest struct {
+	// Type of the command: pull, run, commit or distribution
+	Command    string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+	// User of the docker client, if authenticated
+	User string
+
+	// Request, for the built-in matchers
+	req     authorization.Request
+	reqURL  *url.URL
+	request registryRequest
+}
+
+// Verdict of a matcher, along with the reason of a denial, appended to the denial message
+type MatchResult struct {
+	Verdict Verdict
+	Reason  string
+
+	// Response of the built-in matchers, which word their own denial messages,
+	// and the log line of an abstention, logged if no other matcher decides
+	response     *authorization.Response
+	abstainedLog []interface{}
+}
+
+// Decides on the registry commands, e.g. by querying an inventory of the approved images.
+// The matchers are consulted in order, after the built-in allowlist matcher, until one allows
+// or denies the command. Commands on which all the matchers abstain are denied.
+type Matcher interface {
+	// Returns the name of the matcher, logged along with its decisions
+	Name() string
+	// Returns the verdict of the matcher on the registry command
+	Match(request MatchRequest) MatchResult
+}
+
+// Matchers registered with RegisterMatcher, consulted after the built-in allowlist matcher
+var registeredMatchers []Matcher
+
+// Registers a matcher compiled into the plugin, from the init function of its source file, e.g.
+//
+//	func init() {
+//		RegisterMatcher(&inventoryMatcher{})
+//	}
+//
+// Matchers are consulted in registration order, i.e. in source file name order.
+func RegisterMatcher(matcher Matcher) {
+	registeredMatchers = append(registeredMatchers, matcher)
+}
+
+// Built-in matcher authorizing the registry commands against the configured registries and images
+type allowlistMatcher struct {
+	plugin *ImgAuthZPlugin
+}
+
+// Returns the name of the allowlist matcher
+func (matcher *allowlistMatcher) Name() string {
+	return "allowlist"
+}
+
+// Authorizes the registry command against the authorized registries and images
+func (matcher *allowlistMatcher) Match(request MatchRequest) MatchResult {
+	return matcher.plugin.matchAllowlist(request.req, request.reqURL, request.request)
+}
+
+// Returns the result of a built-in matcher allowing or denying the command as per the response
+func decided(response authorization.Response) MatchResult {
+	if response.Allow {
+		return MatchResult{Verdict: Allow, response: &response}
+	}
+	return MatchResult{Verdict: Deny, response: &response}
+}
+
+// Returns the result of a built-in matcher abstaining, with the response and the log line
+// applying if no other matcher decides
+func abstained(response authorization.Response, v ...interface{}) MatchResult {
+	return MatchResult{Verdict: Abstain, response: &response, abstainedLog: v}
+}
+
+// Returns the matchers of the plugin: the built-in allowlist matcher, the built-in CEL matcher if CEL rules
+// are set, the built-in signed-by matcher if identities are trusted, the built-in remote matcher if a remote
+// policy is set, then the registered ones
+func (plugin *ImgAuthZPlugin) matcherChain() []Matcher {
+	matchers := []Matcher{&allowlistMatcher{plugin: plugin}}
+	if len(plugin.cel) > 0 {
+		matchers = append(matchers, &celMatcher{plugin: plugin})
+	}
+	if plugin.signatures != nil {
+		matchers = append(matchers, &signedByMatcher{plugin: plugin})
+	}
+	if plugin.remote != nil {
+		matchers = append(matchers, &remoteMatcher{plugin: plugin})
+	}
+	return append(matchers, registeredMatchers...)
+}
+
+// Authorizes a registry command with the matcher chain: the first matcher allowing or denying
+// the command decides. If all the matchers abstain, the command is denied.
+func (plugin *ImgAuthZPlugin) authorizeRegistryRequest(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	matchRequest := MatchRequest{
+		Command:    request.command,
+		Registry:   request.image.registry,
+		Repository: request.image.repository,
+		Tag:        request.image.tag,
+		Digest:     request.image.digest,
+		User:       req.User,
+		req:        req,
+		reqURL:     reqURL,
+		request:    request}
+	if len(matchRequest.Tag) == 0 && len(matchRequest.Digest) == 0 {
+		matchRequest.Tag = "latest"
+	}
+
+	var abstention *MatchResult
+	for _, matcher := range plugin.matchers {
+		result := matcher.Match(matchRequest)
+		if result.Verdict == Abstain {
+			if abstention == nil && result.response != nil {
+				abstention = &result
+			}
+			continue
+		}
+		if result.response != nil {
+			return *result.response
+		}
+
+		if result.Verdict == Allow {
+			request.logln("[ALLOWED] Matcher", matcher.Name()+":", request.image, req.RequestMethod, reqURL.String())
+			return authorization.Response{Allow: true}
+		}
+		request.logln("[DENIED] Matcher", matcher.Name()+":", request.image, result.Reason, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg(result.Reason)}
+	}
+
+	// No matcher decided
+	if abstention != nil {
+		request.logln(abstention.abstainedLog...)
+		return *abstention.response
+	}
+	request.logln("[DENIED] No matcher authorized the image:", request.image, req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg("The image is not authorized")}
+}
@@ -0,0 +1,77 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Matcher which never decides, only observing the registry commands left to the matchers
+type observingMatcher struct {
+	seen []string
+}
+
+func (matcher *observingMatcher) Name() string {
+	return "observing"
+}
+
+func (matcher *observingMatcher) Match(request MatchRequest) MatchResult {
+	matcher.seen = append(matcher.seen, request.Command+" "+request.Registry+"/"+request.Repository+":"+request.Tag)
+	return MatchResult{Verdict: Abstain}
+}
+
+// Matcher deciding on the images of its registry as per an inventory of the approved images
+type inventoryMatcher struct {
+	approved map[string]bool
+}
+
+func (matcher *inventoryMatcher) Name() string {
+	return "inventory"
+}
+
+func (matcher *inventoryMatcher) Match(request MatchRequest) MatchResult {
+	if request.Registry != "quay.io" {
+		return MatchResult{Verdict: Abstain}
+	}
+	if matcher.approved[request.Repository+":"+request.Tag] {
+		return MatchResult{Verdict: Allow}
+	}
+	return MatchResult{Verdict: Deny, Reason: "The image is not in the inventory"}
+}
+
+func TestMatchersAreConsultedInOrderUntilOneDecides(t *testing.T) {
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, pluginConfig{registries: []string{"docker.io"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	observing := &observingMatcher{}
+	plugin.matchers = append(plugin.matchers, observing, &inventoryMatcher{approved: map[string]bool{"team/app:1.0": true}})
+
+	for _, test := range []struct {
+		uri     string
+		allowed bool
+		reason  string
+	}{
+		// Decided by the allowlist matcher, before the registered matchers
+		{"/images/create?fromImage=alpine&tag=3.19", true, ""},
+		// Decided by the inventory matcher
+		{"/images/create?fromImage=quay.io/team/app&tag=1.0", true, ""},
+		{"/images/create?fromImage=quay.io/team/app&tag=2.0", false, "The image is not in the inventory"},
+		// All the matchers abstain, and the denial of the allowlist matcher applies
+		{"/images/create?fromImage=ghcr.io/team/app&tag=1.0", false, "You can only use docker images from the following authorized registries"},
+	} {
+		response := plugin.AuthZReq(authorization.Request{RequestMethod: "POST", RequestURI: test.uri})
+		if response.Allow != test.allowed || !strings.Contains(response.Msg, test.reason) {
+			t.Errorf("%s: allowed %v (%s), expected %v (%s)", test.uri, response.Allow, response.Msg, test.allowed, test.reason)
+		}
+	}
+
+	seen := []string{"pull quay.io/team/app:1.0", "pull quay.io/team/app:2.0", "pull ghcr.io/team/app:1.0"}
+	if !reflect.DeepEqual(observing.seen, seen) {
+		t.Errorf("observed %v, expected %v", observing.seen, seen)
+	}
+}
@@ -0,0 +1,241 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry label of the decisions of registries which are not configured, so that the
+// label cardinality is bounded by the policy and not by the requests
+const otherRegistryLabel = "other"
+
+// Labels of the authorization decision counters
+type decisionLabels struct {
+	command  string
+	registry string
+	decision string
+}
+
+// Results of the decision events of the decision stream
+const (
+	streamPublished = "published"
+	streamDropped   = "dropped"
+	streamFailed    = "failed"
+)
+
+// Labels of the decision stream event counters
+type streamLabels struct {
+	sink   string
+	result string
+}
+
+// Paths of the authorization requests, whose latencies are observed separately: the registry commands
+// whose image checks were answered by the decision cache, the other registry commands, and the
+// requests which are not registry commands
+const (
+	latencyCached    = "cached"
+	latencyEvaluated = "evaluated"
+	latencyOther     = "other"
+)
+
+// Upper bounds of the latency histogram buckets, in seconds: from the policy rules evaluated in memory
+// to the image checks querying the registries
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram of the latencies of a request path
+type latencyHistogram struct {
+	// Number of observations of each bucket, not cumulative, the last one for the observations above all the bounds
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// Records a latency, in seconds
+func (histogram *latencyHistogram) observe(seconds float64) {
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+	histogram.counts[bucket]++
+	histogram.sum += seconds
+	histogram.count++
+}
+
+// Plugin metrics, exposed in the Prometheus text format on /metrics
+type pluginMetrics struct {
+	sync.Mutex
+	// Total number of policy reloads, successful or not
+	reloads int64
+	// Total number of failed policy reloads
+	reloadFailures int64
+	// Time and number of rules of the last successfully loaded policy
+	lastReloadTime  time.Time
+	lastReloadRules int
+	// Plugin version and build, and start time
+	version   string
+	build     string
+	startTime time.Time
+	// Total number of registry command decisions
+	decisions map[decisionLabels]int64
+	// Total number of decision events streamed, dropped or lost per sink
+	streams map[streamLabels]int64
+	// Latencies of the authorization requests, per request path
+	latencies map[string]*latencyHistogram
+}
+
+// Create new plugin metrics
+func newPluginMetrics(version string, build string) *pluginMetrics {
+	metrics := &pluginMetrics{
+		version:   version,
+		build:     build,
+		startTime: time.Now(),
+		decisions: make(map[decisionLabels]int64),
+		streams:   make(map[streamLabels]int64),
+		latencies: make(map[string]*latencyHistogram)}
+	for _, path := range []string{latencyCached, latencyEvaluated, latencyOther} {
+		metrics.latencies[path] = &latencyHistogram{counts: make([]int64, len(latencyBuckets)+1)}
+	}
+	return metrics
+}
+
+// Records the latency of an authorization request of the path (latencyCached, latencyEvaluated or latencyOther)
+func (metrics *pluginMetrics) observed(path string, latency time.Duration) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.latencies[path].observe(latency.Seconds())
+}
+
+// Records the decision of a registry command. The registry label must be a configured registry
+// or otherRegistryLabel.
+func (metrics *pluginMetrics) decided(command string, registry string, allowed bool) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	decision := "denied"
+	if allowed {
+		decision = "allowed"
+	}
+	metrics.decisions[decisionLabels{command: command, registry: registry, decision: decision}]++
+}
+
+// Records decision events of the decision stream to the sink: published, dropped (i.e. not buffered)
+// or failed (i.e. lost by the sink)
+func (metrics *pluginMetrics) streamed(sink string, result string, events int) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.streams[streamLabels{sink: sink, result: result}] += int64(events)
+}
+
+// Records a policy load. The initial policy load at startup is not counted as a reload.
+func (metrics *pluginMetrics) policyLoaded(reload bool, rules int, err error) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if reload {
+		metrics.reloads++
+		if err != nil {
+			metrics.reloadFailures++
+		}
+	}
+	if err == nil {
+		metrics.lastReloadTime = time.Now()
+		metrics.lastReloadRules = rules
+	}
+}
+
+// Writes the metrics in the Prometheus text format
+func (metrics *pluginMetrics) write(w io.Writer) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	fmt.Fprintln(w, "# HELP img_authz_policy_reloads_total Total number of policy reloads.")
+	fmt.Fprintln(w, "# TYPE img_authz_policy_reloads_total counter")
+	fmt.Fprintln(w, "img_authz_policy_reloads_total", metrics.reloads)
+	fmt.Fprintln(w, "# HELP img_authz_policy_reload_failures_total Total number of failed policy reloads.")
+	fmt.Fprintln(w, "# TYPE img_authz_policy_reload_failures_total counter")
+	fmt.Fprintln(w, "img_authz_policy_reload_failures_total", metrics.reloadFailures)
+	fmt.Fprintln(w, "# HELP img_authz_policy_last_reload_timestamp_seconds Time of the last successful policy load.")
+	fmt.Fprintln(w, "# TYPE img_authz_policy_last_reload_timestamp_seconds gauge")
+	fmt.Fprintln(w, "img_authz_policy_last_reload_timestamp_seconds", metrics.lastReloadTime.Unix())
+	fmt.Fprintln(w, "# HELP img_authz_policy_rules Number of rules of the last successfully loaded policy.")
+	fmt.Fprintln(w, "# TYPE img_authz_policy_rules gauge")
+	fmt.Fprintln(w, "img_authz_policy_rules", metrics.lastReloadRules)
+	fmt.Fprintln(w, "# HELP img_authz_build_info Version and build of the plugin.")
+	fmt.Fprintln(w, "# TYPE img_authz_build_info gauge")
+	fmt.Fprintf(w, "img_authz_build_info{version=%s,build=%s} 1\n", labelValue(metrics.version), labelValue(metrics.build))
+	fmt.Fprintln(w, "# HELP img_authz_start_time_seconds Start time of the plugin.")
+	fmt.Fprintln(w, "# TYPE img_authz_start_time_seconds gauge")
+	fmt.Fprintln(w, "img_authz_start_time_seconds", metrics.startTime.Unix())
+	fmt.Fprintln(w, "# HELP img_authz_decisions_total Total number of registry command decisions since start.")
+	fmt.Fprintln(w, "# TYPE img_authz_decisions_total counter")
+
+	labels := make([]decisionLabels, 0, len(metrics.decisions))
+	for label := range metrics.decisions {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].command != labels[j].command {
+			return labels[i].command < labels[j].command
+		}
+		if labels[i].registry != labels[j].registry {
+			return labels[i].registry < labels[j].registry
+		}
+		return labels[i].decision < labels[j].decision
+	})
+	for _, label := range labels {
+		fmt.Fprintf(w, "img_authz_decisions_total{command=%s,registry=%s,decision=%s} %d\n",
+			labelValue(label.command), labelValue(label.registry), labelValue(label.decision), metrics.decisions[label])
+	}
+
+	fmt.Fprintln(w, "# HELP img_authz_request_duration_seconds Latency of the authorization requests, by request path.")
+	fmt.Fprintln(w, "# TYPE img_authz_request_duration_seconds histogram")
+	for _, path := range []string{latencyCached, latencyEvaluated, latencyOther} {
+		histogram := metrics.latencies[path]
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += histogram.counts[i]
+			fmt.Fprintf(w, "img_authz_request_duration_seconds_bucket{path=%s,le=%s} %d\n",
+				labelValue(path), labelValue(strconv.FormatFloat(bound, 'g', -1, 64)), cumulative)
+		}
+		fmt.Fprintf(w, "img_authz_request_duration_seconds_bucket{path=%s,le=\"+Inf\"} %d\n", labelValue(path), histogram.count)
+		fmt.Fprintf(w, "img_authz_request_duration_seconds_sum{path=%s} %s\n", labelValue(path), strconv.FormatFloat(histogram.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "img_authz_request_duration_seconds_count{path=%s} %d\n", labelValue(path), histogram.count)
+	}
+
+	if len(metrics.streams) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP img_authz_stream_events_total Total number of decision events of the decision stream, by result.")
+	fmt.Fprintln(w, "# TYPE img_authz_stream_events_total counter")
+	streams := make([]streamLabels, 0, len(metrics.streams))
+	for label := range metrics.streams {
+		streams = append(streams, label)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].sink != streams[j].sink {
+			return streams[i].sink < streams[j].sink
+		}
+		return streams[i].result < streams[j].result
+	})
+	for _, label := range streams {
+		fmt.Fprintf(w, "img_authz_stream_events_total{sink=%s,result=%s} %d\n", labelValue(label.sink), labelValue(label.result), metrics.streams[label])
+	}
+}
+
+// Returns the quoted and escaped label value, as per the Prometheus text format
+func labelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return `"` + value + `"`
+}
+
+// Serves the metrics on /metrics
+func (metrics *pluginMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.write(w)
+}
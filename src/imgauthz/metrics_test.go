@@ -0,0 +1,81 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramBuckets(t *testing.T) {
+	metrics := newPluginMetrics("", "")
+	for _, latency := range []time.Duration{time.Millisecond, 3 * time.Millisecond, 20 * time.Second} {
+		metrics.observed(latencyEvaluated, latency)
+	}
+
+	var output bytes.Buffer
+	metrics.write(&output)
+	for _, expected := range []string{
+		`# TYPE img_authz_request_duration_seconds histogram`,
+		// The buckets are cumulative, their bounds inclusive
+		`img_authz_request_duration_seconds_bucket{path="evaluated",le="0.0005"} 0`,
+		`img_authz_request_duration_seconds_bucket{path="evaluated",le="0.001"} 1`,
+		`img_authz_request_duration_seconds_bucket{path="evaluated",le="0.005"} 2`,
+		`img_authz_request_duration_seconds_bucket{path="evaluated",le="10"} 2`,
+		`img_authz_request_duration_seconds_bucket{path="evaluated",le="+Inf"} 3`,
+		`img_authz_request_duration_seconds_sum{path="evaluated"} 20.004`,
+		`img_authz_request_duration_seconds_count{path="evaluated"} 3`,
+		// The paths without any request are reported too
+		`img_authz_request_duration_seconds_count{path="cached"} 0`,
+		`img_authz_request_duration_seconds_count{path="other"} 0`,
+	} {
+		if !strings.Contains(output.String(), expected+"\n") {
+			t.Errorf("%s not found in\n%s", expected, output.String())
+		}
+	}
+}
+
+func TestLatenciesAreObservedByRequestPath(t *testing.T) {
+	metrics := newPluginMetrics("", "")
+	config := pluginConfig{registries: []string{"docker.io"}, maxLayers: 5, cacheTTL: time.Minute}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, metrics, newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin.manifests = &flakyRegistry{layers: 2}
+	policy := &Policy{plugin: plugin}
+
+	for _, test := range []struct {
+		request authorization.Request
+		path    string
+	}{
+		{pullRequest("alpine:3.19"), latencyEvaluated},
+		{pullRequest("alpine:3.19"), latencyCached},
+		{pullRequest("alpine:3.19"), latencyCached},
+		// Denied before the image checks
+		{pullRequest("evil.io/app:1.0"), latencyEvaluated},
+		{authorization.Request{RequestMethod: "GET", RequestURI: "/containers/json"}, latencyOther},
+	} {
+		counts := make(map[string]int64)
+		for path, histogram := range metrics.latencies {
+			counts[path] = histogram.count
+		}
+		policy.Authorize(test.request)
+		for path, histogram := range metrics.latencies {
+			expected := counts[path]
+			if path == test.path {
+				expected++
+			}
+			if histogram.count != expected {
+				t.Errorf("%s %s: %d %s latencies, expected %d", test.request.RequestMethod, test.request.RequestURI, histogram.count, path, expected)
+			}
+		}
+	}
+}
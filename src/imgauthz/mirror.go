@@ -0,0 +1,74 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mirror (or pull-through cache) of an upstream registry, e.g. mirror.corp.net for docker.io
+type mirrorPrefix struct {
+	// Prefix of the mirrored image names, as registry[/path], e.g. mirror.corp.net or mirror.corp.net/quay
+	prefix string
+	// Upstream registry[/path] replacing the prefix, e.g. docker.io or quay.io
+	upstream string
+}
+
+// Parses the mirror prefixes given as <mirror>[/<path>]=<upstream>[/<path>], e.g. mirror.corp.net=docker.io.
+// The prefixes are sorted longest first, so that the most specific prefix applies.
+func parseMirrorPrefixes(specs []string) ([]mirrorPrefix, error) {
+	mirrors := make([]mirrorPrefix, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || len(normalizeReferenceSeparators(parts[0])) == 0 || len(normalizeReferenceSeparators(parts[1])) == 0 {
+			return nil, fmt.Errorf("invalid --mirror-prefix value: %s (expected <mirror>=<upstream>, e.g. mirror.corp.net=docker.io)", spec)
+		}
+		mirrors = append(mirrors, mirrorPrefix{
+			prefix:   normalizeMirrorPrefix(parts[0]),
+			upstream: normalizeMirrorPrefix(parts[1])})
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		return len(mirrors[i].prefix) > len(mirrors[j].prefix)
+	})
+	return mirrors, nil
+}
+
+// Normalizes a registry[/path] prefix, with its registry host normalized as in the references
+func normalizeMirrorPrefix(prefix string) string {
+	prefix = normalizeReferenceSeparators(strings.TrimSpace(prefix))
+	if idx := strings.Index(prefix, "/"); idx != -1 {
+		return normalizeRegistryHost(prefix[0:idx]) + prefix[idx:]
+	}
+	return normalizeRegistryHost(prefix)
+}
+
+// Parses an image reference as requested by a docker client command, see parseImageReference.
+// References to a mirror are rewritten to their upstream registry, e.g. mirror.corp.net/library/ubuntu
+// is docker.io/library/ubuntu, so that the policy of the upstream registry applies.
+func (plugin *ImgAuthZPlugin) parseReference(image string) imageReference {
+	ref := parseImageReference(image, plugin.defaultRegistry)
+	name := ref.name()
+	for _, mirror := range plugin.mirrors {
+		if !strings.HasPrefix(name, mirror.prefix+"/") {
+			continue
+		}
+		upstream := parseImageReference(mirror.upstream+"/"+strings.TrimPrefix(name, mirror.prefix+"/"), plugin.defaultRegistry)
+		upstream.tag = ref.tag
+		upstream.digest = ref.digest
+		upstream.mirror = mirror.prefix
+		return upstream
+	}
+	return ref
+}
+
+// Returns the mirror prefixes, e.g. to list them in a denial message
+func (plugin *ImgAuthZPlugin) mirrorPrefixesAsString() string {
+	prefixes := make([]string, 0, len(plugin.mirrors))
+	for _, mirror := range plugin.mirrors {
+		prefixes = append(prefixes, mirror.prefix)
+	}
+	return strings.Join(prefixes, ", ")
+}
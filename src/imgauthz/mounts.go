@@ -0,0 +1,130 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Container create request body (i.e. /containers/create): the container configuration
+// along with the host configuration
+type containerCreateBody struct {
+	dockercontainer.Config
+	HostConfig struct {
+		// Bind mounts as <source>:<destination>[:<options>]
+		Binds []string
+		// Mounts, of which the bind mounts have a host path source, and the volume mounts may bind
+		// a host path device through the options of the local volume driver
+		Mounts []struct {
+			Type          string
+			Source        string
+			VolumeOptions *struct {
+				DriverConfig *struct {
+					Name    string
+					Options map[string]string
+				}
+			}
+		}
+		// Capabilities added to and dropped from the default ones, e.g. NET_ADMIN
+		CapAdd  strslice.StrSlice
+		CapDrop strslice.StrSlice
+		// Privileged container, i.e. with all the capabilities
+		Privileged bool
+	}
+}
+
+// Returns the host path device of a volume of the local volume driver (the default one), if any, e.g.
+// docker run --mount type=volume,dst=/host,volume-opt=type=none,volume-opt=o=bind,volume-opt=device=/
+// binds the host root directory. The devices of the other filesystem types, e.g. /dev/sdb1, are host paths too.
+func localVolumeDevice(driver string, options map[string]string) (string, bool) {
+	device := options["device"]
+	if (len(driver) > 0 && driver != "local") || !strings.HasPrefix(device, "/") {
+		return "", false
+	}
+	return path.Clean(device), true
+}
+
+// Returns the cleaned host paths bound into the container, including the host path devices of the
+// volumes created along with it. Named volumes are not host paths and are skipped.
+func (body containerCreateBody) hostMounts() []string {
+	var paths []string
+	for _, bind := range body.HostConfig.Binds {
+		source := strings.SplitN(bind, ":", 2)[0]
+		if strings.HasPrefix(source, "/") {
+			paths = append(paths, path.Clean(source))
+		}
+	}
+	for _, mount := range body.HostConfig.Mounts {
+		if mount.Type == "bind" && len(mount.Source) > 0 {
+			paths = append(paths, path.Clean(mount.Source))
+		}
+		if mount.Type == "volume" && mount.VolumeOptions != nil && mount.VolumeOptions.DriverConfig != nil {
+			driver := mount.VolumeOptions.DriverConfig
+			if device, ok := localVolumeDevice(driver.Name, driver.Options); ok {
+				paths = append(paths, device)
+			}
+		}
+	}
+	return paths
+}
+
+// Returns the host path with its symbolic links resolved on the host, e.g. /var/run is a link to /run on
+// most hosts, so that a denied path cannot be bound through a link to it or to one of its parents.
+// The part of the path which does not exist (yet) is kept as is.
+func resolveHostPath(hostPath string) string {
+	if resolved, err := filepath.EvalSymlinks(hostPath); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(hostPath)
+	if parent == hostPath {
+		return hostPath
+	}
+	return filepath.Join(resolveHostPath(parent), filepath.Base(hostPath))
+}
+
+// Returns true if binding the host path exposes the forbidden host path,
+// i.e. the host path is the forbidden path or one of its parents
+func exposesHostPath(hostPath string, forbidden string) bool {
+	forbidden = path.Clean(forbidden)
+	return hostPath == forbidden || hostPath == "/" || strings.HasPrefix(forbidden, hostPath+"/")
+}
+
+// Returns the first host mount of the request exposing a denied host path, if any.
+// Exact entries deny the path and its parents, glob patterns and regular expressions
+// deny the matching host paths, e.g. /etc/* denies any path under /etc. The paths are matched
+// both as requested and with their symbolic links resolved.
+func (plugin *ImgAuthZPlugin) deniedHostMount(request registryRequest) (string, bool) {
+	for _, hostPath := range request.mounts {
+		hostPaths := []string{hostPath, resolveHostPath(hostPath)}
+		for _, forbidden := range plugin.denyHostMounts {
+			if isPattern(forbidden) {
+				continue
+			}
+			for _, forbiddenPath := range []string{forbidden, resolveHostPath(path.Clean(forbidden))} {
+				if exposesHostPath(hostPaths[0], forbiddenPath) || exposesHostPath(hostPaths[1], forbiddenPath) {
+					return hostPath, true
+				}
+			}
+		}
+		if plugin.deniedHostPaths.matches(hostPaths...) {
+			return hostPath, true
+		}
+	}
+	return "", false
+}
+
+// Returns the glob patterns and regular expressions of the denied host paths
+func hostPathPatterns(entries []string) []string {
+	var patterns []string
+	for _, entry := range entries {
+		if isPattern(entry) {
+			patterns = append(patterns, entry)
+		}
+	}
+	return patterns
+}
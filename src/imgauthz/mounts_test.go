@@ -0,0 +1,75 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns the response to the creation of a container of the body
+func createContainer(policy *Policy, body string) authorization.Response {
+	return policy.Authorize(authorization.Request{RequestMethod: "POST", RequestURI: "/containers/create", RequestBody: []byte(body)})
+}
+
+func TestDeniedHostMountsThroughSymbolicLinks(t *testing.T) {
+	// A host with /var/run linked to /run, as on most systemd hosts
+	root, err := ioutil.TempDir("", "img-authz-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "run"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "run", "docker.sock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "var"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../run", filepath.Join(root, "var", "run")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, denied := range []string{"/var/run/docker.sock", "/run/docker.sock"} {
+		policy := testPolicy(t, Config{Registries: []string{"docker.io"}, DeniedHostMounts: []string{root + denied}})
+		for source, allowed := range map[string]bool{
+			"/run":                  false,
+			"/run/docker.sock":      false,
+			"/var/run":              false,
+			"/var/run/docker.sock":  false,
+			"/var/run/../run":       false,
+			"/var/run/other":        true,
+			"/var/run/missing/path": true,
+		} {
+			body := `{"Image":"alpine:3.19","HostConfig":{"Binds":["` + root + source + `:/mnt"]}}`
+			if response := createContainer(policy, body); response.Allow != allowed {
+				t.Errorf("bind of %s with %s denied: allowed %v, expected %v (%s)", source, denied, response.Allow, allowed, response.Msg)
+			}
+		}
+	}
+}
+
+func TestDeniedHostMountsThroughLocalVolumeDevices(t *testing.T) {
+	policy := testPolicy(t, Config{Registries: []string{"docker.io"}, DeniedHostMounts: []string{"/var/run/docker.sock", "/dev/*"}})
+	for options, allowed := range map[string]bool{
+		// docker run --mount type=volume,dst=/host,volume-opt=type=none,volume-opt=o=bind,volume-opt=device=/
+		`"DriverConfig":{"Options":{"type":"none","o":"bind","device":"/"}}`:                   false,
+		`"DriverConfig":{"Name":"local","Options":{"type":"none","o":"bind","device":"/var"}}`: false,
+		`"DriverConfig":{"Name":"local","Options":{"type":"ext4","device":"/dev/sdb1"}}`:       false,
+		`"DriverConfig":{"Name":"local","Options":{"type":"none","o":"bind","device":"/srv"}}`: true,
+		`"DriverConfig":{"Name":"local","Options":{"type":"nfs","device":":/export"}}`:         true,
+		`"DriverConfig":{"Name":"rexray","Options":{"device":"/"}}`:                            true,
+		`"Labels":{"team":"app"}`: true,
+	} {
+		body := `{"Image":"alpine:3.19","HostConfig":{"Mounts":[{"Type":"volume","Target":"/host","VolumeOptions":{` + options + `}}]}}`
+		if response := createContainer(policy, body); response.Allow != allowed {
+			t.Errorf("volume with %s: allowed %v, expected %v (%s)", options, response.Allow, allowed, response.Msg)
+		}
+	}
+}
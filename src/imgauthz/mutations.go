@@ -0,0 +1,145 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Container mutations, i.e. the requests altering an existing container, which do not involve any registry
+const (
+	// docker update (i.e. /containers/{id}/update): resources and restart policy
+	mutationUpdate = "update"
+	// docker rename (i.e. /containers/{id}/rename)
+	mutationRename = "rename"
+	// docker exec (i.e. /containers/{id}/exec): commands run in the container
+	mutationExec = "exec"
+	// docker exec --privileged: commands run in the container with all the capabilities
+	mutationPrivilegedExec = "privileged-exec"
+	// docker cp into a container (i.e. PUT /containers/{id}/archive)
+	mutationCopy = "copy"
+)
+
+// Docker client commands of the container mutations and their denial reasons
+var containerMutations = map[string]struct{ client, reason string }{
+	mutationUpdate:         {"docker update", "Updating containers is not allowed"},
+	mutationRename:         {"docker rename", "Renaming containers is not allowed"},
+	mutationExec:           {"docker exec", "Running commands in containers is not allowed"},
+	mutationPrivilegedExec: {"docker exec --privileged", "Running privileged commands in containers is not allowed"},
+	mutationCopy:           {"docker cp", "Copying files into containers is not allowed"},
+}
+
+// Returns the mutation and the container of a container mutation request, e.g. update and 4e38e38c8ce0 for
+// POST /v1.41/containers/4e38e38c8ce0/update. Privileged execs are plain execs, see privilegedExec.
+func containerMutation(method string, reqURL *url.URL) (string, string, bool) {
+	parts := strings.Split(strings.Trim(reqURL.Path, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-3] != "containers" {
+		return "", "", false
+	}
+	container, action := parts[len(parts)-2], parts[len(parts)-1]
+	switch {
+	case action == "update" && method == "POST":
+		return mutationUpdate, container, true
+	case action == "rename" && method == "POST":
+		return mutationRename, container, true
+	case action == "exec" && method == "POST":
+		return mutationExec, container, true
+	case action == "archive" && method == "PUT":
+		return mutationCopy, container, true
+	}
+	return "", "", false
+}
+
+// Returns true if the exec create request body runs the command privileged
+func privilegedExec(body []byte) (bool, error) {
+	var exec struct {
+		Privileged bool
+	}
+	if err := json.Unmarshal(body, &exec); err != nil {
+		return false, fmt.Errorf("unparseable request body: %v", err)
+	}
+	return exec.Privileged, nil
+}
+
+// Returns the sorted fields set by a container update request body, e.g. Memory or RestartPolicy,
+// so that the updates are logged without their values
+func updatedFields(body []byte) []string {
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+	var updated []string
+	for field, value := range fields {
+		if value != nil && value != 0.0 && value != "" && value != false {
+			updated = append(updated, field)
+		}
+	}
+	sort.Strings(updated)
+	return updated
+}
+
+// Returns true if the mutation is denied. Denying the execs denies the privileged execs too.
+func (config pluginConfig) deniesMutation(mutation string) bool {
+	for _, denied := range config.denyMutations {
+		if denied == mutation || (denied == mutationExec && mutation == mutationPrivilegedExec) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the container mutation request, if the request is a container mutation and mutations are
+// restricted. Otherwise, the mutation is logged only, as any command without a registry.
+func (plugin *ImgAuthZPlugin) mutationRequest(req authorization.Request, reqURL *url.URL) (registryRequest, bool) {
+	mutation, container, ok := containerMutation(req.RequestMethod, reqURL)
+	if !ok || len(plugin.denyMutations) == 0 {
+		return registryRequest{}, false
+	}
+	request := registryRequest{command: mutation, container: container}
+	if mutation == mutationExec && plugin.deniesMutation(mutationPrivilegedExec) {
+		// An exec whose privileges are unknown must not be mistaken for an unprivileged one
+		privileged, err := privilegedExec(req.RequestBody)
+		if err != nil {
+			request.bodyErr = err
+		} else if privileged {
+			request.command = mutationPrivilegedExec
+		}
+	}
+	return request, true
+}
+
+// Logs a container mutation which is not restricted, so that the operators have visibility on them
+func (plugin *ImgAuthZPlugin) logMutation(req authorization.Request, reqURL *url.URL, request registryRequest) bool {
+	mutation, container, ok := containerMutation(req.RequestMethod, reqURL)
+	if !ok {
+		return false
+	}
+	if mutation == mutationUpdate {
+		request.logln("[ALLOWED] Container "+mutation+":", container, strings.Join(updatedFields(req.RequestBody), ","), req.RequestMethod, reqURL.String())
+	} else {
+		request.logln("[ALLOWED] Container "+mutation+":", container, req.RequestMethod, reqURL.String())
+	}
+	return true
+}
+
+// Returns true if the request is a container mutation
+func (request registryRequest) isMutation() bool {
+	_, ok := containerMutations[request.command]
+	return ok
+}
+
+// Authorizes a container mutation as per the denied mutations
+func (plugin *ImgAuthZPlugin) authorizeMutation(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if !plugin.deniesMutation(request.command) {
+		request.logln("[ALLOWED] Container "+request.command+":", request.container, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: true}
+	}
+	request.logln("[DENIED] Container "+request.command+":", request.container, req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg(containerMutations[request.command].reason)}
+}
@@ -0,0 +1,193 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum number of decision events waiting to be posted to a webhook, dropped beyond
+	webhookQueueSize = 1024
+	// Timeout for posting a single decision event to a webhook
+	webhookTimeout = 5 * time.Second
+)
+
+// Decision on a registry command, as notified to the notifiers
+type DecisionEvent struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Command string    `json:"command"`
+	Image   string    `json:"image"`
+	// Altered container of the container mutations
+	Container string `json:"container,omitempty"`
+	Allowed   bool   `json:"allowed"`
+	// Reason of the decision, e.g. the denial message
+	Reason string `json:"reason,omitempty"`
+}
+
+// Receives the decisions on the registry commands, e.g. to alert on the denials.
+// The notifiers are invoked in order, synchronously with the decisions, so they must return quickly:
+// slow targets (e.g. remote services) should be notified in the background. The decisions of concurrent
+// commands are notified concurrently, so the notifiers must be safe for concurrent use.
+type Notifier interface {
+	// Returns the name of the notifier, logged along with its failures
+	Name() string
+	// Notifies the decision. Failures are logged, and do not affect the decision.
+	Notify(event DecisionEvent) error
+}
+
+// Notifiers registered with RegisterNotifier, invoked before the notifiers configured with --notify
+var registeredNotifiers []Notifier
+
+// Registers a notifier compiled into the plugin, from the init function of its source file, e.g.
+//
+//	func init() {
+//		RegisterNotifier(&pagerNotifier{})
+//	}
+//
+// Notifiers are invoked in registration order, i.e. in source file name order.
+func RegisterNotifier(notifier Notifier) {
+	registeredNotifiers = append(registeredNotifiers, notifier)
+}
+
+// Returns the decision record as a decision event
+func (decision decisionRecord) event() DecisionEvent {
+	return DecisionEvent(decision)
+}
+
+// Returns the built-in notifiers configured with --notify: stdout, syslog or webhook=<url>.
+// The syslog notifier logs with the facility and tag of the plugin logs.
+func newNotifiers(specs []string, syslogFacility string, syslogTag string) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		switch {
+		case spec == "stdout":
+			notifiers = append(notifiers, &stdoutNotifier{encoder: json.NewEncoder(os.Stdout)})
+		case spec == "syslog":
+			facility, err := parseSyslogFacility(syslogFacility)
+			if err != nil {
+				return nil, err
+			}
+			writer, err := syslog.New(facility|syslog.LOG_INFO, syslogTag)
+			if err != nil {
+				return nil, fmt.Errorf("cannot connect to syslog: %v", err)
+			}
+			notifiers = append(notifiers, &syslogNotifier{writer: writer})
+		case parts[0] == "webhook" && len(parts) == 2:
+			endpoint, err := url.Parse(parts[1])
+			if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+				return nil, fmt.Errorf("invalid --notify webhook URL: %s (expected an http or https URL)", parts[1])
+			}
+			notifiers = append(notifiers, newWebhookNotifier(endpoint.String()))
+		default:
+			return nil, fmt.Errorf("invalid --notify value: %s (expected stdout, syslog or webhook=<url>)", spec)
+		}
+	}
+	return notifiers, nil
+}
+
+// Notifier writing the decision events to the standard output, as one JSON record per line
+type stdoutNotifier struct {
+	sync.Mutex
+	encoder *json.Encoder
+}
+
+// Returns the name of the notifier
+func (notifier *stdoutNotifier) Name() string {
+	return "stdout"
+}
+
+// Writes the decision event to the standard output
+func (notifier *stdoutNotifier) Notify(event DecisionEvent) error {
+	notifier.Lock()
+	defer notifier.Unlock()
+	return notifier.encoder.Encode(event)
+}
+
+// Notifier sending the decision events to the local syslog as JSON, with the warning severity for the denials
+type syslogNotifier struct {
+	writer *syslog.Writer
+}
+
+// Returns the name of the notifier
+func (notifier *syslogNotifier) Name() string {
+	return "syslog"
+}
+
+// Sends the decision event to the local syslog
+func (notifier *syslogNotifier) Notify(event DecisionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Allowed {
+		return notifier.writer.Info("[DECISION] " + string(data))
+	}
+	return notifier.writer.Warning("[DECISION] " + string(data))
+}
+
+// Notifier posting the decision events to a webhook as JSON, in the background and in order.
+// The events are dropped when the webhook is too slow to keep up.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	queue  chan DecisionEvent
+}
+
+// Create a new webhook notifier posting to the given URL
+func newWebhookNotifier(url string) *webhookNotifier {
+	notifier := &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan DecisionEvent, webhookQueueSize)}
+	go notifier.post()
+	return notifier
+}
+
+// Returns the name of the notifier
+func (notifier *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Queues the decision event to be posted
+func (notifier *webhookNotifier) Notify(event DecisionEvent) error {
+	select {
+	case notifier.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full, event dropped")
+	}
+}
+
+// Posts the queued decision events, one at a time
+func (notifier *webhookNotifier) post() {
+	for event := range notifier.queue {
+		data, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		response, err := notifier.client.Post(notifier.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Println("[NOTIFY] webhook failed:", event.ID, err)
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			log.Println("[NOTIFY] webhook failed:", event.ID, response.Status)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Prefix of the policy entries which are regular expressions
+const regexPrefix = "regex:"
+
+// Policy entries matched by exact lookup, glob pattern or regular expression.
+// Shared by the allow-lists and the deny-lists.
+type patternSet struct {
+	// Exact entries, for efficient lookup
+	exact map[string]bool
+	// Glob patterns and regular expressions, compiled
+	patterns []*regexp.Regexp
+}
+
+// Returns true if the entry is a glob pattern or a regular expression
+func isPattern(entry string) bool {
+	return strings.HasPrefix(entry, regexPrefix) || strings.ContainsAny(entry, "*?")
+}
+
+// Compiles a glob pattern, where * matches any sequence of characters (including /)
+// and ? matches any single character, into an anchored regular expression.
+// Entries prefixed with regex: are compiled as anchored regular expressions.
+func compilePattern(entry string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(entry, regexPrefix) {
+		return regexp.Compile("^(?:" + strings.TrimPrefix(entry, regexPrefix) + ")$")
+	}
+
+	expr := regexp.QuoteMeta(entry)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+// Create a new pattern set from a list of exact entries, glob patterns and regular expressions
+func newPatternSet(entries []string) (*patternSet, error) {
+	set := &patternSet{exact: make(map[string]bool)}
+	for _, entry := range entries {
+		if !isPattern(entry) {
+			set.exact[entry] = true
+			continue
+		}
+		pattern, err := compilePattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		set.patterns = append(set.patterns, pattern)
+	}
+	return set, nil
+}
+
+// Returns the number of entries in the set
+func (set *patternSet) size() int {
+	return len(set.exact) + len(set.patterns)
+}
+
+// Returns true if any of the values matches an entry of the set.
+// Exact entries are looked up first, followed by the patterns.
+func (set *patternSet) matches(values ...string) bool {
+	for _, value := range values {
+		if set.exact[value] {
+			return true
+		}
+	}
+	for _, pattern := range set.patterns {
+		for _, value := range values {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
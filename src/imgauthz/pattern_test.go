@@ -0,0 +1,41 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDenyListPatterns(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	for _, test := range []struct {
+		config  Config
+		image   string
+		allowed bool
+	}{
+		// Denied registries, as glob patterns and regular expressions
+		{Config{Registries: []string{"*"}, DeniedRegistries: []string{"*.evil.net"}}, "cdn.evil.net/app:1.0", false},
+		{Config{Registries: []string{"*"}, DeniedRegistries: []string{"*.evil.net"}}, "evil.net/app:1.0", true},
+		{Config{Registries: []string{"*"}, DeniedRegistries: []string{"evilregistry.*"}}, "evilregistry.io:5000/app:1.0", false},
+		{Config{Registries: []string{"*"}, DeniedRegistries: []string{"regex:[0-9.]+(:[0-9]+)?"}}, "10.0.0.1:5000/app:1.0", false},
+		{Config{Registries: []string{"*"}, DeniedRegistries: []string{"regex:[0-9.]+(:[0-9]+)?"}}, "my.docker.registry/app:1.0", true},
+		// Denied images, as glob patterns and regular expressions, overriding the more specific authorized entries
+		{Config{Registries: []string{"docker.io"}, Images: []string{"docker.io/library/alpine"}, DeniedImages: []string{"*:latest"}}, "alpine", false},
+		{Config{Registries: []string{"docker.io"}, Images: []string{"docker.io/library/alpine"}, DeniedImages: []string{"*:latest"}}, "alpine:latest", false},
+		{Config{Registries: []string{"docker.io"}, Images: []string{"docker.io/library/alpine"}, DeniedImages: []string{"*:latest"}}, "alpine:3.5", true},
+		{Config{Registries: []string{"docker.io"}, DeniedImages: []string{"docker.io/library/alpine:3.?"}}, "alpine:3.5", false},
+		{Config{Registries: []string{"docker.io"}, DeniedImages: []string{"docker.io/library/alpine:3.?"}}, "alpine:3.19", true},
+		{Config{Registries: []string{"docker.io"}, DeniedImages: []string{"docker.io/corp/*"}}, "corp/team/app:1.0", false},
+		{Config{Registries: []string{"docker.io"}, DeniedImages: []string{`regex:docker\.io/library/alpine:3\.[0-9]+`}}, "alpine:3.19", false},
+		{Config{Registries: []string{"docker.io"}, DeniedImages: []string{`regex:docker\.io/library/alpine:3\.[0-9]+`}}, "alpine:3.19-slim", true},
+		// The digests are matched by the deny-list
+		{Config{Registries: []string{"docker.io"}, DeniedImages: []string{"*@" + digest}}, "alpine@" + digest, false},
+	} {
+		policy := testPolicy(t, test.config)
+		if response := policy.AuthorizePull(test.image); response.Allow != test.allowed {
+			t.Errorf("pull of %s with %+v: allowed %v (%s)", test.image, test.config, response.Allow, response.Msg)
+		}
+	}
+}
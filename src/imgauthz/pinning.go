@@ -0,0 +1,108 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// Resolves the manifest list digests to the digests of their platform manifests
+type platformDigestResolver interface {
+	// Returns the digest of the platform manifest (os[/arch], the host platform if empty) of the manifest
+	// list the reference resolves to, or empty if the reference does not resolve to a manifest list
+	resolvePlatformDigest(ref imageReference, platform string) (string, error)
+}
+
+// Returns the approved digests per image name (registry/repository), from the exact image entries
+// with a digest, e.g. docker.io/library/nginx@sha256:<digest>. Images with approved digests are
+// pinned to these digests.
+func pinnedDigests(images []string, defaultRegistry string) map[string]map[string]bool {
+	pinned := make(map[string]map[string]bool)
+	for _, entry := range images {
+		if isPattern(entry) || !strings.Contains(entry, "@") {
+			continue
+		}
+		ref := parseImageReference(entry, defaultRegistry)
+		if pinned[ref.name()] == nil {
+			pinned[ref.name()] = make(map[string]bool)
+		}
+		pinned[ref.name()][ref.digest] = true
+	}
+	return pinned
+}
+
+// Authorizes a reference to an image pinned to approved digests: references by an approved digest
+// are allowed, whatever their tag, and the other ones denied, including the references by tag only.
+// Returns false if the image is not pinned.
+func (plugin *ImgAuthZPlugin) authorizePinnedDigest(req authorization.Request, reqURL *url.URL, request registryRequest) (authorization.Response, bool) {
+	digests, pinned := plugin.pinnedDigests[request.image.name()]
+	if !pinned {
+		return authorization.Response{}, false
+	}
+
+	if len(request.image.digest) == 0 {
+		request.logln("[DENIED] Pinned image without digest:", request.image, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image is pinned to approved digests and must be referenced by digest")}, true
+	}
+	if !digests[request.image.digest] {
+		if platformDigest := plugin.approvedPlatformDigest(request, digests); len(platformDigest) > 0 {
+			request.logln("[ALLOWED] Approved platform digest:", request.image, platformDigest, req.RequestMethod, reqURL.String())
+			return authorization.Response{Allow: true}, true
+		}
+		request.logln("[DENIED] Digest not approved:", request.image, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The digest " + request.image.digest + " is not approved for the image")}, true
+	}
+
+	request.logln("[ALLOWED] Approved digest:", request.image, req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: true}, true
+}
+
+// Returns the platform digest of the requested manifest list if it is approved, so that the digests of
+// multi-arch images can be pinned by their manifest list or by their platform manifest, if platform
+// digests are matched. Returns empty if the reference is not to a manifest list, if its platform digest
+// is not approved, or if the manifest list could not be fetched.
+func (plugin *ImgAuthZPlugin) approvedPlatformDigest(request registryRequest, digests map[string]bool) string {
+	if plugin.platformDigests == nil || !plugin.checks.acquire() {
+		return ""
+	}
+	defer plugin.checks.release()
+
+	platformDigest, err := plugin.platformDigests.resolvePlatformDigest(request.image, request.platform)
+	if err != nil {
+		request.logln("[PINNING] Platform digest lookup failed:", request.image, err)
+		return ""
+	}
+	if len(platformDigest) == 0 || !digests[platformDigest] {
+		return ""
+	}
+	return platformDigest
+}
+
+// Returns the digest of the platform manifest of the manifest list the reference resolves to,
+// or empty if it is not a manifest list. The architecture defaults to the one of the host.
+func (registry *registryClient) resolvePlatformDigest(ref imageReference, platform string) (string, error) {
+	host, repository := ref.registryHost()
+	manifest, err := registry.fetchManifest(host, repository, ref.manifestReference())
+	if err != nil || !manifest.isList() {
+		return "", err
+	}
+
+	os, arch := runtime.GOOS, runtime.GOARCH
+	if fields := strings.Split(platform, "/"); len(fields[0]) > 0 {
+		os = strings.ToLower(fields[0])
+		if len(fields) > 1 && len(fields[1]) > 0 {
+			arch = strings.ToLower(fields[1])
+		}
+	}
+	for _, platformManifest := range manifest.Manifests {
+		if platformManifest.Platform != nil && platformManifest.Platform.OS == os && platformManifest.Platform.Architecture == arch {
+			return platformManifest.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("no manifest for platform %s/%s", os, arch)
+}
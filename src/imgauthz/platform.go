@@ -0,0 +1,54 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// Returns the normalized OS of a platform given as os[/arch[/variant]], e.g. linux for linux/amd64
+func platformOS(platform string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(platform, "/", 2)[0]))
+}
+
+// Architectures of the platforms by their aliases, as normalized by the docker daemon
+var platformArchitectures = map[string]string{"x86_64": "amd64", "x86-64": "amd64", "aarch64": "arm64"}
+
+// Returns the normalized platform given as os[/arch[/variant]], e.g. linux/amd64 for Linux/x86_64,
+// so that the equivalent platforms requested by pulls and runs are authorized alike
+func normalizePlatform(platform string) string {
+	fields := strings.Split(strings.ToLower(strings.TrimSpace(platform)), "/")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	if len(fields) > 1 {
+		if arch, ok := platformArchitectures[fields[1]]; ok {
+			fields[1] = arch
+		}
+	}
+	return strings.TrimRight(strings.Join(fields, "/"), "/")
+}
+
+// Returns true if the normalized OS is one of the allowed OSes
+func (config pluginConfig) isAllowedOS(requestedOS string) bool {
+	for _, allowed := range config.allowedOS {
+		if platformOS(allowed) == requestedOS {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizes the OS of the platform requested by a pull or a run, e.g. with docker pull --platform windows/amd64.
+// Commands without a platform use the platform of the docker daemon, and are not restricted.
+func (plugin *ImgAuthZPlugin) authorizeOS(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	requestedOS := platformOS(request.platform)
+	if len(plugin.allowedOS) == 0 || len(requestedOS) == 0 || plugin.isAllowedOS(requestedOS) {
+		return authorization.Response{Allow: true}
+	}
+	request.logln("[DENIED] OS not allowed:", request.platform, request.image.name(), req.RequestMethod, reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg("The OS " + requestedOS + " is not allowed, only: " + strings.Join(plugin.allowedOS, ", "))}
+}
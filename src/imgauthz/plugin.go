@@ -0,0 +1,1108 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dockerapi "github.com/docker/docker/api"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Registry command types
+const (
+	// docker pull (i.e. /images/create)
+	pullCommand = "pull"
+	// docker run (i.e. /containers/create)
+	runCommand = "run"
+	// docker commit (i.e. /commit), if restricted
+	commitCommand = "commit"
+	// Registry query, e.g. docker manifest inspect (i.e. /distribution/{name}/json), if restricted
+	distributionCommand = "distribution"
+	// docker save (i.e. /images/{name}/get or /images/get), if restricted
+	saveCommand = "save"
+)
+
+// Maximum number of authorized registries listed in the denial messages
+const maxListedRegistries = 10
+
+// Registry command requested by the docker client
+type registryRequest struct {
+	// Type of the command (pull, run, commit, distribution, save or a container mutation)
+	command string
+	// Requested image, parsed and as sent by the docker client
+	image    imageReference
+	rawImage string
+	// Container labels (run command only)
+	labels map[string]string
+	// Host paths bound into the container (run command only)
+	mounts []string
+	// Normalized capabilities added to the container (run command only)
+	capAdd []string
+	// Requested platform as os[/arch[/variant]], if any
+	platform string
+	// Pull of all the tags of the repository (i.e. docker pull --all-tags)
+	allTags bool
+	// All the saved images, the requested image being the first one (save command only)
+	saved []string
+	// Altered container, by ID or name (container mutations only)
+	container string
+	// Error parsing the request body, if it is missing, unparseable or without an image (run command and
+	// privileged exec only)
+	bodyErr error
+	// Correlation ID, prefixing all the log lines of the request
+	id string
+	// Set to 1 once the image checks are answered by the decision cache, for the latency metrics.
+	// Shared by the copies of the request, and set atomically as the decision may time out meanwhile.
+	cacheHit *int32
+	// Set to 1 once a check fails to reach its decision (e.g. on an error or over the concurrency limit),
+	// so that the decisions of the failed checks are not cached. Tracked by the image checks only.
+	failed *int32
+	// Context of the decision, done once the decision timed out or was returned
+	ctx context.Context
+}
+
+// Records that a check of the request failed to reach its decision, if tracked
+func (request registryRequest) markFailed() {
+	if request.failed != nil {
+		atomic.StoreInt32(request.failed, 1)
+	}
+}
+
+// Returns an error if the decision on the request was abandoned, e.g. after the decision timeout,
+// so that the checks with side effects (e.g. the tag pins or the image quotas) are skipped
+func (request registryRequest) abandoned() error {
+	if request.ctx == nil {
+		return nil
+	}
+	return request.ctx.Err()
+}
+
+// Logs a message prefixed with the correlation ID of the request
+func (request registryRequest) logln(v ...interface{}) {
+	log.Println(append([]interface{}{"[" + request.id + "]"}, v...)...)
+}
+
+// Returns the denial message for the request, mentioning the operation denied
+func (request registryRequest) denialMsg(reason string) string {
+	if request.command == commitCommand && len(request.rawImage) == 0 {
+		return "docker commit denied: cannot commit a container. " + reason
+	}
+	if request.command == commitCommand {
+		return "docker commit denied: cannot commit a container to image " + request.image.name() + ". " + reason
+	}
+	if request.command == runCommand && len(request.rawImage) == 0 {
+		return "docker run denied: cannot create a container. " + reason
+	}
+	if request.command == runCommand {
+		return "docker run denied: cannot create a container from image " + request.image.name() + ". " + reason
+	}
+	if request.command == distributionCommand {
+		return "docker image query denied: cannot query image " + request.image.name() + ". " + reason
+	}
+	if request.isMutation() {
+		return containerMutations[request.command].client + " denied: cannot alter container " + request.container + ". " + reason
+	}
+	if request.command == saveCommand && len(request.rawImage) == 0 {
+		return "docker save denied: cannot save the images. " + reason
+	}
+	if request.command == saveCommand {
+		return "docker save denied: cannot save image " + request.image.name() + ". " + reason
+	}
+	if len(request.rawImage) == 0 {
+		return "docker pull denied: cannot pull the image. " + reason
+	}
+	return "docker pull denied: cannot pull image " + request.image.name() + ". " + reason
+}
+
+// Image Authorization Plugin configuration
+type pluginConfig struct {
+	// List of authorized registries
+	registries []string
+	// List of authorized images (registry/repository)
+	images []string
+	// List of authorized repository path prefixes
+	repositoryPrefixes []string
+	// List of denied registries
+	denyRegistries []string
+	// List of denied images (registry/repository[:tag])
+	denyImages []string
+	// Allow requests whose authorization could not be verified due to an error
+	allowOnError bool
+	// Allow requests whose URL cannot be parsed
+	allowUnparseable bool
+	// Token allowing otherwise denied requests in an emergency
+	breakGlassToken string
+	// Maximum size of the pulled images in bytes (0 for unlimited)
+	maxImageSize int64
+	// Allow pulls whose image size could not be determined
+	allowUnknownSize bool
+	// Maximum number of layers of the images (0 for unlimited)
+	maxLayers int
+	// Allow requests whose image layer count could not be determined
+	allowUnknownLayers bool
+	// Maximum duration of an authorization decision (0 for unlimited)
+	decisionTimeout time.Duration
+	// Durations for which the allowed and the denied decisions of the image checks are cached (0 for uncached)
+	cacheTTL    time.Duration
+	negativeTTL time.Duration
+	// Number of consecutive failures to contact a registry opening its circuit breaker (disabled if 0),
+	// duration for which it stays open, and whether the checks contacting it are skipped meanwhile
+	breakerFailures int
+	breakerCooldown time.Duration
+	breakerDegrade  bool
+	// Time windows constraining the use of registries
+	registryWindows []string
+	// Registry aliases as <alias>=<registry>, and the alias table resolving them to their final registry
+	registryAliases []string
+	aliases         map[string]string
+	// Canonicalization of the exact registry and image entries: off, merge or reject (off if empty)
+	canonicalEntries string
+	// List of images (registry/repository) allowed regardless of any other rule
+	alwaysAllow []string
+	// List of pseudo-images (bare names, e.g. scratch) allowed regardless of any other rule
+	pseudoImages []string
+	// Registry resolving the image names without a registry host (dockerhub if empty)
+	defaultRegistry string
+	// Mirrors rewritten to their upstream registry before matching, as <mirror>=<upstream>
+	mirrorPrefixes []string
+	// Deny the registry commands of clients without an authentication method
+	requireAuth bool
+	// Registries whose pulls require registry credentials (i.e. the X-Registry-Auth header)
+	registryAuth []string
+	// CA bundles of the registries contacted by the plugin, as <registry>=<PEM CA bundle file>,
+	// and the registries whose certificate is not verified
+	registryCAs []string
+	skipVerify  []string
+	// Trusted certificate fingerprints of the registries identified by their certificate, as
+	// <registry>=sha256:<hex>
+	fingerprints []string
+	// Deny the image references which are not requested through a mirror
+	requireMirror bool
+	// Deny the image references without an explicit tag or digest
+	requireExplicitTag bool
+	// Restriction of docker commit: off, deny or allowlist
+	restrictCommit string
+	// OSes of the platforms which can be requested, e.g. linux (any if empty)
+	allowedOS []string
+	// Deny the registries configured as insecure on the docker daemon
+	denyInsecure bool
+	// Deny the registries on the loopback or link-local addresses of the docker host, unless exact entries
+	denyLocal bool
+	// Deny the pulls of all the tags of a repository
+	denyAllTags bool
+	// Authorize the registry queries (i.e. /distribution/{name}/json) as the pulls
+	restrictDist bool
+	// Confidential registries and images, which cannot be saved (i.e. /images/{name}/get)
+	restrictSave []string
+	// Denied container mutations, e.g. update or privileged-exec
+	denyMutations []string
+	// Host paths which cannot be bound into containers
+	denyHostMounts []string
+	// Capabilities which cannot be added to containers
+	denyCapabilities []string
+	// Maximum number of distinct images run per user within the quota window (0 for unlimited)
+	imageQuota  int
+	quotaWindow time.Duration
+	// Maximum number of registry commands per user within the rate interval (0 for unlimited)
+	rateLimit    int
+	rateInterval time.Duration
+	// Pin the pulled tags to the digest first seen, persisted to the pins file if set
+	pinTags     bool
+	pinTagsFile string
+	// Match the digests of the manifest lists of the images pinned to digests by their platform digest too
+	matchPlatforms bool
+	// Deny the runs of the images which were not pulled with the authorization of the plugin,
+	// and the file persisting the pulled images (in memory only if empty)
+	requirePriorPull bool
+	priorPullsFile   string
+	// Minimum Docker API version of the registry commands (any version if nil)
+	minAPIVersion *apiVersion
+	// Registry entries without a port match their host on any port
+	anyRegistryPort bool
+	// Authorize docker run commands against the repo tags and digests of the local image,
+	// allowing them if all of them are authorized rather than any
+	inspectOnRun    bool
+	inspectMatchAll bool
+	// Documentation on how to request an exception, appended to the denial messages
+	helpURL string
+	// Maximum number of concurrent expensive checks (0 for unlimited), and whether to queue
+	// or deny the requests over the limit
+	checkLimit  int
+	queueChecks bool
+	// Require a SLSA provenance attestation signed with the provenance key and built by
+	// a trusted builder
+	requireProvenance bool
+	provenanceKey     string
+	trustedBuilders   []string
+	// Identities allowing any image they signed, as [<name>=]<public key file>
+	signedBy []string
+	// Require a published SBOM, found on the registry or by the SBOM service if set, and the
+	// documentation on how to generate one, mentioned in the denials
+	requireSBOM bool
+	sbomService string
+	sbomHelpURL string
+	// Labels required in the image config of the pulled images, as <key>[=<value>]
+	requireLabels []string
+	// Sources of the entries of the policy rule lists, per list key and aligned with the entries
+	ruleSources map[string][]string
+	// Images listed in the cache manifest, the only ones allowed if set
+	cacheManifest string
+	cachedImages  []string
+	// URL of the key-value store authorizing registries and images in addition to the lists,
+	// and duration for which its answers are cached (not cached if 0)
+	policyBackend string
+	backendTTL    time.Duration
+	// URL of the remote authorization service deciding on the images the lists do not authorize,
+	// and duration for which its decisions are cached (not cached if 0)
+	remotePolicy string
+	remoteTTL    time.Duration
+	// Allow rules written as CEL expressions over the registry command, allowing if any is true
+	celRules []string
+	// Time after which the policy is enforced, audited before (enforced right away if zero)
+	enforceAfter time.Time
+	// Minimum number of authorized registries of an enforced policy, refused below
+	minRegistries int
+	// Log debug messages
+	debug bool
+	// Log the redacted request bodies of the registry commands, along with the debug messages
+	logBodies bool
+}
+
+// Returned when no authorization decision was reached within the decision timeout
+var errDecisionTimeout = errors.New("authorization decision timed out")
+
+// Returned when a container create request has no body, e.g. a raw API call or a body over the size
+// the docker daemon passes to the authorization plugins
+var errEmptyCreateBody = errors.New("empty request body")
+
+// Returned when a container create request body names no image
+var errCreateBodyWithoutImage = errors.New("request body without an image")
+
+// Image Authorization Plugin struct definition
+type ImgAuthZPlugin struct {
+	// Plugin configuration
+	pluginConfig
+	// Docker client connection
+	docker *dockerConnection
+	// Plugin metrics and activity, shared by the reloaded plugins
+	metrics *pluginMetrics
+	status  *pluginStatus
+	// Authorized registries
+	authorizedRegistries *patternSet
+	// Number of authorized registries
+	numAuthorizedRegistries int
+	// List of authorized registries as string
+	authRegistriesAsString string
+	// Authorized images (registry/repository)
+	authorizedImages *patternSet
+	// Denied registries
+	deniedRegistries *patternSet
+	// Denied images (registry/repository[:tag])
+	deniedImages *patternSet
+	// Approved digests of the images pinned to digests, per image (registry/repository)
+	pinnedDigests map[string]map[string]bool
+	// Images (registry/repository) allowed regardless of any other rule
+	alwaysAllowedImages *patternSet
+	// Denied host paths (glob patterns and regular expressions only)
+	deniedHostPaths *patternSet
+	// Registries requiring registry credentials (exact entries and patterns)
+	credentialRegistries *patternSet
+	// Confidential registries and images, which cannot be saved
+	confidential *confidentialEntries
+	// Denied capabilities, normalized
+	deniedCapabilities map[string]bool
+	// Registry manifest client
+	manifests manifestClient
+	// Time windows constraining the use of registries
+	timeWindows []*timeWindow
+	// Mirrors of upstream registries, longest prefix first
+	mirrors []mirrorPrefix
+	// Limits the concurrent expensive checks
+	checks *checkLimiter
+	// Verifies the image provenance, if required
+	provenance provenanceVerifier
+	// Finds the image SBOMs, if required
+	sboms sbomFinder
+	// Required image labels, and the fetcher of the image labels if any is required
+	labelRules  []requiredLabel
+	imageLabels labelFetcher
+	// Verifies the image signatures, if identities are trusted
+	signatures signatureVerifier
+	// Images of the cache manifest, if any
+	approvedImages *cacheManifest
+	// Distinct images run per user, if quotas are configured
+	quotas *imageQuotas
+	// Registry commands per user, if rates are limited
+	rates *rateLimiter
+	// Digests first seen per pulled tag, if tags are pinned
+	tagPins *tagPins
+	// Images pulled through the plugin, if the runs require a prior pull
+	priorPulls *priorPulls
+	// Resolves the pulled tags to their digest
+	digests digestResolver
+	// Resolves the manifest list digests to their platform digest, if platform digests are matched
+	platformDigests platformDigestResolver
+	// Policy backend, if any
+	backend policySource
+	// Remote policy, if any
+	remote remotePolicy
+	// Compiled CEL rules, if any
+	cel []celRule
+	// Matchers deciding on the registry commands, the built-in allowlist matcher first
+	matchers []Matcher
+	// Resolves a registry host to its addresses
+	lookupHost func(host string) ([]net.IP, error)
+	// Cache of the decisions of the image checks, shared by the reloaded plugins, and the policy
+	// generation of the plugin, tagging its cached decisions
+	decisions  *decisionCache
+	generation uint64
+	// TLS trust of the registries contacted by the checks
+	trust *registryTrust
+	// Circuit breakers of the registries contacted by the checks, if enabled
+	breaker *registryBreaker
+	// Returns the current time
+	now func() time.Time
+}
+
+// Returns the list of authorized registries as string, capped to the first maxListedRegistries registries
+// so that the denial messages of large allowlists stay readable
+func authRegistries(registries []string, helpURL string) string {
+	if len(registries) <= maxListedRegistries {
+		return strings.Join(registries, ", ")
+	}
+	listed := strings.Join(registries[0:maxListedRegistries], ", ") + fmt.Sprintf(" and %d more", len(registries)-maxListedRegistries)
+	if len(helpURL) > 0 {
+		listed += " (see the help URL for the full list)"
+	}
+	return listed
+}
+
+// Create a new docker client connection to the docker daemon, with the HTTP transport tuned as configured
+func newDockerHostConnection(dockerHost string, transport transportConfig) (*dockerConnection, error) {
+	docker, err := newDockerConnection(func() (dockerAPI, error) {
+		httpClient, err := newDockerHTTPClient(dockerHost, transport)
+		if err != nil {
+			return nil, err
+		}
+		return dockerclient.NewClient(dockerHost, dockerapi.DefaultVersion, httpClient, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	go docker.monitor()
+	return docker, nil
+}
+
+// Create a new image authorization plugin, starting a new policy generation of the decision cache
+// (a cache of its own if nil)
+func newPlugin(docker *dockerConnection, metrics *pluginMetrics, status *pluginStatus, decisions *decisionCache, config pluginConfig) (*ImgAuthZPlugin, error) {
+	if err := config.checkMinRegistries(time.Now()); err != nil {
+		return nil, err
+	}
+
+	trust, err := newRegistryTrust(config.registryCAs, config.skipVerify, config.fingerprints)
+	if err != nil {
+		return nil, err
+	}
+	var breaker *registryBreaker
+	if config.breakerFailures > 0 {
+		breaker = newRegistryBreaker(config.breakerFailures, config.breakerCooldown, config.breakerDegrade)
+	}
+	registry := newRegistryClient(trust, breaker)
+	plugin := &ImgAuthZPlugin{
+		pluginConfig:           config,
+		docker:                 docker,
+		metrics:                metrics,
+		status:                 status,
+		authRegistriesAsString: authRegistries(config.registries, config.helpURL),
+		manifests:              registry,
+		digests:                registry,
+		checks:                 newCheckLimiter(config.checkLimit, config.queueChecks),
+		pinnedDigests:          pinnedDigests(config.images, config.defaultRegistry),
+		deniedCapabilities:     capabilitySet(config.denyCapabilities),
+		decisions:              decisions,
+		trust:                  trust,
+		breaker:                breaker,
+		lookupHost:             lookupRegistryHost,
+		now:                    time.Now}
+
+	if plugin.mirrors, err = parseMirrorPrefixes(config.mirrorPrefixes); err != nil {
+		return nil, err
+	}
+	if config.requireMirror && len(plugin.mirrors) == 0 {
+		return nil, fmt.Errorf("--require-mirror requires at least one --mirror-prefix")
+	}
+	if plugin.authorizedRegistries, err = newPatternSet(normalizeEntries(config.registries, normalizeRegistryHost)); err != nil {
+		return nil, err
+	}
+	if plugin.authorizedImages, err = newPatternSet(normalizeEntries(config.images, normalizeImageName)); err != nil {
+		return nil, err
+	}
+	if plugin.deniedRegistries, err = newPatternSet(normalizeEntries(config.denyRegistries, normalizeRegistryHost)); err != nil {
+		return nil, err
+	}
+	if plugin.deniedImages, err = newPatternSet(normalizeEntries(config.denyImages, normalizeImageName)); err != nil {
+		return nil, err
+	}
+	if plugin.alwaysAllowedImages, err = newPatternSet(normalizeEntries(config.alwaysAllow, normalizeImageName)); err != nil {
+		return nil, err
+	}
+	if plugin.deniedHostPaths, err = newPatternSet(hostPathPatterns(config.denyHostMounts)); err != nil {
+		return nil, err
+	}
+	if plugin.confidential, err = newConfidentialEntries(config.restrictSave); err != nil {
+		return nil, err
+	}
+	if plugin.credentialRegistries, err = newPatternSet(normalizeEntries(config.registryAuth, normalizeRegistryHost)); err != nil {
+		return nil, err
+	}
+	if len(config.cacheManifest) > 0 {
+		if plugin.approvedImages, err = newCacheManifest(config.cachedImages, config.defaultRegistry); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.policyBackend) > 0 {
+		if plugin.backend, err = newPolicySource(config.policyBackend, config.backendTTL); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.remotePolicy) > 0 {
+		if plugin.remote, err = newRemotePolicy(config.remotePolicy, config.remoteTTL); err != nil {
+			return nil, err
+		}
+	}
+	if plugin.cel, err = newCELRules(config.celRules); err != nil {
+		return nil, err
+	}
+	if config.requireSBOM && len(config.sbomService) > 0 {
+		plugin.sboms = newServiceSBOMFinder(config.sbomService)
+	} else if config.requireSBOM {
+		plugin.sboms = newRegistrySBOMFinder(registry)
+	}
+	if plugin.labelRules, err = parseRequiredLabels(config.requireLabels); err != nil {
+		return nil, err
+	}
+	if len(plugin.labelRules) > 0 {
+		plugin.imageLabels = registry
+	}
+	if config.matchPlatforms {
+		plugin.platformDigests = registry
+	}
+	if config.imageQuota > 0 {
+		plugin.quotas = newImageQuotas(config.imageQuota, config.quotaWindow)
+	}
+	if config.rateLimit > 0 {
+		plugin.rates = newRateLimiter(config.rateLimit, config.rateInterval)
+	}
+	if config.pinTags {
+		if plugin.tagPins, err = newTagPins(config.pinTagsFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.requirePriorPull {
+		if plugin.priorPulls, err = newPriorPulls(config.priorPullsFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.requireProvenance {
+		if plugin.provenance, err = newAttestationVerifier(config.provenanceKey, config.trustedBuilders, registry); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.signedBy) > 0 {
+		if plugin.signatures, err = newCosignVerifier(config.signedBy, registry); err != nil {
+			return nil, err
+		}
+	}
+	plugin.numAuthorizedRegistries = plugin.authorizedRegistries.size()
+	plugin.matchers = plugin.matcherChain()
+
+	for _, spec := range config.registryWindows {
+		window, err := parseTimeWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		plugin.timeWindows = append(plugin.timeWindows, window)
+	}
+	if plugin.decisions == nil {
+		plugin.decisions = newDecisionCache()
+	}
+	plugin.generation = plugin.decisions.newGeneration()
+
+	return plugin, nil
+}
+
+// Logs a debug message of the request, if debug logging is enabled
+func (plugin *ImgAuthZPlugin) debugln(request registryRequest, v ...interface{}) {
+	if plugin.debug {
+		request.logln(append([]interface{}{"[DEBUG]"}, v...)...)
+	}
+}
+
+// Responds to a request whose authorization could not be verified due to an error
+// (e.g. the docker daemon is unreachable), as per the configured on-error behavior.
+func (plugin *ImgAuthZPlugin) errorResponse(request registryRequest, reqURL *url.URL, err error) authorization.Response {
+	request.markFailed()
+	if plugin.allowOnError {
+		request.logln("[ALLOWED] Error:", err, request.image.name(), reqURL.String())
+		return authorization.Response{Allow: true}
+	}
+	request.logln("[DENIED] Error:", err, request.image.name(), reqURL.String())
+	return authorization.Response{Allow: false, Msg: request.denialMsg("Authorization could not be verified: " + err.Error())}
+}
+
+// Responds to a request whose URL cannot be parsed (e.g. with an invalid escape or a control character),
+// which cannot be told apart from a registry command, as per the configured unparseable behavior.
+// Denials are enforced as the registry command denials, i.e. allowed in audit mode.
+func (plugin *ImgAuthZPlugin) unparseableResponse(req authorization.Request, err error) authorization.Response {
+	request := registryRequest{id: newRequestID()}
+	if urlErr, ok := err.(*url.Error); ok {
+		// The parse error repeats the whole URL, which is logged truncated
+		err = urlErr.Err
+	}
+	if plugin.allowUnparseable {
+		request.logln("[ALLOWED] Unparseable request URL:", loggedReference(req.RequestURI), err, req.RequestMethod)
+		return authorization.Response{Allow: true}
+	}
+	request.logln("[DENIED] Unparseable request URL:", loggedReference(req.RequestURI), err, req.RequestMethod)
+	response := authorization.Response{Allow: false, Msg: "docker request denied: the request URL cannot be parsed: " + err.Error()}
+	if len(plugin.helpURL) > 0 {
+		response.Msg += " To request an exception, see " + plugin.helpURL
+	}
+	response, _ = plugin.enforce(request, response)
+	return response
+}
+
+// Returns true if there are any authorized registries configured.
+// Otherwise, returns false
+func (plugin *ImgAuthZPlugin) hasAuthorizedRegistries() bool {
+	return (plugin.numAuthorizedRegistries > 0)
+}
+
+// Returns true if there are any authorized images or repository prefixes configured.
+// Otherwise, any image from an authorized registry is allowed.
+func (plugin *ImgAuthZPlugin) hasImageRules() bool {
+	return plugin.authorizedImages.size() > 0 || len(plugin.repositoryPrefixes) > 0
+}
+
+// Returns true if the requested image is authorized by the image rules.
+// Image entries without a tag authorize every tag of the image, the ones with a tag or a tag pattern
+// (e.g. docker.io/library/nginx:1.*) the matching tags only.
+// Exact image matches are looked up first, followed by the image patterns and the repository path prefixes.
+func (plugin *ImgAuthZPlugin) isAuthorizedImage(ref imageReference) bool {
+	if plugin.authorizedImages.matches(ref.name(), ref.String()) {
+		return true
+	}
+	for _, prefix := range plugin.repositoryPrefixes {
+		if hasRepositoryPrefix(ref.repository, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the repository path lies under the prefix, matched by whole path components,
+// e.g. platform (or platform/) matches platform and platform/app, but not platformx/app
+func hasRepositoryPrefix(repository string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return repository == prefix || strings.HasPrefix(repository, prefix+"/")
+}
+
+// Returns true if the requested image is a pseudo-image, i.e. one of the bare names which do
+// not come from any registry (e.g. scratch), without any registry host, namespace or digest
+func (plugin *ImgAuthZPlugin) isPseudoImage(request registryRequest) bool {
+	name := strings.TrimSuffix(normalizeReferenceSeparators(strings.TrimSpace(request.rawImage)), ":latest")
+	for _, pseudoImage := range plugin.pseudoImages {
+		if name == pseudoImage {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names a registry is matched by: the registry itself (i.e. host:port, if it has a port)
+// and, if port-less entries match any port, its host alone
+func (plugin *ImgAuthZPlugin) registryNames(registry string) []string {
+	if host, port := splitRegistryPort(registry); plugin.anyRegistryPort && len(port) > 0 {
+		return []string{registry, host}
+	}
+	return []string{registry}
+}
+
+// Returns the registry as metrics label if it is an exact authorized or denied registry,
+// otherwise otherRegistryLabel
+func (plugin *ImgAuthZPlugin) registryLabel(registry string) string {
+	if plugin.authorizedRegistries.exact[registry] || plugin.deniedRegistries.exact[registry] {
+		return registry
+	}
+	return otherRegistryLabel
+}
+
+// Parses the docker client command to determine the command type and the requested image used in the command.
+// If an image is used in the command (i.e. docker pull or docker run commands), then the registry request and true is returned.
+// Otherwise, returns an empty request and false.
+func (plugin *ImgAuthZPlugin) processRequest(req authorization.Request, reqURL *url.URL) (registryRequest, bool) {
+	// Unparseable requests are decided before, as per the unparseable behavior
+	if reqURL == nil {
+		return registryRequest{}, false
+	}
+
+	image := ""
+	tag := ""
+	command := ""
+	var labels map[string]string
+	var mounts []string
+	var capAdd []string
+	var bodyErr error
+
+	// docker run
+	if strings.HasSuffix(reqURL.Path, "/containers/create") {
+		var body containerCreateBody
+		// A body which is missing, cannot be parsed (e.g. truncated) or names no image must not be
+		// mistaken for a command without an image
+		if len(bytes.TrimSpace(req.RequestBody)) == 0 {
+			bodyErr = errEmptyCreateBody
+		} else if err := json.Unmarshal(req.RequestBody, &body); err != nil {
+			bodyErr = fmt.Errorf("unparseable request body: %v", err)
+		} else if len(body.Image) == 0 {
+			bodyErr = errCreateBodyWithoutImage
+		}
+		image = body.Image
+		labels = body.Labels
+		mounts = body.hostMounts()
+		capAdd = body.addedCapabilities()
+		command = runCommand
+	}
+
+	// docker commit, unless commits are not restricted. The repository and the tag of the new image
+	// are passed separately, and both are optional.
+	if strings.HasSuffix(reqURL.Path, "/commit") && plugin.restrictsCommits() {
+		image = reqURL.Query().Get("repo")
+		if tag := reqURL.Query().Get("tag"); len(tag) > 0 && len(image) > 0 {
+			image = image + ":" + tag
+		}
+		if len(image) == 0 {
+			return registryRequest{command: commitCommand}, true
+		}
+		return registryRequest{command: commitCommand, image: plugin.parseReference(image), rawImage: image}, true
+	}
+
+	// Registry queries, unless they are not restricted
+	if image, ok := distributionImage(reqURL.Path); ok && plugin.restrictDist {
+		return registryRequest{command: distributionCommand, image: plugin.parseReference(image), rawImage: image}, true
+	}
+
+	// docker save, unless saves are not restricted
+	if names := savedImages(reqURL); len(names) > 0 && len(plugin.restrictSave) > 0 {
+		return registryRequest{command: saveCommand, image: plugin.parseReference(names[0]), rawImage: names[0], saved: names}, true
+	}
+
+	// Container mutations, unless they are not restricted
+	if request, ok := plugin.mutationRequest(req, reqURL); ok {
+		return request, true
+	}
+
+	// docker pull
+	if strings.HasSuffix(reqURL.Path, "/images/create") {
+		image = reqURL.Query().Get("fromImage")
+		tag = reqURL.Query().Get("tag")
+		command = pullCommand
+	}
+
+	image, platform := requestedImage(image, tag, reqURL.Query().Get("platform"))
+	if bodyErr != nil {
+		return registryRequest{command: command, rawImage: image, bodyErr: bodyErr}, true
+	}
+	if len(image) > 0 {
+		request := registryRequest{command: command, image: plugin.parseReference(image), rawImage: image, labels: labels, mounts: mounts, capAdd: capAdd,
+			platform: platform}
+		// The docker daemon pulls all the tags of a repository requested without any tag or digest,
+		// while the docker client passes the latest tag explicitly otherwise
+		request.allTags = command == pullCommand && !request.image.hasExplicitTag()
+		return request, true
+	}
+
+	return registryRequest{}, false
+}
+
+// Returns the image reference and the normalized platform requested by a pull or a run, so that the
+// equivalent pulls and runs are authorized alike. The image is the fromImage of a pull or the image of the
+// create body of a run, the tag (or digest) is passed separately by the docker client for pulls only,
+// and the platform is passed as a query parameter of both, e.g. docker pull --platform linux/amd64.
+func requestedImage(image string, tag string, platform string) (string, string) {
+	if len(tag) > 0 && len(image) > 0 {
+		if strings.Contains(tag, ":") {
+			image = image + "@" + tag
+		} else {
+			image = image + ":" + tag
+		}
+	}
+	return image, normalizePlatform(platform)
+}
+
+// Authorizes the docker client command.
+// Non registry related commands are allowed by default.
+// If the command uses a registry, the command is allowed only if the registry is authorized.
+// Otherwise, the request is denied!
+func (plugin *ImgAuthZPlugin) AuthZReq(req authorization.Request) authorization.Response {
+	// Time the whole request, by request path
+	start := time.Now()
+	path := latencyOther
+	defer func() { plugin.metrics.observed(path, time.Since(start)) }()
+
+	// Parse request and the request body. The query parameters are decoded once, by reqURL.Query(),
+	// as by the docker daemon, which does not process the queries it cannot parse (e.g. with an invalid escape).
+	reqURL, err := url.ParseRequestURI(req.RequestURI)
+	if err == nil {
+		_, err = url.ParseQuery(reqURL.RawQuery)
+	}
+	// The commands of requests which cannot be parsed are unknown
+	if err != nil {
+		return plugin.unparseableResponse(req, err)
+	}
+
+	// Find out the requested image and whether or not a registry is present in the client command
+	request, isRegistryCommand := plugin.processRequest(req, reqURL)
+	request.id = newRequestID()
+	request.cacheHit = new(int32)
+	ctx, cancel := plugin.decisionContext()
+	defer cancel()
+	request.ctx = ctx
+
+	// Docker command do not involve registries
+	if isRegistryCommand == false {
+		// Allowed by default! The container mutations are logged as such, for visibility
+		if !plugin.logMutation(req, reqURL, request) {
+			request.logln("[ALLOWED] Not a registry command:", req.RequestMethod, reqURL.String())
+		}
+		return authorization.Response{Allow: true}
+	}
+
+	// The reference is not validated yet, and is logged as requested, quoted
+	plugin.debugln(request, "[REQUEST]", request.command, loggedReference(request.rawImage), "User:", req.User, req.RequestMethod, reqURL.String())
+	if plugin.logBodies {
+		plugin.debugln(request, "[BODY]", req.RequestMethod, req.RequestURI, loggedBody(req.RequestBody))
+	}
+	response := plugin.decideWithinTimeout(req, reqURL, request)
+	path = latencyEvaluated
+	if atomic.LoadInt32(request.cacheHit) == 1 {
+		path = latencyCached
+	}
+	// Only the pulls the policy authorizes count as prior pulls, not the ones allowed in audit mode
+	// or after the decision timeout
+	if response.Allow && request.abandoned() == nil {
+		plugin.recordPull(request)
+	}
+	if !response.Allow && len(plugin.helpURL) > 0 {
+		response.Msg += " To request an exception, see " + plugin.helpURL
+	}
+	response, reason := plugin.enforce(request, response)
+	plugin.metrics.decided(request.command, plugin.registryLabel(request.image.registry), response.Allow)
+	// Container mutations have no image
+	image := request.image.String()
+	if request.isMutation() {
+		image = ""
+	}
+	plugin.status.record(decisionRecord{
+		ID:        request.id,
+		Time:      plugin.now(),
+		User:      req.User,
+		Command:   request.command,
+		Image:     image,
+		Container: request.container,
+		Allowed:   response.Allow,
+		Reason:    reason})
+	return response
+}
+
+// Returns the context of a decision, done after the decision timeout, if any
+func (plugin *ImgAuthZPlugin) decisionContext() (context.Context, context.CancelFunc) {
+	if plugin.decisionTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), plugin.decisionTimeout)
+}
+
+// Decides whether the registry command is allowed or denied within the decision timeout.
+// If no decision is reached in time, the on-error behavior applies, and the abandoned decision
+// skips its side effects (see abandoned).
+func (plugin *ImgAuthZPlugin) decideWithinTimeout(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	// No timeout configured
+	if plugin.decisionTimeout <= 0 {
+		return plugin.decide(req, reqURL, request)
+	}
+
+	// Make sure that the docker daemon is not blocked by a slow decision path
+	decision := make(chan authorization.Response, 1)
+	go func() {
+		decision <- plugin.decide(req, reqURL, request)
+	}()
+
+	select {
+	case response := <-decision:
+		return response
+	case <-request.ctx.Done():
+		request.logln("[TIMEOUT] No decision within", plugin.decisionTimeout, request.image.name(), req.RequestMethod, reqURL.String())
+		return plugin.errorResponse(request, reqURL, errDecisionTimeout)
+	}
+}
+
+// Decides whether the registry command is allowed or denied
+func (plugin *ImgAuthZPlugin) decide(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	// The image of a missing or unparseable request body is unknown, so that the request cannot be authorized
+	if request.bodyErr != nil {
+		request.logln("[ERROR] Request body:", request.bodyErr, len(req.RequestBody), "bytes", req.RequestMethod, reqURL.String())
+		return plugin.errorResponse(request, reqURL, request.bodyErr)
+	}
+
+	// Clients of older API versions are denied, even with a break-glass token
+	if response := plugin.authorizeAPIVersion(req, reqURL, request); !response.Allow {
+		return response
+	}
+
+	// Crafted references (e.g. very long or with control characters) are denied before they confuse
+	// the parsing or the logs, even with a break-glass token
+	if err := validateReference(request.rawImage); err != nil {
+		request.logln("[DENIED] Invalid image reference:", loggedReference(request.rawImage), err, req.RequestMethod, reqURL.Path)
+		// The denial message does not repeat the reference
+		unnamed := registryRequest{command: request.command}
+		return authorization.Response{Allow: false, Msg: unnamed.denialMsg("The image reference is invalid: " + err.Error())}
+	}
+
+	// Commits do not involve any registry, and are authorized as per the commit restriction only
+	if request.command == commitCommand {
+		return plugin.authorizeCommit(req, reqURL, request)
+	}
+
+	// Saves do not involve any registry, and are authorized against the confidential images only
+	if request.command == saveCommand {
+		return plugin.authorizeSave(req, reqURL, request)
+	}
+
+	// Container mutations do not involve any registry, and are authorized as per the denied mutations only
+	if request.isMutation() {
+		return plugin.authorizeMutation(req, reqURL, request)
+	}
+
+	// References which do not name any repository (e.g. / or :latest) are denied, even with a break-glass token
+	if len(request.image.repository) == 0 {
+		request.logln("[DENIED] Invalid image reference:", request.rawImage, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image reference " + request.rawImage + " is invalid")}
+	}
+
+	// Host paths are denied regardless of the image, even with a break-glass token
+	if hostPath, denied := plugin.deniedHostMount(request); denied {
+		request.logln("[DENIED] Host mount:", hostPath, request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The host path " + hostPath + " cannot be mounted")}
+	}
+
+	// Capabilities are denied regardless of the image, even with a break-glass token
+	if capability, denied := plugin.deniedCapability(request); denied {
+		request.logln("[DENIED] Capability:", capability, request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The capability " + capability + " cannot be added")}
+	}
+
+	// Pseudo-images do not come from any registry and are always allowed, before any other rule
+	if plugin.isPseudoImage(request) {
+		plugin.debugln(request, "[ALLOWED] Pseudo-image:", request.rawImage, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: true}
+	}
+
+	// Infrastructure images are always allowed, before any other rule
+	if plugin.alwaysAllowedImages.matches(request.image.name()) {
+		plugin.debugln(request, "[ALLOWED] Always allowed image:", request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: true}
+	}
+
+	// Registries on the addresses of the docker host are denied, even with a break-glass token
+	if response := plugin.authorizeLocalRegistry(req, reqURL, request); !response.Allow {
+		return response
+	}
+
+	// Registry queries do not pull any image, and are authorized against the registry and image rules only
+	if request.command == distributionCommand {
+		return plugin.authorizeDistribution(req, reqURL, request)
+	}
+
+	// Registry commands over the rate limit of the user are denied, even with a break-glass token
+	if response := plugin.authorizeRate(req, reqURL, request); !response.Allow {
+		return response
+	}
+
+	// Registry commands for another OS than the allowed ones are denied, even with a break-glass token
+	if response := plugin.authorizeOS(req, reqURL, request); !response.Allow {
+		return response
+	}
+
+	// Registries configured as insecure on the docker daemon are denied, even with a break-glass token
+	if response := plugin.authorizeSecureRegistry(req, reqURL, request); !response.Allow {
+		return response
+	}
+
+	// Registry commands of unauthenticated clients are denied, even with a break-glass token
+	if plugin.requireAuth && len(strings.TrimSpace(req.UserAuthNMethod)) == 0 {
+		request.logln("[DENIED] Unauthenticated client:", request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("Registry commands require an authenticated client")}
+	}
+
+	// Pulls without registry credentials from the registries requiring them are denied, even with a break-glass token
+	if response := plugin.authorizeRegistryAuth(req, reqURL, request); !response.Allow {
+		return response
+	}
+
+	var response authorization.Response
+	if plugin.inspectOnRun && request.command == runCommand {
+		response = plugin.limitedCheck(reqURL, request, func() authorization.Response {
+			return plugin.authorizeResolvedImage(req, reqURL, request)
+		})
+	} else {
+		response = plugin.authorizeRegistryRequest(req, reqURL, request)
+	}
+	if response.Allow {
+		response = plugin.authorizePriorPull(reqURL, request)
+	}
+	if response.Allow {
+		response = plugin.authorizeRegistryIdentity(reqURL, request)
+	}
+	if response.Allow {
+		response = plugin.authorizeImageContent(reqURL, request)
+	}
+	// Pinned after the other checks, so that only the otherwise authorized tags are pinned
+	if response.Allow {
+		response = plugin.authorizeTagPin(reqURL, request)
+	}
+	// Counted last, so that only the otherwise authorized images count against the quota
+	if response.Allow {
+		response = plugin.authorizeImageQuota(req, reqURL, request)
+	}
+
+	// An otherwise denied pull can still be allowed in an emergency. The overridden denial is kept
+	// as the reason of the decision, for the audit log and the notifiers.
+	if response.Allow == false && plugin.isBreakGlass(req, reqURL, request) {
+		return authorization.Response{Allow: true, Msg: "Break-glass override of: " + response.Msg}
+	}
+
+	return response
+}
+
+// Authorizes a registry command against the authorized registries and images: the built-in allowlist matcher.
+// Registry commands which are not authorized (but not explicitly denied either) are left to the next matchers.
+func (plugin *ImgAuthZPlugin) matchAllowlist(req authorization.Request, reqURL *url.URL, request registryRequest) MatchResult {
+	// The cache manifest, if any, replaces the registry and image rules
+	if plugin.approvedImages != nil {
+		return decided(plugin.authorizeCachedImage(req, reqURL, request))
+	}
+
+	requestedImage := request.image
+	requestedRegistry := requestedImage.registry
+
+	// Deny-lists take precedence over the authorized registries and images
+	if plugin.deniedRegistries.matches(plugin.registryNames(requestedRegistry)...) {
+		request.logln("[DENIED] Denied registry:", requestedRegistry, req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: false, Msg: request.denialMsg("The registry " + requestedRegistry + " is denied")})
+	}
+	if plugin.deniedImages.matches(requestedImage.name(), requestedImage.String()) {
+		request.logln("[DENIED] Denied image:", requestedImage, req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: false, Msg: request.denialMsg("The image is denied")})
+	}
+
+	// References without a tag or digest resolve to the latest tag implicitly
+	if plugin.requireExplicitTag && !requestedImage.hasExplicitTag() {
+		request.logln("[DENIED] No explicit tag or digest:", requestedImage.name(), req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: false, Msg: request.denialMsg("An explicit tag or digest is required")})
+	}
+
+	// A single pull of all the tags fetches arbitrarily many images
+	if plugin.denyAllTags && request.allTags {
+		request.logln("[DENIED] All tags:", requestedImage.name(), req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: false, Msg: request.denialMsg("Pulling all the tags of a repository is denied, pull a tag or digest instead")})
+	}
+
+	// References straight to the upstream registries bypass the mirrors
+	if plugin.requireMirror && len(requestedImage.mirror) == 0 {
+		request.logln("[DENIED] Not through a mirror:", requestedImage.name(), req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: false, Msg: request.denialMsg("Images must be requested through a registry mirror: " + plugin.mirrorPrefixesAsString())})
+	}
+
+	// There are no authorized registries, nor a policy backend which could authorize some.
+	if plugin.hasAuthorizedRegistries() == false && plugin.backend == nil {
+		// So, deny the request by default, unless another matcher authorizes it!
+		return abstained(authorization.Response{Allow: false, Msg: request.denialMsg("No authorized registries configured")},
+			"[DENIED] No authorized registries", req.RequestMethod, reqURL.String())
+	}
+
+	// Verify that registry requested is authorized
+	authorizedRegistry := plugin.authorizedRegistries.matches(plugin.registryNames(requestedRegistry)...)
+	if authorizedRegistry == false {
+		// Not in the lists, the policy backend may still authorize it
+		var err error
+		if authorizedRegistry, err = plugin.backendContains(backendRegistries, plugin.registryNames(requestedRegistry)...); err != nil {
+			return decided(plugin.errorResponse(request, reqURL, err))
+		}
+	}
+	if authorizedRegistry == false {
+		// Oops.. The requested registry is not authorized. Deny the request, unless another matcher authorizes it!
+		return abstained(authorization.Response{Allow: false, Msg: request.denialMsg("You can only use docker images from the following authorized registries: " + plugin.authRegistriesAsString)},
+			"[DENIED] Registry:", requestedRegistry, req.RequestMethod, reqURL.String())
+	}
+
+	// Verify that registry requested can be used at this time
+	if withinWindow, windows := plugin.isWithinTimeWindow(requestedRegistry, plugin.now()); withinWindow == false {
+		request.logln("[DENIED] Outside time window:", requestedRegistry, req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: false, Msg: request.denialMsg("The registry " + requestedRegistry + " can only be used during the following time windows: " + strings.Join(windows, "; "))})
+	}
+
+	// Images pinned to approved digests are allowed by these digests only
+	if response, pinned := plugin.authorizePinnedDigest(req, reqURL, request); pinned {
+		return decided(response)
+	}
+
+	// Is an authorized registry and no image rules are configured: Allow!
+	hasImageRules := plugin.hasImageRules()
+	if hasImageRules == false {
+		var err error
+		if hasImageRules, err = plugin.backendHasEntries(backendImages); err != nil {
+			return decided(plugin.errorResponse(request, reqURL, err))
+		}
+	}
+	if hasImageRules == false {
+		request.logln("[ALLOWED] Registry:", requestedRegistry, req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: true})
+	}
+
+	// Verify that image requested is authorized
+	if plugin.isAuthorizedImage(requestedImage) {
+		request.logln("[ALLOWED] Image:", requestedImage, req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: true})
+	}
+	if authorized, err := plugin.backendContains(backendImages, requestedImage.name(), requestedImage.String()); err != nil {
+		return decided(plugin.errorResponse(request, reqURL, err))
+	} else if authorized {
+		request.logln("[ALLOWED] Policy backend image:", requestedImage, req.RequestMethod, reqURL.String())
+		return decided(authorization.Response{Allow: true})
+	}
+
+	// The registry is authorized but the image is not. Deny the request, unless another matcher authorizes it!
+	return abstained(authorization.Response{Allow: false, Msg: request.denialMsg("The image is not authorized on registry " + requestedRegistry)},
+		"[DENIED] Image:", requestedImage, req.RequestMethod, reqURL.String())
+}
+
+// Authorizes the docker client response.
+// All responses are allowed by default.
+func (plugin *ImgAuthZPlugin) AuthZRes(req authorization.Request) authorization.Response {
+	// Allowed by default.
+	return authorization.Response{Allow: true}
+}
@@ -0,0 +1,205 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the policy of the rules, failing the test if it cannot be created
+func testPolicy(t *testing.T, config Config) *Policy {
+	t.Helper()
+	policy, err := NewPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// Starts a registry accepting the connections without ever responding, as a slow decision path.
+// Returns the registry host, and the function stopping the registry.
+func unresponsiveRegistry(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func TestDecisionTimeout(t *testing.T) {
+	registry, stop := unresponsiveRegistry(t)
+	defer stop()
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, pluginConfig{
+		registries:      []string{registry},
+		maxImageSize:    1 << 30,
+		decisionTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := authorization.Request{RequestMethod: "POST", RequestURI: "/images/create?fromImage=" + registry + "/alpine&tag=3.19"}
+
+	// The image size check blocks, and the pull is denied as per the on-error behavior
+	start := time.Now()
+	response := plugin.AuthZReq(req)
+	if response.Allow || !strings.Contains(response.Msg, errDecisionTimeout.Error()) {
+		t.Fatalf("slow pull: allowed %v (%s)", response.Allow, response.Msg)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("slow pull answered after %s", elapsed)
+	}
+
+	plugin.allowOnError = true
+	if response := plugin.AuthZReq(req); !response.Allow {
+		t.Fatalf("slow pull with --on-error allow: %s", response.Msg)
+	}
+}
+
+func TestRepositoryPrefixesMatchWholePathComponents(t *testing.T) {
+	policy := testPolicy(t, Config{
+		Registries:         []string{"docker.io", "my.docker.registry"},
+		RepositoryPrefixes: []string{"platform", "team/"}})
+	for image, allowed := range map[string]bool{
+		"my.docker.registry/platform":          true,
+		"my.docker.registry/platform/app":      true,
+		"my.docker.registry/platform/tools/ci": true,
+		"my.docker.registry/team/app:1.0":      true,
+		"my.docker.registry/platformx/app":     false,
+		"my.docker.registry/platform-tools":    false,
+		"my.docker.registry/teamx/app":         false,
+		"my.docker.registry/other/platform":    false,
+	} {
+		if response := policy.AuthorizePull(image); response.Allow != allowed {
+			t.Errorf("pull of %s: allowed %v, expected %v (%s)", image, response.Allow, allowed, response.Msg)
+		}
+	}
+}
+
+// Matcher allowing the registry commands once released, as a slow decision path
+type blockingMatcher struct {
+	release chan struct{}
+}
+
+func (matcher *blockingMatcher) Name() string {
+	return "blocking"
+}
+
+func (matcher *blockingMatcher) Match(request MatchRequest) MatchResult {
+	<-matcher.release
+	return MatchResult{Verdict: Allow}
+}
+
+func TestDecisionTimeoutSkipsTheSideEffects(t *testing.T) {
+	config := pluginConfig{
+		registries:      []string{"my.docker.registry"},
+		imageQuota:      1,
+		quotaWindow:     time.Hour,
+		decisionTimeout: 50 * time.Millisecond}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := &blockingMatcher{release: make(chan struct{})}
+	plugin.matchers = append(plugin.matchers, slow)
+	policy := &Policy{plugin: plugin}
+
+	// The slow run times out, and is denied as per the on-error behavior
+	start := time.Now()
+	response := policy.AuthorizeRun("alpine:3.19")
+	if response.Allow || !strings.Contains(response.Msg, errDecisionTimeout.Error()) {
+		t.Fatalf("slow run: allowed %v (%s)", response.Allow, response.Msg)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("slow run answered after %s", elapsed)
+	}
+
+	// The abandoned decision completes without counting against the quota of a single image
+	close(slow.release)
+	time.Sleep(50 * time.Millisecond)
+	if response := policy.AuthorizeRun("busybox:1.36"); !response.Allow {
+		t.Fatalf("run after the timeout: %s", response.Msg)
+	}
+	if response := policy.AuthorizeRun("alpine:3.19"); response.Allow {
+		t.Fatal("run over the quota allowed")
+	}
+}
+
+func TestQueryParametersAreDecodedOnce(t *testing.T) {
+	for _, test := range []struct {
+		config Config
+		uri    string
+	}{
+		// An escaped tag does not hide the tag the docker daemon pulls
+		{Config{Registries: []string{"docker.io"}, Images: []string{"docker.io/library/alpine:3.*"}},
+			"/images/create?fromImage=alpine&x=%26tag%3D3.19&tag=edge"},
+		// An escaped platform does not hide the platform the docker daemon pulls
+		{Config{Registries: []string{"docker.io"}, AllowedOS: []string{"linux"}},
+			"/images/create?fromImage=alpine&tag=3.19&x=%26platform%3Dlinux&platform=windows"},
+		// An escaped image does not hide the image the docker daemon pulls
+		{Config{Registries: []string{"docker.io"}},
+			"/images/create?x=%26fromImage%3Dalpine%26tag%3D3.19&fromImage=evil.io/bad&tag=1"},
+	} {
+		policy := testPolicy(t, test.config)
+		if response := policy.Authorize(authorization.Request{RequestMethod: "POST", RequestURI: test.uri}); response.Allow {
+			t.Errorf("%s allowed", test.uri)
+		}
+	}
+
+	// The escaped values are decoded as part of their parameter
+	policy := testPolicy(t, Config{Registries: []string{"docker.io"}, Images: []string{"docker.io/library/alpine:3.*"}})
+	if response := policy.Authorize(authorization.Request{RequestMethod: "POST", RequestURI: "/images/create?fromImage=alpine&tag=3%2E19"}); !response.Allow {
+		t.Errorf("escaped tag denied: %s", response.Msg)
+	}
+	response := policy.Authorize(authorization.Request{RequestMethod: "POST", RequestURI: "/images/create?fromImage=alpine%zz&tag=3.19"})
+	if response.Allow || !strings.Contains(response.Msg, "the request URL cannot be parsed") {
+		t.Errorf("invalid escape: allowed %v (%s)", response.Allow, response.Msg)
+	}
+}
+
+func TestDebugLogsQuoteTheRequestedReferences(t *testing.T) {
+	config := pluginConfig{registries: []string{"docker.io"}, debug: true}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{plugin: plugin}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(ioutil.Discard)
+	if response := policy.Authorize(pullRequest("alpine\x1b[31m\u202e:3.19")); response.Allow {
+		t.Error("pull of an invalid reference allowed")
+	}
+	if strings.ContainsAny(logs.String(), "\x1b\u202e") {
+		t.Errorf("special characters logged: %q", logs.String())
+	}
+	if expected := `[DEBUG] [REQUEST] pull "alpine\x1b[31m\u202e:3.19"`; !strings.Contains(logs.String(), expected) {
+		t.Errorf("%q not logged: %q", expected, logs.String())
+	}
+}
@@ -0,0 +1,242 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Effective policy of the plugin, as dumped by --dump-policy.
+// Lists are deduplicated and sorted, so that the output is stable for a given configuration.
+type policy struct {
+	Registries         []string `json:"registries"`
+	Images             []string `json:"images"`
+	RepositoryPrefixes []string `json:"repositoryPrefixes"`
+	DeniedRegistries   []string `json:"deniedRegistries"`
+	DeniedImages       []string `json:"deniedImages"`
+	MaxImageSize       int64    `json:"maxImageSize"`
+	UnknownImageSize   string   `json:"unknownImageSize"`
+	MaxLayers          int      `json:"maxLayers"`
+	UnknownLayers      string   `json:"unknownLayers"`
+	OnError            string   `json:"onError"`
+	OnUnparseable      string   `json:"onUnparseable"`
+	DecisionTimeout    string   `json:"decisionTimeout"`
+	DecisionCacheTTL   string   `json:"decisionCacheTTL"`
+	NegativeCacheTTL   string   `json:"negativeCacheTTL"`
+	BreakerFailures    int      `json:"breakerFailures"`
+	BreakerCooldown    string   `json:"breakerCooldown"`
+	BreakerOpen        string   `json:"breakerOpen"`
+	RegistryWindows    []string `json:"registryWindows"`
+	RegistryAliases    []string `json:"registryAliases"`
+	CanonicalEntries   string   `json:"canonicalizeEntries"`
+	AlwaysAllow        []string `json:"alwaysAllow"`
+	PseudoImages       []string `json:"pseudoImages"`
+	DeniedHostMounts   []string `json:"deniedHostMounts"`
+	DeniedCapabilities []string `json:"deniedCapabilities"`
+	DefaultRegistry    string   `json:"defaultRegistry,omitempty"`
+	MirrorPrefixes     []string `json:"mirrorPrefixes"`
+	RequireMirror      bool     `json:"requireMirror"`
+	AllowedOS          []string `json:"allowedOS"`
+	DenyInsecure       bool     `json:"denyInsecureRegistry"`
+	DenyLocal          bool     `json:"denyLocalRegistries"`
+	DenyAllTags        bool     `json:"denyAllTags"`
+	RestrictDist       bool     `json:"restrictDistribution"`
+	RestrictSave       []string `json:"restrictSave"`
+	DenyMutations      []string `json:"denyMutations"`
+	RequireAuth        bool     `json:"requireAuth"`
+	RegistryAuth       []string `json:"requireRegistryAuth"`
+	RegistryCAs        []string `json:"registryCAs"`
+	SkipVerify         []string `json:"registrySkipVerify"`
+	Fingerprints       []string `json:"registryFingerprints"`
+	AnyRegistryPort    bool     `json:"anyRegistryPort"`
+	MinAPIVersion      string   `json:"minAPIVersion,omitempty"`
+	ImageQuota         int      `json:"imageQuota"`
+	ImageQuotaWindow   string   `json:"imageQuotaWindow"`
+	RateLimit          int      `json:"rateLimit"`
+	RateLimitInterval  string   `json:"rateLimitInterval"`
+	PinTags            bool     `json:"pinTags"`
+	PinTagsFile        string   `json:"pinTagsFile,omitempty"`
+	MatchPlatforms     bool     `json:"matchPlatformDigests"`
+	RequirePriorPull   bool     `json:"requirePriorPull"`
+	PriorPullsFile     string   `json:"priorPullsFile,omitempty"`
+	InspectOnRun       bool     `json:"inspectOnRun"`
+	InspectMatch       string   `json:"inspectMatch"`
+	MaxChecks          int      `json:"maxConcurrentChecks"`
+	ChecksOverLimit    string   `json:"checksOverLimit"`
+	HelpURL            string   `json:"helpURL,omitempty"`
+	RequireExplicitTag bool     `json:"requireExplicitTag"`
+	RestrictCommit     string   `json:"restrictCommit"`
+	EnforceAfter       string   `json:"enforceAfter,omitempty"`
+	MinRegistries      int      `json:"minRegistries"`
+	RequireProvenance  bool     `json:"requireProvenance"`
+	TrustedBuilders    []string `json:"trustedBuilders"`
+	SignedBy           []string `json:"signedBy"`
+	RequireSBOM        bool     `json:"requireSBOM"`
+	SBOMService        string   `json:"sbomService,omitempty"`
+	RequiredLabels     []string `json:"requiredLabels"`
+	CacheManifest      string   `json:"cacheManifest,omitempty"`
+	CachedImages       []string `json:"cachedImages,omitempty"`
+	PolicyBackend      string   `json:"policyBackend,omitempty"`
+	RemotePolicy       string   `json:"remotePolicy,omitempty"`
+	CELRules           []string `json:"celRules"`
+	BreakGlass         bool     `json:"breakGlass"`
+	// Sources of the entries of the rule lists, per list and entry
+	Sources map[string]map[string][]string `json:"sources"`
+}
+
+// Returns a deduplicated and sorted copy of the list
+func sortedSet(list []string) []string {
+	seen := make(map[string]bool)
+	set := make([]string, 0, len(list))
+	for _, entry := range list {
+		if !seen[entry] {
+			seen[entry] = true
+			set = append(set, entry)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+// Returns "allow" or "deny"
+func allowOrDeny(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Returns "any" or "all"
+func anyOrAll(all bool) string {
+	if all {
+		return "all"
+	}
+	return "any"
+}
+
+// Returns "queue" or "deny"
+func queueOrDeny(queue bool) string {
+	if queue {
+		return "queue"
+	}
+	return "deny"
+}
+
+// Returns the enforcement cutover time as an RFC 3339 timestamp, or empty if enforced right away
+func enforceAfterString(cutover time.Time) string {
+	if cutover.IsZero() {
+		return ""
+	}
+	return cutover.Format(time.RFC3339)
+}
+
+// Returns the minimum API version, or empty if any version is allowed
+func minAPIVersionString(version *apiVersion) string {
+	if version == nil {
+		return ""
+	}
+	return version.String()
+}
+
+// Returns the commit restriction, off if not set
+func commitRestriction(restriction string) string {
+	if len(restriction) == 0 {
+		return commitOff
+	}
+	return restriction
+}
+
+// Returns the canonicalization of the policy entries, off if not set
+func canonicalization(mode string) string {
+	if len(mode) == 0 {
+		return canonicalOff
+	}
+	return mode
+}
+
+// Returns the effective policy of the plugin.
+// The break-glass token itself is never part of the policy.
+func (plugin *ImgAuthZPlugin) policy() policy {
+	config := plugin.pluginConfig
+	return policy{
+		Registries:         sortedSet(config.registries),
+		Images:             sortedSet(config.images),
+		RepositoryPrefixes: sortedSet(config.repositoryPrefixes),
+		DeniedRegistries:   sortedSet(config.denyRegistries),
+		DeniedImages:       sortedSet(config.denyImages),
+		MaxImageSize:       config.maxImageSize,
+		UnknownImageSize:   allowOrDeny(config.allowUnknownSize),
+		MaxLayers:          config.maxLayers,
+		UnknownLayers:      allowOrDeny(config.allowUnknownLayers),
+		OnError:            allowOrDeny(config.allowOnError),
+		OnUnparseable:      allowOrDeny(config.allowUnparseable),
+		DecisionTimeout:    config.decisionTimeout.String(),
+		DecisionCacheTTL:   config.cacheTTL.String(),
+		NegativeCacheTTL:   config.negativeTTL.String(),
+		BreakerFailures:    config.breakerFailures,
+		BreakerCooldown:    config.breakerCooldown.String(),
+		BreakerOpen:        allowOrDeny(config.breakerDegrade),
+		RegistryWindows:    sortedSet(config.registryWindows),
+		RegistryAliases:    sortedSet(config.registryAliases),
+		CanonicalEntries:   canonicalization(config.canonicalEntries),
+		AlwaysAllow:        sortedSet(config.alwaysAllow),
+		PseudoImages:       sortedSet(config.pseudoImages),
+		DeniedHostMounts:   sortedSet(config.denyHostMounts),
+		DeniedCapabilities: sortedSet(normalizeEntries(config.denyCapabilities, normalizeCapability)),
+		DefaultRegistry:    config.defaultRegistry,
+		MirrorPrefixes:     sortedSet(config.mirrorPrefixes),
+		RequireMirror:      config.requireMirror,
+		AllowedOS:          sortedSet(config.allowedOS),
+		DenyInsecure:       config.denyInsecure,
+		DenyLocal:          config.denyLocal,
+		DenyAllTags:        config.denyAllTags,
+		RestrictDist:       config.restrictDist,
+		RestrictSave:       sortedSet(config.restrictSave),
+		DenyMutations:      sortedSet(config.denyMutations),
+		RequireAuth:        config.requireAuth,
+		RegistryAuth:       sortedSet(config.registryAuth),
+		RegistryCAs:        sortedSet(config.registryCAs),
+		SkipVerify:         sortedSet(config.skipVerify),
+		Fingerprints:       sortedSet(config.fingerprints),
+		AnyRegistryPort:    config.anyRegistryPort,
+		MinAPIVersion:      minAPIVersionString(config.minAPIVersion),
+		ImageQuota:         config.imageQuota,
+		ImageQuotaWindow:   config.quotaWindow.String(),
+		RateLimit:          config.rateLimit,
+		RateLimitInterval:  config.rateInterval.String(),
+		PinTags:            config.pinTags,
+		PinTagsFile:        config.pinTagsFile,
+		MatchPlatforms:     config.matchPlatforms,
+		RequirePriorPull:   config.requirePriorPull,
+		PriorPullsFile:     config.priorPullsFile,
+		InspectOnRun:       config.inspectOnRun,
+		InspectMatch:       anyOrAll(config.inspectMatchAll),
+		MaxChecks:          config.checkLimit,
+		ChecksOverLimit:    queueOrDeny(config.queueChecks),
+		HelpURL:            config.helpURL,
+		RequireExplicitTag: config.requireExplicitTag,
+		RestrictCommit:     commitRestriction(config.restrictCommit),
+		EnforceAfter:       enforceAfterString(config.enforceAfter),
+		MinRegistries:      config.minRegistries,
+		RequireProvenance:  config.requireProvenance,
+		TrustedBuilders:    sortedSet(config.trustedBuilders),
+		SignedBy:           sortedSet(config.signedBy),
+		RequireSBOM:        config.requireSBOM,
+		SBOMService:        config.sbomService,
+		RequiredLabels:     sortedSet(config.requireLabels),
+		CacheManifest:      config.cacheManifest,
+		CachedImages:       sortedSet(config.cachedImages),
+		PolicyBackend:      redactedBackendURL(config.policyBackend),
+		RemotePolicy:       redactedBackendURL(config.remotePolicy),
+		CELRules:           append([]string{}, config.celRules...),
+		BreakGlass:         len(config.breakGlassToken) > 0,
+		Sources:            config.entrySources()}
+}
+
+// Returns the effective policy of the plugin as indented JSON
+func (plugin *ImgAuthZPlugin) dumpPolicy() ([]byte, error) {
+	return json.MarshalIndent(plugin.policy(), "", "  ")
+}
@@ -0,0 +1,150 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Lists of the policy backend, authorizing registries and images in addition to the local policy
+const (
+	backendRegistries = "registries"
+	backendImages     = "images"
+)
+
+// Source of policy entries queried at request time, e.g. a key-value store
+type policySource interface {
+	// Returns true if any of the entries is a member of the list
+	containsAny(list string, entries ...string) (bool, error)
+	// Returns true if the list has any entry
+	hasEntries(list string) (bool, error)
+}
+
+// Create a new policy source for the backend URL, e.g. redis://kv.example:6379/0,
+// caching the answers for the given duration (not cached if 0)
+func newPolicySource(backendURL string, ttl time.Duration) (policySource, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --policy-backend value: %v", err)
+	}
+
+	var source policySource
+	switch u.Scheme {
+	case "redis", "rediss":
+		if source, err = newRedisPolicySource(u); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid --policy-backend value: unsupported scheme %q (expected redis or rediss)", u.Scheme)
+	}
+
+	if ttl <= 0 {
+		return source, nil
+	}
+	return newCachedPolicySource(source, ttl), nil
+}
+
+// Returns the backend URL without its password, if any, for logging
+func redactedBackendURL(backendURL string) string {
+	u, err := url.Parse(backendURL)
+	if err != nil || u.User == nil {
+		return backendURL
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// Cached answer of the policy source
+type cachedAnswer struct {
+	answer  bool
+	expires time.Time
+}
+
+// Policy source caching the answers of another policy source, so that the latency of the requests
+// is bounded by the cache hits. Errors are not cached.
+type cachedPolicySource struct {
+	sync.Mutex
+	source  policySource
+	ttl     time.Duration
+	answers map[string]cachedAnswer
+	now     func() time.Time
+}
+
+// Create a new caching policy source
+func newCachedPolicySource(source policySource, ttl time.Duration) *cachedPolicySource {
+	return &cachedPolicySource{source: source, ttl: ttl, answers: make(map[string]cachedAnswer), now: time.Now}
+}
+
+// Returns the cached answer for the key, and true if it is cached and not expired yet
+func (cache *cachedPolicySource) cached(key string) (bool, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	cached, ok := cache.answers[key]
+	if !ok || cache.now().After(cached.expires) {
+		delete(cache.answers, key)
+		return false, false
+	}
+	return cached.answer, true
+}
+
+// Caches the answer for the key
+func (cache *cachedPolicySource) store(key string, answer bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.answers[key] = cachedAnswer{answer: answer, expires: cache.now().Add(cache.ttl)}
+}
+
+// Returns true if any of the entries is a member of the list, querying the entries which are not cached
+func (cache *cachedPolicySource) containsAny(list string, entries ...string) (bool, error) {
+	for _, entry := range entries {
+		key := "member/" + list + "/" + entry
+		member, ok := cache.cached(key)
+		if !ok {
+			var err error
+			if member, err = cache.source.containsAny(list, entry); err != nil {
+				return false, err
+			}
+			cache.store(key, member)
+		}
+		if member {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns true if the list has any entry, querying the source if it is not cached
+func (cache *cachedPolicySource) hasEntries(list string) (bool, error) {
+	key := "entries/" + list
+	if answer, ok := cache.cached(key); ok {
+		return answer, nil
+	}
+	answer, err := cache.source.hasEntries(list)
+	if err != nil {
+		return false, err
+	}
+	cache.store(key, answer)
+	return answer, nil
+}
+
+// Returns true if any of the entries is a member of the list of the policy backend, if any
+func (plugin *ImgAuthZPlugin) backendContains(list string, entries ...string) (bool, error) {
+	if plugin.backend == nil {
+		return false, nil
+	}
+	return plugin.backend.containsAny(list, entries...)
+}
+
+// Returns true if the list of the policy backend, if any, has any entry
+func (plugin *ImgAuthZPlugin) backendHasEntries(list string) (bool, error) {
+	if plugin.backend == nil {
+		return false, nil
+	}
+	return plugin.backend.hasEntries(list)
+}
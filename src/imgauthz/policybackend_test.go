@@ -0,0 +1,121 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Policy source of in-memory lists, counting its queries, or failing
+type memoryPolicySource struct {
+	lists   map[string][]string
+	err     error
+	queries int
+}
+
+func (source *memoryPolicySource) containsAny(list string, entries ...string) (bool, error) {
+	source.queries++
+	if source.err != nil {
+		return false, source.err
+	}
+	for _, entry := range entries {
+		for _, member := range source.lists[list] {
+			if entry == member {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (source *memoryPolicySource) hasEntries(list string) (bool, error) {
+	source.queries++
+	return len(source.lists[list]) > 0, source.err
+}
+
+func TestPolicyBackendAuthorizesInAdditionToTheLocalPolicy(t *testing.T) {
+	for _, test := range []struct {
+		config  pluginConfig
+		lists   map[string][]string
+		err     error
+		image   string
+		allowed bool
+		msg     string
+	}{
+		// Registries of the backend, with or without local registries
+		{pluginConfig{}, map[string][]string{backendRegistries: {"my.docker.registry"}}, nil, "my.docker.registry/app:1.0", true, ""},
+		{pluginConfig{}, map[string][]string{backendRegistries: {"my.docker.registry"}}, nil, "quay.io/app:1.0", false, "authorized registries"},
+		{pluginConfig{registries: []string{"docker.io"}}, map[string][]string{backendRegistries: {"my.docker.registry"}}, nil, "alpine:3.19", true, ""},
+		// Images of the backend, by name or by reference, restricting the registries as the local images
+		{pluginConfig{}, map[string][]string{backendRegistries: {"my.docker.registry"}, backendImages: {"my.docker.registry/team/app"}}, nil, "my.docker.registry/team/app:1.0", true, ""},
+		{pluginConfig{}, map[string][]string{backendRegistries: {"my.docker.registry"}, backendImages: {"my.docker.registry/team/app:1.0"}}, nil, "my.docker.registry/team/app:2.0", false, "not authorized on registry"},
+		{pluginConfig{registries: []string{"docker.io"}}, map[string][]string{backendImages: {"docker.io/library/alpine"}}, nil, "busybox:1.36", false, "not authorized on registry"},
+		// The deny-lists take precedence over the backend
+		{pluginConfig{denyRegistries: []string{"my.docker.registry"}}, map[string][]string{backendRegistries: {"my.docker.registry"}}, nil, "my.docker.registry/app:1.0", false, ""},
+		// The backend is not queried for the locally authorized images
+		{pluginConfig{registries: []string{"docker.io"}, images: []string{"docker.io/library/alpine"}}, nil, errors.New("connection refused"), "alpine:3.19", true, ""},
+		// Backend errors apply the on-error behavior
+		{pluginConfig{}, nil, errors.New("connection refused"), "my.docker.registry/app:1.0", false, "connection refused"},
+		{pluginConfig{allowOnError: true}, nil, errors.New("connection refused"), "my.docker.registry/app:1.0", true, ""},
+	} {
+		config := test.config
+		if err := mergeRuleSources(&config, nil); err != nil {
+			t.Fatal(err)
+		}
+		plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plugin.backend = &memoryPolicySource{lists: test.lists, err: test.err}
+		policy := &Policy{plugin: plugin}
+		if response := policy.AuthorizePull(test.image); response.Allow != test.allowed || !strings.Contains(response.Msg, test.msg) {
+			t.Errorf("pull of %s with %v: allowed %v (%s)", test.image, test.lists, response.Allow, response.Msg)
+		}
+	}
+}
+
+func TestPolicyBackendAnswersAreCached(t *testing.T) {
+	source := &memoryPolicySource{lists: map[string][]string{backendRegistries: {"my.docker.registry"}}}
+	now := time.Now()
+	cache := newCachedPolicySource(source, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for _, test := range []struct {
+		elapsed time.Duration
+		err     error
+		entry   string
+		member  bool
+		queries int
+	}{
+		{0, nil, "my.docker.registry", true, 1},
+		{30 * time.Second, nil, "my.docker.registry", true, 1},
+		// Expired
+		{time.Minute, nil, "my.docker.registry", true, 2},
+		{0, nil, "quay.io", false, 3},
+		{0, nil, "quay.io", false, 3},
+		// The errors are not cached
+		{0, errors.New("connection refused"), "ghcr.io", false, 4},
+		{0, errors.New("connection refused"), "ghcr.io", false, 5},
+		{0, nil, "ghcr.io", false, 6},
+	} {
+		now = now.Add(test.elapsed)
+		source.err = test.err
+		member, err := cache.containsAny(backendRegistries, test.entry)
+		if member != test.member || (err != nil) != (test.err != nil) || source.queries != test.queries {
+			t.Errorf("%s: member %v (%v), %d queries, expected %v and %d queries", test.entry, member, err, source.queries, test.member, test.queries)
+		}
+	}
+}
+
+func TestPolicyBackendURLs(t *testing.T) {
+	if _, err := newPolicySource("etcd://kv.example:2379", 0); err == nil {
+		t.Error("etcd backend accepted")
+	}
+	if redacted := redactedBackendURL("redis://:s3cr3t@kv.example:6379/0"); strings.Contains(redacted, "s3cr3t") {
+		t.Errorf("password logged: %s", redacted)
+	}
+}
@@ -0,0 +1,108 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Images pulled with the authorization of the plugin (registry/repository:tag or registry/repository@digest),
+// in memory and persisted to the pulls file if any
+type priorPulls struct {
+	sync.Mutex
+	// File the pulled images are loaded from and saved to (in memory only if empty)
+	path   string
+	images map[string]bool
+}
+
+// Create new prior pulls, loaded from the pulls file if it exists
+func newPriorPulls(path string) (*priorPulls, error) {
+	pulls := &priorPulls{path: path, images: make(map[string]bool)}
+	if len(path) == 0 {
+		return pulls, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pulls, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the prior pulls file: %v", err)
+	}
+	var images []string
+	if err := json.Unmarshal(data, &images); err != nil {
+		return nil, fmt.Errorf("parsing the prior pulls file %s: %v", path, err)
+	}
+	for _, image := range images {
+		pulls.images[image] = true
+	}
+	return pulls, nil
+}
+
+// Records the pull of the image, and saves it to the pulls file if it is new.
+// The pull is recorded in memory even if it cannot be saved.
+func (pulls *priorPulls) add(image string) error {
+	pulls.Lock()
+	defer pulls.Unlock()
+
+	if pulls.images[image] {
+		return nil
+	}
+	pulls.images[image] = true
+	if len(pulls.path) == 0 {
+		return nil
+	}
+
+	images := make([]string, 0, len(pulls.images))
+	for pulled := range pulls.images {
+		images = append(images, pulled)
+	}
+	sort.Strings(images)
+	data, err := json.MarshalIndent(images, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(pulls.path, append(data, '\n'))
+	}
+	if err != nil {
+		return fmt.Errorf("saving the prior pulls file: %v", err)
+	}
+	return nil
+}
+
+// Returns true if the image was pulled with the authorization of the plugin
+func (pulls *priorPulls) contains(image string) bool {
+	pulls.Lock()
+	defer pulls.Unlock()
+	return pulls.images[image]
+}
+
+// Records an authorized pull, if the runs require a prior pull
+func (plugin *ImgAuthZPlugin) recordPull(request registryRequest) {
+	if plugin.priorPulls == nil || request.command != pullCommand {
+		return
+	}
+	if err := plugin.priorPulls.add(request.image.String()); err != nil {
+		request.logln("[ERROR] Prior pull:", request.image.String(), err)
+	}
+}
+
+// Authorizes a docker run command against the images pulled with the authorization of the plugin,
+// if the runs require a prior pull: images which were not pulled through the plugin (e.g. pulled before
+// it was installed, or loaded from an archive) are denied, even if their name is authorized.
+func (plugin *ImgAuthZPlugin) authorizePriorPull(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.priorPulls == nil || request.command != runCommand {
+		return authorization.Response{Allow: true}
+	}
+	if !plugin.priorPulls.contains(request.image.String()) {
+		request.logln("[DENIED] No prior pull:", request.image.String(), reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The image " + request.image.String() + " was not pulled through the authorization plugin, please pull it first")}
+	}
+	return authorization.Response{Allow: true}
+}
@@ -0,0 +1,217 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Media type of the attestation layers, as attached by cosign attest
+	mediaTypeDSSEEnvelope = "application/vnd.dsse.envelope.v1+json"
+	// Payload type of the in-toto attestations
+	inTotoPayloadType = "application/vnd.in-toto+json"
+	// SLSA provenance predicate types
+	slsaProvenanceV02 = "https://slsa.dev/provenance/v0.2"
+	slsaProvenanceV1  = "https://slsa.dev/provenance/v1"
+)
+
+// Returned when an image has no attestation at all
+var errNoAttestation = errors.New("no provenance attestation")
+
+// Verifies the provenance of the images
+type provenanceVerifier interface {
+	// Returns the ID of the trusted builder which built the image,
+	// or an error if the image has no acceptable provenance
+	verifyProvenance(ref imageReference) (string, error)
+}
+
+// Verifies the SLSA provenance attestations attached to the images on their registry,
+// as attached by cosign attest --key: the attestations are DSSE envelopes signed with the
+// configured public key, stored as layers of the sha256-<digest>.att tag of the image repository.
+type attestationVerifier struct {
+	// Registry client
+	registry *registryClient
+	// Public key signing the attestations (Ed25519, ECDSA or RSA)
+	key crypto.PublicKey
+	// Trusted builder identities
+	builders *patternSet
+}
+
+// DSSE envelope of an attestation
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// In-toto statement of a SLSA provenance attestation, v0.2 or v1
+type provenanceStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	Predicate struct {
+		// SLSA provenance v0.2
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		// SLSA provenance v1
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// Create a new attestation verifier, with the PEM encoded public key file signing the attestations
+// and the trusted builder identities (exact, glob patterns or regular expressions)
+func newAttestationVerifier(keyPath string, builders []string, registry *registryClient) (*attestationVerifier, error) {
+	if len(builders) == 0 {
+		return nil, errors.New("--require-provenance requires at least one --trusted-builder")
+	}
+	key, err := readPublicKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provenance public key %s: %v", keyPath, err)
+	}
+	trusted, err := newPatternSet(builders)
+	if err != nil {
+		return nil, err
+	}
+	return &attestationVerifier{registry: registry, key: key, builders: trusted}, nil
+}
+
+// Returns the ID of the trusted builder of the first valid provenance attestation of the image
+func (verifier *attestationVerifier) verifyProvenance(ref imageReference) (string, error) {
+	host, repository := ref.registryHost()
+
+	// Attestations are attached to the digest of the manifest (list) the reference resolves to
+	digest, err := verifier.registry.resolveDigest(ref)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("unsupported image digest %s", digest)
+	}
+
+	data, _, err := verifier.registry.fetchRaw(attachedManifestURL(host, repository, digest, "att"), manifestMediaTypes())
+	if err != nil {
+		return "", errNoAttestation
+	}
+	var attestations imageManifest
+	if err := json.Unmarshal(data, &attestations); err != nil {
+		return "", fmt.Errorf("decoding the attestations of %s: %v", digest, err)
+	}
+
+	err = errNoAttestation
+	for _, layer := range attestations.Layers {
+		if layer.MediaType != mediaTypeDSSEEnvelope {
+			continue
+		}
+		envelope, _, fetchErr := verifier.registry.fetchRaw(registryBlobURL(host, repository, layer.Digest), mediaTypeDSSEEnvelope)
+		if fetchErr != nil {
+			err = fetchErr
+			continue
+		}
+		var builder string
+		if builder, err = verifier.verifyEnvelope(envelope, strings.TrimPrefix(digest, "sha256:")); err == nil {
+			return builder, nil
+		}
+	}
+	return "", err
+}
+
+// Verifies a DSSE envelope: its signature, its SLSA provenance predicate, its subject digest
+// and its builder. Returns the builder ID.
+func (verifier *attestationVerifier) verifyEnvelope(data []byte, sha256Hex string) (string, error) {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return "", fmt.Errorf("decoding the attestation: %v", err)
+	}
+	if envelope.PayloadType != inTotoPayloadType {
+		return "", fmt.Errorf("unsupported attestation payload type %s", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return "", fmt.Errorf("decoding the attestation payload: %v", err)
+	}
+
+	signed := false
+	pae := dssePAE(envelope.PayloadType, payload)
+	for _, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err == nil && verifySignature(verifier.key, pae, sig) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return "", errors.New("invalid attestation signature")
+	}
+
+	var statement provenanceStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return "", fmt.Errorf("decoding the attestation statement: %v", err)
+	}
+	builder := statement.Predicate.Builder.ID
+	switch statement.PredicateType {
+	case slsaProvenanceV02:
+	case slsaProvenanceV1:
+		builder = statement.Predicate.RunDetails.Builder.ID
+	default:
+		return "", fmt.Errorf("attestation is not a SLSA provenance: %s", statement.PredicateType)
+	}
+
+	subject := false
+	for _, s := range statement.Subject {
+		if s.Digest["sha256"] == sha256Hex {
+			subject = true
+		}
+	}
+	if !subject {
+		return "", errors.New("provenance attestation of another image")
+	}
+	if !verifier.builders.matches(builder) {
+		return "", fmt.Errorf("untrusted builder %q", builder)
+	}
+	return builder, nil
+}
+
+// Returns the DSSE pre-authentication encoding of the payload, which is what is signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// Authorizes a pulled image by its provenance, if provenance is required.
+// Images without acceptable provenance are denied, including when it cannot be verified.
+func (plugin *ImgAuthZPlugin) authorizeProvenance(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.provenance == nil || request.command != pullCommand {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		builder, err := plugin.provenance.verifyProvenance(request.image)
+		if err != nil {
+			if response, ok := plugin.degradedResponse(request, reqURL, "provenance check", err); ok {
+				return response
+			}
+			request.logln("[DENIED] Provenance:", request.image.String(), err, reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The image has no acceptable provenance: " + err.Error())}
+		}
+		plugin.debugln(request, "[PROVENANCE] Built by trusted builder:", builder, request.image.String())
+		return authorization.Response{Allow: true}
+	})
+}
@@ -0,0 +1,73 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Distinct images (registry/repository) run per user within a sliding time window, in memory.
+// An image is counted until the window has elapsed since the user last ran it.
+type imageQuotas struct {
+	sync.Mutex
+	// Maximum number of distinct images per user
+	limit  int
+	window time.Duration
+	// Time each image was last run, per user
+	images map[string]map[string]time.Time
+	now    func() time.Time
+}
+
+// Create new image quotas of the given number of distinct images per user and window
+func newImageQuotas(limit int, window time.Duration) *imageQuotas {
+	return &imageQuotas{limit: limit, window: window, images: make(map[string]map[string]time.Time), now: time.Now}
+}
+
+// Records the image run by the user and returns true, unless it is a new distinct image
+// beyond the quota of the user. Returns the number of distinct images of the user as well.
+func (quotas *imageQuotas) use(user string, image string) (bool, int) {
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	now := quotas.now()
+	images := quotas.images[user]
+	for name, last := range images {
+		if now.Sub(last) >= quotas.window {
+			delete(images, name)
+		}
+	}
+
+	if _, seen := images[image]; !seen && len(images) >= quotas.limit {
+		return false, len(images)
+	}
+	if images == nil {
+		images = make(map[string]time.Time)
+		quotas.images[user] = images
+	}
+	images[image] = now
+	return true, len(images)
+}
+
+// Authorizes a docker run command against the image quota of the user, if any.
+// Runs by clients without a user are counted together, as an anonymous user.
+func (plugin *ImgAuthZPlugin) authorizeImageQuota(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.quotas == nil || request.command != runCommand {
+		return authorization.Response{Allow: true}
+	}
+
+	// A decision which timed out meanwhile must not count against the quota
+	if err := request.abandoned(); err != nil {
+		return plugin.errorResponse(request, reqURL, errDecisionTimeout)
+	}
+	allowed, count := plugin.quotas.use(req.User, request.image.name())
+	if !allowed {
+		request.logln("[DENIED] Image quota:", request.image.name(), "user:", req.User, req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("You already ran " + strconv.Itoa(count) + " distinct images within " + plugin.quotaWindow.String() + ", the maximum allowed")}
+	}
+	return authorization.Response{Allow: true}
+}
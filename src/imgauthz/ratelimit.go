@@ -0,0 +1,96 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Limits the rate of the registry commands per user with token buckets, in memory.
+// Each bucket holds up to limit tokens and is refilled by limit tokens per interval, so that
+// a user can burst up to the limit, then run limit commands per interval.
+type rateLimiter struct {
+	sync.Mutex
+	// Maximum number of registry commands per user and interval
+	limit    int
+	interval time.Duration
+	// Token bucket per user, the users without a name sharing a single bucket
+	buckets map[string]*tokenBucket
+	// Time of the last cleanup of the refilled buckets
+	cleaned time.Time
+	now     func() time.Time
+}
+
+// Token bucket of a user
+type tokenBucket struct {
+	tokens float64
+	// Time the tokens were last updated
+	updated time.Time
+}
+
+// Create a new rate limiter of the given number of registry commands per user and interval
+func newRateLimiter(limit int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, interval: interval, buckets: make(map[string]*tokenBucket), cleaned: time.Now(), now: time.Now}
+}
+
+// Takes a token from the bucket of the user and returns true, unless the bucket is empty.
+// Returns the delay until the next token as well, if the bucket is empty.
+func (limiter *rateLimiter) take(user string) (bool, time.Duration) {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	now := limiter.now()
+	limiter.cleanup(now)
+
+	bucket, ok := limiter.buckets[user]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limiter.limit), updated: now}
+		limiter.buckets[user] = bucket
+	}
+	perToken := limiter.interval / time.Duration(limiter.limit)
+	bucket.tokens += float64(now.Sub(bucket.updated)) / float64(perToken)
+	if bucket.tokens > float64(limiter.limit) {
+		bucket.tokens = float64(limiter.limit)
+	}
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(perToken))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Removes the buckets which are full again, once per interval, so that the buckets of the users
+// who stopped sending commands do not pile up. A removed bucket is recreated full.
+func (limiter *rateLimiter) cleanup(now time.Time) {
+	if now.Sub(limiter.cleaned) < limiter.interval {
+		return
+	}
+	for user, bucket := range limiter.buckets {
+		if now.Sub(bucket.updated) >= limiter.interval {
+			delete(limiter.buckets, user)
+		}
+	}
+	limiter.cleaned = now
+}
+
+// Authorizes a registry command against the rate limit of the user, if any.
+// Commands of clients without a user are limited together, as an anonymous user.
+func (plugin *ImgAuthZPlugin) authorizeRate(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.rates == nil {
+		return authorization.Response{Allow: true}
+	}
+
+	allowed, retry := plugin.rates.take(req.User)
+	if !allowed {
+		retry = (retry + time.Second - 1).Truncate(time.Second)
+		request.logln("[DENIED] Rate limit:", "User:", req.User, request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("Too many registry commands, please retry in " + retry.String())}
+	}
+	return authorization.Response{Allow: true}
+}
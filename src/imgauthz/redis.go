@@ -0,0 +1,205 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Timeout for a single policy backend query, including the connection
+	redisTimeout = 2 * time.Second
+	// Default prefix of the policy backend keys, e.g. img-authz:registries
+	redisDefaultPrefix = "img-authz:"
+)
+
+// Policy source backed by Redis sets: the members of the <prefix>registries and <prefix>images sets
+// are authorized, e.g. SADD img-authz:registries my.docker.registry.
+// Queries share a single connection, reconnected on failure.
+type redisPolicySource struct {
+	sync.Mutex
+	// Address, TLS and credentials of the Redis server
+	addr     string
+	useTLS   bool
+	username string
+	password string
+	db       int
+	// Prefix of the keys of the sets
+	prefix string
+	// Current connection, if any
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Create a new Redis policy source from a URL like redis[s]://[[user]:password@]host[:port][/db][?prefix=img-authz:]
+func newRedisPolicySource(u *url.URL) (*redisPolicySource, error) {
+	source := &redisPolicySource{addr: u.Host, useTLS: u.Scheme == "rediss", prefix: redisDefaultPrefix}
+	if len(u.Hostname()) == 0 {
+		return nil, errors.New("invalid --policy-backend value: missing Redis host")
+	}
+	if len(u.Port()) == 0 {
+		source.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		source.username = u.User.Username()
+		source.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); len(db) > 0 {
+		var err error
+		if source.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid --policy-backend value: invalid Redis database %q", db)
+		}
+	}
+	if prefix, ok := u.Query()["prefix"]; ok {
+		source.prefix = prefix[0]
+	}
+	return source, nil
+}
+
+// Returns true if any of the entries is a member of the <prefix><list> set
+func (source *redisPolicySource) containsAny(list string, entries ...string) (bool, error) {
+	for _, entry := range entries {
+		reply, err := source.command("SISMEMBER", source.prefix+list, entry)
+		if err != nil {
+			return false, err
+		}
+		if reply == 1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns true if the <prefix><list> set has any member
+func (source *redisPolicySource) hasEntries(list string) (bool, error) {
+	reply, err := source.command("SCARD", source.prefix+list)
+	if err != nil {
+		return false, err
+	}
+	return reply > 0, nil
+}
+
+// Sends a command expecting an integer reply, connecting first if needed
+func (source *redisPolicySource) command(args ...string) (int64, error) {
+	source.Lock()
+	defer source.Unlock()
+
+	if source.conn == nil {
+		if err := source.connect(); err != nil {
+			return 0, fmt.Errorf("policy backend unavailable: %v", err)
+		}
+	}
+	reply, err := source.roundTrip(args...)
+	if err != nil {
+		source.close()
+		return 0, fmt.Errorf("policy backend unavailable: %v", err)
+	}
+	value, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("policy backend: unexpected reply to %s: %v", args[0], reply)
+	}
+	return value, nil
+}
+
+// Connects, authenticates and selects the database
+func (source *redisPolicySource) connect() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if source.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", source.addr, &tls.Config{ServerName: strings.Split(source.addr, ":")[0]})
+	} else {
+		conn, err = dialer.Dial("tcp", source.addr)
+	}
+	if err != nil {
+		return err
+	}
+	source.conn = conn
+	source.reader = bufio.NewReader(conn)
+
+	if len(source.password) > 0 {
+		args := []string{"AUTH", source.password}
+		if len(source.username) > 0 {
+			args = []string{"AUTH", source.username, source.password}
+		}
+		if _, err := source.roundTrip(args...); err != nil {
+			source.close()
+			return err
+		}
+	}
+	if source.db != 0 {
+		if _, err := source.roundTrip("SELECT", strconv.Itoa(source.db)); err != nil {
+			source.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Closes the current connection
+func (source *redisPolicySource) close() {
+	if source.conn != nil {
+		source.conn.Close()
+	}
+	source.conn = nil
+	source.reader = nil
+}
+
+// Sends a command as a RESP array of bulk strings and reads its reply
+func (source *redisPolicySource) roundTrip(args ...string) (interface{}, error) {
+	source.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(source.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return readRESPReply(source.reader)
+}
+
+// Reads a RESP reply: simple string, error, integer or bulk string.
+// Error replies are returned as errors.
+func readRESPReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	}
+	return nil, fmt.Errorf("unsupported reply: %q", line)
+}
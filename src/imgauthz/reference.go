@@ -0,0 +1,303 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Image reference as requested by the docker client command
+type imageReference struct {
+	// Registry hosting the image
+	registry string
+	// Repository path within the registry (i.e. without registry and tag)
+	repository string
+	// Image tag, if any
+	tag string
+	// Image digest, if any
+	digest string
+	// Mirror prefix the image was requested through, if any (see parseReference)
+	mirror string
+}
+
+const (
+	// Registry of the image names without a registry host (e.g. alpine or user/app),
+	// unless another default registry is configured
+	dockerHubRegistry = "docker.io"
+	// Legacy dockerhub registry, normalized to dockerHubRegistry
+	legacyDockerHubRegistry = "index.docker.io"
+	// Registry host serving the dockerhub images
+	dockerHubHost = "registry-1.docker.io"
+	// Namespace of the dockerhub official images (e.g. alpine is docker.io/library/alpine)
+	officialNamespace = "library"
+	// Maximum length of a requested image reference. The longest valid docker references are about
+	// half as long: a name of 255 characters, a tag of 128 and a sha512 digest.
+	maxReferenceLength = 1024
+	// Maximum length of a logged invalid reference
+	maxLoggedReferenceLength = 256
+)
+
+// Returns the image name as registry/repository
+func (ref imageReference) name() string {
+	return ref.registry + "/" + ref.repository
+}
+
+// Returns the full image reference as registry/repository[:tag][@digest].
+// References without a tag or digest default to the latest tag, as for the docker client.
+func (ref imageReference) String() string {
+	reference := ref.name()
+	if len(ref.tag) > 0 {
+		reference += ":" + ref.tag
+	}
+	if len(ref.digest) > 0 {
+		reference += "@" + ref.digest
+	}
+	if !ref.hasExplicitTag() {
+		reference += ":latest"
+	}
+	return reference
+}
+
+// Returns true if the reference has an explicit tag or digest, as opposed to the implicit latest tag
+func (ref imageReference) hasExplicitTag() bool {
+	return len(ref.tag) > 0 || len(ref.digest) > 0
+}
+
+// Returns the tag or digest identifying the image manifest, defaulting to the latest tag
+func (ref imageReference) manifestReference() string {
+	if len(ref.digest) > 0 {
+		return ref.digest
+	}
+	if len(ref.tag) > 0 {
+		return ref.tag
+	}
+	return "latest"
+}
+
+// Returns the registry host serving the image and the repository path on that host.
+// The dockerhub images are served by the dockerhub registry host.
+func (ref imageReference) registryHost() (string, string) {
+	if ref.registry == dockerHubRegistry {
+		return dockerHubHost, ref.repository
+	}
+	return ref.registry, ref.repository
+}
+
+// Returns true if the first component of an image name is a registry host, as opposed to
+// a dockerhub namespace (e.g. user in user/app). As for Docker, components with uppercase
+// letters are registry hosts, since namespaces are lowercase.
+func isRegistryHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost" || strings.ToLower(component) != component
+}
+
+// Parses an image reference of the form [registry/]repository[:tag][@digest].
+// Image names without a registry host are resolved against the default registry (dockerhub if empty),
+// with the official images in the official namespace, e.g. alpine is docker.io/library/alpine
+// and user/app is docker.io/user/app. The path separators are normalized before and after the tag
+// and the digest are split off, see normalizeReferenceSeparators, and the OCI-style transports and
+// qualifiers are stripped off, see stripReferenceQualifiers. References without any repository (e.g. / or :latest) have
+// an empty repository.
+func parseImageReference(image string, defaultRegistry string) imageReference {
+	ref := imageReference{}
+	image = normalizeReferenceSeparators(stripReferenceQualifiers(strings.TrimSpace(image)))
+
+	// Strip off the digest, if any
+	if idx := strings.Index(image, "@"); idx != -1 {
+		ref.digest = image[idx+1:]
+		image = image[0:idx]
+	}
+
+	// Strip off the tag, if any. A colon before the last slash belongs to the registry host,
+	// e.g. its port or a bracketed IPv6 address like [2001:db8::1]:5000.
+	if idx := strings.LastIndex(image, ":"); idx != -1 && idx > strings.LastIndex(image, "/") {
+		ref.tag = image[idx+1:]
+		image = image[0:idx]
+	}
+
+	// Normalize the separators left before the tag or the digest, e.g. alpine//:latest
+	image = normalizeReferenceSeparators(image)
+	if idx := strings.Index(image, "/"); idx != -1 && isRegistryHost(image[0:idx]) {
+		ref.registry = normalizeRegistryHost(image[0:idx])
+		ref.repository = officialRepository(ref.registry, image[idx+1:])
+		return ref
+	}
+
+	// If no registry is specified, assume it is the default registry
+	ref.registry = defaultRegistry
+	if len(ref.registry) == 0 {
+		ref.registry = dockerHubRegistry
+	}
+	ref.repository = image
+	if len(image) > 0 && !strings.Contains(image, "/") {
+		ref.repository = officialNamespace + "/" + image
+	}
+	return ref
+}
+
+// Validates a requested image reference, before it is parsed: references longer than maxReferenceLength,
+// with invalid UTF-8 or with characters which are not printable or are spaces (e.g. control characters,
+// or bidirectional overrides disguising the name in the logs) are invalid. Surrounding spaces are trimmed
+// as by parseImageReference.
+func validateReference(image string) error {
+	image = strings.TrimSpace(image)
+	if len(image) > maxReferenceLength {
+		return fmt.Errorf("longer than %d characters", maxReferenceLength)
+	}
+	if !utf8.ValidString(image) {
+		return fmt.Errorf("not valid UTF-8")
+	}
+	for _, c := range image {
+		if !unicode.IsPrint(c) || c == ' ' {
+			return fmt.Errorf("contains the disallowed character %U", c)
+		}
+	}
+	return nil
+}
+
+// Returns a requested image reference as logged: quoted, so that its special characters are escaped,
+// and truncated to maxLoggedReferenceLength
+func loggedReference(image string) string {
+	if len(image) > maxLoggedReferenceLength {
+		return fmt.Sprintf("%q... (%d bytes truncated)", image[0:maxLoggedReferenceLength], len(image)-maxLoggedReferenceLength)
+	}
+	return fmt.Sprintf("%q", image)
+}
+
+// Strips off the transport prefix and the qualifiers that some OCI-aware clients add to the image
+// references, e.g. docker://ghcr.io/team/app:1.0 or oci://ghcr.io/team/app:1.0 is ghcr.io/team/app:1.0,
+// and ghcr.io/team/app:1.0?platform=linux/amd64 or ghcr.io/team/app:1.0#annotation is ghcr.io/team/app:1.0.
+// The qualifiers are not supported by the docker daemon, and do not take part in the matching.
+func stripReferenceQualifiers(image string) string {
+	if idx := strings.IndexAny(image, "?#"); idx != -1 {
+		image = image[0:idx]
+	}
+	if idx := strings.Index(image, "://"); idx != -1 && isTransport(image[0:idx]) {
+		image = image[idx+3:]
+	}
+	return image
+}
+
+// Returns true if the scheme of a reference is a transport name, e.g. docker or oci,
+// as opposed to a registry host and port
+func isTransport(scheme string) bool {
+	if len(scheme) == 0 {
+		return false
+	}
+	for _, c := range scheme {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the repository of an image of the normalized registry (see normalizeRegistryHost), as written:
+// as for Docker, the dockerhub repositories without a namespace are official images, e.g. docker.io/alpine
+// is docker.io/library/alpine. As the hosts are lowercased, so is Docker.IO/alpine, unlike for Docker.
+func officialRepository(registry string, repository string) string {
+	isDockerHub := registry == dockerHubRegistry || registry == legacyDockerHubRegistry
+	if isDockerHub && len(repository) > 0 && !strings.Contains(repository, "/") {
+		return officialNamespace + "/" + repository
+	}
+	return repository
+}
+
+// Normalizes the path separators of an image name as sent by the docker client: repeated slashes
+// are collapsed and leading and trailing slashes are removed, e.g. my.docker.registry//team//app/
+// is my.docker.registry/team/app. Returns an empty string if nothing but separators is left.
+func normalizeReferenceSeparators(name string) string {
+	for strings.Contains(name, "//") {
+		name = strings.Replace(name, "//", "/", -1)
+	}
+	return strings.Trim(name, "/")
+}
+
+// Normalizes a registry host, so that all the forms of a host match the same policy entries:
+// the legacy dockerhub host index.docker.io is docker.io as for Docker, hosts are case-insensitive
+// and lowercased (e.g. My.Registry is my.registry), internationalized hosts (e.g. bücher.example)
+// are converted to their punycode form (e.g. xn--bcher-kva.example), and bracketed IPv6 hosts
+// to their canonical form (e.g. [2001:DB8:0::1]:5000 is [2001:db8::1]:5000).
+// Hosts which are not valid domain names are lowercased only.
+// Unlike Docker, which keeps the case of the host, hosts are lowercased so that the policy entries
+// cannot be bypassed by changing the case of a host, which resolves alike.
+func normalizeRegistryHost(registry string) string {
+	registry = strings.ToLower(registry)
+	if registry == legacyDockerHubRegistry {
+		return dockerHubRegistry
+	}
+
+	host, port := splitRegistryPort(registry)
+	if ip := bracketedIPv6(host); ip != nil {
+		host = "[" + ip.String() + "]"
+	} else if !isASCII(host) {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return registry
+		}
+		host = ascii
+	}
+	if len(port) > 0 {
+		return host + ":" + port
+	}
+	return host
+}
+
+// Returns the IPv6 address of a bracketed registry host like [2001:db8::1], or nil for other hosts
+func bracketedIPv6(host string) net.IP {
+	if len(host) < 2 || host[0] != '[' || host[len(host)-1] != ']' {
+		return nil
+	}
+	ip := net.ParseIP(host[1 : len(host)-1])
+	if ip == nil || ip.To4() != nil {
+		return nil
+	}
+	return ip
+}
+
+// Splits a registry into its host and port, if any, e.g. my.docker.registry:5000 or [::1]:5000
+func splitRegistryPort(registry string) (string, string) {
+	idx := strings.LastIndex(registry, ":")
+	if idx == -1 || strings.LastIndex(registry, "]") > idx {
+		return registry, ""
+	}
+	return registry[0:idx], registry[idx+1:]
+}
+
+// Normalizes the registry host of an image name like registry/repository, see normalizeRegistryHost,
+// and its repository as an official image if it is a dockerhub repository without a namespace
+func normalizeImageName(name string) string {
+	if idx := strings.Index(name, "/"); idx != -1 {
+		registry := normalizeRegistryHost(name[0:idx])
+		return registry + "/" + officialRepository(registry, name[idx+1:])
+	}
+	return name
+}
+
+// Normalizes the exact policy entries with the normalize function, see normalizeRegistryHost.
+// Glob patterns and regular expressions are returned unchanged.
+func normalizeEntries(entries []string, normalize func(string) string) []string {
+	normalized := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !isPattern(entry) {
+			entry = normalize(entry)
+		}
+		normalized = append(normalized, entry)
+	}
+	return normalized
+}
+
+// Returns true if the string contains ASCII characters only
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/distribution/reference"
+	"strings"
+	"testing"
+)
+
+func TestReferencesAreParsedAsByDocker(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	for _, image := range []string{
+		"alpine",
+		"alpine:3.19",
+		"library/alpine",
+		"user/app:1.0",
+		"docker.io/alpine",
+		"docker.io/user/app",
+		"index.docker.io/alpine",
+		"my.docker.registry/app",
+		"my.docker.registry:5000/team/app:1.0",
+		"localhost/app",
+		"localhost:5000/app",
+		"127.0.0.1:5000/app",
+		"[::1]:5000/app",
+		"team/group/app",
+		"alpine@" + digest,
+		"my.docker.registry/app:1.0@" + digest,
+	} {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			t.Fatalf("%s: %v", image, err)
+		}
+		named = reference.TagNameOnly(named)
+		tag, digest := "", ""
+		if tagged, ok := named.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		if digested, ok := named.(reference.Digested); ok {
+			digest = digested.Digest().String()
+		}
+		// Docker adds the latest tag to the references without a tag nor a digest, as the plugin does when matching
+		ref := parseImageReference(image, "")
+		if len(ref.tag) == 0 && len(ref.digest) == 0 {
+			ref.tag = "latest"
+		}
+		expected := imageReference{registry: reference.Domain(named), repository: reference.Path(named), tag: tag, digest: digest}
+		if ref != expected {
+			t.Errorf("%s: parsed as %+v, expected %+v", image, ref, expected)
+		}
+	}
+}
+
+// Unlike Docker, which keeps the case of the hosts, the hosts are lowercased before anything else
+func TestRegistryHostsAreCaseInsensitive(t *testing.T) {
+	for image, expected := range map[string]imageReference{
+		"MY.Docker.Registry/app":           {registry: "my.docker.registry", repository: "app"},
+		"My.Docker.Registry:5000/team/app": {registry: "my.docker.registry:5000", repository: "team/app"},
+		"Foo/bar":                          {registry: "foo", repository: "bar"},
+		"Docker.IO/alpine":                 {registry: "docker.io", repository: "library/alpine"},
+		"DOCKER.IO/user/app":               {registry: "docker.io", repository: "user/app"},
+		"INDEX.DOCKER.IO/library/alpine":   {registry: "docker.io", repository: "library/alpine"},
+		"Index.Docker.IO/alpine":           {registry: "docker.io", repository: "library/alpine"},
+	} {
+		if ref := parseImageReference(image, ""); ref != expected {
+			t.Errorf("%s: parsed as %+v, expected %+v", image, ref, expected)
+		}
+	}
+
+	policy := testPolicy(t, Config{
+		Registries:       []string{"*"},
+		DeniedRegistries: []string{"docker.io"},
+		DeniedImages:     []string{"quay.io/team/app"}})
+	for _, image := range []string{"INDEX.DOCKER.IO/library/alpine", "Index.Docker.IO/alpine", "DOCKER.IO/alpine", "Quay.IO/team/app"} {
+		if response := policy.AuthorizePull(image); response.Allow {
+			t.Errorf("pull of %s allowed", image)
+		}
+	}
+	policy = testPolicy(t, Config{Registries: []string{"docker.io"}, Images: []string{"docker.io/library/alpine"}})
+	for _, image := range []string{"DOCKER.IO/alpine", "INDEX.DOCKER.IO/alpine:3.19"} {
+		if response := policy.AuthorizePull(image); !response.Allow {
+			t.Errorf("pull of %s: %s", image, response.Msg)
+		}
+	}
+}
+
+// The separators are normalized before the tag and the digest are split off, so that a trailing
+// slash does not end up in the tag or the digest
+func TestReferenceSeparatorsAreNormalized(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	for image, expected := range map[string]imageReference{
+		"my.docker.registry//team//app/":          {registry: "my.docker.registry", repository: "team/app"},
+		"my.docker.registry//team//app:1.0/":      {registry: "my.docker.registry", repository: "team/app", tag: "1.0"},
+		"/my.docker.registry/app@" + digest + "/": {registry: "my.docker.registry", repository: "app", digest: digest},
+		"//alpine:3.19//":                         {registry: "docker.io", repository: "library/alpine", tag: "3.19"},
+		"/":                                       {registry: "docker.io"},
+	} {
+		if ref := parseImageReference(image, ""); ref != expected {
+			t.Errorf("%s: parsed as %+v, expected %+v", image, ref, expected)
+		}
+	}
+
+	policy := testPolicy(t, Config{Registries: []string{"my.docker.registry"}, Images: []string{"my.docker.registry/team/app:1.0"}})
+	for _, image := range []string{"my.docker.registry//team//app:1.0/", "my.docker.registry/team/app:1.0//"} {
+		if response := policy.AuthorizePull(image); !response.Allow {
+			t.Errorf("pull of %s: %s", image, response.Msg)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// Timeout for a single registry request
+	registryTimeout = 10 * time.Second
+	// Maximum size of a manifest or blob fetched as is
+	maxRegistryDocumentSize = 4 << 20
+
+	// Manifest media types
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+// Returned when a manifest or blob does not exist on the registry
+var errRegistryNotFound = errors.New("not found on the registry")
+
+// Parses the parameters of a WWW-Authenticate challenge
+var challengeParams = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Content descriptor of a manifest, config or layer
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Image manifest (or manifest list) as served by the registry
+type imageManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// Returns the (compressed) image size, i.e. the size of the config and all the layers
+func (manifest *imageManifest) size() int64 {
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// Returns true if the manifest is a manifest list (or OCI index) of platform specific manifests
+func (manifest *imageManifest) isList() bool {
+	return manifest.MediaType == mediaTypeManifestList || manifest.MediaType == mediaTypeOCIIndex || len(manifest.Manifests) > 0
+}
+
+// Fetches image manifests from the registries
+type manifestClient interface {
+	// Returns the manifest of the image for the platform of the host
+	getManifest(ref imageReference) (*imageManifest, error)
+}
+
+// Docker registry (v2 API) manifest client.
+// Registries requiring a token are accessed with an anonymous token.
+type registryClient struct {
+	client *http.Client
+	// Circuit breakers of the registries, if enabled
+	breaker *registryBreaker
+}
+
+// Create a new registry manifest client, verifying the registries as per the registry trust
+// and failing fast on the registries whose circuit breaker is open
+func newRegistryClient(trust *registryTrust, breaker *registryBreaker) *registryClient {
+	return &registryClient{client: &http.Client{Timeout: registryTimeout, Transport: trust.transport()}, breaker: breaker}
+}
+
+// Returns the manifest of the image for the platform of the host.
+// Manifest lists are resolved to the manifest of the host platform.
+func (registry *registryClient) getManifest(ref imageReference) (*imageManifest, error) {
+	host, repository := ref.registryHost()
+
+	manifest, err := registry.fetchManifest(host, repository, ref.manifestReference())
+	if err != nil || !manifest.isList() {
+		return manifest, err
+	}
+
+	for _, platformManifest := range manifest.Manifests {
+		if platformManifest.Platform != nil && platformManifest.Platform.OS == runtime.GOOS && platformManifest.Platform.Architecture == runtime.GOARCH {
+			return registry.fetchManifest(host, repository, platformManifest.Digest)
+		}
+	}
+	return nil, fmt.Errorf("no manifest for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// Returns the URL of a manifest, by tag or digest
+func registryManifestURL(host string, repository string, reference string) string {
+	return "https://" + host + "/v2/" + repository + "/manifests/" + reference
+}
+
+// Returns the URL of a blob, by digest
+func registryBlobURL(host string, repository string, digest string) string {
+	return "https://" + host + "/v2/" + repository + "/blobs/" + digest
+}
+
+// Returns the URL of the manifest cosign attaches an artifact of an image digest with,
+// i.e. the sha256-<digest>.<suffix> tag, e.g. sha256-<digest>.att for the attestations
+func attachedManifestURL(host string, repository string, digest string, suffix string) string {
+	return registryManifestURL(host, repository, strings.Replace(digest, ":", "-", 1)+"."+suffix)
+}
+
+// Returns the digest of the manifest (or manifest list) the reference resolves to
+func (registry *registryClient) resolveDigest(ref imageReference) (string, error) {
+	if len(ref.digest) > 0 {
+		return ref.digest, nil
+	}
+	host, repository := ref.registryHost()
+	_, digest, err := registry.fetchRaw(registryManifestURL(host, repository, ref.manifestReference()), manifestMediaTypes())
+	return digest, err
+}
+
+// Returns the accepted manifest media types
+func manifestMediaTypes() string {
+	return strings.Join([]string{mediaTypeManifest, mediaTypeManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex}, ", ")
+}
+
+// Fetches a manifest by tag or digest
+func (registry *registryClient) fetchManifest(host string, repository string, reference string) (*imageManifest, error) {
+	manifestURL := registryManifestURL(host, repository, reference)
+
+	resp, err := registry.getWithToken(manifestURL, manifestMediaTypes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching manifest %s: %s", manifestURL, resp.Status)
+	}
+
+	var manifest imageManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest %s: %v", manifestURL, err)
+	}
+	if len(manifest.MediaType) == 0 {
+		manifest.MediaType = resp.Header.Get("Content-Type")
+	}
+	return &manifest, nil
+}
+
+// Fetches a manifest or a blob as is, up to maxRegistryDocumentSize, along with its digest
+func (registry *registryClient) fetchRaw(requestURL string, accept string) ([]byte, string, error) {
+	resp, err := registry.getWithToken(requestURL, accept)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errRegistryNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching %s: %s", requestURL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRegistryDocumentSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading %s: %v", requestURL, err)
+	}
+	if len(data) > maxRegistryDocumentSize {
+		return nil, "", fmt.Errorf("fetching %s: larger than %d bytes", requestURL, maxRegistryDocumentSize)
+	}
+	return data, fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// Sends a GET request to the registry, with an anonymous token if the registry requires one.
+// The requests to a registry whose circuit breaker is open fail right away.
+func (registry *registryClient) getWithToken(requestURL string, accept string) (*http.Response, error) {
+	host := requestURL
+	if parsed, err := url.Parse(requestURL); err == nil {
+		host = parsed.Host
+	}
+	if !registry.breaker.allow(host) {
+		return nil, fmt.Errorf("%s: %v", host, errCircuitOpen)
+	}
+
+	resp, err := registry.get(requestURL, accept, "")
+	registry.breaker.record(host, resp, err)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	resp.Body.Close()
+	token, err := registry.getToken(host, resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return nil, err
+	}
+	resp, err = registry.get(requestURL, accept, token)
+	registry.breaker.record(host, resp, err)
+	return resp, err
+}
+
+// Fetches an anonymous token as per the Bearer challenge of the registry host. The failures of its
+// token service count as failures of the registry.
+func (registry *registryClient) getToken(host string, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", errors.New("unsupported registry authentication challenge: " + challenge)
+	}
+
+	params := make(map[string]string)
+	for _, match := range challengeParams.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if len(params["realm"]) == 0 {
+		return "", errors.New("missing realm in registry authentication challenge: " + challenge)
+	}
+
+	query := url.Values{}
+	if len(params["service"]) > 0 {
+		query.Set("service", params["service"])
+	}
+	if len(params["scope"]) > 0 {
+		query.Set("scope", params["scope"])
+	}
+
+	resp, err := registry.get(params["realm"]+"?"+query.Encode(), "application/json", "")
+	registry.breaker.record(host, resp, err)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching registry token: %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding registry token: %v", err)
+	}
+	if len(token.Token) > 0 {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// Sends a GET request to the registry
+func (registry *registryClient) get(requestURL string, accept string, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return registry.client.Do(req)
+}
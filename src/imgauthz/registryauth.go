@@ -0,0 +1,69 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// HTTP header carrying the registry credentials of docker pull, as a base64url encoded JSON auth config.
+// Its value is never logged, and is redacted from the recorded requests.
+const registryAuthHeader = "X-Registry-Auth"
+
+// Registry credentials of the X-Registry-Auth header, as sent by the docker client
+type registryAuthConfig struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// Returns true if the request carries registry credentials in its X-Registry-Auth header.
+// The docker client sends an empty auth config (i.e. {}) when it has no credentials for the registry,
+// so the header must hold a username, a password or a token.
+func hasRegistryCredentials(req authorization.Request) bool {
+	for name, value := range req.RequestHeaders {
+		if !strings.EqualFold(name, registryAuthHeader) {
+			continue
+		}
+		data, err := base64.URLEncoding.DecodeString(value)
+		if err != nil {
+			if data, err = base64.StdEncoding.DecodeString(value); err != nil {
+				return false
+			}
+		}
+		var config registryAuthConfig
+		if json.Unmarshal(data, &config) != nil {
+			return false
+		}
+		return len(config.Username) > 0 || len(config.Password) > 0 || len(config.Auth) > 0 ||
+			len(config.IdentityToken) > 0 || len(config.RegistryToken) > 0
+	}
+	return false
+}
+
+// Authorizes a pull by the registry credentials of the client, if its registry requires them, so that
+// the registries requiring authentication are not pulled from anonymously. Mirrored references are
+// matched by their upstream registry, as the other registry entries.
+func (plugin *ImgAuthZPlugin) authorizeRegistryAuth(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.credentialRegistries.size() == 0 || request.command != pullCommand {
+		return authorization.Response{Allow: true}
+	}
+	registry := request.image.registry
+	if !plugin.credentialRegistries.matches(plugin.registryNames(registry)...) {
+		return authorization.Response{Allow: true}
+	}
+
+	if !hasRegistryCredentials(req) {
+		request.logln("[DENIED] No registry credentials:", request.image.name(), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: false, Msg: request.denialMsg("The registry " + registry + " requires credentials, please docker login " + registry + " first")}
+	}
+	plugin.debugln(request, "[AUTH] Registry credentials presented:", registry)
+	return authorization.Response{Allow: true}
+}
@@ -0,0 +1,223 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TLS trust of the registries the plugin fetches the manifests, blobs and signatures from.
+// The registries are verified with the system CAs, unless a registry is trusted with its own CA bundle
+// (e.g. self-signed) or, as a last resort, without any verification. The registries identified by their
+// certificate must also present a certificate whose fingerprint is trusted.
+type registryTrust struct {
+	// TLS configurations by registry address (host:port), for the registries which are not verified
+	// with the system CAs only
+	configs map[string]*tls.Config
+	// Trusted certificate fingerprints by registry address, for the registries identified by their certificate
+	fingerprints map[string]map[string]bool
+}
+
+// Returns the address of a registry, with the HTTPS port if it has no port, e.g. my.docker.registry:443
+func registryAddress(registry string) string {
+	registry = normalizeRegistryHost(registry)
+	if _, port := splitRegistryPort(registry); len(port) == 0 {
+		return registry + ":443"
+	}
+	return registry
+}
+
+// Returns the registry trust of the CA bundle entries, given as <registry>=<PEM CA bundle file>,
+// e.g. my.docker.registry:5000=/etc/img-authz/registry-ca.pem, and of the registries whose certificate
+// is not verified. The CA bundles are trusted in addition to the system CAs. The registries of the
+// fingerprint entries, given as <registry>=sha256:<hex>, must also present a certificate with one
+// of their fingerprints.
+func newRegistryTrust(caEntries []string, skipVerify []string, fingerprintEntries []string) (*registryTrust, error) {
+	trust := &registryTrust{configs: make(map[string]*tls.Config), fingerprints: make(map[string]map[string]bool)}
+	for _, entry := range caEntries {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || len(strings.TrimSpace(fields[0])) == 0 || len(strings.TrimSpace(fields[1])) == 0 {
+			return nil, fmt.Errorf("invalid registry CA: %s (expected <registry>=<PEM CA bundle file>)", entry)
+		}
+		registry, path := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		if isPattern(registry) {
+			return nil, fmt.Errorf("invalid registry CA: %s (patterns are not supported)", entry)
+		}
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the CA bundle of the registry %s: %v", registry, err)
+		}
+
+		config := trust.config(registryAddress(registry))
+		if config.RootCAs == nil {
+			if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+				config.RootCAs = x509.NewCertPool()
+			}
+		}
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in the CA bundle of the registry %s: %s", registry, path)
+		}
+	}
+	for _, registry := range skipVerify {
+		if isPattern(registry) {
+			return nil, fmt.Errorf("invalid registry without TLS verification: %s (patterns are not supported)", registry)
+		}
+		trust.config(registryAddress(registry)).InsecureSkipVerify = true
+	}
+	for _, entry := range fingerprintEntries {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || len(strings.TrimSpace(fields[0])) == 0 || isPattern(fields[0]) {
+			return nil, fmt.Errorf("invalid registry fingerprint: %s (expected <registry>=sha256:<hex>)", entry)
+		}
+		fingerprint, err := parseFingerprint(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry fingerprint: %s (%v)", entry, err)
+		}
+		// The dockerhub is contacted on its registry host
+		host, _ := imageReference{registry: normalizeRegistryHost(strings.TrimSpace(fields[0]))}.registryHost()
+		address := registryAddress(host)
+		if trust.fingerprints[address] == nil {
+			trust.fingerprints[address] = make(map[string]bool)
+		}
+		trust.fingerprints[address][fingerprint] = true
+		trust.config(address).VerifyConnection = trust.verifyFingerprint(address)
+	}
+	return trust, nil
+}
+
+// Returns the normalized certificate fingerprint, i.e. sha256: followed by the lowercase hexadecimal
+// SHA-256 digest of the certificate, with or without colons, e.g. as printed by
+// openssl x509 -noout -fingerprint -sha256
+func parseFingerprint(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !strings.HasPrefix(value, "sha256:") {
+		return "", fmt.Errorf("expected a sha256: fingerprint")
+	}
+	digest := strings.Replace(strings.TrimPrefix(value, "sha256:"), ":", "", -1)
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("expected %d hexadecimal bytes", sha256.Size)
+	}
+	return "sha256:" + digest, nil
+}
+
+// Returns the fingerprint of the certificate, as sha256:<hex>
+func certificateFingerprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(cert.Raw))
+}
+
+// Returns the TLS connection verifier of the registry address, failing the connections to a registry
+// which does not present a certificate with a trusted fingerprint, once its certificate chain is verified
+func (trust *registryTrust) verifyFingerprint(address string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("the registry %s presented no certificate", address)
+		}
+		if fingerprint := certificateFingerprint(state.PeerCertificates[0]); !trust.fingerprints[address][fingerprint] {
+			return fmt.Errorf("the registry %s presented a certificate whose fingerprint is not trusted: %s", address, fingerprint)
+		}
+		return nil
+	}
+}
+
+// Returns true if the registry address is identified by its certificate fingerprint
+func (trust *registryTrust) hasFingerprints(address string) bool {
+	return trust != nil && len(trust.fingerprints[address]) > 0
+}
+
+// Returns the fingerprint of the certificate presented by the registry address, once its certificate
+// chain is verified as per the registry trust
+func (trust *registryTrust) peerFingerprint(address string) (string, error) {
+	config := trust.configs[address].Clone()
+	config.VerifyConnection = nil
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: registryTimeout}, "tcp", address, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return certificateFingerprint(conn.ConnectionState().PeerCertificates[0]), nil
+}
+
+// Returns the TLS configuration of the registry address, created if needed
+func (trust *registryTrust) config(address string) *tls.Config {
+	config, ok := trust.configs[address]
+	if !ok {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+		trust.configs[address] = config
+	}
+	return config
+}
+
+// Returns the TLS configuration of the registry address, e.g. my.docker.registry:443, or nil if the
+// registry is verified with the system CAs only
+func (trust *registryTrust) tlsConfig(address string) *tls.Config {
+	if trust == nil {
+		return nil
+	}
+	return trust.configs[registryAddress(address)]
+}
+
+// Returns the HTTP transport of the registry clients, selecting the TLS configuration of each request by
+// its registry address. The token services of the registries are verified with the system CAs, unless
+// configured too.
+func (trust *registryTrust) transport() http.RoundTripper {
+	if trust == nil || len(trust.configs) == 0 {
+		return http.DefaultTransport
+	}
+	transports := make(map[string]http.RoundTripper, len(trust.configs))
+	for address, config := range trust.configs {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		transports[address] = transport
+	}
+	return &registryTransport{transports: transports}
+}
+
+// HTTP transport dispatching the requests to the transport of their registry address
+type registryTransport struct {
+	transports map[string]http.RoundTripper
+}
+
+// Sends the request with the transport of its registry address, or the default transport
+func (transport *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if registry, ok := transport.transports[registryAddress(req.URL.Host)]; ok && req.URL.Scheme == "https" {
+		return registry.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Authorizes a pull from a registry identified by its certificate fingerprint, so that the authorization
+// is bound to the identity of the registry rather than to its host name: the registry must present a
+// certificate with a trusted fingerprint, or the pull is denied. If the registry cannot be contacted,
+// the on-error behavior applies. The manifests, blobs and signatures are fetched from such a registry
+// only if it presents a trusted certificate too.
+func (plugin *ImgAuthZPlugin) authorizeRegistryIdentity(reqURL *url.URL, request registryRequest) authorization.Response {
+	host, _ := request.image.registryHost()
+	address := registryAddress(host)
+	if request.command != pullCommand || !plugin.trust.hasFingerprints(address) {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		fingerprint, err := plugin.trust.peerFingerprint(address)
+		if err != nil {
+			return plugin.errorResponse(request, reqURL, fmt.Errorf("registry certificate check failed: %v", err))
+		}
+		if !plugin.trust.fingerprints[address][fingerprint] {
+			request.logln("[DENIED] Registry certificate:", request.image.registry, fingerprint, reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The registry " + request.image.registry + " presented a certificate whose fingerprint is not trusted: " + fingerprint)}
+		}
+		plugin.debugln(request, "[TRUST] Registry certificate trusted:", request.image.registry, fingerprint)
+		return authorization.Response{Allow: true}
+	})
+}
@@ -0,0 +1,94 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistryFingerprints(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	for _, test := range []struct {
+		value       string
+		fingerprint string
+	}{
+		{"sha256:" + digest, "sha256:" + digest},
+		// As printed by openssl x509 -noout -fingerprint -sha256
+		{"SHA256:" + strings.TrimSuffix(strings.Repeat("AB:", 32), ":"), "sha256:" + digest},
+		{" sha256:" + digest + " ", "sha256:" + digest},
+		{"md5:" + strings.Repeat("ab", 16), ""},
+		{"sha256:" + strings.Repeat("zz", 32), ""},
+		{"sha256:abcd", ""},
+	} {
+		fingerprint, err := parseFingerprint(test.value)
+		if fingerprint != test.fingerprint || (err != nil) != (len(test.fingerprint) == 0) {
+			t.Errorf("%s: %q (%v)", test.value, fingerprint, err)
+		}
+	}
+
+	for _, entry := range []string{"*.corp=sha256:" + digest, "=sha256:" + digest, "my.docker.registry", "my.docker.registry=sha256:abcd"} {
+		if _, err := newRegistryTrust(nil, nil, []string{entry}); err == nil {
+			t.Errorf("fingerprint %s accepted", entry)
+		}
+	}
+
+	// The dockerhub is identified on its registry host
+	trust, err := newRegistryTrust(nil, nil, []string{"docker.io=sha256:" + digest})
+	if err != nil || !trust.hasFingerprints("registry-1.docker.io:443") {
+		t.Errorf("dockerhub fingerprint: %v", err)
+	}
+}
+
+func TestRegistriesMustPresentATrustedFingerprint(t *testing.T) {
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "https://")
+	dir, err := ioutil.TempDir("", "img-authz-registry-trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: registry.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	trusted := certificateFingerprint(registry.Certificate())
+
+	for _, test := range []struct {
+		registry    string
+		fingerprint string
+		allowed     bool
+		msg         string
+	}{
+		{host, trusted, true, ""},
+		{host, "sha256:" + strings.Repeat("ab", 32), false, "fingerprint is not trusted: " + trusted},
+		// The on-error behavior applies to the unreachable registries
+		{"127.0.0.1:1", trusted, false, "registry certificate check failed"},
+	} {
+		config := pluginConfig{registries: []string{test.registry}, registryCAs: []string{host + "=" + ca}, fingerprints: []string{test.registry + "=" + test.fingerprint}}
+		if err := mergeRuleSources(&config, nil); err != nil {
+			t.Fatal(err)
+		}
+		plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy := &Policy{plugin: plugin}
+		image := test.registry + "/team/app:1.0"
+		if response := policy.AuthorizePull(image); response.Allow != test.allowed || !strings.Contains(response.Msg, test.msg) {
+			t.Errorf("pull of %s with %s: allowed %v (%s)", image, test.fingerprint, response.Allow, response.Msg)
+		}
+		// Runs are not checked, the images were checked when pulled
+		if response := policy.AuthorizeRun(image); !response.Allow {
+			t.Errorf("run of %s with %s: %s", image, test.fingerprint, response.Msg)
+		}
+	}
+}
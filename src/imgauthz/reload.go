@@ -0,0 +1,106 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"log"
+	"sync"
+	"time"
+)
+
+// Authorization plugin whose policy can be reloaded at runtime.
+// Requests are served by the current plugin, which is replaced as a whole on a successful reload,
+// so that in-flight requests keep using the policy they started with.
+type reloadablePlugin struct {
+	sync.RWMutex
+	// Current plugin
+	current *ImgAuthZPlugin
+	// Loads the configuration and creates a new plugin
+	load func() (*ImgAuthZPlugin, error)
+	// Plugin metrics
+	metrics *pluginMetrics
+	// Requests being authorized, drained on shutdown
+	inflight sync.WaitGroup
+	// Recorder of the authorization requests, if any
+	recorder *traceRecorder
+}
+
+// Create a new reloadable plugin, loading the initial policy
+func newReloadablePlugin(load func() (*ImgAuthZPlugin, error), metrics *pluginMetrics) (*reloadablePlugin, error) {
+	plugin, err := load()
+	metrics.policyLoaded(false, numRules(plugin), err)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadablePlugin{current: plugin, load: load, metrics: metrics}, nil
+}
+
+// Returns the number of rules of the plugin policy
+func numRules(plugin *ImgAuthZPlugin) int {
+	if plugin == nil {
+		return 0
+	}
+	return plugin.pluginConfig.numRules()
+}
+
+// Returns the current plugin
+func (reloadable *reloadablePlugin) plugin() *ImgAuthZPlugin {
+	reloadable.RLock()
+	defer reloadable.RUnlock()
+	return reloadable.current
+}
+
+// Reloads the policy. If the new policy cannot be loaded, the current policy is kept.
+// All the reload triggers go through this function.
+func (reloadable *reloadablePlugin) reload() error {
+	plugin, err := reloadable.load()
+	reloadable.metrics.policyLoaded(true, numRules(plugin), err)
+	if err != nil {
+		log.Println("[RELOAD] Policy reload failed, keeping the current policy:", err)
+		return err
+	}
+
+	reloadable.Lock()
+	reloadable.current = plugin
+	reloadable.Unlock()
+	log.Println("[RELOAD] Policy reloaded:", numRules(plugin), "rules")
+	return nil
+}
+
+// Authorizes the docker client command with the current policy
+func (reloadable *reloadablePlugin) AuthZReq(req authorization.Request) authorization.Response {
+	reloadable.inflight.Add(1)
+	defer reloadable.inflight.Done()
+	if reloadable.recorder != nil {
+		reloadable.recorder.record(req)
+	}
+	return reloadable.plugin().AuthZReq(req)
+}
+
+// Authorizes the docker client response with the current policy
+func (reloadable *reloadablePlugin) AuthZRes(req authorization.Request) authorization.Response {
+	return reloadable.plugin().AuthZRes(req)
+}
+
+// Waits for the requests being authorized to complete, up to the timeout (0 for unlimited).
+// Returns false if the timeout expired first.
+func (reloadable *reloadablePlugin) drain(timeout time.Duration) bool {
+	drained := make(chan struct{})
+	go func() {
+		reloadable.inflight.Wait()
+		close(drained)
+	}()
+
+	if timeout <= 0 {
+		<-drained
+		return true
+	}
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
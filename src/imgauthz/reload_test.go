@@ -0,0 +1,60 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPolicyReloadMetrics(t *testing.T) {
+	metrics := newPluginMetrics("", "")
+	// Registries of the next policy load, which fails without any
+	var registries []string
+	load := func() (*ImgAuthZPlugin, error) {
+		if len(registries) == 0 {
+			return nil, errors.New("invalid policy")
+		}
+		return newPlugin(nil, metrics, newPluginStatus(0), nil, pluginConfig{registries: registries})
+	}
+
+	registries = []string{"docker.io"}
+	reloadable, err := newReloadablePlugin(load, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		registries []string
+		failed     bool
+		metrics    []string
+	}{
+		// The initial load is not a reload
+		{nil, false, []string{"img_authz_policy_reloads_total 0", "img_authz_policy_reload_failures_total 0", "img_authz_policy_rules 1"}},
+		{[]string{"docker.io", "quay.io"}, false, []string{"img_authz_policy_reloads_total 1", "img_authz_policy_reload_failures_total 0", "img_authz_policy_rules 2"}},
+		// The failed reloads keep the rules of the current policy
+		{[]string{}, true, []string{"img_authz_policy_reloads_total 2", "img_authz_policy_reload_failures_total 1", "img_authz_policy_rules 2"}},
+		{[]string{"quay.io"}, false, []string{"img_authz_policy_reloads_total 3", "img_authz_policy_reload_failures_total 1", "img_authz_policy_rules 1"}},
+	} {
+		if test.registries != nil {
+			registries = test.registries
+			current := reloadable.plugin()
+			if err := reloadable.reload(); (err != nil) != test.failed {
+				t.Fatalf("reload of %v: %v", test.registries, err)
+			}
+			if test.failed && reloadable.plugin() != current {
+				t.Error("current policy replaced by a failed reload")
+			}
+		}
+
+		var output bytes.Buffer
+		metrics.write(&output)
+		for _, expected := range test.metrics {
+			if !strings.Contains(output.String(), expected+"\n") {
+				t.Errorf("after the reload of %v: %s not found in\n%s", test.registries, expected, output.String())
+			}
+		}
+	}
+}
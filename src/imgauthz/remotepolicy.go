@@ -0,0 +1,141 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Timeout of a remote policy query
+const remotePolicyTimeout = 5 * time.Second
+
+// Decision of the remote policy on an image, along with the reason of a denial
+type remoteDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// Remote authorization service deciding on the image references at request time, so that a frequently
+// changing policy applies without reloads
+type remotePolicy interface {
+	// Returns the decision on the registry command of the normalized image reference
+	decide(command string, ref imageReference) (remoteDecision, error)
+}
+
+// Create a new remote policy for the service URL, caching its decisions for the given duration
+// (not cached if 0)
+func newRemotePolicy(serviceURL string, ttl time.Duration) (remotePolicy, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid --remote-policy value: %s (expected e.g. https://authz.example.com/decide)", serviceURL)
+	}
+	policy := &httpRemotePolicy{client: &http.Client{Timeout: remotePolicyTimeout}, serviceURL: serviceURL}
+	if ttl <= 0 {
+		return policy, nil
+	}
+	return newCachedRemotePolicy(policy, ttl), nil
+}
+
+// Remote policy queried with GET <service URL>?command=<command>&image=<reference>, answering
+// 200 OK with {"allow": true} or {"allow": false, "reason": "..."}
+type httpRemotePolicy struct {
+	client     *http.Client
+	serviceURL string
+}
+
+// Queries the decision of the service on the registry command of the image reference
+func (policy *httpRemotePolicy) decide(command string, ref imageReference) (remoteDecision, error) {
+	separator := "?"
+	if strings.Contains(policy.serviceURL, "?") {
+		separator = "&"
+	}
+	query := url.Values{}
+	query.Set("command", command)
+	query.Set("image", ref.String())
+	resp, err := policy.client.Get(policy.serviceURL + separator + query.Encode())
+	if err != nil {
+		return remoteDecision{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteDecision{}, fmt.Errorf("querying the remote policy: %s", resp.Status)
+	}
+	var decision remoteDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return remoteDecision{}, fmt.Errorf("decoding the remote policy decision: %v", err)
+	}
+	return decision, nil
+}
+
+// Remote policy caching the decisions of another remote policy per command and image reference, so that
+// the latency of the requests is bounded by the cache hits. Errors are not cached.
+type cachedRemotePolicy struct {
+	policy     remotePolicy
+	ttl        time.Duration
+	decisions  *decisionCache
+	generation uint64
+	now        func() time.Time
+}
+
+// Create a new caching remote policy
+func newCachedRemotePolicy(policy remotePolicy, ttl time.Duration) *cachedRemotePolicy {
+	decisions := newDecisionCache()
+	return &cachedRemotePolicy{policy: policy, ttl: ttl, decisions: decisions, generation: decisions.newGeneration(), now: time.Now}
+}
+
+// Returns the cached decision on the registry command of the image reference, querying it if not cached
+func (cache *cachedRemotePolicy) decide(command string, ref imageReference) (remoteDecision, error) {
+	key := command + " " + ref.String()
+	if response, ok := cache.decisions.get(key, cache.generation, cache.now()); ok {
+		return remoteDecision{Allow: response.Allow, Reason: response.Msg}, nil
+	}
+	decision, err := cache.policy.decide(command, ref)
+	if err != nil {
+		return remoteDecision{}, err
+	}
+	cache.decisions.put(key, authorization.Response{Allow: decision.Allow, Msg: decision.Reason}, cache.generation, cache.ttl, cache.now())
+	return decision, nil
+}
+
+// Built-in matcher deciding with the remote policy on the registry commands which the allowlist does not
+// authorize. Consulted after the allowlist matcher, so that the deny-lists and the other explicit rules
+// still deny. The remote policy decides: the next matchers are not consulted.
+type remoteMatcher struct {
+	plugin *ImgAuthZPlugin
+}
+
+// Returns the name of the remote matcher
+func (matcher *remoteMatcher) Name() string {
+	return "remote"
+}
+
+// Allows or denies the image as per the remote policy. If the remote policy cannot be queried, the
+// on-error behavior applies.
+func (matcher *remoteMatcher) Match(request MatchRequest) MatchResult {
+	plugin := matcher.plugin
+	return decided(plugin.limitedCheck(request.reqURL, request.request, func() authorization.Response {
+		image := request.request.image
+		decision, err := plugin.remote.decide(request.Command, image)
+		if err != nil {
+			return plugin.errorResponse(request.request, request.reqURL, fmt.Errorf("remote policy query failed: %v", err))
+		}
+		if decision.Allow {
+			request.request.logln("[ALLOWED] Remote policy:", image.String(), request.req.RequestMethod, request.reqURL.String())
+			return authorization.Response{Allow: true}
+		}
+
+		request.request.logln("[DENIED] Remote policy:", image.String(), decision.Reason, request.req.RequestMethod, request.reqURL.String())
+		if len(decision.Reason) == 0 {
+			return authorization.Response{Allow: false, Msg: request.request.denialMsg("The image is not authorized by the remote policy")}
+		}
+		return authorization.Response{Allow: false, Msg: request.request.denialMsg("The image is not authorized by the remote policy: " + decision.Reason)}
+	}))
+}
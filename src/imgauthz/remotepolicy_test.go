@@ -0,0 +1,122 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Remote policy allowing the images of the team repositories, counting its queries, or failing
+type teamRemotePolicy struct {
+	err     error
+	queries int
+}
+
+func (policy *teamRemotePolicy) decide(command string, ref imageReference) (remoteDecision, error) {
+	policy.queries++
+	if policy.err != nil {
+		return remoteDecision{}, policy.err
+	}
+	if strings.HasPrefix(ref.repository, "team/") {
+		return remoteDecision{Allow: true}, nil
+	}
+	return remoteDecision{Allow: false, Reason: "not in the inventory"}, nil
+}
+
+func TestRemotePolicyDecidesWhatTheAllowlistDoesNotAuthorize(t *testing.T) {
+	remote := &teamRemotePolicy{}
+	config := pluginConfig{registries: []string{"docker.io"}, denyImages: []string{"quay.io/team/banned"}}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newCachedRemotePolicy(remote, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	plugin.remote = cache
+	plugin.matchers = plugin.matcherChain()
+	policy := &Policy{plugin: plugin}
+
+	for _, test := range []struct {
+		elapsed      time.Duration
+		err          error
+		allowOnError bool
+		image        string
+		allowed      bool
+		msg          string
+		queries      int
+	}{
+		// The allowlist and the deny-lists decide first
+		{0, nil, false, "alpine:3.19", true, "", 0},
+		{0, nil, false, "quay.io/team/banned:1.0", false, "", 0},
+		{0, nil, false, "quay.io/team/app:1.0", true, "", 1},
+		{0, nil, false, "quay.io/other/app:1.0", false, "The image is not authorized by the remote policy: not in the inventory", 2},
+		// The decisions are cached, allowed or denied
+		{30 * time.Second, errors.New("connection refused"), false, "quay.io/team/app:1.0", true, "", 2},
+		{0, errors.New("connection refused"), false, "quay.io/other/app:1.0", false, "not in the inventory", 2},
+		// Once expired, the on-error behavior applies to the failed queries, which are not cached
+		{time.Minute, errors.New("connection refused"), false, "quay.io/team/app:1.0", false, "connection refused", 3},
+		{0, errors.New("connection refused"), true, "quay.io/team/app:1.0", true, "", 4},
+		{0, nil, false, "quay.io/team/app:1.0", true, "", 5},
+	} {
+		now = now.Add(test.elapsed)
+		remote.err = test.err
+		plugin.allowOnError = test.allowOnError
+		response := policy.AuthorizePull(test.image)
+		if response.Allow != test.allowed || !strings.Contains(response.Msg, test.msg) || remote.queries != test.queries {
+			t.Errorf("pull of %s (error %v): allowed %v (%s), %d queries, expected %d", test.image, test.err, response.Allow, response.Msg, remote.queries, test.queries)
+		}
+	}
+}
+
+func TestRemotePolicyService(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("tenant") != "ci":
+			w.WriteHeader(http.StatusBadRequest)
+		case query.Get("command") == pullCommand && query.Get("image") == "quay.io/team/app:1.0":
+			w.Write([]byte(`{"allow": true}`))
+		case query.Get("image") == "quay.io/team/broken:1.0":
+			w.Write([]byte(`not json`))
+		default:
+			w.Write([]byte(`{"allow": false, "reason": "not in the inventory"}`))
+		}
+	}))
+	defer service.Close()
+
+	remote, err := newRemotePolicy(service.URL+"/decide?tenant=ci", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		command  string
+		image    string
+		decision remoteDecision
+		err      bool
+	}{
+		{pullCommand, "quay.io/team/app:1.0", remoteDecision{Allow: true}, false},
+		{runCommand, "quay.io/team/app:1.0", remoteDecision{Reason: "not in the inventory"}, false},
+		{pullCommand, "quay.io/team/broken:1.0", remoteDecision{}, true},
+	} {
+		decision, err := remote.decide(test.command, parseImageReference(test.image, ""))
+		if decision != test.decision || (err != nil) != test.err {
+			t.Errorf("%s of %s: %+v (%v)", test.command, test.image, decision, err)
+		}
+	}
+
+	for _, serviceURL := range []string{"ftp://authz.example/decide", "https://", "authz.example/decide"} {
+		if _, err := newRemotePolicy(serviceURL, 0); err == nil {
+			t.Errorf("%s accepted", serviceURL)
+		}
+	}
+}
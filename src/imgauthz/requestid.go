@@ -0,0 +1,18 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Returns a new random correlation ID, e.g. 3f9a1c0b, to trace the log lines of a request
+func newRequestID() string {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(id)
+}
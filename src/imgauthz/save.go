@@ -0,0 +1,103 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/url"
+	"strings"
+)
+
+// Returns the images exported by a docker save request, i.e. /images/{name}/get for a single image
+// or /images/get?names=... for several images, or nil for any other request.
+// The image name is part of the path, and may contain slashes.
+func savedImages(reqURL *url.URL) []string {
+	if strings.HasSuffix(reqURL.Path, "/images/get") {
+		var names []string
+		for _, name := range reqURL.Query()["names"] {
+			if len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	index := strings.Index(reqURL.Path, "/images/")
+	if index < 0 || !strings.HasSuffix(reqURL.Path, "/get") {
+		return nil
+	}
+	name := strings.TrimSuffix(reqURL.Path[index+len("/images/"):], "/get")
+	if len(name) == 0 {
+		return nil
+	}
+	return []string{name}
+}
+
+// Confidential registries and images, which cannot be saved
+type confidentialEntries struct {
+	registries *patternSet
+	images     *patternSet
+}
+
+// Returns the confidential entries: registries, e.g. my.docker.registry or *.corp.net, and images,
+// i.e. the entries with a repository, e.g. my.docker.registry/team/app or 'docker.io/corp/*'
+func newConfidentialEntries(entries []string) (*confidentialEntries, error) {
+	var registries, images []string
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") || strings.HasPrefix(entry, regexPrefix) {
+			images = append(images, entry)
+		} else {
+			registries = append(registries, entry)
+		}
+	}
+
+	var confidential confidentialEntries
+	var err error
+	if confidential.registries, err = newPatternSet(normalizeEntries(registries, normalizeRegistryHost)); err != nil {
+		return nil, err
+	}
+	if confidential.images, err = newPatternSet(normalizeEntries(images, normalizeImageName)); err != nil {
+		return nil, err
+	}
+	return &confidential, nil
+}
+
+// Returns true if the image is on a confidential registry, or is a confidential image
+func (plugin *ImgAuthZPlugin) isConfidential(ref imageReference) bool {
+	return plugin.confidential.registries.matches(plugin.registryNames(ref.registry)...) ||
+		plugin.confidential.images.matches(ref.name(), ref.String())
+}
+
+// Authorizes a docker save command, which exports images out of the docker host, so that the
+// confidential images cannot be exfiltrated. The saved images are matched by their reference and, as
+// they may be saved by ID or under another local tag, by the repo tags and digests of the local images.
+// If the local images cannot be inspected, the on-error behavior applies.
+func (plugin *ImgAuthZPlugin) authorizeSave(req authorization.Request, reqURL *url.URL, request registryRequest) authorization.Response {
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		for _, name := range request.saved {
+			if err := validateReference(name); err != nil {
+				request.logln("[DENIED] Invalid image reference:", loggedReference(name), err, req.RequestMethod, reqURL.Path)
+				unnamed := registryRequest{command: request.command}
+				return authorization.Response{Allow: false, Msg: unnamed.denialMsg("The image reference is invalid: " + err.Error())}
+			}
+
+			references := []string{name}
+			if plugin.docker != nil {
+				local, _, err := plugin.docker.imageReferences(name)
+				if err != nil {
+					return plugin.errorResponse(request, reqURL, err)
+				}
+				references = append(references, local...)
+			}
+			for _, reference := range references {
+				if ref := plugin.parseReference(reference); plugin.isConfidential(ref) {
+					request.logln("[DENIED] Confidential image:", name, ref.name(), req.RequestMethod, reqURL.String())
+					return authorization.Response{Allow: false, Msg: request.denialMsg("The image " + ref.name() + " is confidential and cannot be saved")}
+				}
+			}
+		}
+		request.logln("[ALLOWED] Save:", strings.Join(request.saved, " "), req.RequestMethod, reqURL.String())
+		return authorization.Response{Allow: true}
+	})
+}
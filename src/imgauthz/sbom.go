@@ -0,0 +1,202 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Annotations of the attestation manifests of the images built by docker buildx
+	buildxReferenceType     = "vnd.docker.reference.type"
+	buildxAttestationType   = "attestation-manifest"
+	predicateTypeAnnotation = "in-toto.io/predicate-type"
+)
+
+// Predicate types of the SBOM attestations
+var sbomPredicateTypes = map[string]bool{
+	"https://spdx.dev/Document":      true,
+	"https://cyclonedx.org/bom":      true,
+	"https://cyclonedx.org/bom/v1.4": true,
+	"https://cyclonedx.org/bom/v1.5": true,
+	"https://cyclonedx.org/bom/v1.6": true,
+}
+
+// Finds the SBOM of the images
+type sbomFinder interface {
+	// Returns a description of the SBOM of the image (e.g. its predicate type),
+	// or empty if the image has no SBOM
+	findSBOM(ref imageReference) (string, error)
+}
+
+// Finds the SBOMs published on the registry along with the images: the SBOM attestations of docker
+// buildx (--sbom), and the SBOMs attached or attested by cosign (attach sbom, attest --type spdxjson
+// or cyclonedx). The attestation signatures are not verified.
+type registrySBOMFinder struct {
+	registry *registryClient
+}
+
+// Create a new registry SBOM finder, looking up the SBOMs with the registry client
+func newRegistrySBOMFinder(registry *registryClient) *registrySBOMFinder {
+	return &registrySBOMFinder{registry: registry}
+}
+
+// Returns the predicate type of the first SBOM of the image found on the registry
+func (finder *registrySBOMFinder) findSBOM(ref imageReference) (string, error) {
+	host, repository := ref.registryHost()
+	digest, err := finder.registry.resolveDigest(ref)
+	if err != nil {
+		return "", err
+	}
+
+	// docker buildx attestation manifests, referenced by the manifest list of the image
+	data, _, err := finder.registry.fetchRaw(registryManifestURL(host, repository, digest), manifestMediaTypes())
+	if err != nil {
+		return "", err
+	}
+	var index imageManifest
+	if err := json.Unmarshal(data, &index); err != nil {
+		return "", fmt.Errorf("decoding manifest %s: %v", digest, err)
+	}
+	for _, manifest := range index.Manifests {
+		if manifest.Annotations[buildxReferenceType] != buildxAttestationType {
+			continue
+		}
+		if predicateType, err := finder.attestationManifestSBOM(host, repository, manifest.Digest); err != nil || len(predicateType) > 0 {
+			return predicateType, err
+		}
+	}
+
+	// cosign attach sbom
+	if _, _, err := finder.registry.fetchRaw(attachedManifestURL(host, repository, digest, "sbom"), manifestMediaTypes()); err == nil {
+		return "cosign attached SBOM", nil
+	} else if err != errRegistryNotFound {
+		return "", err
+	}
+
+	// cosign attest
+	return finder.cosignAttestationSBOM(host, repository, digest)
+}
+
+// Returns the SBOM predicate type of a docker buildx attestation manifest, or empty if it has none
+func (finder *registrySBOMFinder) attestationManifestSBOM(host string, repository string, digest string) (string, error) {
+	data, _, err := finder.registry.fetchRaw(registryManifestURL(host, repository, digest), manifestMediaTypes())
+	if err != nil {
+		return "", err
+	}
+	var manifest imageManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("decoding attestation manifest %s: %v", digest, err)
+	}
+	for _, layer := range manifest.Layers {
+		if predicateType := layer.Annotations[predicateTypeAnnotation]; sbomPredicateTypes[predicateType] {
+			return predicateType, nil
+		}
+	}
+	return "", nil
+}
+
+// Returns the SBOM predicate type of the cosign attestations of the image digest, or empty if it has none
+func (finder *registrySBOMFinder) cosignAttestationSBOM(host string, repository string, digest string) (string, error) {
+	data, _, err := finder.registry.fetchRaw(attachedManifestURL(host, repository, digest, "att"), manifestMediaTypes())
+	if err == errRegistryNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var attestations imageManifest
+	if err := json.Unmarshal(data, &attestations); err != nil {
+		return "", fmt.Errorf("decoding the attestations of %s: %v", digest, err)
+	}
+
+	for _, layer := range attestations.Layers {
+		if layer.MediaType != mediaTypeDSSEEnvelope {
+			continue
+		}
+		if predicateType := layer.Annotations["predicateType"]; sbomPredicateTypes[predicateType] {
+			return predicateType, nil
+		}
+		data, _, err := finder.registry.fetchRaw(registryBlobURL(host, repository, layer.Digest), mediaTypeDSSEEnvelope)
+		if err != nil {
+			return "", err
+		}
+		var envelope dsseEnvelope
+		var statement provenanceStatement
+		if json.Unmarshal(data, &envelope) != nil {
+			continue
+		}
+		if payload, err := base64.StdEncoding.DecodeString(envelope.Payload); err != nil || json.Unmarshal(payload, &statement) != nil {
+			continue
+		}
+		if sbomPredicateTypes[statement.PredicateType] {
+			return statement.PredicateType, nil
+		}
+	}
+	return "", nil
+}
+
+// Finds the SBOMs with an external service, queried with GET <service URL>?image=<reference>:
+// 200 OK if the image has an SBOM, 404 Not Found if it has none
+type serviceSBOMFinder struct {
+	client     *http.Client
+	serviceURL string
+}
+
+// Create a new SBOM service finder
+func newServiceSBOMFinder(serviceURL string) *serviceSBOMFinder {
+	return &serviceSBOMFinder{client: &http.Client{Timeout: registryTimeout}, serviceURL: serviceURL}
+}
+
+// Returns the service URL if the service has an SBOM for the image
+func (finder *serviceSBOMFinder) findSBOM(ref imageReference) (string, error) {
+	separator := "?"
+	if strings.Contains(finder.serviceURL, "?") {
+		separator = "&"
+	}
+	resp, err := finder.client.Get(finder.serviceURL + separator + "image=" + url.QueryEscape(ref.String()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return finder.serviceURL, nil
+	case http.StatusNotFound:
+		return "", nil
+	}
+	return "", fmt.Errorf("querying the SBOM service: %s", resp.Status)
+}
+
+// Authorizes a registry command by the SBOM of its image, if an SBOM is required.
+// Images without an SBOM are denied. Images whose SBOM could not be looked up (e.g. the registry
+// is unreachable) are allowed or denied as per the on-error behavior.
+func (plugin *ImgAuthZPlugin) authorizeSBOM(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.sboms == nil {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		sbom, err := plugin.sboms.findSBOM(request.image)
+		if err != nil {
+			if response, ok := plugin.degradedResponse(request, reqURL, "SBOM check", err); ok {
+				return response
+			}
+			return plugin.errorResponse(request, reqURL, fmt.Errorf("SBOM lookup failed: %v", err))
+		}
+		if len(sbom) == 0 {
+			request.logln("[DENIED] No SBOM:", request.image.String(), reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The image has no published SBOM. To generate and publish one, see " + plugin.sbomHelpURL)}
+		}
+		plugin.debugln(request, "[SBOM] Found:", sbom, request.image.String())
+		return authorization.Response{Allow: true}
+	})
+}
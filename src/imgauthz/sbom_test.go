@@ -0,0 +1,84 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// SBOM finder of the SBOM predicate types of the images by name, or failing
+type staticSBOMFinder struct {
+	sboms map[string]string
+	err   error
+}
+
+func (finder *staticSBOMFinder) findSBOM(ref imageReference) (string, error) {
+	return finder.sboms[ref.name()], finder.err
+}
+
+func TestRequiredSBOMs(t *testing.T) {
+	sboms := map[string]string{"docker.io/library/alpine": "https://spdx.dev/Document"}
+	for _, test := range []struct {
+		image        string
+		err          error
+		allowOnError bool
+		allowed      bool
+		msg          string
+	}{
+		{"alpine:3.19", nil, false, true, ""},
+		{"busybox:1.36", nil, false, false, "The image has no published SBOM. To generate and publish one, see https://wiki.example/sbom"},
+		// Images without SBOM are denied even when allowing on error
+		{"busybox:1.36", nil, true, false, "no published SBOM"},
+		{"alpine:3.19", errors.New("registry unreachable"), false, false, "SBOM lookup failed: registry unreachable"},
+		{"alpine:3.19", errors.New("registry unreachable"), true, true, ""},
+	} {
+		config := pluginConfig{registries: []string{"docker.io"}, requireSBOM: true, sbomHelpURL: "https://wiki.example/sbom", allowOnError: test.allowOnError}
+		if err := mergeRuleSources(&config, nil); err != nil {
+			t.Fatal(err)
+		}
+		plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plugin.sboms = &staticSBOMFinder{sboms: sboms, err: test.err}
+		policy := &Policy{plugin: plugin}
+		if response := policy.AuthorizePull(test.image); response.Allow != test.allowed || !strings.Contains(response.Msg, test.msg) {
+			t.Errorf("pull of %s (error %v, allow on error %v): allowed %v (%s)", test.image, test.err, test.allowOnError, response.Allow, response.Msg)
+		}
+	}
+}
+
+func TestSBOMService(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("image") {
+		case "docker.io/library/alpine:3.19":
+			w.WriteHeader(http.StatusOK)
+		case "docker.io/library/busybox:1.36":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer service.Close()
+
+	finder := newServiceSBOMFinder(service.URL + "/sbom")
+	for _, test := range []struct {
+		image string
+		found bool
+		err   bool
+	}{
+		{"alpine:3.19", true, false},
+		{"busybox:1.36", false, false},
+		{"ubuntu:24.04", false, true},
+	} {
+		sbom, err := finder.findSBOM(parseImageReference(test.image, ""))
+		if (len(sbom) > 0) != test.found || (err != nil) != test.err {
+			t.Errorf("%s: SBOM %q (%v)", test.image, sbom, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+// JSON schema of the policy files, embedded in the binary.
+// Must be kept in sync with the configFile struct: every field of the struct is a property.
+const policySchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "img-authz-plugin policy file",
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "registries":         {"type": "array", "items": {"type": "string"}},
+    "images":             {"type": "array", "items": {"type": "string"}},
+    "repositoryPrefixes": {"type": "array", "items": {"type": "string"}},
+    "deniedRegistries":   {"type": "array", "items": {"type": "string"}},
+    "deniedImages":       {"type": "array", "items": {"type": "string"}},
+    "registryWindows":    {"type": "array", "items": {"type": "string"}},
+    "alwaysAllow":        {"type": "array", "items": {"type": "string"}},
+    "deniedHostMounts":   {"type": "array", "items": {"type": "string"}},
+    "deniedCapabilities": {"type": "array", "items": {"type": "string"}},
+    "registryAliases":    {"type": "array", "items": {"type": "string"}},
+    "override":           {"type": "boolean"},
+    "settings":           {"type": "object"}
+  }
+}`
+
+// Subset of the JSON schema keywords used by the policy schema
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+}
+
+// Parsed policy schema. The embedded schema is valid, so that it cannot fail at runtime.
+var parsedPolicySchema = mustParseSchema(policySchema)
+
+// Parses a JSON schema, panicking if it is invalid
+func mustParseSchema(schema string) *jsonSchema {
+	var parsed jsonSchema
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		panic(fmt.Sprintf("invalid JSON schema: %v", err))
+	}
+	return &parsed
+}
+
+// Validates a JSON or YAML policy file against the policy schema.
+// Returns the first violation, with the path of the offending value, e.g.
+// $.registries[1]: expected string, got number.
+func validatePolicy(data []byte, isYAML bool) error {
+	var document interface{}
+	var err error
+	if isYAML {
+		err = yaml.Unmarshal(data, &document)
+		document = jsonValue(document)
+	} else {
+		err = json.Unmarshal(data, &document)
+	}
+	if err != nil {
+		return err
+	}
+
+	// An empty YAML document is an empty policy
+	if document == nil && isYAML {
+		return nil
+	}
+	return parsedPolicySchema.validate("$", document)
+}
+
+// Converts a value decoded from YAML to its JSON equivalent, i.e. with string map keys
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			object[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, item := range value {
+			array[i] = jsonValue(item)
+		}
+		return array
+	}
+	return value
+}
+
+// Returns the JSON type name of a decoded value
+func jsonTypeName(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if value == float64(int64(value)) {
+			return "integer"
+		}
+		return "number"
+	case int, int64, uint64:
+		return "integer"
+	case float32:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// Validates a decoded value against the schema, path locating the value in the document
+func (schema *jsonSchema) validate(path string, value interface{}) error {
+	actual := jsonTypeName(value)
+	if len(schema.Type) > 0 && actual != schema.Type && !(schema.Type == "number" && actual == "integer") {
+		return fmt.Errorf("%s: expected %s, got %s", path, schema.Type, actual)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, known := schema.Properties[key]
+			if !known {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					return fmt.Errorf("%s: unknown field %q (expected one of %s)", path, key, strings.Join(schema.propertyNames(), ", "))
+				}
+				continue
+			}
+			if err := property.validate(path+"."+key, value[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range value {
+				if err := schema.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the sorted property names of an object schema
+func (schema *jsonSchema) propertyNames() []string {
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,51 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicySchemaHasEveryPolicyFileField(t *testing.T) {
+	fields := reflect.TypeOf(configFile{})
+	if fields.NumField() != len(parsedPolicySchema.Properties) {
+		t.Errorf("%d policy file fields, %d schema properties", fields.NumField(), len(parsedPolicySchema.Properties))
+	}
+	for i := 0; i < fields.NumField(); i++ {
+		name := strings.Split(fields.Field(i).Tag.Get("json"), ",")[0]
+		if parsedPolicySchema.Properties[name] == nil {
+			t.Errorf("policy file field %s not in the schema", name)
+		}
+	}
+}
+
+func TestPolicyFilesAreValidatedAgainstTheSchema(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		isYAML bool
+		err    string
+	}{
+		{`{}`, false, ""},
+		{`{"registries": ["docker.io"], "override": true}`, false, ""},
+		{`{"registry": ["docker.io"]}`, false, `unknown field "registry"`},
+		{`{"registries": "docker.io"}`, false, "$.registries: expected array, got string"},
+		{`{"registries": ["docker.io", 1]}`, false, "$.registries[1]: expected string, got integer"},
+		{`{"override": "yes"}`, false, "$.override: expected boolean, got string"},
+		{`[]`, false, "$: expected object, got array"},
+		{"", true, ""},
+		{"registries:\n  - docker.io\noverride: true\n", true, ""},
+		{"imagez:\n  - docker.io/library/alpine\n", true, `unknown field "imagez"`},
+		{"registries:\n  - 5000\n", true, "$.registries[0]: expected string, got integer"},
+	} {
+		err := validatePolicy([]byte(test.policy), test.isYAML)
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%q: %v", test.policy, err)
+		}
+		if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q: %v, expected %s", test.policy, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,824 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+
+// Package imgauthz implements the image authorization plugin: its policy, its decisions and its service.
+// Policies can be tested from other Go projects with NewPolicy, see api.go.
+package imgauthz
+
+import (
+	"flag"
+	"fmt"
+	units "github.com/docker/go-units"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"reflect"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	pluginSocket      = "/run/docker/plugins/img-authz-plugin.sock"
+)
+
+// Pseudo-images which are always allowed by default, as they do not come from any registry
+var defaultPseudoImages = []string{
+	"scratch",
+}
+
+// Infrastructure images which are always allowed by default, as the host breaks without them
+var defaultAlwaysAllow = []string{
+	"k8s.gcr.io/pause",
+	"registry.k8s.io/pause",
+	"gcr.io/google_containers/pause*",
+}
+
+var (
+	flDockerHost         = flag.String("host", defaultDockerHost, "Specifies the host where docker daemon is running")
+	flDockerTLSCA        = flag.String("docker-tls-ca", "", "Specifies the PEM CA certificate verifying a tcp:// docker daemon over TLS, reloaded on SIGHUP (TLS with the system CAs if only a client certificate is set)")
+	flDockerTLSCert      = flag.String("docker-tls-cert", "", "Specifies the PEM client certificate of the TLS connection to a tcp:// docker daemon, reloaded on SIGHUP (no client certificate if empty)")
+	flDockerTLSKey       = flag.String("docker-tls-key", "", "Specifies the PEM private key of --docker-tls-cert, reloaded on SIGHUP")
+	flDockerMaxIdle      = flag.Int("docker-max-idle-conns", 10, "Specifies the maximum number of idle (keep-alive) connections kept open to the docker daemon (0 to close the connections after each request)")
+	flDockerIdleTimeout  = flag.Duration("docker-idle-timeout", 90*time.Second, "Specifies the duration after which an idle connection to the docker daemon is closed (0 for unlimited)")
+	flDockerKeepAlive    = flag.Duration("docker-keepalive", 30*time.Second, "Specifies the interval of the TCP keep-alive probes of the connections to a tcp:// docker host (negative to disable)")
+	flBreakGlassToken    = flag.String("breakglass-token", "", "Specifies the token which allows otherwise denied pulls in an emergency, read from the X-Img-Authz-Breakglass header (disabled if empty)")
+	flMaxImageSize       = flag.String("max-image-size", "0", "Specifies the maximum size of the pulled images, e.g. 500MB or 2GB (0 for unlimited)")
+	flUnknownImageSize   = flag.String("unknown-image-size", "allow", "Specifies whether to allow or deny pulls whose image size could not be determined (allow or deny)")
+	flMaxLayers          = flag.Int("max-layers", 0, "Specifies the maximum number of layers of the pulled and run images (0 for unlimited)")
+	flUnknownLayers      = flag.String("unknown-layers", "allow", "Specifies whether to allow or deny the requests whose image layer count could not be determined (allow or deny)")
+	flDecisionTimeout    = flag.Duration("decision-timeout", 0, "Specifies the maximum duration of an authorization decision, after which the on-error behavior applies (0 for unlimited)")
+	flNoDefaultAlways    = flag.Bool("no-default-always-allow", false, "Clears the default list of always allowed infrastructure images")
+	flNoDefaultPseudo    = flag.Bool("no-default-pseudo-images", false, "Clears the default list of always allowed pseudo-images (i.e. scratch)")
+	flDebug              = flag.Bool("debug", false, "Enables debug logging")
+	flLogBodies          = flag.Bool("log-bodies", false, "Logs the request URI and the redacted, size-capped request body of the registry commands (requires --debug)")
+	flSyslog             = flag.String("syslog", syslogOff, "Specifies whether to log to stderr only (off), to stderr and the local syslog (also) or to the local syslog only (only)")
+	flSyslogFacility     = flag.String("syslog-facility", "daemon", "Specifies the syslog facility, e.g. daemon or local0")
+	flSyslogTag          = flag.String("syslog-tag", "img-authz-plugin", "Specifies the syslog tag")
+	flDumpPolicy         = flag.Bool("dump-policy", false, "Prints the effective policy as JSON and exits without starting the plugin")
+	flConfigFile         = flag.String("config", "", "Specifies the JSON policy file, merged with the command line options and reloaded on SIGHUP")
+	flConfigDir          = flag.String("config-dir", "", "Specifies a directory of JSON and YAML policy files, merged in name order after --config and reloaded on SIGHUP")
+	flPolicyPubKey       = flag.String("policy-pubkey", "", "Specifies the PEM public key (Ed25519, ECDSA or RSA) verifying the policy file signature (disabled if empty)")
+	flPolicySig          = flag.String("policy-sig", "", "Specifies the detached signature of the policy file, verified with --policy-pubkey before every policy load")
+	flMetricsAddr        = flag.String("metrics-addr", "", "Specifies the address to serve the metrics on, e.g. 127.0.0.1:9323 (disabled if empty)")
+	flOnError            = flag.String("on-error", "deny", "Specifies whether to allow or deny requests whose authorization could not be verified due to an error (allow or deny)")
+	flOnUnparseable      = flag.String("on-unparseable", "deny", "Specifies whether to allow or deny requests whose URL cannot be parsed, e.g. with an invalid escape or a control character, as they could be registry commands (allow or deny)")
+	flDefaultRegistry    = flag.String("default-registry", "", "Specifies the registry resolving the image names without a registry host, e.g. my.mirror.registry resolves ubuntu to my.mirror.registry/library/ubuntu (docker.io if empty)")
+	flImageQuota         = flag.Int("image-quota", 0, "Specifies the maximum number of distinct images each user can run within --image-quota-window, tracked in memory and reset on reload (0 for unlimited)")
+	flImageQuotaWindow   = flag.Duration("image-quota-window", 24*time.Hour, "Specifies the sliding time window of --image-quota")
+	flRateLimit          = flag.Int("rate-limit", 0, "Specifies the maximum number of registry commands each user can send within --rate-limit-interval, tracked in memory and reset on reload (0 for unlimited)")
+	flRateLimitInterval  = flag.Duration("rate-limit-interval", time.Minute, "Specifies the time interval of --rate-limit")
+	flMatchPlatforms     = flag.Bool("match-platform-digests", false, "Allows the references to a manifest list digest of the images pinned to digests (i.e. --image <image>@<digest>) whose platform manifest digest is approved, as resolved on the registry")
+	flPinTags            = flag.Bool("pin-tags", false, "Pins each pulled tag to the digest it first resolves to, and denies later pulls of the tag resolving to a different digest")
+	flRequirePriorPull   = flag.Bool("require-prior-pull", false, "Denies the runs of the images which were not pulled with the authorization of the plugin, e.g. pulled before it was installed or loaded from an archive")
+	flPriorPullsFile     = flag.String("prior-pulls-file", "", "Specifies the JSON file persisting the images pulled with --require-prior-pull across restarts and reloads (in memory and reset on reload if empty)")
+	flPinTagsFile        = flag.String("pin-tags-file", "", "Specifies the JSON file persisting the --pin-tags pins across restarts and reloads (in memory and reset on reload if empty)")
+	flMinAPIVersion      = flag.String("min-api-version", "", "Specifies the minimum Docker API version of the registry commands, e.g. 1.40; commands of clients using an older API version are denied (any version if empty)")
+	flAnyRegistryPort    = flag.Bool("any-registry-port", false, "Matches the registry entries without a port, e.g. my.docker.registry, on any port of their host (by default, registries match with their port only)")
+	flInspectOnRun       = flag.Bool("inspect-on-run", false, "Authorizes docker run commands against the repo tags and digests of the local image, as resolved by the docker daemon, rather than the requested reference")
+	flInspectMatch       = flag.String("inspect-match", "any", "Specifies whether --inspect-on-run allows the run if any or only if all of the repo tags and digests of the local image are authorized (any or all)")
+	flMaxChecks          = flag.Int("max-concurrent-checks", 0, "Specifies the maximum number of concurrent expensive checks, e.g. registry manifest fetches or image inspects (0 for unlimited)")
+	flChecksOverLimit    = flag.String("checks-over-limit", "queue", "Specifies whether to queue or deny the requests whose expensive checks are over --max-concurrent-checks (queue or deny)")
+	flHelpURL            = flag.String("help-url", "", "Specifies the URL of the documentation on how to request an exception, appended to the denial messages (omitted if empty)")
+	flDenyInsecure       = flag.Bool("deny-insecure-registry", false, "Denies the pulls and runs of images from the registries configured as insecure (HTTP or unverified TLS) on the docker daemon, as reported by docker info")
+	flDenyLocal          = flag.Bool("deny-local-registries", false, "Denies the image references whose registry is a loopback or link-local address of the docker host (e.g. localhost or 169.254.169.254), or resolves to one, unless it is an exact --registry entry")
+	flDenyAllTags        = flag.Bool("deny-all-tags", false, "Denies the pulls of all the tags of a repository (i.e. docker pull --all-tags), which fetch arbitrarily many images")
+	flRestrictDist       = flag.Bool("restrict-distribution", false, "Denies the registry queries (i.e. /distribution/{name}/json, e.g. docker manifest inspect) of the registries and images which are not authorized, as their pulls")
+	flRequireMirror      = flag.Bool("require-mirror", false, "Denies the image references which are not requested through a mirror (see --mirror-prefix), e.g. straight to their upstream registry")
+	flRequireAuth        = flag.Bool("require-auth", false, "Denies the registry commands of unauthenticated clients, i.e. without an authentication method such as TLS client certificates")
+	flCanonicalEntries   = flag.String("canonicalize-entries", canonicalOff, "Specifies whether the exact registry and image entries are used as written (off), or canonicalized at load, e.g. ubuntu to docker.io/library/ubuntu, with the entries written differently but canonicalizing to the same entry merged (merge) or rejected (reject)")
+	flRestrictCommit     = flag.String("restrict-commit", commitOff, "Specifies whether to allow docker commit (off), deny it (deny) or allow it to the authorized registries and images only (allowlist)")
+	flRequireExplicitTag = flag.Bool("require-explicit-tag", false, "Denies the image references without an explicit tag or digest, which implicitly resolve to the latest tag")
+	flAdminToken         = flag.String("admin-token", "", "Specifies the token required by the admin endpoints, e.g. /status on the metrics address (disabled if empty)")
+	flPolicyExport       = flag.String("policy-export", "", "Specifies the file the effective policy is exported to as JSON on SIGUSR1 or GET /policy/export on the metrics address, with the admin token (disabled if empty)")
+	flStatusDecisions    = flag.Int("status-decisions", 50, "Specifies the number of last decisions reported on /status")
+	flMinRegistries      = flag.Int("min-registries", 0, "Specifies the minimum number of authorized registries: an enforced policy with fewer registries is refused at startup and on reload (no minimum if 0)")
+	flEnforceAfter       = flag.String("enforce-after", "", "Specifies the RFC 3339 time after which the policy is enforced, e.g. 2024-07-01T00:00:00Z; before it, denied requests are logged and allowed (enforced right away if empty)")
+	flRequireProvenance  = flag.Bool("require-provenance", false, "Denies the pulled images without a SLSA provenance attestation signed with --provenance-pubkey and built by a --trusted-builder")
+	flProvenancePubKey   = flag.String("provenance-pubkey", "", "Specifies the PEM public key (Ed25519, ECDSA or RSA) signing the provenance attestations, e.g. the cosign public key")
+	flRequireSBOM        = flag.Bool("require-sbom", false, "Denies the pulled and run images without a published SBOM, found on the registry or by --sbom-service")
+	flSBOMService        = flag.String("sbom-service", "", "Specifies the URL of the service finding the image SBOMs, queried with GET <url>?image=<reference> (the registry if empty)")
+	flSBOMHelpURL        = flag.String("sbom-help-url", "https://docs.docker.com/build/metadata/attestations/sbom/", "Specifies the URL of the documentation on how to generate an SBOM, mentioned in the denials of --require-sbom")
+	flCacheManifest      = flag.String("cache-manifest", "", "Specifies the file listing the only images allowed, one reference or digest per line, bypassing the registry and image rules and reloaded on SIGHUP (disabled if empty)")
+	flPolicyBackend      = flag.String("policy-backend", "", "Specifies the URL of the Redis server authorizing registries and images in addition to the lists, e.g. redis://:password@kv.example:6379/0 (disabled if empty)")
+	flPolicyBackendTTL   = flag.Duration("policy-backend-ttl", 30*time.Second, "Specifies the duration for which the answers of the --policy-backend are cached (0 for uncached)")
+	flRemotePolicy       = flag.String("remote-policy", "", "Specifies the URL of the authorization service deciding on the images which the lists do not authorize, queried with GET <url>?command=<command>&image=<reference> and answering {\"allow\": true|false, \"reason\": \"...\"} (disabled if empty)")
+	flRemotePolicyTTL    = flag.Duration("remote-policy-ttl", 30*time.Second, "Specifies the duration for which the decisions of the --remote-policy are cached per command and image reference (0 for uncached)")
+	flDecisionStream     = flag.String("decision-stream", "", "Specifies the sink the decisions are published to asynchronously as JSON, e.g. nats://nats.example:4222/img-authz.decisions or https://events.example.com/ingest, dropping them if the sink cannot keep up (disabled if empty)")
+	flStreamBuffer       = flag.Int("decision-stream-buffer", 4096, "Specifies the number of decisions buffered for the --decision-stream, dropped beyond")
+	flSummaryInterval    = flag.Duration("summary-interval", 0, "Specifies the interval at which the allowed and denied counts per registry are summarized, e.g. 1h (disabled if 0)")
+	flSummaryFile        = flag.String("summary-file", "", "Specifies the file the --summary-interval summaries are appended to as JSON lines (logged if empty)")
+	flSummaryMode        = flag.String("summary-mode", summaryReset, "Specifies whether the --summary-interval summaries count the decisions of the interval (reset) or since start (accumulate)")
+	flAuditLog           = flag.String("audit-log", "", "Specifies the file the registry command decisions are appended to as JSON lines, flushed on shutdown (disabled if empty)")
+	flRecord             = flag.String("record", "", "Specifies the file the authorization requests are appended to as JSON lines, with their sensitive values redacted, to be replayed with --replay (disabled if empty)")
+	flAssertFile         = flag.String("assert-file", "", "Specifies a file of reference,expected lines (e.g. alpine:3.19,allow), checks the decisions on their pulls, prints the mismatches and exits, non-zero on any mismatch")
+	flReplay             = flag.String("replay", "", "Feeds the requests of a --record trace file through the policy, prints the decisions as JSON lines and exits without starting the plugin")
+	flQuietStartup       = flag.Bool("quiet-startup", false, "Logs the counts of the authorized registries and images at startup and on reload, rather than each policy rule entry (the decisions are logged as usual)")
+	flDecisionCacheTTL   = flag.Duration("decision-cache-ttl", 0, "Specifies the duration for which the allowed decisions of the image checks (e.g. --max-image-size) are cached per image digest or reference (0 for uncached)")
+	flNegativeCacheTTL   = flag.Duration("negative-cache-ttl", 0, "Specifies the duration for which the denied decisions of the image checks are cached per image digest or reference, e.g. 10s, shorter than --decision-cache-ttl (0 for uncached)")
+	flBreakerFailures    = flag.Int("breaker-failures", 0, "Specifies the number of consecutive failures to contact a registry (network or server errors) after which its circuit breaker opens, failing its checks right away (disabled if 0)")
+	flBreakerCooldown    = flag.Duration("breaker-cooldown", 30*time.Second, "Specifies the duration for which an open circuit breaker fails the checks of its registry, before a single trial request is sent to it")
+	flBreakerOpen        = flag.String("breaker-open", "deny", "Specifies whether the checks contacting a registry whose circuit breaker is open fail as per their own setting (deny) or are skipped, matching the image by name only (allow)")
+	flShutdownTimeout    = flag.Duration("shutdown-timeout", 10*time.Second, "Specifies the maximum duration to wait for the requests being authorized on SIGTERM or SIGINT, before the audit log is closed (0 for unlimited)")
+	authorizedRegistries stringslice
+	authorizedImages     stringslice
+	repositoryPrefixes   stringslice
+	deniedRegistries     stringslice
+	deniedImages         stringslice
+	registryWindows      stringslice
+	registryAliases      stringslice
+	alwaysAllow          stringslice
+	denyHostMounts       stringslice
+	denyCapabilities     stringslice
+	trustedBuilders      stringslice
+	signedBy             stringslice
+	requireLabels        stringslice
+	registryAuth         stringslice
+	registryCAs          stringslice
+	skipVerify           stringslice
+	fingerprints         stringslice
+	restrictSave         stringslice
+	denyMutations        stringslice
+	pseudoImages         stringslice
+	mirrorPrefixes       stringslice
+	allowedOS            stringslice
+	notifyTargets        stringslice
+	celRules             stringslice
+	daemonPolicies       stringslice
+	// Settings of the policy file applied at startup
+	startupSettings map[string]interface{}
+)
+
+// Runs the plugin service of the given version and build, as configured by the command line options.
+// Returns when the effective policy is dumped, otherwise serves the docker daemon until shut down.
+func Main(version string, build string) {
+
+	// Fetch the registry cmd line options
+	flag.Var(&authorizedRegistries, "registry", "Specifies the authorized image registries")
+	flag.Var(&authorizedImages, "image", "Specifies the authorized images as registry/repository, or the official images by their bare name (e.g. ubuntu)")
+	flag.Var(&repositoryPrefixes, "repository-prefix", "Specifies the authorized repository path prefixes across authorized registries")
+	flag.Var(&deniedRegistries, "deny-registry", "Specifies the denied image registries, overriding the authorized registries")
+	flag.Var(&deniedImages, "deny-image", "Specifies the denied images as registry/repository[:tag], overriding the authorized images")
+	flag.Var(&alwaysAllow, "always-allow", "Specifies the images as registry/repository which are allowed regardless of any other rule, in addition to the defaults")
+	flag.Var(&denyHostMounts, "deny-host-mount", "Specifies the host paths which cannot be bound into containers, e.g. /var/run/docker.sock, along with their parents")
+	flag.Var(&denyCapabilities, "deny-capability", "Specifies the capabilities which cannot be added to containers, e.g. SYS_ADMIN, also denying --cap-add ALL")
+	flag.Var(&mirrorPrefixes, "mirror-prefix", "Specifies a mirror (or pull-through cache) of an upstream registry as <mirror>[/<path>]=<upstream>[/<path>], e.g. mirror.corp.net=docker.io, whose references are matched as their upstream references")
+	flag.Var(&allowedOS, "allowed-os", "Specifies an OS whose platform can be requested by the pulls and runs, e.g. linux, denying the platforms of the other OSes, e.g. docker pull --platform windows/amd64 (any OS if not set)")
+	flag.Var(&notifyTargets, "notify", "Specifies a target the decisions are notified to as JSON: stdout, syslog or webhook=<url>, e.g. webhook=https://alerts.example.com/docker (the decisions are logged only if not set)")
+	flag.Var(&pseudoImages, "pseudo-image", "Specifies the pseudo-images, i.e. bare names which do not come from any registry, which are always allowed, in addition to the defaults (scratch)")
+	flag.Var(&signedBy, "signed-by", "Specifies an identity allowing any image it signed with cosign sign --key, regardless of its registry and name, as [<name>=]<PEM public key file>, e.g. release=/etc/img-authz/release.pub")
+	flag.Var(&trustedBuilders, "trusted-builder", "Specifies the builder identities trusted to build the images with --require-provenance, e.g. https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_container_slsa3.yml@refs/tags/v1.9.0")
+	flag.Var(&restrictSave, "restrict-save", "Denies docker save of the images of a confidential registry (e.g. my.docker.registry) or of a confidential image (e.g. 'my.docker.registry/team/*'), matched by reference and by the repo tags and digests of the local image")
+	flag.Var(&registryAuth, "require-registry-auth", "Denies the pulls from the registry without registry credentials (i.e. without docker login), e.g. my.docker.registry or '*.corp.net'")
+	flag.Var(&registryCAs, "registry-ca", "Specifies the PEM CA bundle trusted, in addition to the system CAs, to verify a registry the plugin fetches manifests and signatures from, as <registry>=<file>, e.g. my.docker.registry:5000=/etc/img-authz/registry-ca.pem")
+	flag.Var(&skipVerify, "registry-skip-verify", "Specifies a registry whose TLS certificate is not verified when the plugin fetches manifests and signatures from it, e.g. my.docker.registry:5000 (prefer --registry-ca)")
+	flag.Var(&fingerprints, "registry-fingerprint", "Specifies a trusted SHA-256 fingerprint of the TLS certificate of a registry identified by its certificate, as <registry>=sha256:<hex>, e.g. my.docker.registry:5000=sha256:9f86...; its pulls are denied if it presents another certificate")
+	flag.Var(&denyMutations, "deny-mutation", "Denies a container mutation: update (docker update), rename (docker rename), exec (docker exec), privileged-exec (docker exec --privileged) or copy (docker cp into a container); the others are allowed and logged")
+	flag.Var(&requireLabels, "require-label", "Denies the pulled images whose image config on the registry lacks the label, as <key> or <key>=<value>, e.g. org.opencontainers.image.source")
+	flag.Var(&registryAliases, "registry-alias", "Specifies a short name of a registry as <alias>=<registry>, e.g. hub=docker.io, usable in the policy entries (e.g. --registry hub) and resolved in the image references")
+	flag.Var(&celRules, "cel-rule", "Specifies an allow rule as a CEL expression over the registry command, e.g. 'registry == \"docker.io\" && tag != \"latest\"', allowing the images which the lists do not authorize if any rule is true; the variables are command, registry, repository, image, tag, digest, user and platform")
+	flag.Var(&daemonPolicies, "daemon-policy", "Specifies a policy file applied over the policy to the requests of one docker daemon as <name>=<file>, e.g. build=/etc/img-authz/build.yaml, served on the socket /run/docker/plugins/<name>.sock, i.e. to the daemon started with --authorization-plugin=<name>")
+	flag.Var(&registryWindows, "registry-window", "Specifies a time window during which a registry can be used as <registry>,<days>,<HH:MM>-<HH:MM>,<timezone>, e.g. my.docker.registry,Mon-Fri,09:00-17:00,Europe/Berlin")
+	flag.Parse()
+
+	// Apply the settings of the policy file to the options which are not set on the command line
+	settings, err := readSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	applied, err := applySettings(flag.CommandLine, settings)
+	if err != nil {
+		log.Fatalf("policy file %s: %v", *flConfigFile, err)
+	}
+	startupSettings = settings
+
+	// Select the log output
+	output, flags, err := newLogOutput(*flSyslog, *flSyslogFacility, *flSyslogTag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(output)
+	log.SetFlags(flags)
+
+	log.Println("Plugin Version:", version, "Build: ", build)
+	for _, name := range applied {
+		log.Println("Policy file setting:", name)
+	}
+
+	// Create the docker client connection, shared by the reloaded plugins
+	if *flDockerMaxIdle < 0 {
+		log.Fatalf("invalid --docker-max-idle-conns value: %d (expected 0 or more)", *flDockerMaxIdle)
+	}
+	transport := transportConfig{maxIdleConns: *flDockerMaxIdle, idleTimeout: *flDockerIdleTimeout, keepAlive: *flDockerKeepAlive,
+		tls: tlsFiles{caCert: *flDockerTLSCA, cert: *flDockerTLSCert, key: *flDockerTLSKey}}
+	docker, err := newDockerHostConnection(*flDockerHost, transport)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Docker client connections:", transport.maxIdleConns, "idle, closed after", transport.idleTimeout, "- keep-alive", transport.keepAlive)
+	if transport.tls.enabled() {
+		log.Println("Docker client TLS enabled, certificates reloaded on SIGHUP")
+	}
+
+	// Create image authorization plugin
+	metrics := newPluginMetrics(version, build)
+	status := newPluginStatus(*flStatusDecisions)
+	daemons, err := parseDaemonPolicies(daemonPolicies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	load := func(daemon *daemonPolicy, decisions *decisionCache) (*ImgAuthZPlugin, error) {
+		config, err := loadConfig(daemon)
+		if err != nil {
+			return nil, err
+		}
+		plugin, err := newPlugin(docker, metrics, status, decisions, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range plugin.policyWarnings() {
+			log.Println("[WARNING]", warning)
+		}
+		return plugin, nil
+	}
+	decisions := newDecisionCache()
+	reloadable, err := newReloadablePlugin(func() (*ImgAuthZPlugin, error) {
+		return load(nil, decisions)
+	}, metrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Print the effective policy and exit
+	if *flDumpPolicy {
+		dump, err := reloadable.plugin().dumpPolicy()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(dump))
+		return
+	}
+
+	// Replay the recorded requests and exit
+	if len(*flReplay) > 0 {
+		if err := replayTrace(reloadable.plugin(), *flReplay, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Check the expected decisions and exit, non-zero on any mismatch
+	if len(*flAssertFile) > 0 {
+		mismatches, err := checkAssertions(reloadable.plugin(), *flAssertFile, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if mismatches > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Record the authorization requests, if configured
+	if len(*flRecord) > 0 {
+		if reloadable.recorder, err = newTraceRecorder(*flRecord); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Recording the requests to:", *flRecord)
+	}
+
+	// Load the daemon policies, each with its own decision cache, as their decisions differ
+	plugins, err := newDaemonPlugins(reloadable, daemons, func(daemon daemonPolicy) (*reloadablePlugin, error) {
+		decisions := newDecisionCache()
+		return newReloadablePlugin(func() (*ImgAuthZPlugin, error) {
+			return load(&daemon, decisions)
+		}, metrics)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Notify the decisions to the registered notifiers, to the configured ones, to the decision stream,
+	// then to the audit log, if any
+	notifiers, err := newNotifiers(notifyTargets, *flSyslogFacility, *flSyslogTag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	status.notifiers = append(append(status.notifiers, registeredNotifiers...), notifiers...)
+	if len(*flDecisionStream) > 0 {
+		if *flStreamBuffer <= 0 {
+			log.Fatalf("invalid --decision-stream-buffer value: %d (expected 1 or more)", *flStreamBuffer)
+		}
+		sink, err := newStreamSink(*flDecisionStream)
+		if err != nil {
+			log.Fatal(err)
+		}
+		status.notifiers = append(status.notifiers, newDecisionStream(sink, *flStreamBuffer, metrics))
+	}
+	for _, notifier := range status.notifiers {
+		log.Println("Decision notifier:", notifier.Name())
+	}
+
+	// Append the decisions to the audit log, if any
+	var audit *auditLog
+	if len(*flAuditLog) > 0 {
+		if audit, err = newAuditLog(*flAuditLog); err != nil {
+			log.Fatal(err)
+		}
+		status.notifiers = append(status.notifiers, audit)
+		log.Println("Audit log:", *flAuditLog)
+	}
+
+	// Summarize the decisions per registry periodically, if configured
+	if *flSummaryInterval > 0 {
+		if *flSummaryMode != summaryReset && *flSummaryMode != summaryAccumulate {
+			log.Fatalf("invalid --summary-mode value: %s (expected %s or %s)", *flSummaryMode, summaryReset, summaryAccumulate)
+		}
+		summarizer := newDecisionSummarizer(metrics, *flSummaryMode, *flSummaryFile)
+		go summarizer.run(*flSummaryInterval)
+		if len(*flSummaryFile) > 0 {
+			log.Println("Decision summaries every", *flSummaryInterval, "("+*flSummaryMode+"):", *flSummaryFile)
+		} else {
+			log.Println("Decision summaries every", *flSummaryInterval, "("+*flSummaryMode+"), logged")
+		}
+	}
+
+	// Reload the policy on SIGHUP, export it on SIGUSR1, shut down cleanly on SIGTERM and SIGINT
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				plugins.reload()
+				// The rotated TLS certificates of the docker daemon connection are reloaded along with the policy
+				if transport.tls.enabled() {
+					if err := docker.refresh(); err != nil {
+						log.Println("[CLIENT] Cannot reload the docker TLS certificates, keeping the current client:", err)
+					} else {
+						log.Println("[CLIENT] Docker TLS certificates reloaded")
+					}
+				}
+				continue
+			}
+			if sig == syscall.SIGUSR1 {
+				if len(*flPolicyExport) == 0 {
+					log.Println("[EXPORT] SIGUSR1 ignored, no --policy-export file")
+				} else {
+					exportCurrentPolicy(reloadable, *flPolicyExport)
+				}
+				continue
+			}
+			shutdown(sig, plugins, status, audit)
+		}
+	}()
+
+	// Serve the metrics and, if an admin token is set, the status and the policy export
+	if len(*flMetricsAddr) > 0 {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics)
+			if len(*flAdminToken) > 0 {
+				mux.Handle("/status", &statusHandler{status: status, reloadable: reloadable, adminToken: *flAdminToken})
+				if len(*flPolicyExport) > 0 {
+					mux.Handle("/policy/export", &policyExportHandler{reloadable: reloadable, adminToken: *flAdminToken, path: *flPolicyExport})
+				}
+			}
+			log.Fatal(http.ListenAndServe(*flMetricsAddr, mux))
+		}()
+	}
+
+	// Start service handler on the local sock
+	u, _ := user.Lookup("root")
+	gid, _ := strconv.Atoi(u.Gid)
+	if err := plugins.serve(gid); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Shuts down the plugin: waits for the requests being authorized, then flushes and closes
+// the audit log and the trace file, if any, so that no decision record is dropped. The metrics are pulled from
+// the metrics address, so there is nothing to flush.
+func shutdown(sig os.Signal, plugins *daemonPlugins, status *pluginStatus, audit *auditLog) {
+	log.Println("[SHUTDOWN] Received", sig, "- waiting for the requests being authorized")
+	if !plugins.drain(*flShutdownTimeout) {
+		log.Println("[SHUTDOWN] Requests still being authorized after", *flShutdownTimeout)
+	}
+
+	exitCode := 0
+	if audit != nil {
+		if err := audit.close(); err != nil {
+			log.Println("[SHUTDOWN] Cannot close the audit log:", err)
+			exitCode = 1
+		}
+	}
+	if plugins.common.recorder != nil {
+		if err := plugins.common.recorder.close(); err != nil {
+			log.Println("[SHUTDOWN] Cannot close the trace file:", err)
+			exitCode = 1
+		}
+	}
+
+	allowed, denied := status.totals()
+	log.Println("[SHUTDOWN] Plugin stopped after", allowed, "allowed and", denied, "denied registry commands")
+	os.Exit(exitCode)
+}
+
+// Loads the plugin configuration from the command line options and the policy file, if any, with
+// the policy file of the daemon policy merged last, if any. Called at startup and on every policy reload.
+func loadConfig(daemon *daemonPolicy) (pluginConfig, error) {
+	if *flOnError != "allow" && *flOnError != "deny" {
+		return pluginConfig{}, fmt.Errorf("invalid --on-error value: %s (expected allow or deny)", *flOnError)
+	}
+	if *flOnUnparseable != "allow" && *flOnUnparseable != "deny" {
+		return pluginConfig{}, fmt.Errorf("invalid --on-unparseable value: %s (expected allow or deny)", *flOnUnparseable)
+	}
+	if *flUnknownImageSize != "allow" && *flUnknownImageSize != "deny" {
+		return pluginConfig{}, fmt.Errorf("invalid --unknown-image-size value: %s (expected allow or deny)", *flUnknownImageSize)
+	}
+	if *flUnknownLayers != "allow" && *flUnknownLayers != "deny" {
+		return pluginConfig{}, fmt.Errorf("invalid --unknown-layers value: %s (expected allow or deny)", *flUnknownLayers)
+	}
+	if *flMaxLayers < 0 {
+		return pluginConfig{}, fmt.Errorf("invalid --max-layers value: %d (expected 0 or more)", *flMaxLayers)
+	}
+	if *flImageQuota < 0 || *flImageQuotaWindow <= 0 {
+		return pluginConfig{}, fmt.Errorf("invalid --image-quota value: %d within %s (expected 0 or more within a positive window)", *flImageQuota, *flImageQuotaWindow)
+	}
+	if *flRestrictCommit != commitOff && *flRestrictCommit != commitDeny && *flRestrictCommit != commitAllowlist {
+		return pluginConfig{}, fmt.Errorf("invalid --restrict-commit value: %s (expected off, deny or allowlist)", *flRestrictCommit)
+	}
+	if *flCanonicalEntries != canonicalOff && *flCanonicalEntries != canonicalMerge && *flCanonicalEntries != canonicalReject {
+		return pluginConfig{}, fmt.Errorf("invalid --canonicalize-entries value: %s (expected off, merge or reject)", *flCanonicalEntries)
+	}
+	for _, mutation := range denyMutations {
+		if _, ok := containerMutations[mutation]; !ok {
+			return pluginConfig{}, fmt.Errorf("invalid --deny-mutation value: %s (expected update, rename, exec, privileged-exec or copy)", mutation)
+		}
+	}
+	if *flRateLimit < 0 || *flRateLimitInterval <= 0 {
+		return pluginConfig{}, fmt.Errorf("invalid --rate-limit value: %d within %s (expected 0 or more within a positive interval)", *flRateLimit, *flRateLimitInterval)
+	}
+	if *flInspectMatch != "any" && *flInspectMatch != "all" {
+		return pluginConfig{}, fmt.Errorf("invalid --inspect-match value: %s (expected any or all)", *flInspectMatch)
+	}
+	if *flDecisionCacheTTL < 0 || *flNegativeCacheTTL < 0 {
+		return pluginConfig{}, fmt.Errorf("invalid decision cache TTLs: %s and %s (expected 0 or more)", *flDecisionCacheTTL, *flNegativeCacheTTL)
+	}
+	if *flBreakerFailures < 0 || *flBreakerCooldown <= 0 {
+		return pluginConfig{}, fmt.Errorf("invalid --breaker-failures value: %d with a cooldown of %s (expected 0 or more with a positive cooldown)", *flBreakerFailures, *flBreakerCooldown)
+	}
+	if *flBreakerOpen != "allow" && *flBreakerOpen != "deny" {
+		return pluginConfig{}, fmt.Errorf("invalid --breaker-open value: %s (expected allow or deny)", *flBreakerOpen)
+	}
+	if *flMinRegistries < 0 {
+		return pluginConfig{}, fmt.Errorf("invalid --min-registries value: %d (expected 0 or more)", *flMinRegistries)
+	}
+	if *flChecksOverLimit != "queue" && *flChecksOverLimit != "deny" {
+		return pluginConfig{}, fmt.Errorf("invalid --checks-over-limit value: %s (expected queue or deny)", *flChecksOverLimit)
+	}
+	enforceAfter, err := parseEnforceAfter(*flEnforceAfter)
+	if err != nil {
+		return pluginConfig{}, err
+	}
+	var minAPIVersion *apiVersion
+	if len(*flMinAPIVersion) > 0 {
+		version, err := parseAPIVersion(*flMinAPIVersion)
+		if err != nil {
+			return pluginConfig{}, fmt.Errorf("invalid --min-api-version value: %v", err)
+		}
+		minAPIVersion = &version
+		log.Println("Minimum API version:", version)
+	}
+	maxImageSize, err := units.FromHumanSize(*flMaxImageSize)
+	if err != nil {
+		return pluginConfig{}, fmt.Errorf("invalid --max-image-size value: %v", err)
+	}
+	if maxImageSize > 0 {
+		log.Println("Maximum image size:", units.HumanSize(float64(maxImageSize)))
+	}
+	if *flMaxLayers > 0 {
+		log.Println("Maximum image layers:", *flMaxLayers)
+	}
+
+	config := pluginConfig{
+		pseudoImages:       append([]string{}, pseudoImages...),
+		mirrorPrefixes:     append([]string{}, mirrorPrefixes...),
+		allowedOS:          normalizeEntries(allowedOS, platformOS),
+		allowOnError:       *flOnError == "allow",
+		allowUnparseable:   *flOnUnparseable == "allow",
+		breakGlassToken:    *flBreakGlassToken,
+		maxImageSize:       maxImageSize,
+		allowUnknownSize:   *flUnknownImageSize == "allow",
+		maxLayers:          *flMaxLayers,
+		allowUnknownLayers: *flUnknownLayers == "allow",
+		decisionTimeout:    *flDecisionTimeout,
+		defaultRegistry:    normalizeRegistryHost(*flDefaultRegistry),
+		requireAuth:        *flRequireAuth,
+		registryAuth:       append([]string{}, registryAuth...),
+		registryCAs:        append([]string{}, registryCAs...),
+		skipVerify:         append([]string{}, skipVerify...),
+		fingerprints:       append([]string{}, fingerprints...),
+		requireMirror:      *flRequireMirror,
+		denyInsecure:       *flDenyInsecure,
+		denyLocal:          *flDenyLocal,
+		cacheTTL:           *flDecisionCacheTTL,
+		negativeTTL:        *flNegativeCacheTTL,
+		breakerFailures:    *flBreakerFailures,
+		breakerCooldown:    *flBreakerCooldown,
+		breakerDegrade:     *flBreakerOpen == "allow",
+		denyAllTags:        *flDenyAllTags,
+		restrictDist:       *flRestrictDist,
+		denyMutations:      append([]string{}, denyMutations...),
+		restrictSave:       append([]string{}, restrictSave...),
+		anyRegistryPort:    *flAnyRegistryPort,
+		minAPIVersion:      minAPIVersion,
+		imageQuota:         *flImageQuota,
+		quotaWindow:        *flImageQuotaWindow,
+		rateLimit:          *flRateLimit,
+		rateInterval:       *flRateLimitInterval,
+		pinTags:            *flPinTags,
+		matchPlatforms:     *flMatchPlatforms,
+		pinTagsFile:        *flPinTagsFile,
+		requirePriorPull:   *flRequirePriorPull,
+		priorPullsFile:     *flPriorPullsFile,
+		inspectOnRun:       *flInspectOnRun,
+		inspectMatchAll:    *flInspectMatch == "all",
+		helpURL:            *flHelpURL,
+		checkLimit:         *flMaxChecks,
+		queueChecks:        *flChecksOverLimit == "queue",
+		requireExplicitTag: *flRequireExplicitTag,
+		restrictCommit:     *flRestrictCommit,
+		canonicalEntries:   *flCanonicalEntries,
+		enforceAfter:       enforceAfter,
+		minRegistries:      *flMinRegistries,
+		requireProvenance:  *flRequireProvenance,
+		provenanceKey:      *flProvenancePubKey,
+		trustedBuilders:    append([]string{}, trustedBuilders...),
+		signedBy:           append([]string{}, signedBy...),
+		requireSBOM:        *flRequireSBOM,
+		sbomService:        *flSBOMService,
+		sbomHelpURL:        *flSBOMHelpURL,
+		requireLabels:      append([]string{}, requireLabels...),
+		policyBackend:      *flPolicyBackend,
+		backendTTL:         *flPolicyBackendTTL,
+		remotePolicy:       *flRemotePolicy,
+		remoteTTL:          *flRemotePolicyTTL,
+		celRules:           append([]string{}, celRules...),
+		debug:              *flDebug,
+		logBodies:          *flLogBodies}
+
+	if config.logBodies && !config.debug {
+		log.Println("--log-bodies has no effect without --debug")
+	}
+
+	// Policy rule sources, in order of increasing precedence
+	var sources []ruleSource
+	if *flNoDefaultAlways == false {
+		sources = append(sources, ruleSource{name: "defaults", rules: &configFile{AlwaysAllow: defaultAlwaysAllow}})
+	}
+	sources = append(sources, ruleSource{name: "command line", rules: &configFile{
+		Registries:         authorizedRegistries,
+		Images:             authorizedImages,
+		RepositoryPrefixes: repositoryPrefixes,
+		DeniedRegistries:   deniedRegistries,
+		DeniedImages:       deniedImages,
+		RegistryWindows:    registryWindows,
+		AlwaysAllow:        alwaysAllow,
+		DeniedHostMounts:   denyHostMounts,
+		DeniedCapabilities: denyCapabilities,
+		RegistryAliases:    registryAliases}})
+	if *flNoDefaultPseudo == false {
+		config.pseudoImages = append(defaultPseudoImages, config.pseudoImages...)
+	}
+
+	// Verify the policy file signature, if a public key is configured
+	var verifier *policyVerifier
+	if len(*flPolicyPubKey) > 0 || len(*flPolicySig) > 0 {
+		if len(*flPolicyPubKey) == 0 || len(*flPolicySig) == 0 || len(*flConfigFile) == 0 {
+			return pluginConfig{}, fmt.Errorf("--policy-pubkey and --policy-sig must be set together, along with --config")
+		}
+		if verifier, err = newPolicyVerifier(*flPolicyPubKey, *flPolicySig); err != nil {
+			return pluginConfig{}, err
+		}
+	}
+
+	// The policy file
+	if len(*flConfigFile) > 0 {
+		file, err := readConfigFile(*flConfigFile, verifier)
+		if err != nil {
+			return pluginConfig{}, err
+		}
+		if verifier != nil {
+			log.Println("Policy file signature verified:", *flPolicySig)
+		}
+		if !reflect.DeepEqual(file.Settings, startupSettings) {
+			log.Println("[WARNING] The settings of the policy file changed, they apply on restart only")
+		}
+		sources = append(sources, ruleSource{name: *flConfigFile, rules: file})
+	}
+
+	// The policy files of the policy directory, in order
+	if len(*flConfigDir) > 0 {
+		paths, err := configDirFiles(*flConfigDir)
+		if err != nil {
+			return pluginConfig{}, err
+		}
+		for _, path := range paths {
+			file, err := readConfigFile(path, nil)
+			if err != nil {
+				return pluginConfig{}, err
+			}
+			sources = append(sources, ruleSource{name: path, rules: file})
+		}
+	}
+
+	// The policy file of the daemon, whose settings are ignored as those of the policy directory.
+	// Its in-memory state is its own, and so are its state files.
+	if daemon != nil {
+		file, err := readConfigFile(daemon.file, nil)
+		if err != nil {
+			return pluginConfig{}, err
+		}
+		sources = append(sources, ruleSource{name: daemon.file, rules: file})
+		config.pinTagsFile = daemon.stateFile(config.pinTagsFile)
+		config.priorPullsFile = daemon.stateFile(config.priorPullsFile)
+	}
+	if err := mergeRuleSources(&config, sources); err != nil {
+		return pluginConfig{}, err
+	}
+
+	// Read the cache manifest, on every policy reload
+	if len(*flCacheManifest) > 0 {
+		if config.cachedImages, err = readCacheManifest(*flCacheManifest); err != nil {
+			return pluginConfig{}, err
+		}
+		config.cacheManifest = *flCacheManifest
+		log.Println("Cache manifest:", config.cacheManifest, "-", len(config.cachedImages), "images allowed only, the registry and image rules are bypassed")
+	}
+
+	if !config.enforceAfter.IsZero() {
+		if time.Now().Before(config.enforceAfter) {
+			log.Println("Audit mode: denied requests are logged and allowed until", config.enforceAfter.Format(time.RFC3339))
+		} else {
+			log.Println("Enforce mode: the policy is enforced since", config.enforceAfter.Format(time.RFC3339))
+		}
+	}
+	if len(config.policyBackend) > 0 {
+		log.Println("Policy backend:", redactedBackendURL(config.policyBackend), "- answers cached for", config.backendTTL)
+	}
+	if len(config.remotePolicy) > 0 {
+		log.Println("Remote policy:", redactedBackendURL(config.remotePolicy), "- decisions cached for", config.remoteTTL)
+	}
+	for _, rule := range config.celRules {
+		log.Println("CEL rule:", rule)
+	}
+	if config.cacheTTL > 0 || config.negativeTTL > 0 {
+		log.Println("Image check decisions cached:", config.cacheTTL, "if allowed,", config.negativeTTL, "if denied")
+	}
+	if config.breakerFailures > 0 {
+		log.Println("Registry circuit breaker:", config.breakerFailures, "consecutive failures, open for", config.breakerCooldown, "-", allowOrDeny(config.breakerDegrade), "while open")
+	}
+	if config.imageQuota > 0 {
+		log.Println("Image quota:", config.imageQuota, "distinct images per user within", config.quotaWindow)
+	}
+	if config.rateLimit > 0 {
+		log.Println("Rate limit:", config.rateLimit, "registry commands per user within", config.rateInterval)
+	}
+	if config.requirePriorPull && len(config.priorPullsFile) > 0 {
+		log.Println("Runs require a prior pull, persisted to:", config.priorPullsFile)
+	} else if config.requirePriorPull {
+		log.Println("Runs require a prior pull, in memory")
+	}
+	if config.pinTags && len(config.pinTagsFile) > 0 {
+		log.Println("Tags pinned to their first digest, persisted to:", config.pinTagsFile)
+	} else if config.pinTags {
+		log.Println("Tags pinned to their first digest, in memory")
+	}
+	if config.matchPlatforms {
+		log.Println("Pinned digests matched by their platform digest too")
+	}
+	if config.requireAuth {
+		log.Println("Authenticated clients required")
+	}
+	for _, registry := range config.registryAuth {
+		log.Println("Registry credentials required:", registry)
+	}
+	for _, entry := range config.registryCAs {
+		log.Println("Registry CA bundle:", entry)
+	}
+	for _, registry := range config.skipVerify {
+		log.Println("[WARNING] Registry TLS certificate not verified:", registry)
+	}
+	for _, entry := range config.fingerprints {
+		log.Println("Registry certificate fingerprint:", entry)
+	}
+	if config.requireSBOM && len(config.sbomService) > 0 {
+		log.Println("SBOM required, found by:", config.sbomService)
+	} else if config.requireSBOM {
+		log.Println("SBOM required, found on the registries")
+	}
+	if config.requireProvenance {
+		log.Println("Provenance required, signed with:", config.provenanceKey)
+		for _, builder := range config.trustedBuilders {
+			log.Println("Trusted builder:", builder)
+		}
+	}
+	for _, identity := range config.signedBy {
+		log.Println("Images signed by:", identity)
+	}
+	for _, label := range config.requireLabels {
+		log.Println("Required image label:", label)
+	}
+	if config.requireExplicitTag {
+		log.Println("Explicit image tags or digests required")
+	}
+	if config.restrictCommit == commitDeny {
+		log.Println("Commits denied")
+	} else if config.restrictCommit == commitAllowlist {
+		log.Println("Commits allowed to the authorized registries and images only")
+	}
+	if len(config.defaultRegistry) > 0 {
+		log.Println("Default registry:", config.defaultRegistry)
+	}
+	if *flQuietStartup == false {
+		logPolicyEntries(config)
+	}
+	log.Println("No. of authorized registries: ", len(config.registries))
+	log.Println("No. of authorized images: ", len(config.images))
+	if config.requireMirror {
+		log.Println("Images required through a mirror")
+	}
+	if config.denyInsecure {
+		log.Println("Insecure registries denied")
+	}
+	if config.denyLocal {
+		log.Println("Local registries denied, unless exact registry entries")
+	}
+	if config.denyAllTags {
+		log.Println("Pulls of all the tags denied")
+	}
+	if config.restrictDist {
+		log.Println("Registry queries restricted to the authorized registries and images")
+	}
+	for _, entry := range config.restrictSave {
+		log.Println("Confidential, cannot be saved:", entry)
+	}
+	for _, mutation := range config.denyMutations {
+		log.Println("Container mutation denied:", mutation)
+	}
+
+	return config, nil
+}
+
+// Logs the entries of the policy rule lists, one per line, unless --quiet-startup is set
+func logPolicyEntries(config pluginConfig) {
+	for _, registry := range config.registries {
+		log.Println("Authorized registry:", registry)
+	}
+	for _, image := range config.images {
+		log.Println("Authorized image:", image)
+	}
+	for _, prefix := range config.repositoryPrefixes {
+		log.Println("Authorized repository prefix:", prefix)
+	}
+	for _, registry := range config.denyRegistries {
+		log.Println("Denied registry:", registry)
+	}
+	for _, image := range config.denyImages {
+		log.Println("Denied image:", image)
+	}
+	aliases := make([]string, 0, len(config.aliases))
+	for alias := range config.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		log.Println("Registry alias:", alias, "=>", config.aliases[alias])
+	}
+	for _, window := range config.registryWindows {
+		log.Println("Registry time window:", window)
+	}
+	for _, mirror := range config.mirrorPrefixes {
+		log.Println("Mirror prefix:", mirror)
+	}
+	for _, allowed := range config.allowedOS {
+		log.Println("Allowed OS:", allowed)
+	}
+	for _, image := range config.alwaysAllow {
+		log.Println("Always allowed image:", image)
+	}
+	for _, image := range config.pseudoImages {
+		log.Println("Always allowed pseudo-image:", image)
+	}
+	for _, hostPath := range config.denyHostMounts {
+		log.Println("Denied host mount:", hostPath)
+	}
+	for _, capability := range config.denyCapabilities {
+		log.Println("Denied capability:", capability)
+	}
+}
@@ -0,0 +1,102 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Command line options locating and verifying the policy file itself, which its settings cannot set
+var unsettableOptions = map[string]bool{
+	"config":        true,
+	"config-dir":    true,
+	"policy-pubkey": true,
+	"policy-sig":    true,
+	"dump-policy":   true,
+	"replay":        true,
+	"assert-file":   true,
+}
+
+// Reads the settings of the --config policy file, verified against its signature if configured
+func readSettings() (map[string]interface{}, error) {
+	if len(*flConfigFile) == 0 {
+		return nil, nil
+	}
+	var verifier *policyVerifier
+	if len(*flPolicyPubKey) > 0 && len(*flPolicySig) > 0 {
+		var err error
+		if verifier, err = newPolicyVerifier(*flPolicyPubKey, *flPolicySig); err != nil {
+			return nil, err
+		}
+	}
+	file, err := readConfigFile(*flConfigFile, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return file.Settings, nil
+}
+
+// Sets the command line options which are not set on the command line to the settings of the policy file,
+// keyed by option name, e.g. "require-explicit-tag": true or "mirror-prefix": ["mirror.corp.net=docker.io"].
+// The options given on the command line take precedence. Returns the names of the options set.
+func applySettings(flags *flag.FlagSet, settings map[string]interface{}) ([]string, error) {
+	onCommandLine := map[string]bool{}
+	flags.Visit(func(option *flag.Flag) {
+		onCommandLine[option.Name] = true
+	})
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var applied []string
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("invalid setting %s: unknown option", name)
+		}
+		if unsettableOptions[name] {
+			return nil, fmt.Errorf("invalid setting %s: the option can only be set on the command line", name)
+		}
+		if onCommandLine[name] {
+			continue
+		}
+
+		// Lists set repeatable options once per item
+		values, isList := settings[name].([]interface{})
+		if !isList {
+			values = []interface{}{settings[name]}
+		}
+		for _, value := range values {
+			text, err := settingValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid setting %s: %v", name, err)
+			}
+			if err := flags.Set(name, text); err != nil {
+				return nil, fmt.Errorf("invalid setting %s value %s: %v", name, text, err)
+			}
+		}
+		applied = append(applied, name)
+	}
+	return applied, nil
+}
+
+// Returns a setting value as given on the command line
+func settingValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case int, int64, uint64:
+		return fmt.Sprint(value), nil
+	}
+	return "", fmt.Errorf("expected a string, boolean, number or list of them, got %s", jsonTypeName(value))
+}
@@ -0,0 +1,103 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Returned when the policy file does not match its detached signature
+var errInvalidPolicySignature = errors.New("invalid policy file signature")
+
+// Verifies the detached signature of the policy file with the configured public key
+type policyVerifier struct {
+	// Public key (Ed25519, ECDSA or RSA)
+	key crypto.PublicKey
+	// Path of the detached signature
+	sigPath string
+}
+
+// Create a new policy verifier from a PEM encoded public key file and a detached signature file
+func newPolicyVerifier(keyPath string, sigPath string) (*policyVerifier, error) {
+	key, err := readPublicKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy public key %s: %v", keyPath, err)
+	}
+	return &policyVerifier{key: key, sigPath: sigPath}, nil
+}
+
+// Reads a PEM encoded public key file. Ed25519, ECDSA and RSA keys are supported.
+func readPublicKey(keyPath string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// Reads the detached signature, either binary or base64 encoded
+func (verifier *policyVerifier) readSignature() ([]byte, error) {
+	sig, err := ioutil.ReadFile(verifier.sigPath)
+	if err != nil {
+		return nil, err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err == nil {
+		return decoded, nil
+	}
+	return sig, nil
+}
+
+// Verifies the policy file data against the detached signature.
+// Ed25519 signatures are over the data itself, ECDSA (ASN.1) and RSA (PKCS #1 v1.5) signatures
+// are over its SHA-256 digest, as created by e.g. openssl dgst -sha256 -sign.
+func (verifier *policyVerifier) verify(data []byte) error {
+	sig, err := verifier.readSignature()
+	if err != nil {
+		return fmt.Errorf("cannot read policy file signature: %v", err)
+	}
+	if !verifySignature(verifier.key, data, sig) {
+		return errInvalidPolicySignature
+	}
+	return nil
+}
+
+// Returns true if the signature of the data is valid for the public key.
+// Ed25519 signatures are over the data itself, ECDSA (ASN.1) and RSA (PKCS #1 v1.5) signatures
+// are over its SHA-256 digest.
+func verifySignature(key crypto.PublicKey, data []byte, sig []byte) bool {
+	digest := sha256.Sum256(data)
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
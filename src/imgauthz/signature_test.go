@@ -0,0 +1,119 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns the public key of a new key pair of the given type, and the function signing as the policy signatures
+func newSigningKey(t *testing.T, keyType string) (crypto.PublicKey, func(data []byte) []byte) {
+	t.Helper()
+	digest := func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+	switch keyType {
+	case "ed25519":
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return public, func(data []byte) []byte { return ed25519.Sign(private, data) }
+	case "ecdsa":
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &private.PublicKey, func(data []byte) []byte {
+			sig, _ := ecdsa.SignASN1(rand.Reader, private, digest(data))
+			return sig
+		}
+	default:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &private.PublicKey, func(data []byte) []byte {
+			sig, _ := rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest(data))
+			return sig
+		}
+	}
+}
+
+func TestPolicyFileSignatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-authz-signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "policy.pem")
+	policyPath := filepath.Join(dir, "policy.json")
+	sigPath := filepath.Join(dir, "policy.json.sig")
+	policy := []byte(`{"registries": ["my.docker.registry"]}`)
+	if err := ioutil.WriteFile(policyPath, policy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyType := range []string{"ed25519", "ecdsa", "rsa"} {
+		public, sign := newSigningKey(t, keyType)
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		verifier, err := newPolicyVerifier(keyPath, sigPath)
+		if err != nil {
+			t.Fatalf("%s key: %v", keyType, err)
+		}
+
+		for _, test := range []struct {
+			name  string
+			sig   []byte
+			valid bool
+		}{
+			{"binary signature", sign(policy), true},
+			{"base64 signature", []byte(base64.StdEncoding.EncodeToString(sign(policy)) + "\n"), true},
+			{"signature of other data", sign([]byte(`{"registries": ["*"]}`)), false},
+			{"missing signature", nil, false},
+		} {
+			os.Remove(sigPath)
+			if test.sig != nil {
+				if err := ioutil.WriteFile(sigPath, test.sig, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file, err := readConfigFile(policyPath, verifier)
+			if test.valid && (err != nil || len(file.Registries) != 1) {
+				t.Errorf("%s key, %s: %v", keyType, test.name, err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("%s key, %s: policy file accepted", keyType, test.name)
+			}
+		}
+	}
+
+	// Keys which are not PEM encoded public keys are refused
+	if err := ioutil.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newPolicyVerifier(keyPath, sigPath); err == nil {
+		t.Error("invalid public key accepted")
+	}
+}
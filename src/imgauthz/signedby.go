@@ -0,0 +1,188 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"strings"
+)
+
+const (
+	// Media type of the signature layers, as attached by cosign sign
+	mediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// Annotation of a signature layer holding its base64 encoded signature
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// Type of the simple signing payloads of the image signatures
+	cosignSignatureType = "cosign container image signature"
+)
+
+// Returned when an image has no signature at all
+var errNoSignature = errors.New("no signature")
+
+// Verifies the signatures of the images
+type signatureVerifier interface {
+	// Returns the name of the trusted identity which signed the image,
+	// or an error if no trusted identity signed it
+	verifyImageSignature(ref imageReference) (string, error)
+}
+
+// Identity trusted to sign images, by its public key
+type signingIdentity struct {
+	name string
+	// Public key (Ed25519, ECDSA or RSA)
+	key crypto.PublicKey
+}
+
+// Verifies the signatures attached to the images on their registry, as attached by cosign sign --key:
+// simple signing payloads stored as layers of the sha256-<digest>.sig tag of the image repository,
+// signed with the key of a trusted identity.
+type cosignVerifier struct {
+	// Registry client
+	registry *registryClient
+	// Trusted identities
+	identities []signingIdentity
+}
+
+// Simple signing payload of an image signature
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// Create a new signature verifier, with the trusted identities given as [<name>=]<PEM public key file>,
+// named after their file if unnamed
+func newCosignVerifier(specs []string, registry *registryClient) (*cosignVerifier, error) {
+	verifier := &cosignVerifier{registry: registry}
+	for _, spec := range specs {
+		name, keyPath := spec, spec
+		if parts := strings.SplitN(spec, "=", 2); len(parts) == 2 {
+			name, keyPath = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		}
+		if len(name) == 0 || len(keyPath) == 0 {
+			return nil, fmt.Errorf("invalid --signed-by value: %s (expected [<name>=]<public key file>)", spec)
+		}
+		key, err := readPublicKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --signed-by public key %s: %v", keyPath, err)
+		}
+		verifier.identities = append(verifier.identities, signingIdentity{name: name, key: key})
+	}
+	return verifier, nil
+}
+
+// Returns the name of the trusted identity of the first valid signature of the image
+func (verifier *cosignVerifier) verifyImageSignature(ref imageReference) (string, error) {
+	host, repository := ref.registryHost()
+
+	// Signatures are attached to the digest of the manifest (list) the reference resolves to
+	digest, err := verifier.registry.resolveDigest(ref)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("unsupported image digest %s", digest)
+	}
+
+	data, _, err := verifier.registry.fetchRaw(attachedManifestURL(host, repository, digest, "sig"), manifestMediaTypes())
+	if err != nil {
+		return "", errNoSignature
+	}
+	var signatures imageManifest
+	if err := json.Unmarshal(data, &signatures); err != nil {
+		return "", fmt.Errorf("decoding the signatures of %s: %v", digest, err)
+	}
+
+	err = errNoSignature
+	for _, layer := range signatures.Layers {
+		if layer.MediaType != mediaTypeSimpleSigning || len(layer.Annotations[cosignSignatureAnnotation]) == 0 {
+			continue
+		}
+		payload, _, fetchErr := verifier.registry.fetchRaw(registryBlobURL(host, repository, layer.Digest), mediaTypeSimpleSigning)
+		if fetchErr != nil {
+			err = fetchErr
+			continue
+		}
+		var identity string
+		if identity, err = verifier.verifyPayload(payload, layer.Annotations[cosignSignatureAnnotation], digest); err == nil {
+			return identity, nil
+		}
+	}
+	return "", err
+}
+
+// Verifies a simple signing payload: its signature by a trusted identity, and its image digest.
+// Returns the name of the identity.
+func (verifier *cosignVerifier) verifyPayload(payload []byte, signature string, digest string) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", fmt.Errorf("decoding the signature: %v", err)
+	}
+	identity := ""
+	for _, trusted := range verifier.identities {
+		if verifySignature(trusted.key, payload, sig) {
+			identity = trusted.name
+			break
+		}
+	}
+	if len(identity) == 0 {
+		return "", errors.New("not signed by a trusted identity")
+	}
+
+	var signed simpleSigningPayload
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return "", fmt.Errorf("decoding the signature payload: %v", err)
+	}
+	if signed.Critical.Type != cosignSignatureType {
+		return "", fmt.Errorf("signature payload is not an image signature: %s", signed.Critical.Type)
+	}
+	if signed.Critical.Image.DockerManifestDigest != digest {
+		return "", errors.New("signature of another image")
+	}
+	return identity, nil
+}
+
+// Built-in matcher allowing the images signed by a trusted identity, regardless of their registry and name.
+// Consulted after the allowlist matcher, so that the deny-lists and the other explicit rules still deny.
+type signedByMatcher struct {
+	plugin *ImgAuthZPlugin
+}
+
+// Returns the name of the signed-by matcher
+func (matcher *signedByMatcher) Name() string {
+	return "signed-by"
+}
+
+// Allows the image if a trusted identity signed it, and abstains otherwise, including when
+// the signatures cannot be verified. Committed images are not on any registry yet.
+func (matcher *signedByMatcher) Match(request MatchRequest) MatchResult {
+	if request.Command == commitCommand {
+		return MatchResult{Verdict: Abstain}
+	}
+
+	plugin := matcher.plugin
+	signed := false
+	response := plugin.limitedCheck(request.reqURL, request.request, func() authorization.Response {
+		identity, err := plugin.signatures.verifyImageSignature(request.request.image)
+		if err != nil {
+			plugin.debugln(request.request, "[SIGNATURE] Not signed by a trusted identity:", request.request.image.String(), err)
+			return authorization.Response{Allow: true}
+		}
+		signed = true
+		request.request.logln("[ALLOWED] Signed by:", identity, request.request.image.String(), request.req.RequestMethod, request.reqURL.String())
+		return authorization.Response{Allow: true}
+	})
+	if !response.Allow || signed {
+		return decided(response)
+	}
+	return MatchResult{Verdict: Abstain}
+}
@@ -0,0 +1,102 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+// Signature verifier of the trusted identities which signed the images, by image name
+type staticSignatures map[string]string
+
+func (signatures staticSignatures) verifyImageSignature(ref imageReference) (string, error) {
+	if identity, ok := signatures[ref.name()]; ok {
+		return identity, nil
+	}
+	return "", errNoSignature
+}
+
+func TestSignedByAllowsTheImagesOfAnyRegistry(t *testing.T) {
+	signatures := staticSignatures{"quay.io/team/app": "release", "evil.io/bad": "release", "docker.io/library/busybox": "release"}
+	for _, test := range []struct {
+		config  pluginConfig
+		image   string
+		allowed bool
+	}{
+		// Signed images are allowed on any registry, even without any authorized registry
+		{pluginConfig{registries: []string{"docker.io"}}, "quay.io/team/app:1.0", true},
+		{pluginConfig{}, "quay.io/team/app:1.0", true},
+		{pluginConfig{registries: []string{"docker.io"}}, "quay.io/team/other:1.0", false},
+		{pluginConfig{}, "quay.io/team/other:1.0", false},
+		// The allowlist still allows the unsigned images
+		{pluginConfig{registries: []string{"docker.io"}}, "alpine:3.19", true},
+		// The deny-lists still deny the signed images
+		{pluginConfig{registries: []string{"docker.io"}, denyImages: []string{"evil.io/bad"}}, "evil.io/bad:1.0", false},
+		{pluginConfig{registries: []string{"docker.io"}, denyImages: []string{"docker.io/library/busybox"}}, "busybox:1.36", false},
+	} {
+		config := test.config
+		if err := mergeRuleSources(&config, nil); err != nil {
+			t.Fatal(err)
+		}
+		plugin, err := newPlugin(nil, newPluginMetrics("", ""), newPluginStatus(0), nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plugin.signatures = signatures
+		plugin.matchers = plugin.matcherChain()
+		policy := &Policy{plugin: plugin}
+		if response := policy.AuthorizePull(test.image); response.Allow != test.allowed {
+			t.Errorf("pull of %s with %+v: allowed %v (%s)", test.image, test.config, response.Allow, response.Msg)
+		}
+	}
+}
+
+func TestSignaturePayloadsAreVerified(t *testing.T) {
+	trusted, release, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &cosignVerifier{identities: []signingIdentity{{name: "release", key: trusted}}}
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"quay.io/team/app"},"image":{"docker-manifest-digest":"sha256:ab"},"type":"cosign container image signature"},"optional":null}`)
+	other := []byte(`{"critical":{"identity":{"docker-reference":"quay.io/team/app"},"image":{"docker-manifest-digest":"sha256:ab"},"type":"attestation"},"optional":null}`)
+	sign := func(key ed25519.PrivateKey, payload []byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	}
+
+	for _, test := range []struct {
+		name      string
+		payload   []byte
+		signature string
+		digest    string
+		valid     bool
+	}{
+		{"trusted signature", payload, sign(release, payload), "sha256:ab", true},
+		{"signature of another image", payload, sign(release, payload), "sha256:cd", false},
+		{"untrusted signature", payload, sign(untrusted, payload), "sha256:ab", false},
+		{"signature of another payload", payload, sign(release, other), "sha256:ab", false},
+		{"not an image signature", other, sign(release, other), "sha256:ab", false},
+		{"invalid signature encoding", payload, "not base64!", "sha256:ab", false},
+	} {
+		identity, err := verifier.verifyPayload(test.payload, test.signature, test.digest)
+		if test.valid && (err != nil || identity != "release") {
+			t.Errorf("%s: identity %q (%v)", test.name, identity, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: verified", test.name)
+		}
+	}
+
+	for _, spec := range []string{"=release.pem", "release=/nonexistent/release.pem"} {
+		if _, err := newCosignVerifier([]string{spec}, nil); err == nil {
+			t.Errorf("trusted identity %s accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Source of policy rules: the defaults, the command line or a policy file
+type ruleSource struct {
+	// Name of the source, e.g. command line or the path of the policy file
+	name  string
+	rules *configFile
+}
+
+// List of policy rules of the configuration, along with the entries of a source
+type ruleList struct {
+	// Key of the list, as in the policy file
+	key     string
+	merged  *[]string
+	entries []string
+}
+
+// Returns the lists of policy rules of the configuration, along with the entries of the source rules
+func ruleLists(config *pluginConfig, rules *configFile) []ruleList {
+	return []ruleList{
+		{"registries", &config.registries, rules.Registries},
+		{"images", &config.images, rules.Images},
+		{"repositoryPrefixes", &config.repositoryPrefixes, rules.RepositoryPrefixes},
+		{"deniedRegistries", &config.denyRegistries, rules.DeniedRegistries},
+		{"deniedImages", &config.denyImages, rules.DeniedImages},
+		{"registryWindows", &config.registryWindows, rules.RegistryWindows},
+		{"alwaysAllow", &config.alwaysAllow, rules.AlwaysAllow},
+		{"deniedHostMounts", &config.denyHostMounts, rules.DeniedHostMounts},
+		{"deniedCapabilities", &config.denyCapabilities, rules.DeniedCapabilities},
+		{"registryAliases", &config.registryAliases, rules.RegistryAliases}}
+}
+
+// Merges the policy rules of the sources into the lists of the configuration, in order of increasing
+// precedence: the defaults, the command line, the --config policy file, then the policy files of
+// --config-dir in name order. Each source adds its entries to the lists of the earlier sources, or
+// replaces the lists it has entries for if it overrides them (see configFile.Override). Once merged,
+// the registry aliases are resolved, the legacy entries are migrated, and the entries are canonicalized if
+// enabled, with the official images listed by their bare name resolved either way, see
+// pluginConfig.canonicalizeEntries. The contribution of each source is logged, and the
+// sources of the merged entries are recorded, see pluginConfig.entrySources.
+// This is the only place where the policy rules are merged.
+func mergeRuleSources(config *pluginConfig, sources []ruleSource) error {
+	config.ruleSources = make(map[string][]string)
+	for _, source := range sources {
+		var contributed []string
+		for _, list := range ruleLists(config, source.rules) {
+			if len(list.entries) == 0 {
+				continue
+			}
+			if source.rules.Override {
+				*list.merged = nil
+				config.ruleSources[list.key] = nil
+				contributed = append(contributed, fmt.Sprintf("%s=%d (override)", list.key, len(list.entries)))
+			} else {
+				contributed = append(contributed, fmt.Sprintf("%s=%d", list.key, len(list.entries)))
+			}
+			*list.merged = append(*list.merged, list.entries...)
+			for range list.entries {
+				config.ruleSources[list.key] = append(config.ruleSources[list.key], source.name)
+			}
+		}
+
+		if len(contributed) == 0 {
+			log.Println("Policy source:", source.name, "- no rules")
+		} else {
+			log.Println("Policy source:", source.name, "-", strings.Join(contributed, " "))
+		}
+	}
+
+	// Entries are resolved and migrated in place, so that they keep their sources
+	if err := config.resolveRegistryAliases(); err != nil {
+		return err
+	}
+	config.migrateLegacyEntries()
+	return config.canonicalizeEntries()
+}
+
+// Returns the sources of the merged policy rules, per list and entry,
+// e.g. {"registries": {"docker.io": ["command line", "/etc/img-authz/policy.json"]}}
+func (config pluginConfig) entrySources() map[string]map[string][]string {
+	sources := make(map[string]map[string][]string)
+	for _, list := range ruleLists(&config, &configFile{}) {
+		names := config.ruleSources[list.key]
+		for i, entry := range *list.merged {
+			if i >= len(names) {
+				break
+			}
+			if sources[list.key] == nil {
+				sources[list.key] = make(map[string][]string)
+			}
+			if !containsString(sources[list.key][entry], names[i]) {
+				sources[list.key][entry] = append(sources[list.key][entry], names[i])
+			}
+		}
+	}
+	return sources
+}
+
+// Returns true if the list contains the value
+func containsString(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
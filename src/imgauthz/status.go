@@ -0,0 +1,155 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authorization decision of a registry command, as reported on /status
+type decisionRecord struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Command string    `json:"command"`
+	Image   string    `json:"image"`
+	// Altered container of the container mutations
+	Container string `json:"container,omitempty"`
+	Allowed   bool   `json:"allowed"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Plugin activity since start, shared by the reloaded plugins.
+// The last decisions are kept in a bounded ring buffer.
+type pluginStatus struct {
+	sync.Mutex
+	// Time the plugin was started
+	started time.Time
+	// Total number of allowed and denied registry commands
+	allowed int64
+	denied  int64
+	// Ring buffer of the last decisions, next is the index of the oldest one once the buffer is full
+	decisions []decisionRecord
+	next      int
+	// Notifiers of the decisions, e.g. the audit log
+	notifiers []Notifier
+}
+
+// Create a new plugin status keeping the given number of last decisions
+func newPluginStatus(size int) *pluginStatus {
+	if size < 0 {
+		size = 0
+	}
+	return &pluginStatus{started: time.Now(), decisions: make([]decisionRecord, 0, size)}
+}
+
+// Records the decision of a registry command, and notifies it to the notifiers, in order.
+// The notifiers are invoked once the status is unlocked, so that a slow notifier does not hold up
+// the decisions of the other commands, nor /status.
+func (status *pluginStatus) record(decision decisionRecord) {
+	status.Lock()
+	notifiers := append([]Notifier(nil), status.notifiers...)
+	event := decision.event()
+	status.add(decision)
+	status.Unlock()
+
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(event); err != nil {
+			log.Println("[NOTIFY]", notifier.Name(), "failed:", decision.ID, err)
+		}
+	}
+}
+
+// Counts the decision and adds it to the last decisions, with the status locked
+func (status *pluginStatus) add(decision decisionRecord) {
+	if decision.Allowed {
+		status.allowed++
+	} else {
+		status.denied++
+	}
+
+	switch {
+	case cap(status.decisions) == 0:
+	case len(status.decisions) < cap(status.decisions):
+		status.decisions = append(status.decisions, decision)
+	default:
+		status.decisions[status.next] = decision
+		status.next = (status.next + 1) % len(status.decisions)
+	}
+}
+
+// Status report, as served on /status
+type statusReport struct {
+	Uptime        string           `json:"uptime"`
+	UptimeSeconds int64            `json:"uptimeSeconds"`
+	Rules         map[string]int   `json:"rules"`
+	Allowed       int64            `json:"allowed"`
+	Denied        int64            `json:"denied"`
+	Decisions     []decisionRecord `json:"decisions"`
+}
+
+// Returns the total number of allowed and denied registry commands
+func (status *pluginStatus) totals() (int64, int64) {
+	status.Lock()
+	defer status.Unlock()
+	return status.allowed, status.denied
+}
+
+// Returns the status report, with the last decisions from the oldest to the most recent one
+func (status *pluginStatus) report(plugin *ImgAuthZPlugin) statusReport {
+	status.Lock()
+	defer status.Unlock()
+
+	uptime := time.Since(status.started)
+	report := statusReport{
+		Uptime:        uptime.String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		Rules:         plugin.pluginConfig.ruleCounts(),
+		Allowed:       status.allowed,
+		Denied:        status.denied,
+		Decisions:     make([]decisionRecord, 0, len(status.decisions))}
+	report.Decisions = append(report.Decisions, status.decisions[status.next:]...)
+	report.Decisions = append(report.Decisions, status.decisions[:status.next]...)
+	return report
+}
+
+// Serves the status report of the current plugin on /status, to the holders of the admin token only
+type statusHandler struct {
+	status     *pluginStatus
+	reloadable *reloadablePlugin
+	adminToken string
+}
+
+// Returns true if the request bears the admin token, otherwise responds with 401 Unauthorized.
+// No request is authorized without an admin token.
+func authorizedAdmin(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(adminToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// Serves the status report as JSON
+func (handler *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizedAdmin(w, r, handler.adminToken) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handler.status.report(handler.reloadable.plugin()))
+}
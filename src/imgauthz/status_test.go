@@ -0,0 +1,125 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Notifier blocking on its first decision event until released
+type blockingNotifier struct {
+	blocked  chan struct{}
+	released chan struct{}
+}
+
+// Returns the name of the notifier
+func (notifier *blockingNotifier) Name() string {
+	return "blocking"
+}
+
+// Blocks on the first decision event until released
+func (notifier *blockingNotifier) Notify(event DecisionEvent) error {
+	if event.ID == "1" {
+		close(notifier.blocked)
+		<-notifier.released
+	}
+	return nil
+}
+
+func TestSlowNotifiersDoNotHoldUpTheStatus(t *testing.T) {
+	notifier := &blockingNotifier{blocked: make(chan struct{}), released: make(chan struct{})}
+	status := newPluginStatus(10)
+	status.notifiers = []Notifier{notifier}
+
+	go status.record(decisionRecord{ID: "1", Allowed: true})
+	<-notifier.blocked
+	defer close(notifier.released)
+
+	recorded := make(chan struct{})
+	go func() {
+		status.record(decisionRecord{ID: "2"})
+		status.totals()
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("decision held up by a slow notifier")
+	}
+	if allowed, denied := status.totals(); allowed != 1 || denied != 1 {
+		t.Errorf("%d allowed and %d denied decisions, expected 1 and 1", allowed, denied)
+	}
+}
+
+func TestStatusReportsTheLastDecisions(t *testing.T) {
+	config := pluginConfig{registries: []string{"docker.io"}}
+	if err := mergeRuleSources(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+	status := newPluginStatus(2)
+	plugin, err := newPlugin(nil, newPluginMetrics("", ""), status, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{plugin: plugin}
+	for _, image := range []string{"alpine:3.19", "evil.io/app:1.0", "busybox:1.36"} {
+		request := pullRequest(image)
+		request.User = "alice"
+		policy.Authorize(request)
+	}
+	handler := &statusHandler{status: status, reloadable: &reloadablePlugin{current: plugin}, adminToken: "s3cr3t"}
+
+	for _, test := range []struct {
+		method        string
+		authorization string
+		code          int
+	}{
+		{"GET", "", http.StatusUnauthorized},
+		{"GET", "Bearer wrong", http.StatusUnauthorized},
+		{"POST", "Bearer s3cr3t", http.StatusMethodNotAllowed},
+		{"GET", "Bearer s3cr3t", http.StatusOK},
+	} {
+		request := httptest.NewRequest(test.method, "/status", nil)
+		if len(test.authorization) > 0 {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.code {
+			t.Errorf("%s /status with %q: %d, expected %d", test.method, test.authorization, recorder.Code, test.code)
+		}
+		if recorder.Code != http.StatusOK {
+			continue
+		}
+
+		// The oldest decision is out of the ring buffer, but still counted
+		var report statusReport
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Allowed != 2 || report.Denied != 1 || report.Rules["registries"] != 1 {
+			t.Errorf("%d allowed, %d denied, %d registries", report.Allowed, report.Denied, report.Rules["registries"])
+		}
+		for i, expected := range []decisionRecord{
+			{User: "alice", Command: pullCommand, Image: "evil.io/app:1.0", Allowed: false},
+			{User: "alice", Command: pullCommand, Image: "docker.io/library/busybox:1.36", Allowed: true},
+		} {
+			if i >= len(report.Decisions) {
+				t.Fatalf("%d decisions, expected 2", len(report.Decisions))
+			}
+			decision := report.Decisions[i]
+			if decision.User != expected.User || decision.Command != expected.Command || decision.Image != expected.Image || decision.Allowed != expected.Allowed || len(decision.ID) == 0 {
+				t.Errorf("decision %d: %+v, expected %+v", i, decision, expected)
+			}
+		}
+		if reason := report.Decisions[0].Reason; !strings.Contains(reason, "cannot pull image evil.io/app") {
+			t.Errorf("denial reason: %s", reason)
+		}
+	}
+}
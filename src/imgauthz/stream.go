@@ -0,0 +1,275 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Maximum number of decision events published to a sink at once
+	streamBatchSize = 100
+	// Interval at which the dropped decision events are logged, if any
+	streamDropLogInterval = 10 * time.Second
+	// Timeout for connecting and publishing to a sink
+	streamTimeout = 5 * time.Second
+	// Default subject of the decision events published to NATS
+	defaultNATSSubject = "img-authz.decisions"
+)
+
+// Destination of the decision stream, e.g. a message broker topic
+type StreamSink interface {
+	// Returns the name of the sink, logged and reported in the metrics along with its events
+	Name() string
+	// Publishes a batch of decision events, in order. It is called from a single goroutine,
+	// and the events of a failed batch are not published again.
+	Publish(events []DecisionEvent) error
+}
+
+// Creates a stream sink from its URL, e.g. kafka://broker:9092/topic
+type StreamSinkFactory func(sinkURL *url.URL) (StreamSink, error)
+
+// Stream sink factories by URL scheme, in addition to the built-in nats, http and https sinks
+var registeredStreamSinks = make(map[string]StreamSinkFactory)
+
+// Registers a stream sink compiled into the plugin for the URL scheme, from the init function of
+// its source file, e.g. to stream the decisions to Kafka:
+//
+//	func init() {
+//		RegisterStreamSink("kafka", newKafkaSink)
+//	}
+func RegisterStreamSink(scheme string, factory StreamSinkFactory) {
+	registeredStreamSinks[scheme] = factory
+}
+
+// Returns the stream sink of the URL: nats://[user:password@]host[:port][/subject],
+// http(s)://... or a registered sink
+func newStreamSink(sinkURL string) (StreamSink, error) {
+	parsed, err := url.Parse(sinkURL)
+	if err != nil || len(parsed.Host) == 0 {
+		return nil, fmt.Errorf("invalid --decision-stream URL: %s (expected e.g. nats://nats.example:4222/img-authz.decisions)", sinkURL)
+	}
+	if factory, ok := registeredStreamSinks[parsed.Scheme]; ok {
+		return factory(parsed)
+	}
+	switch parsed.Scheme {
+	case "nats":
+		return newNATSSink(parsed), nil
+	case "http", "https":
+		return &httpStreamSink{url: parsed.String(), client: &http.Client{Timeout: streamTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unsupported --decision-stream scheme: %s (expected nats, http, https or a registered sink)", parsed.Scheme)
+}
+
+// Notifier publishing the decision events to a stream sink asynchronously, in batches.
+// Notifying never blocks: the events are dropped when the buffer is full, i.e. when the sink cannot
+// keep up, and the drops are counted and logged periodically.
+type decisionStream struct {
+	sink    StreamSink
+	metrics *pluginMetrics
+	queue   chan DecisionEvent
+	// Number of events dropped since the last drop log line
+	dropped int64
+}
+
+// Create a new decision stream to the sink, buffering up to the given number of events
+func newDecisionStream(sink StreamSink, buffer int, metrics *pluginMetrics) *decisionStream {
+	stream := &decisionStream{sink: sink, metrics: metrics, queue: make(chan DecisionEvent, buffer)}
+	go stream.publish()
+	go stream.logDrops()
+	return stream
+}
+
+// Returns the name of the notifier
+func (stream *decisionStream) Name() string {
+	return "stream:" + stream.sink.Name()
+}
+
+// Queues the decision event to be published, or drops it if the buffer is full
+func (stream *decisionStream) Notify(event DecisionEvent) error {
+	select {
+	case stream.queue <- event:
+	default:
+		atomic.AddInt64(&stream.dropped, 1)
+		stream.metrics.streamed(stream.sink.Name(), streamDropped, 1)
+	}
+	return nil
+}
+
+// Publishes the queued events, in batches of the events queued meanwhile
+func (stream *decisionStream) publish() {
+	batch := make([]DecisionEvent, 0, streamBatchSize)
+	for event := range stream.queue {
+		batch = append(batch[:0], event)
+	collect:
+		for len(batch) < streamBatchSize {
+			select {
+			case event := <-stream.queue:
+				batch = append(batch, event)
+			default:
+				break collect
+			}
+		}
+
+		if err := stream.sink.Publish(batch); err != nil {
+			log.Println("[STREAM]", stream.sink.Name(), "failed:", len(batch), "decision events lost:", err)
+			stream.metrics.streamed(stream.sink.Name(), streamFailed, len(batch))
+			continue
+		}
+		stream.metrics.streamed(stream.sink.Name(), streamPublished, len(batch))
+	}
+}
+
+// Logs the number of dropped events periodically, rather than every drop, if any were dropped
+func (stream *decisionStream) logDrops() {
+	for range time.Tick(streamDropLogInterval) {
+		if dropped := atomic.SwapInt64(&stream.dropped, 0); dropped > 0 {
+			log.Println("[STREAM]", stream.sink.Name(), "cannot keep up:", dropped, "decision events dropped")
+		}
+	}
+}
+
+// Sink posting the batches of decision events to an HTTP endpoint, as JSON lines
+type httpStreamSink struct {
+	url    string
+	client *http.Client
+}
+
+// Returns the name of the sink
+func (sink *httpStreamSink) Name() string {
+	return "http"
+}
+
+// Posts the batch of decision events as JSON lines (application/x-ndjson)
+func (sink *httpStreamSink) Publish(events []DecisionEvent) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	response, err := sink.client.Post(sink.url, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("posting to %s: %s", sink.url, response.Status)
+	}
+	return nil
+}
+
+// Sink publishing the decision events to a NATS subject, one message per event, with the NATS
+// text protocol. The connection is established on the first batch, and again after a failure.
+type natsSink struct {
+	sync.Mutex
+	address  string
+	subject  string
+	user     string
+	password string
+	conn     net.Conn
+}
+
+// Create a new NATS sink, publishing to the subject of the URL path
+func newNATSSink(sinkURL *url.URL) *natsSink {
+	sink := &natsSink{address: sinkURL.Host, subject: strings.Trim(sinkURL.Path, "/")}
+	if len(sinkURL.Port()) == 0 {
+		sink.address = net.JoinHostPort(sinkURL.Hostname(), "4222")
+	}
+	if len(sink.subject) == 0 {
+		sink.subject = defaultNATSSubject
+	}
+	if sinkURL.User != nil {
+		sink.user = sinkURL.User.Username()
+		sink.password, _ = sinkURL.User.Password()
+	}
+	return sink
+}
+
+// Returns the name of the sink
+func (sink *natsSink) Name() string {
+	return "nats"
+}
+
+// Publishes the decision events to the subject
+func (sink *natsSink) Publish(events []DecisionEvent) error {
+	sink.Lock()
+	defer sink.Unlock()
+	if sink.conn == nil {
+		if err := sink.connect(); err != nil {
+			return err
+		}
+	}
+
+	var messages bytes.Buffer
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&messages, "PUB %s %d\r\n%s\r\n", sink.subject, len(data), data)
+	}
+	sink.conn.SetWriteDeadline(time.Now().Add(streamTimeout))
+	if _, err := sink.conn.Write(messages.Bytes()); err != nil {
+		sink.conn.Close()
+		sink.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Connects to the NATS server: reads its INFO, sends the CONNECT options, and answers its PINGs
+func (sink *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", sink.address, streamTimeout)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(streamTimeout))
+	if info, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS server greeting: %q %v", info, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	options, _ := json.Marshal(map[string]interface{}{"verbose": false, "pedantic": false, "name": "img-authz-plugin",
+		"user": sink.user, "pass": sink.password})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", options); err != nil {
+		conn.Close()
+		return err
+	}
+	sink.conn = conn
+	go sink.serve(conn, reader)
+	return nil
+}
+
+// Answers the PINGs of the server and logs its errors, until the connection is closed
+func (sink *natsSink) serve(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			sink.Lock()
+			fmt.Fprint(conn, "PONG\r\n")
+			sink.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Println("[STREAM] nats error:", strings.TrimSpace(line))
+		}
+	}
+}
@@ -1,7 +1,7 @@
 // Docker Image Authorization Plugin.
 // Allows docker images to be fetched from a list of authorized registries only.
 // AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
-package main
+package imgauthz
 
 import "fmt"
 
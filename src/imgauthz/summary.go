@@ -0,0 +1,130 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Modes of the periodic decision summaries: the counts of the last interval, or since start
+const (
+	summaryReset      = "reset"
+	summaryAccumulate = "accumulate"
+)
+
+// Decision counts of a registry, over all the registry commands
+type registrySummary struct {
+	Registry string `json:"registry"`
+	Allowed  int64  `json:"allowed"`
+	Denied   int64  `json:"denied"`
+}
+
+// Rollup of the decision counts per registry, since the last summary or since start
+type decisionSummary struct {
+	Time       string            `json:"time"`
+	Since      string            `json:"since"`
+	Registries []registrySummary `json:"registries"`
+}
+
+// Returns the allowed and denied counts per registry label since start
+func (metrics *pluginMetrics) registryDecisions() map[string]registrySummary {
+	metrics.Lock()
+	defer metrics.Unlock()
+	counts := make(map[string]registrySummary)
+	for label, count := range metrics.decisions {
+		summary := counts[label.registry]
+		summary.Registry = label.registry
+		if label.decision == "allowed" {
+			summary.Allowed += count
+		} else {
+			summary.Denied += count
+		}
+		counts[label.registry] = summary
+	}
+	return counts
+}
+
+// Summarizes the decision counters of the metrics periodically, as a lightweight alternative to
+// scraping the metrics. The counters are never reset: the summaries of the reset mode are the
+// differences with the counts of the previous summary.
+type decisionSummarizer struct {
+	metrics    *pluginMetrics
+	accumulate bool
+	// File the summaries are appended to as JSON lines (logged if empty)
+	path     string
+	since    time.Time
+	previous map[string]registrySummary
+}
+
+// Create a new decision summarizer of the metrics, in the reset or accumulate mode
+func newDecisionSummarizer(metrics *pluginMetrics, mode string, path string) *decisionSummarizer {
+	return &decisionSummarizer{metrics: metrics, accumulate: mode == summaryAccumulate, path: path,
+		since: time.Now(), previous: make(map[string]registrySummary)}
+}
+
+// Returns the summary of the decisions up to now, sorted by registry. The reset mode omits the registries
+// without any decision since the last summary.
+func (summarizer *decisionSummarizer) summarize(now time.Time) decisionSummary {
+	counts := summarizer.metrics.registryDecisions()
+	summary := decisionSummary{Time: now.UTC().Format(time.RFC3339), Since: summarizer.since.UTC().Format(time.RFC3339),
+		Registries: []registrySummary{}}
+	for registry, count := range counts {
+		if !summarizer.accumulate {
+			previous := summarizer.previous[registry]
+			count.Allowed -= previous.Allowed
+			count.Denied -= previous.Denied
+			if count.Allowed == 0 && count.Denied == 0 {
+				continue
+			}
+		}
+		summary.Registries = append(summary.Registries, count)
+	}
+	sort.Slice(summary.Registries, func(i, j int) bool {
+		return summary.Registries[i].Registry < summary.Registries[j].Registry
+	})
+
+	if !summarizer.accumulate {
+		summarizer.previous = counts
+		summarizer.since = now
+	}
+	return summary
+}
+
+// Appends the summary to the summary file, or logs it
+func (summarizer *decisionSummarizer) emit(summary decisionSummary) error {
+	if len(summarizer.path) == 0 {
+		log.Println("[SUMMARY] Decisions from", summary.Since, "to", summary.Time+":", len(summary.Registries), "registries")
+		for _, registry := range summary.Registries {
+			log.Println("[SUMMARY]", registry.Registry+":", registry.Allowed, "allowed,", registry.Denied, "denied")
+		}
+		return nil
+	}
+
+	line, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(summarizer.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Emits a summary at every interval, until the plugin stops
+func (summarizer *decisionSummarizer) run(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		if err := summarizer.emit(summarizer.summarize(now)); err != nil {
+			log.Println("[SUMMARY] Cannot write the summary to", summarizer.path+":", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"strings"
+)
+
+// Syslog modes: logs to stderr only, to stderr and syslog, or to syslog only
+const (
+	syslogOff  = "off"
+	syslogAlso = "also"
+	syslogOnly = "only"
+)
+
+// Syslog facilities, by name
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// Returns the syslog facility by name
+func parseSyslogFacility(name string) (syslog.Priority, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid syslog facility: %s", name)
+	}
+	return facility, nil
+}
+
+// Returns the log output for the syslog mode, along with the log flags.
+// The syslog lines are not prefixed with the date and time, as syslog records them already.
+func newLogOutput(mode string, facilityName string, tag string) (io.Writer, int, error) {
+	if mode == syslogOff {
+		return os.Stderr, log.LstdFlags, nil
+	}
+	if mode != syslogAlso && mode != syslogOnly {
+		return nil, 0, fmt.Errorf("invalid --syslog value: %s (expected off, also or only)", mode)
+	}
+
+	facility, err := parseSyslogFacility(facilityName)
+	if err != nil {
+		return nil, 0, err
+	}
+	writer, err := syslog.New(facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot connect to syslog: %v", err)
+	}
+	if mode == syslogOnly {
+		return writer, 0, nil
+	}
+	return io.MultiWriter(os.Stderr, writer), log.LstdFlags, nil
+}
@@ -0,0 +1,111 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// Resolves image references to the digest of their manifest on the registry
+type digestResolver interface {
+	// Returns the digest of the manifest (or manifest list) of the image
+	resolveDigest(ref imageReference) (string, error)
+}
+
+// Digests first seen per tag (registry/repository:tag), in memory and persisted to the pins file if any
+type tagPins struct {
+	sync.Mutex
+	// File the pins are loaded from and saved to (in memory only if empty)
+	path    string
+	digests map[string]string
+}
+
+// Create new tag pins, loaded from the pins file if it exists
+func newTagPins(path string) (*tagPins, error) {
+	pins := &tagPins{path: path, digests: make(map[string]string)}
+	if len(path) == 0 {
+		return pins, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the tag pins file: %v", err)
+	}
+	if err := json.Unmarshal(data, &pins.digests); err != nil {
+		return nil, fmt.Errorf("parsing the tag pins file %s: %v", path, err)
+	}
+	if pins.digests == nil {
+		pins.digests = make(map[string]string)
+	}
+	return pins, nil
+}
+
+// Pins the tag to the digest, unless it is already pinned. Returns the digest the tag is pinned to.
+// New pins are saved to the pins file, and are not kept if they cannot be saved.
+func (pins *tagPins) pin(tag string, digest string) (string, error) {
+	pins.Lock()
+	defer pins.Unlock()
+
+	if pinned, ok := pins.digests[tag]; ok {
+		return pinned, nil
+	}
+	pins.digests[tag] = digest
+	if len(pins.path) == 0 {
+		return digest, nil
+	}
+
+	data, err := json.MarshalIndent(pins.digests, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(pins.path, append(data, '\n'))
+	}
+	if err != nil {
+		delete(pins.digests, tag)
+		return "", fmt.Errorf("saving the tag pins file: %v", err)
+	}
+	return digest, nil
+}
+
+// Authorizes a docker pull command against the digest first seen for the tag, if tags are pinned:
+// the first pull of a tag pins it to the digest it resolves to on the registry, and later pulls
+// of the tag resolving to a different digest are denied. Pulls by digest only are not pinned.
+func (plugin *ImgAuthZPlugin) authorizeTagPin(reqURL *url.URL, request registryRequest) authorization.Response {
+	if plugin.tagPins == nil || request.command != pullCommand || len(request.image.tag) == 0 {
+		return authorization.Response{Allow: true}
+	}
+
+	return plugin.limitedCheck(reqURL, request, func() authorization.Response {
+		digest, err := plugin.digests.resolveDigest(request.image)
+		if err != nil {
+			if response, ok := plugin.degradedResponse(request, reqURL, "tag pin check", err); ok {
+				return response
+			}
+			return plugin.errorResponse(request, reqURL, fmt.Errorf("resolving the tag digest failed: %v", err))
+		}
+
+		// A decision which timed out meanwhile must not pin the tag
+		if err := request.abandoned(); err != nil {
+			return plugin.errorResponse(request, reqURL, errDecisionTimeout)
+		}
+		tag := request.image.name() + ":" + request.image.tag
+		pinned, err := plugin.tagPins.pin(tag, digest)
+		if err != nil {
+			return plugin.errorResponse(request, reqURL, err)
+		}
+		if pinned != digest {
+			request.logln("[DENIED] Tag digest changed:", tag, digest, "pinned to", pinned, reqURL.String())
+			return authorization.Response{Allow: false, Msg: request.denialMsg("The tag " + tag + " is pinned to " + pinned + " but now resolves to " + digest)}
+		}
+		plugin.debugln(request, "[PIN] Tag digest:", tag, digest)
+		return authorization.Response{Allow: true}
+	})
+}
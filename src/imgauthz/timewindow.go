@@ -0,0 +1,135 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Abbreviated week days, as accepted in the time windows
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// Time window during which the matching registries can be used,
+// e.g. my.docker.registry,Mon-Fri,09:00-17:00,Europe/Berlin
+type timeWindow struct {
+	// Original time window specification
+	spec string
+	// Registries constrained by the time window
+	registries *patternSet
+	// Week days of the time window
+	days map[time.Weekday]bool
+	// Start and end of the time window, in minutes since midnight (end excluded)
+	start int
+	end   int
+	// Time zone of the time window
+	location *time.Location
+}
+
+// Parses a week day range like Mon-Fri (or Fri-Mon) or a single week day like Sat
+func parseWeekdays(spec string) (map[time.Weekday]bool, error) {
+	bounds := strings.SplitN(spec, "-", 2)
+	first, ok := weekdays[bounds[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid week day %q", bounds[0])
+	}
+	last := first
+	if len(bounds) == 2 {
+		if last, ok = weekdays[bounds[1]]; !ok {
+			return nil, fmt.Errorf("invalid week day %q", bounds[1])
+		}
+	}
+
+	days := make(map[time.Weekday]bool)
+	for day := first; ; day = (day + 1) % 7 {
+		days[day] = true
+		if day == last {
+			break
+		}
+	}
+	return days, nil
+}
+
+// Parses a time of the day like 09:00 into minutes since midnight
+func parseTimeOfDay(spec string) (int, error) {
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of the day %q", spec)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Parses a time window of the form <registry>,<days>,<HH:MM>-<HH:MM>,<timezone>.
+// The time zone is mandatory, so that the window never depends on the time zone of the host.
+func parseTimeWindow(spec string) (*timeWindow, error) {
+	fields := strings.Split(spec, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid time window %q: expected <registry>,<days>,<HH:MM>-<HH:MM>,<timezone>", spec)
+	}
+
+	window := &timeWindow{spec: spec}
+	var err error
+	if window.registries, err = newPatternSet(normalizeEntries([]string{fields[0]}, normalizeRegistryHost)); err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+	}
+	if window.days, err = parseWeekdays(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+	}
+
+	hours := strings.SplitN(fields[2], "-", 2)
+	if len(hours) != 2 {
+		return nil, fmt.Errorf("invalid time window %q: expected <HH:MM>-<HH:MM>", spec)
+	}
+	if window.start, err = parseTimeOfDay(hours[0]); err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+	}
+	if window.end, err = parseTimeOfDay(hours[1]); err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+	}
+	if window.start >= window.end {
+		return nil, fmt.Errorf("invalid time window %q: start must be before end", spec)
+	}
+
+	if len(fields[3]) == 0 {
+		return nil, fmt.Errorf("invalid time window %q: missing time zone", spec)
+	}
+	if window.location, err = time.LoadLocation(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+	}
+
+	return window, nil
+}
+
+// Returns true if the time is within the time window, in the time zone of the window
+func (window *timeWindow) contains(now time.Time) bool {
+	local := now.In(window.location)
+	minutes := local.Hour()*60 + local.Minute()
+	return window.days[local.Weekday()] && minutes >= window.start && minutes < window.end
+}
+
+// Checks the registry against the configured time windows.
+// Returns true if the registry is not constrained by any time window or if the time is within
+// one of its time windows. Otherwise, returns false along with the windows of the registry.
+func (plugin *ImgAuthZPlugin) isWithinTimeWindow(registry string, now time.Time) (bool, []string) {
+	var windows []string
+	for _, window := range plugin.timeWindows {
+		if !window.registries.matches(plugin.registryNames(registry)...) {
+			continue
+		}
+		if window.contains(now) {
+			return true, nil
+		}
+		windows = append(windows, window.spec)
+	}
+	return len(windows) == 0, windows
+}
@@ -0,0 +1,75 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindowBoundaries(t *testing.T) {
+	window, err := parseTimeWindow("my.docker.registry,Mon-Fri,09:00-17:00,Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		now      time.Time
+		contains bool
+	}{
+		// Monday, the start is included and the end excluded
+		{time.Date(2024, 7, 1, 8, 59, 59, 0, berlin), false},
+		{time.Date(2024, 7, 1, 9, 0, 0, 0, berlin), true},
+		{time.Date(2024, 7, 1, 16, 59, 59, 0, berlin), true},
+		{time.Date(2024, 7, 1, 17, 0, 0, 0, berlin), false},
+		// Friday evening and Saturday morning
+		{time.Date(2024, 7, 5, 16, 59, 0, 0, berlin), true},
+		{time.Date(2024, 7, 6, 9, 0, 0, 0, berlin), false},
+		// The window is in its own time zone: 07:00 UTC is 09:00 in Berlin in summer, 08:00 in winter
+		{time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 1, 8, 7, 0, 0, 0, time.UTC), false},
+		{time.Date(2024, 1, 8, 8, 0, 0, 0, time.UTC), true},
+	} {
+		if contains := window.contains(test.now); contains != test.contains {
+			t.Errorf("%s: contained %v, expected %v", test.now, contains, test.contains)
+		}
+	}
+}
+
+func TestTimeWindowConstrainsItsRegistriesOnly(t *testing.T) {
+	window, err := parseTimeWindow("my.docker.registry,Sat-Sun,00:00-23:59,UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := &ImgAuthZPlugin{timeWindows: []*timeWindow{window}}
+	monday := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	if within, windows := plugin.isWithinTimeWindow("my.docker.registry", monday); within || len(windows) != 1 {
+		t.Errorf("constrained registry: within %v, windows %v", within, windows)
+	}
+	if within, _ := plugin.isWithinTimeWindow("my.docker.registry", monday.AddDate(0, 0, 5)); !within {
+		t.Error("constrained registry not within its window")
+	}
+	if within, _ := plugin.isWithinTimeWindow("docker.io", monday); !within {
+		t.Error("unconstrained registry not within a time window")
+	}
+}
+
+func TestInvalidTimeWindows(t *testing.T) {
+	for _, spec := range []string{
+		"my.docker.registry,Mon-Fri,09:00-17:00",
+		"my.docker.registry,Mon-Fri,09:00-17:00,",
+		"my.docker.registry,Mon-Fri,09:00-17:00,Nowhere/Else",
+		"my.docker.registry,Mon-Fry,09:00-17:00,UTC",
+		"my.docker.registry,Mon-Fri,17:00-09:00,UTC",
+		"my.docker.registry,Mon-Fri,09:00,UTC",
+	} {
+		if _, err := parseTimeWindow(spec); err == nil {
+			t.Errorf("%s: no error", spec)
+		}
+	}
+}
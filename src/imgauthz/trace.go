@@ -0,0 +1,149 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-plugins-helpers/authorization"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Authorization request as recorded in a trace file, one JSON record per line.
+// The sensitive values of the headers and of the JSON body are redacted, as in the logged bodies.
+type tracedRequest struct {
+	Time       time.Time         `json:"time"`
+	User       string            `json:"user,omitempty"`
+	AuthMethod string            `json:"authMethod,omitempty"`
+	Method     string            `json:"method"`
+	URI        string            `json:"uri"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// Redacted JSON body, omitted if empty or not JSON
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Returns the request as recorded, with its sensitive values redacted
+func newTracedRequest(req authorization.Request, now time.Time) tracedRequest {
+	traced := tracedRequest{
+		Time:       now,
+		User:       req.User,
+		AuthMethod: req.UserAuthNMethod,
+		Method:     req.RequestMethod,
+		URI:        req.RequestURI}
+	if len(req.RequestHeaders) > 0 {
+		traced.Headers = make(map[string]string, len(req.RequestHeaders))
+		for name, value := range req.RequestHeaders {
+			if isSensitiveKey(name) {
+				value = redacted
+			}
+			traced.Headers[name] = value
+		}
+	}
+
+	var body interface{}
+	if len(req.RequestBody) > 0 && json.Unmarshal(req.RequestBody, &body) == nil {
+		traced.Body, _ = json.Marshal(redactValue(body))
+	}
+	return traced
+}
+
+// Returns the authorization request to replay
+func (traced tracedRequest) request() authorization.Request {
+	return authorization.Request{
+		User:            traced.User,
+		UserAuthNMethod: traced.AuthMethod,
+		RequestMethod:   traced.Method,
+		RequestURI:      traced.URI,
+		RequestHeaders:  traced.Headers,
+		RequestBody:     []byte(traced.Body)}
+}
+
+// Recorder of the authorization requests, appended to a trace file as they are received
+type traceRecorder struct {
+	sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	closed  bool
+}
+
+// Create a new trace recorder appending to the given file, created if needed
+func newTraceRecorder(path string) (*traceRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &traceRecorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Appends the authorization request to the trace file.
+// Requests recorded after the recorder is closed are dropped.
+func (recorder *traceRecorder) record(req authorization.Request) {
+	traced := newTracedRequest(req, time.Now())
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.closed {
+		return
+	}
+	if err := recorder.encoder.Encode(traced); err != nil {
+		log.Println("[TRACE] Cannot record the request:", req.RequestMethod, req.RequestURI, err)
+	}
+}
+
+// Closes the trace file
+func (recorder *traceRecorder) close() error {
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.closed {
+		return nil
+	}
+	recorder.closed = true
+	return recorder.file.Close()
+}
+
+// Decision on a replayed request, as reported by replayTrace
+type replayedDecision struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	URI     string    `json:"uri"`
+	Allowed bool      `json:"allowed"`
+	Msg     string    `json:"msg,omitempty"`
+}
+
+// Feeds the requests of a trace file through the plugin, in order, and writes the decisions
+// to out as one JSON record per line
+func replayTrace(plugin *ImgAuthZPlugin, path string, out io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(out)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var traced tracedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &traced); err != nil {
+			return fmt.Errorf("invalid trace file %s, line %d: %v", path, line, err)
+		}
+		response := plugin.AuthZReq(traced.request())
+		if err := encoder.Encode(replayedDecision{
+			Time:    traced.Time,
+			Method:  traced.Method,
+			URI:     traced.URI,
+			Allowed: response.Allow,
+			Msg:     response.Msg}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,110 @@
+// Docker Image Authorization Plugin.
+// Allows docker images to be fetched from a list of authorized registries only.
+// AUTHOR: Chaitanya Prakash N <cpdevws@gmail.com>
+package imgauthz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	dockerclient "github.com/docker/docker/client"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeout for establishing a connection to the docker daemon
+const dockerDialTimeout = 30 * time.Second
+
+// Tuning of the HTTP transport of the docker client
+type transportConfig struct {
+	// Maximum number of idle (keep-alive) connections kept open to the docker daemon (0 to close them after each request)
+	maxIdleConns int
+	// Duration after which an idle connection is closed (0 for unlimited)
+	idleTimeout time.Duration
+	// Interval of the TCP keep-alive probes, for tcp:// docker hosts (0 for the default of 15s, negative to disable)
+	keepAlive time.Duration
+	// TLS material of the tcp:// docker hosts, read again on every new docker client
+	tls tlsFiles
+}
+
+// PEM files of the TLS connection to the docker daemon, as for the docker client
+type tlsFiles struct {
+	// CA certificate verifying the docker daemon (the system CAs if empty)
+	caCert string
+	// Client certificate and key, if the docker daemon verifies its clients
+	cert string
+	key  string
+}
+
+// Returns true if the docker daemon is connected to over TLS
+func (files tlsFiles) enabled() bool {
+	return len(files.caCert) > 0 || len(files.cert) > 0 || len(files.key) > 0
+}
+
+// Returns the TLS configuration of the docker daemon connection, loaded from the files
+func (files tlsFiles) load() (*tls.Config, error) {
+	if (len(files.cert) > 0) != (len(files.key) > 0) {
+		return nil, fmt.Errorf("the docker TLS client certificate and key must be set together")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(files.caCert) > 0 {
+		pem, err := ioutil.ReadFile(files.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the docker TLS CA certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in the docker TLS CA certificate file: %s", files.caCert)
+		}
+	}
+	if len(files.cert) > 0 {
+		cert, err := tls.LoadX509KeyPair(files.cert, files.key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the docker TLS client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Returns the HTTP client of the docker daemon at the docker host, e.g. unix:///var/run/docker.sock
+// or tcp://127.0.0.1:2375, with its transport tuned as configured. The TLS material, if any, is read from its files.
+func newDockerHTTPClient(dockerHost string, config transportConfig) (*http.Client, error) {
+	proto, addr, _, err := dockerclient.ParseHost(dockerHost)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: dockerDialTimeout, KeepAlive: config.keepAlive}
+	transport := &http.Transport{
+		MaxIdleConns:        config.maxIdleConns,
+		MaxIdleConnsPerHost: config.maxIdleConns,
+		IdleConnTimeout:     config.idleTimeout,
+		DisableKeepAlives:   config.maxIdleConns == 0,
+	}
+	switch proto {
+	case "unix":
+		// The requests name a dummy host, all of them go to the socket
+		transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, proto, addr)
+		}
+	case "tcp":
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = http.ProxyFromEnvironment
+		// The docker client talks HTTPS to the docker daemon when its transport has a TLS configuration
+		if config.tls.enabled() {
+			if transport.TLSClientConfig, err = config.tls.load(); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported docker host protocol %s (expected unix or tcp): %s", proto, dockerHost)
+	}
+	if proto != "tcp" && config.tls.enabled() {
+		return nil, fmt.Errorf("the docker TLS certificates require a tcp:// docker host: %s", dockerHost)
+	}
+	return &http.Client{Transport: transport}, nil
+}
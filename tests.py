@@ -4,9 +4,14 @@
 # Test scripts for authorization plugin
 # Author: Chaitanya Prakash N <cpdevws@gmail.com>
 
+import BaseHTTPServer
 import docker
+import json
+import threading
 import unittest
-from subprocess import call
+import urllib2
+import urlparse
+from subprocess import call, check_output, CalledProcessError, STDOUT
 
 class TestAuthorizationPlugin(unittest.TestCase):
 	@classmethod
@@ -18,10 +23,12 @@ class TestAuthorizationPlugin(unittest.TestCase):
 		call(["systemctl", "restart", "img-authz-plugin"])
 		call(["systemctl", "start", "docker"])
 
-	def setup_with_registries(self, registries):
+	def setup_with_registries(self, registries, options=None):
 		if registries == None:
 			registries = ""
-		call(["make", "config", "REGISTRIES=%s"%registries])
+		if options == None:
+			options = ""
+		call(["make", "config", "REGISTRIES=%s"%registries, "OPTIONS=%s"%options])
 		call(["make", "uninstall"])
 		call(["make", "install"])
 		call(["systemctl", "daemon-reload"])
@@ -35,14 +42,47 @@ class TestAuthorizationPlugin(unittest.TestCase):
 			return False
 		return True
 			
-	def docker_run(self, image):
+	def docker_pull_with_headers(self, image, headers):
 		client = docker.from_env()
+		client.api.headers.update(headers)
 		try:
-			client.containers.run(image, "echo 'from container'")
+			client.images.pull(image)
+		except docker.errors.APIError, exception:
+			return False
+		return True
+
+	def docker_run(self, image, labels=None):
+		client = docker.from_env()
+		try:
+			client.containers.run(image, "echo 'from container'", labels=labels)
 		except docker.errors.APIError, exception:
 			return False
 		return True
 			
+	def docker_run_with_volumes(self, image, volumes):
+		client = docker.from_env()
+		try:
+			client.containers.run(image, "echo 'from container'", volumes=volumes)
+		except docker.errors.APIError, exception:
+			return False
+		return True
+
+	def docker_pull_denial(self, image):
+		client = docker.from_env()
+		try:
+			client.images.pull(image)
+		except docker.errors.APIError, exception:
+			return str(exception)
+		return ""
+
+	def docker_run_denial(self, image):
+		client = docker.from_env()
+		try:
+			client.containers.run(image, "echo 'from container'")
+		except docker.errors.APIError, exception:
+			return str(exception)
+		return ""
+
 	def docker_pull_is_denied(self, image):
 		self.assertEqual(self.docker_pull(image), False)
 		
@@ -55,6 +95,33 @@ class TestAuthorizationPlugin(unittest.TestCase):
 	def docker_run_is_allowed(self, image):
 		self.assertEqual(self.docker_run(image), True)
 
+	def docker_commit_denial(self, repository, tag=None):
+		client = docker.from_env()
+		container = client.containers.create("alpine:latest", "echo 'from container'")
+		try:
+			container.commit(repository, tag)
+		except docker.errors.APIError, exception:
+			return str(exception)
+		finally:
+			container.remove()
+		return ""
+
+	def test_commit_is_allowed_when_commits_are_not_restricted(self):
+		self.setup_with_registries("docker.io")
+		self.assertEqual(self.docker_commit_denial("my.docker.registry/app", "1.0"), "")
+
+	def test_commit_is_denied_when_commits_are_denied(self):
+		self.setup_with_registries("docker.io", "--restrict-commit deny")
+		self.assertIn("docker commit denied", self.docker_commit_denial("docker.io/user/app", "1.0"))
+		self.assertIn("docker commit denied", self.docker_commit_denial(None))
+
+	def test_commit_to_authorized_image_is_allowed_when_commits_are_allowlisted(self):
+		self.setup_with_registries("docker.io", "--restrict-commit allowlist --deny-image *:latest")
+		self.assertEqual(self.docker_commit_denial("docker.io/user/app", "1.0"), "")
+		self.assertIn("docker commit denied", self.docker_commit_denial("docker.io/user/app", "latest"))
+		self.assertIn("docker commit denied", self.docker_commit_denial("my.docker.registry/app", "1.0"))
+		self.assertIn("must name an authorized image repository", self.docker_commit_denial(None))
+
 	def test_pull_is_not_allowed_when_no_registries_are_authorized(self):
 		self.setup_with_registries(None)
 		self.docker_pull_is_denied("alpine:latest")
@@ -87,6 +154,1632 @@ class TestAuthorizationPlugin(unittest.TestCase):
 		self.setup_with_registries("my.docker.registry,library")
 		self.docker_run_is_allowed("alpine:latest")
 
+	def test_pull_is_allowed_when_image_is_authorized(self):
+		self.setup_with_registries("library", "--image library/alpine")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_image_is_not_authorized(self):
+		self.setup_with_registries("library", "--image library/busybox")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_allowed_when_tag_matches_tag_pattern(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine:3.*")
+		self.docker_pull_is_allowed("alpine:3.5")
+
+	def test_pull_is_not_allowed_when_tag_does_not_match_tag_pattern(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine:3.*")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_allowed_when_exact_tag_is_authorized(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine:3.5")
+		self.docker_pull_is_allowed("alpine:3.5")
+		self.docker_pull_is_denied("alpine:3.6")
+
+	def test_pull_with_double_slashes_is_matched_as_normalized_reference(self):
+		self.setup_with_registries("my.docker.registry", "--image my.docker.registry/team/app")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("my.docker.registry//team//app:latest"))
+
+	def test_pull_with_trailing_slash_is_matched_as_normalized_reference(self):
+		self.setup_with_registries("docker.io", "--deny-image docker.io/library/alpine")
+		self.assertIn("docker pull denied", self.docker_pull_denial("alpine/:latest"))
+
+	def test_pull_with_leading_slash_is_matched_as_normalized_reference(self):
+		self.setup_with_registries("docker.io", "--deny-image docker.io/library/alpine")
+		self.assertIn("docker pull denied", self.docker_pull_denial("/alpine:latest"))
+
+	def test_pull_of_slashes_only_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		self.assertIn("is invalid", self.docker_pull_denial("//:latest"))
+
+	def test_pull_is_allowed_when_repository_prefix_matches(self):
+		self.setup_with_registries("library", "--repository-prefix library")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_run_is_allowed_when_repository_prefix_matches(self):
+		self.setup_with_registries("library", "--repository-prefix library/")
+		self.docker_run_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_repository_prefix_matches_part_of_a_path_component(self):
+		self.setup_with_registries("library", "--repository-prefix library/alp")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_not_allowed_when_repository_prefix_does_not_match(self):
+		self.setup_with_registries("library", "--repository-prefix library/alpinex")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_not_allowed_when_repository_prefix_matches_unauthorized_registry(self):
+		self.setup_with_registries("my.docker.registry", "--repository-prefix library")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_denial_mentions_pull(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("docker pull denied", self.docker_pull_denial("alpine:latest"))
+
+	def test_run_denial_mentions_run(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("docker run denied", self.docker_run_denial("alpine:latest"))
+
+	def test_denial_mentions_help_url_when_configured(self):
+		self.setup_with_registries("my.docker.registry", "--help-url https://wiki.example.com/docker-images")
+		self.assertIn("To request an exception, see https://wiki.example.com/docker-images", self.docker_pull_denial("alpine:latest"))
+
+	def test_denial_does_not_mention_help_url_when_unset(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertNotIn("To request an exception", self.docker_pull_denial("alpine:latest"))
+
+	def test_denial_lists_the_first_ten_authorized_registries_only(self):
+		registries = ["registry%d.example.com" % i for i in range(25)]
+		self.setup_with_registries(",".join(registries))
+		denial = self.docker_pull_denial("alpine:latest")
+		self.assertIn(", ".join(registries[0:10]) + " and 15 more", denial)
+		self.assertNotIn(registries[10], denial)
+		self.assertNotIn("full list", denial)
+
+	def test_denial_of_large_allowlist_points_to_help_url(self):
+		registries = ["registry%d.example.com" % i for i in range(25)]
+		self.setup_with_registries(",".join(registries), "--help-url https://wiki.example.com/docker-images")
+		self.assertIn("and 15 more (see the help URL for the full list)", self.docker_pull_denial("alpine:latest"))
+
+	def test_pull_is_allowed_in_audit_mode_before_enforcement(self):
+		self.setup_with_registries("my.docker.registry", "--enforce-after 2999-01-01T00:00:00Z")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.assertIn("Would be denied after 2999-01-01T00:00:00Z", self.plugin_log_lines("[AUDIT]")[-1])
+
+	def test_pull_is_not_allowed_after_enforcement(self):
+		self.setup_with_registries("my.docker.registry", "--enforce-after 2000-01-01T00:00:00Z")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_canonical_entries_are_merged(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--canonicalize-entries", "merge", "--registry", "Docker.io", "--image", "ubuntu", "--image", "docker.io/library/ubuntu", "--deny-image", "index.docker.io/alpine:3.19"]))
+		self.assertEqual(policy["registries"], ["docker.io"])
+		self.assertEqual(policy["images"], ["docker.io/library/ubuntu"])
+		self.assertEqual(policy["deniedImages"], ["docker.io/library/alpine:3.19"])
+
+	def test_plugin_does_not_start_with_ambiguous_entries(self):
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--dump-policy", "--canonicalize-entries", "reject", "--registry", "docker.io", "--image", "ubuntu", "--image", "docker.io/library/ubuntu"], stderr=STDOUT)
+		self.assertIn("--image ubuntu and docker.io/library/ubuntu are both docker.io/library/ubuntu", failure.exception.output)
+
+	def test_pull_is_allowed_by_canonical_image_entry(self):
+		self.setup_with_registries("docker.io", "--image ubuntu --canonicalize-entries merge")
+		self.docker_pull_is_allowed("ubuntu:latest")
+
+	def test_pull_is_allowed_by_bare_official_image(self):
+		self.setup_with_registries("docker.io", "--image ubuntu --deny-image busybox")
+		self.docker_pull_is_allowed("ubuntu")
+		self.docker_pull_is_allowed("docker.io/library/ubuntu:latest")
+		self.docker_pull_is_denied("busybox")
+		self.docker_pull_is_denied("alpine")
+
+	def test_bare_official_images_are_dumped_canonical(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--image", "ubuntu:22.04", "--deny-image", "busybox", "--image", "docker.io/library/*"]))
+		self.assertEqual(sorted(policy["images"]), ["docker.io/library/*", "docker.io/library/ubuntu:22.04"])
+		self.assertEqual(policy["deniedImages"], ["docker.io/library/busybox"])
+
+	def daemon_pull_is_allowed(self, socket, image):
+		request = json.dumps({"RequestMethod": "POST", "RequestUri": "/images/create?fromImage=" + image})
+		response = check_output(["curl", "-s", "--unix-socket", socket, "-H", "Content-Type: application/json",
+			"-X", "POST", "-d", request, "http://localhost/AuthZPlugin.AuthZReq"])
+		return json.loads(response)["Allow"]
+
+	def test_daemon_policy_is_selected_by_socket(self):
+		with open("/tmp/img-authz-build.json", "w") as policy_file:
+			json.dump({"images": ["docker.io/library/alpine"]}, policy_file)
+		self.setup_with_registries("docker.io", "--image docker.io/library/busybox --daemon-policy build=/tmp/img-authz-build.json")
+		self.assertTrue(self.daemon_pull_is_allowed("/run/docker/plugins/build.sock", "alpine"))
+		self.assertTrue(self.daemon_pull_is_allowed("/run/docker/plugins/build.sock", "busybox"))
+		self.assertFalse(self.daemon_pull_is_allowed("/run/docker/plugins/img-authz-plugin.sock", "alpine"))
+		self.docker_pull_is_denied("alpine")
+		self.docker_pull_is_allowed("busybox")
+
+	def test_plugin_does_not_start_with_invalid_daemon_policy(self):
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--dump-policy", "--daemon-policy", "img-authz-plugin=/tmp/img-authz-build.json"], stderr=STDOUT)
+		self.assertIn("invalid daemon policy", failure.exception.output)
+
+	def test_pull_is_allowed_by_cel_rule(self):
+		self.write_policy_file({"registries": ["my.docker.registry"], "settings": {
+			"cel-rule": ['registry == "docker.io" && repository.startsWith("library/") && tag != "latest"']}})
+		self.setup_with_registries(None, "--config /tmp/img-authz-policy.json")
+		self.docker_pull_is_allowed("alpine:3.19")
+		self.docker_pull_is_denied("alpine:latest")
+		self.docker_pull_is_denied("nginxinc/nginx-unprivileged:stable")
+
+	def test_cel_rules_are_dumped(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--cel-rule", 'tag != "latest"', "--cel-rule", 'user == "alice"']))
+		self.assertEqual(policy["celRules"], ['tag != "latest"', 'user == "alice"'])
+
+	def test_plugin_does_not_start_with_invalid_cel_rule(self):
+		for rule in ['registry ==', 'registry', 'unknown == "x"']:
+			with self.assertRaises(CalledProcessError) as failure:
+				check_output(["./img-authz-plugin", "--dump-policy", "--cel-rule", rule], stderr=STDOUT)
+			self.assertIn("invalid --cel-rule", failure.exception.output)
+
+	def test_registry_fingerprints_are_dumped(self):
+		entry = "my.docker.registry:5000=sha256:" + "ab" * 32
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--registry-fingerprint", entry]))
+		self.assertEqual(policy["registryFingerprints"], [entry])
+
+	def test_plugin_does_not_start_with_invalid_registry_fingerprint(self):
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--dump-policy", "--registry-fingerprint", "my.docker.registry=sha256:abcd"], stderr=STDOUT)
+		self.assertIn("invalid registry fingerprint", failure.exception.output)
+
+	def test_pull_follows_on_error_when_fingerprinted_registry_is_unreachable(self):
+		self.setup_with_registries("127.0.0.1:1", "--registry-fingerprint 127.0.0.1:1=sha256:" + "ab" * 32 + " --on-error deny")
+		self.assertIn("registry certificate check failed", self.docker_pull_denial("127.0.0.1:1/team/app:1"))
+
+	def test_pull_is_not_allowed_from_registry_with_other_certificate(self):
+		self.setup_with_registries("docker.io", "--registry-fingerprint docker.io=sha256:" + "ab" * 32)
+		self.assertIn("presented a certificate whose fingerprint is not trusted", self.docker_pull_denial("alpine:latest"))
+
+	def test_plugin_does_not_start_below_min_registries(self):
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--dump-policy", "--min-registries", "2", "--registry", "docker.io"], stderr=STDOUT)
+		self.assertIn("fewer than the --min-registries minimum of 2", failure.exception.output)
+
+	def test_plugin_starts_at_min_registries(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--min-registries", "2", "--registry", "docker.io", "--registry", "my.docker.registry"]))
+		self.assertEqual(policy["minRegistries"], 2)
+
+	def test_plugin_starts_below_min_registries_in_audit_mode(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--min-registries", "2", "--enforce-after", "2999-01-01T00:00:00Z"]))
+		self.assertEqual(policy["registries"], [])
+
+	def test_plugin_does_not_start_with_invalid_enforcement_time(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--enforce-after", "tomorrow", "--dump-policy"])
+
+	def test_pull_is_allowed_with_valid_breakglass_token(self):
+		self.setup_with_registries("my.docker.registry", "--breakglass-token s3cr3t")
+		self.assertEqual(self.docker_pull_with_headers("alpine:latest", {"X-Img-Authz-Breakglass": "s3cr3t"}), True)
+
+	def test_pull_is_not_allowed_with_invalid_breakglass_token(self):
+		self.setup_with_registries("my.docker.registry", "--breakglass-token s3cr3t")
+		self.assertEqual(self.docker_pull_with_headers("alpine:latest", {"X-Img-Authz-Breakglass": "wrong"}), False)
+
+	def test_run_is_not_allowed_with_breakglass_token_label(self):
+		self.setup_with_registries("my.docker.registry", "--breakglass-token s3cr3t")
+		self.assertEqual(self.docker_run("alpine:latest", {"img-authz.breakglass": "s3cr3t"}), False)
+
+	def test_pull_is_allowed_when_image_is_below_max_size(self):
+		self.setup_with_registries("library", "--max-image-size 100MB")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_image_is_above_max_size(self):
+		self.setup_with_registries("library", "--max-image-size 1MB")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_allowed_when_image_is_below_max_layers(self):
+		self.setup_with_registries("docker.io", "--max-layers 5")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_image_is_above_max_layers(self):
+		self.setup_with_registries("docker.io", "--max-layers 1")
+		self.assertIn("exceeding the maximum of 1", self.docker_pull_denial("python:3.12-slim"))
+
+	def test_run_is_not_allowed_when_local_image_is_above_max_layers(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("python:3.12-slim")
+		self.setup_with_registries("docker.io", "--max-layers 1")
+		self.docker_run_is_denied("python:3.12-slim")
+
+	def test_denial_of_image_checks_is_cached(self):
+		self.setup_with_registries("docker.io", "--max-layers 1 --negative-cache-ttl 1m")
+		cached = len(self.plugin_log_lines("[DENIED] Cached denial:"))
+		self.docker_pull_is_denied("python:3.12-slim")
+		self.assertEqual(len(self.plugin_log_lines("[DENIED] Cached denial:")), cached)
+		self.assertIn("exceeding the maximum of 1", self.docker_pull_denial("python:3.12-slim"))
+		self.assertEqual(len(self.plugin_log_lines("[DENIED] Cached denial:")), cached + 1)
+
+	def test_cached_denials_are_dropped_on_reload(self):
+		self.setup_with_registries("docker.io", "--max-layers 1 --negative-cache-ttl 1m")
+		self.docker_pull_is_denied("python:3.12-slim")
+		cached = len(self.plugin_log_lines("[DENIED] Cached denial:"))
+		call(["systemctl", "reload", "img-authz-plugin"])
+		self.docker_pull_is_denied("python:3.12-slim")
+		self.assertEqual(len(self.plugin_log_lines("[DENIED] Cached denial:")), cached)
+
+	def test_checks_are_skipped_while_registry_breaker_is_open(self):
+		self.setup_with_registries("127.0.0.1:1", "--max-layers 1 --unknown-layers deny --breaker-failures 2 --breaker-open allow")
+		skipped = len(self.plugin_log_lines("[BREAKER] [ALLOWED]"))
+		self.assertIn("layer count could not be determined", self.docker_pull_denial("127.0.0.1:1/team/app:1"))
+		self.assertNotIn("layer count could not be determined", self.docker_pull_denial("127.0.0.1:1/team/app:1"))
+		self.assertIn("circuit open: 127.0.0.1:1", self.plugin_log_lines("[BREAKER] !!! Registry unavailable")[-1])
+		self.assertNotIn("layer count could not be determined", self.docker_pull_denial("127.0.0.1:1/team/app:2"))
+		self.assertEqual(len(self.plugin_log_lines("[BREAKER] [ALLOWED]")), skipped + 2)
+
+	def test_checks_fail_while_registry_breaker_is_open_by_default(self):
+		self.setup_with_registries("127.0.0.1:1", "--max-layers 1 --unknown-layers deny --breaker-failures 1")
+		self.assertIn("layer count could not be determined", self.docker_pull_denial("127.0.0.1:1/team/app:1"))
+		self.assertIn("circuit breaker open", self.docker_pull_denial("127.0.0.1:1/team/app:1"))
+
+	def test_cached_decisions_of_current_policy_generation_are_used(self):
+		self.setup_with_registries("docker.io", "--max-layers 100 --decision-cache-ttl 1m --debug")
+		self.docker_pull_is_allowed("alpine:latest")
+		cached = len(self.plugin_log_lines("[CACHE] Cached image checks:"))
+		call(["systemctl", "reload", "img-authz-plugin"])
+		self.docker_pull_is_allowed("alpine:latest")
+		self.assertEqual(len(self.plugin_log_lines("[CACHE] Cached image checks:")), cached)
+		self.docker_pull_is_allowed("alpine:latest")
+		self.assertEqual(len(self.plugin_log_lines("[CACHE] Cached image checks:")), cached + 1)
+
+	def test_run_is_not_allowed_beyond_image_quota(self):
+		self.setup_with_registries("docker.io", "--image-quota 2")
+		self.docker_run_is_allowed("alpine:latest")
+		self.docker_run_is_allowed("busybox:latest")
+		self.docker_run_is_allowed("alpine:3.19")
+		self.assertIn("distinct images within", self.docker_run_denial("hello-world:latest"))
+		self.docker_pull_is_allowed("hello-world:latest")
+
+	def test_image_quota_is_reset_on_reload(self):
+		self.setup_with_registries("docker.io", "--image-quota 1")
+		self.docker_run_is_allowed("alpine:latest")
+		self.docker_run_is_denied("busybox:latest")
+		call(["systemctl", "reload", "img-authz-plugin"])
+		self.docker_run_is_allowed("busybox:latest")
+
+	def write_tag_pins(self, pins):
+		with open("/tmp/img-authz-pins.json", "w") as pins_file:
+			json.dump(pins, pins_file)
+
+	def read_tag_pins(self):
+		with open("/tmp/img-authz-pins.json") as pins_file:
+			return json.load(pins_file)
+
+	def test_first_pull_pins_tag(self):
+		call(["rm", "-f", "/tmp/img-authz-pins.json"])
+		self.setup_with_registries("docker.io", "--pin-tags --pin-tags-file /tmp/img-authz-pins.json")
+		self.docker_pull_is_allowed("alpine:3.19")
+		self.assertTrue(self.read_tag_pins()["docker.io/library/alpine:3.19"].startswith("sha256:"))
+
+	def test_repull_of_same_digest_is_allowed(self):
+		call(["rm", "-f", "/tmp/img-authz-pins.json"])
+		self.setup_with_registries("docker.io", "--pin-tags --pin-tags-file /tmp/img-authz-pins.json")
+		self.docker_pull_is_allowed("alpine:3.19")
+		self.docker_pull_is_allowed("alpine:3.19")
+
+	def test_repull_of_changed_digest_is_denied(self):
+		self.write_tag_pins({"docker.io/library/alpine:3.19": "sha256:" + "0" * 64})
+		self.setup_with_registries("docker.io", "--pin-tags --pin-tags-file /tmp/img-authz-pins.json")
+		self.assertIn("is pinned to sha256:" + "0" * 64, self.docker_pull_denial("alpine:3.19"))
+		self.docker_pull_is_allowed("alpine:3.18")
+
+	def test_run_after_authorized_pull_is_allowed_with_prior_pull_required(self):
+		self.setup_with_registries("docker.io", "--require-prior-pull")
+		self.docker_pull_is_allowed("alpine:3.19")
+		self.docker_run_is_allowed("alpine:3.19")
+
+	def test_run_of_unknown_image_is_not_allowed_with_prior_pull_required(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:3.18")
+		self.setup_with_registries("docker.io", "--require-prior-pull")
+		self.assertIn("was not pulled through the authorization plugin", self.docker_run_denial("alpine:3.18"))
+
+	def test_prior_pulls_are_persisted_across_restarts(self):
+		call(["rm", "-f", "/tmp/img-authz-pulls.json"])
+		self.setup_with_registries("docker.io", "--require-prior-pull --prior-pulls-file /tmp/img-authz-pulls.json")
+		self.docker_pull_is_allowed("alpine:3.19")
+		call(["systemctl", "restart", "img-authz-plugin"])
+		self.docker_run_is_allowed("alpine:3.19")
+		with open("/tmp/img-authz-pulls.json") as pulls_file:
+			self.assertEqual(json.load(pulls_file), ["docker.io/library/alpine:3.19"])
+
+	def test_pull_burst_over_rate_limit_is_denied(self):
+		self.setup_with_registries("docker.io", "--rate-limit 2 --rate-limit-interval 1h")
+		self.docker_pull_is_allowed("alpine:3.18")
+		self.docker_pull_is_allowed("alpine:3.19")
+		self.assertIn("Too many registry commands, please retry in", self.docker_pull_denial("alpine:3.20"))
+		self.assertIn("Too many registry commands", self.docker_run_denial("alpine:3.19"))
+
+	def test_rate_limit_does_not_apply_to_always_allowed_images(self):
+		self.setup_with_registries("docker.io", "--rate-limit 1 --rate-limit-interval 1h --always-allow docker.io/library/busybox")
+		self.docker_pull_is_allowed("alpine:3.19")
+		self.docker_pull_is_allowed("busybox:latest")
+		self.docker_pull_is_allowed("busybox:latest")
+		self.docker_pull_is_denied("alpine:3.19")
+
+	def concurrent_pull_denials(self, images):
+		denials = []
+		threads = [threading.Thread(target=lambda image=image: denials.append(self.docker_pull_denial(image))) for image in images]
+		for thread in threads:
+			thread.start()
+		for thread in threads:
+			thread.join()
+		return denials
+
+	def test_concurrent_pulls_over_check_limit_are_denied(self):
+		self.setup_with_registries("docker.io", "--max-image-size 1GB --max-concurrent-checks 1 --checks-over-limit deny")
+		denials = self.concurrent_pull_denials(["alpine:3.4", "alpine:3.5", "alpine:3.6", "busybox:1.25", "busybox:1.26"])
+		self.assertTrue(any("Too many concurrent authorization checks" in denial for denial in denials))
+
+	def test_concurrent_pulls_over_check_limit_are_queued(self):
+		self.setup_with_registries("docker.io", "--max-image-size 1GB --max-concurrent-checks 1")
+		denials = self.concurrent_pull_denials(["alpine:3.4", "alpine:3.5", "alpine:3.6", "busybox:1.25", "busybox:1.26"])
+		self.assertEqual(denials, [""] * 5)
+
+	def test_pull_is_not_allowed_when_glob_deny_overrides_authorized_image(self):
+		self.setup_with_registries("library", "--image library/alpine --deny-image *:latest")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_not_allowed_when_glob_deny_matches_authorized_registry(self):
+		self.setup_with_registries("library", "--deny-registry docker.*")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_allowed_when_glob_registry_is_authorized(self):
+		self.setup_with_registries("docker.*")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_dump_policy_is_sorted_and_deduplicated(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy",
+			"--registry", "my.docker.registry", "--registry", "library", "--registry", "my.docker.registry",
+			"--deny-image", "*:latest"]))
+		self.assertEqual(policy["registries"], ["docker.io", "my.docker.registry"])
+		self.assertEqual(policy["deniedImages"], ["*:latest"])
+		self.assertEqual(policy["onError"], "deny")
+
+	def test_dump_policy_reports_registry_trust(self):
+		check_output(["sh", "-c", "openssl req -x509 -newkey rsa:2048 -nodes -subj /CN=my.docker.registry -keyout /tmp/img-authz-registry.key -out /tmp/img-authz-registry-ca.pem 2>/dev/null"])
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--registry", "my.docker.registry",
+			"--registry-ca", "my.docker.registry=/tmp/img-authz-registry-ca.pem", "--registry-skip-verify", "lab.docker.registry:5000"]))
+		self.assertEqual(policy["registryCAs"], ["my.docker.registry=/tmp/img-authz-registry-ca.pem"])
+		self.assertEqual(policy["registrySkipVerify"], ["lab.docker.registry:5000"])
+
+	def test_plugin_does_not_start_with_unreadable_registry_ca(self):
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--dump-policy", "--registry-ca", "my.docker.registry=/nonexistent/ca.pem"], stderr=STDOUT)
+		self.assertIn("cannot read the CA bundle of the registry my.docker.registry", failure.exception.output)
+
+	def test_pull_is_allowed_when_dockerhub_registry_is_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_allowed_when_official_image_is_authorized_in_dockerhub_registry(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_official_image_is_denied_in_dockerhub_registry(self):
+		self.setup_with_registries("docker.io", "--deny-image docker.io/library/alpine")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_dump_policy_migrates_legacy_library_entries(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy",
+			"--registry", "library", "--image", "library/alpine", "--deny-image", "user/app"]))
+		self.assertEqual(policy["registries"], ["docker.io"])
+		self.assertEqual(policy["images"], ["docker.io/library/alpine"])
+		self.assertEqual(policy["deniedImages"], ["docker.io/user/app"])
+
+	def test_pull_of_scratch_is_not_denied_when_no_registries_are_authorized(self):
+		self.setup_with_registries(None)
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("scratch"))
+
+	def test_run_of_scratch_is_not_denied_when_registry_is_not_authorized(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertNotIn("docker run denied", self.docker_run_denial("scratch"))
+
+	def test_pull_of_scratch_is_denied_without_default_pseudo_images(self):
+		self.setup_with_registries("my.docker.registry", "--no-default-pseudo-images")
+		self.assertIn("docker pull denied", self.docker_pull_denial("scratch"))
+
+	def test_pull_of_scratch_with_registry_is_denied(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("docker pull denied", self.docker_pull_denial("docker.io/library/scratch:latest"))
+
+	def dump_policy_warnings(self, options):
+		output = check_output(["./img-authz-plugin", "--dump-policy"] + options, stderr=STDOUT)
+		return [line for line in output.splitlines() if "[WARNING]" in line]
+
+	def test_no_warning_when_authorized_image_is_on_authorized_registry(self):
+		self.assertEqual(self.dump_policy_warnings(["--registry", "docker.io", "--image", "docker.io/library/alpine"]), [])
+
+	def test_warning_when_authorized_image_is_on_unauthorized_registry(self):
+		warnings = self.dump_policy_warnings(["--registry", "docker.io", "--image", "my.docker.registry/team/app"])
+		self.assertEqual(len(warnings), 1)
+		self.assertIn("my.docker.registry/team/app is on the registry my.docker.registry which is not authorized", warnings[0])
+
+	def test_warning_when_authorized_image_is_on_denied_registry(self):
+		warnings = self.dump_policy_warnings(["--registry", "my.docker.registry", "--deny-registry", "my.docker.registry", "--image", "my.docker.registry/team/app"])
+		self.assertIn("is on the denied registry my.docker.registry", warnings[0])
+
+	def test_no_warning_for_registry_only_or_wildcard_policy(self):
+		self.assertEqual(self.dump_policy_warnings(["--registry", "docker.io"]), [])
+		self.assertEqual(self.dump_policy_warnings(["--registry", "*", "--image", "my.docker.registry/team/app"]), [])
+		self.assertEqual(self.dump_policy_warnings(["--registry", "docker.io", "--image", "*/team/app"]), [])
+
+	def test_dump_policy_lists_default_pseudo_images(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--pseudo-image", "nothing"]))
+		self.assertEqual(policy["pseudoImages"], ["nothing", "scratch"])
+
+	def test_pull_is_allowed_when_always_allowed_image_is_denied(self):
+		self.setup_with_registries("library", "--deny-image library/alpine --always-allow library/alpine")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_allowed_when_always_allowed_registry_is_not_authorized(self):
+		self.setup_with_registries(None, "--always-allow library/alp*")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_unicode_form_of_registry_is_denied(self):
+		self.setup_with_registries("xn--bcher-kva.example", "--deny-registry b\xc3\xbccher.example")
+		self.assertIn("docker pull denied", self.docker_pull_denial("xn--bcher-kva.example/app:latest"))
+
+	def test_pull_is_not_denied_when_punycode_form_of_registry_is_authorized(self):
+		self.setup_with_registries("xn--bcher-kva.example")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("b\xc3\xbccher.example/app:latest"))
+
+	def test_pull_is_not_denied_when_ipv6_registry_with_port_is_authorized(self):
+		self.setup_with_registries("[2001:db8:0::1]:5000")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("[2001:db8::1]:5000/app:latest"))
+		self.assertIn("docker pull denied", self.docker_pull_denial("[2001:db8::1]:5001/app:latest"))
+
+	def test_pull_is_not_denied_when_ipv6_registry_without_port_is_authorized(self):
+		self.setup_with_registries("[2001:db8::1]")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("[2001:DB8::1]/team/app:latest"))
+		self.assertIn("docker pull denied", self.docker_pull_denial("[2001:db8::2]/team/app:latest"))
+
+	def test_pull_from_mirror_is_allowed_when_upstream_image_is_authorized(self):
+		self.setup_with_registries("docker.io", "--mirror-prefix mirror.gcr.io=docker.io --image docker.io/library/alpine")
+		self.docker_pull_is_allowed("mirror.gcr.io/library/alpine:latest")
+		self.docker_pull_is_allowed("mirror.gcr.io/alpine:latest")
+
+	def test_pull_from_mirror_is_not_allowed_when_upstream_image_is_not_authorized(self):
+		self.setup_with_registries("docker.io", "--mirror-prefix mirror.gcr.io=docker.io --image docker.io/library/busybox")
+		self.docker_pull_is_denied("mirror.gcr.io/library/alpine:latest")
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_denied("mirror.gcr.io/library/alpine:latest")
+
+	def test_pull_from_upstream_is_not_allowed_when_mirror_is_required(self):
+		self.setup_with_registries("docker.io", "--mirror-prefix mirror.gcr.io=docker.io --require-mirror")
+		self.assertIn("through a registry mirror: mirror.gcr.io", self.docker_pull_denial("alpine:latest"))
+		self.docker_pull_is_denied("docker.io/library/alpine:latest")
+		self.docker_pull_is_allowed("mirror.gcr.io/library/alpine:latest")
+
+	def test_require_mirror_without_mirror_prefix_is_rejected(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--require-mirror"], stderr=STDOUT)
+
+	def test_pull_is_allowed_when_registry_alias_is_authorized(self):
+		self.setup_with_registries("hub", "--registry-alias hub=docker.io --image hub/library/alpine")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("busybox:latest")
+
+	def test_pull_from_registry_alias_is_not_matched_as_registry(self):
+		self.setup_with_registries("my.docker.registry", "--registry-alias my.registry.alias=my.docker.registry")
+		self.assertIn("docker pull denied", self.docker_pull_denial("my.registry.alias/team/app:latest"))
+
+	def test_dump_policy_resolves_registry_aliases(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy",
+			"--registry-alias", "hub=docker.io", "--registry-alias", "dh=hub", "--registry", "dh"]))
+		self.assertEqual(policy["registries"], ["docker.io"])
+		self.assertEqual(policy["registryAliases"], ["dh=hub", "hub=docker.io"])
+
+	def test_registry_alias_cycle_is_rejected(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy",
+				"--registry-alias", "a.io=b.io", "--registry-alias", "b.io=a.io"], stderr=STDOUT)
+
+	def test_pull_is_not_allowed_when_mixed_case_form_of_registry_is_denied(self):
+		self.setup_with_registries("*", "--deny-registry my.docker.registry")
+		self.assertIn("is denied", self.docker_pull_denial("MY.Docker.Registry/app:latest"))
+
+	def test_pull_is_allowed_when_legacy_dockerhub_host_is_used(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine")
+		self.docker_pull_is_allowed("index.docker.io/alpine:latest")
+		self.docker_pull_is_allowed("docker.io/alpine:latest")
+
+	def test_pull_is_not_denied_when_mixed_case_form_of_registry_is_authorized(self):
+		self.setup_with_registries("My.Docker.Registry")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("my.docker.registry/app:latest"))
+
+	def test_pull_is_allowed_when_default_registry_is_authorized(self):
+		self.setup_with_registries("my.docker.registry", "--default-registry my.docker.registry")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_default_registry_is_not_authorized(self):
+		self.setup_with_registries("library", "--default-registry my.docker.registry")
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_allowed_when_image_under_default_registry_is_authorized(self):
+		self.setup_with_registries("my.docker.registry", "--default-registry my.docker.registry --image my.docker.registry/library/alpine")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_from_unauthenticated_client_when_auth_is_required(self):
+		self.setup_with_registries("library", "--require-auth")
+		self.assertIn("require an authenticated client", self.docker_pull_denial("alpine:latest"))
+
+	def test_non_registry_command_is_allowed_from_unauthenticated_client_when_auth_is_required(self):
+		self.setup_with_registries("library", "--require-auth")
+		docker.from_env().images.list()
+
+	def docker_pull_with_credentials_denial(self, image, auth_config):
+		client = docker.from_env()
+		try:
+			client.images.pull(image, auth_config=auth_config)
+		except docker.errors.APIError, exception:
+			return str(exception)
+		return ""
+
+	def test_pull_is_not_allowed_without_credentials_when_registry_auth_is_required(self):
+		self.setup_with_registries("docker.io,my.docker.registry", "--require-registry-auth my.docker.registry")
+		self.assertIn("requires credentials, please docker login my.docker.registry", self.docker_pull_denial("my.docker.registry/team/app:latest"))
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_with_credentials_is_authorized_when_registry_auth_is_required(self):
+		self.setup_with_registries("my.docker.registry", "--require-registry-auth my.docker.registry")
+		denial = self.docker_pull_with_credentials_denial("my.docker.registry/team/app:latest", {"username": "user", "password": "secret"})
+		self.assertNotIn("requires credentials", denial)
+		log = check_output(["journalctl", "-u", "img-authz-plugin", "--no-pager", "-o", "cat"])
+		self.assertNotIn("secret", log)
+
+	def test_run_is_not_allowed_with_implicit_latest_tag_when_explicit_tag_is_required(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--require-explicit-tag")
+		self.assertIn("An explicit tag or digest is required", self.docker_run_denial("alpine"))
+
+	def test_run_is_allowed_with_explicit_latest_tag_when_explicit_tag_is_required(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--require-explicit-tag")
+		self.docker_run_is_allowed("alpine:latest")
+
+	def test_run_is_not_allowed_when_binding_denied_host_path(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-host-mount /var/run/docker.sock")
+		self.assertEqual(self.docker_run_with_volumes("alpine:latest", {"/var/run/docker.sock": {"bind": "/var/run/docker.sock", "mode": "rw"}}), False)
+
+	def test_run_is_not_allowed_when_binding_parent_of_denied_host_path(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-host-mount /var/run/docker.sock")
+		self.assertEqual(self.docker_run_with_volumes("alpine:latest", {"/": {"bind": "/host", "mode": "ro"}}), False)
+
+	def test_run_is_allowed_when_binding_other_host_path(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-host-mount /var/run/docker.sock")
+		self.assertEqual(self.docker_run_with_volumes("alpine:latest", {"/tmp": {"bind": "/data", "mode": "ro"}}), True)
+
+	def test_run_is_not_allowed_when_binding_link_target_of_denied_host_path(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-host-mount /var/run/docker.sock")
+		self.assertEqual(self.docker_run_with_volumes("alpine:latest", {"/run": {"bind": "/host-run", "mode": "ro"}}), False)
+
+	def docker_run_with_mounts(self, image, mounts):
+		client = docker.from_env()
+		try:
+			client.containers.run(image, "echo 'from container'", mounts=mounts)
+		except docker.errors.APIError, exception:
+			return False
+		return True
+
+	def test_run_is_not_allowed_when_local_volume_binds_denied_host_path(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-host-mount /var/run/docker.sock")
+		driver = docker.types.DriverConfig("local", {"type": "none", "o": "bind", "device": "/"})
+		self.assertEqual(self.docker_run_with_mounts("alpine:latest", [docker.types.Mount("/host", None, type="volume", driver_config=driver)]), False)
+
+	def docker_pull_denial_with_api_version(self, image, version):
+		client = docker.from_env(version=version)
+		try:
+			client.images.pull(image)
+		except docker.errors.APIError, exception:
+			return str(exception)
+		return ""
+
+	def test_pull_is_not_allowed_below_min_api_version(self):
+		self.setup_with_registries("docker.io", "--min-api-version 1.30")
+		self.assertIn("older than the minimum API version 1.30", self.docker_pull_denial_with_api_version("alpine:latest", "1.29"))
+
+	def test_pull_is_allowed_at_min_api_version(self):
+		self.setup_with_registries("docker.io", "--min-api-version 1.30")
+		self.assertEqual(self.docker_pull_denial_with_api_version("alpine:latest", "1.30"), "")
+
+	def test_plugin_does_not_start_with_invalid_min_api_version(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--min-api-version", "latest"])
+
+	def docker_cli_run_denial(self, image, pull):
+		try:
+			check_output(["docker", "run", "--rm", "--pull=%s"%pull, image, "echo", "from container"], stderr=STDOUT)
+		except CalledProcessError, error:
+			return error.output
+		return ""
+
+	def test_run_with_pull_always_is_not_allowed_when_registry_is_not_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("docker pull denied", self.docker_cli_run_denial("alpine:latest", "always"))
+
+	def test_run_with_pull_always_is_allowed_when_registry_is_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.assertEqual(self.docker_cli_run_denial("alpine:latest", "always"), "")
+
+	def test_run_with_pull_missing_is_not_allowed_when_image_is_not_authorized(self):
+		self.setup_with_registries("docker.io", "--deny-image docker.io/library/busybox")
+		self.assertIn("denied", self.docker_cli_run_denial("busybox:latest", "missing"))
+
+	def test_run_with_pull_never_is_not_allowed_when_local_image_is_not_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("docker run denied", self.docker_cli_run_denial("alpine:latest", "never"))
+
+	def test_run_with_pull_never_is_allowed_when_local_image_is_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.assertEqual(self.docker_cli_run_denial("alpine:latest", "never"), "")
+
+	def docker_run_with_capabilities(self, image, cap_add=None, cap_drop=None, privileged=False):
+		client = docker.from_env()
+		try:
+			client.containers.run(image, "echo 'from container'", cap_add=cap_add, cap_drop=cap_drop, privileged=privileged)
+		except docker.errors.APIError, exception:
+			return False
+		return True
+
+	def test_run_is_not_allowed_when_adding_denied_capability(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-capability SYS_ADMIN")
+		self.assertEqual(self.docker_run_with_capabilities("alpine:latest", cap_add=["CAP_SYS_ADMIN"]), False)
+
+	def test_run_is_not_allowed_when_adding_all_capabilities(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-capability SYS_ADMIN")
+		self.assertEqual(self.docker_run_with_capabilities("alpine:latest", cap_add=["ALL"]), False)
+
+	def test_run_is_not_allowed_when_privileged_and_capability_is_denied(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-capability SYS_ADMIN")
+		self.assertEqual(self.docker_run_with_capabilities("alpine:latest", privileged=True), False)
+
+	def test_run_is_allowed_when_adding_other_capability(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-capability SYS_ADMIN")
+		self.assertEqual(self.docker_run_with_capabilities("alpine:latest", cap_add=["NET_ADMIN"], cap_drop=["SYS_ADMIN"]), True)
+
+	def test_run_is_allowed_without_capabilities_when_capability_is_denied(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--deny-capability SYS_ADMIN")
+		self.assertEqual(self.docker_run_with_capabilities("alpine:latest"), True)
+
+	def image_id(self, image):
+		return docker.from_env().images.get(image).id
+
+	def test_run_by_id_is_allowed_when_resolved_image_is_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--inspect-on-run --image docker.io/library/alpine")
+		self.docker_run_is_allowed(self.image_id("alpine:latest"))
+
+	def test_run_by_id_is_not_allowed_when_no_resolved_image_is_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--inspect-on-run --image docker.io/library/busybox")
+		self.docker_run_is_denied(self.image_id("alpine:latest"))
+
+	def test_run_by_id_is_allowed_when_any_resolved_image_is_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		docker.from_env().images.get("alpine:latest").tag("my.docker.registry/alpine", "latest")
+		self.setup_with_registries("docker.io", "--inspect-on-run --inspect-match any")
+		self.docker_run_is_allowed(self.image_id("alpine:latest"))
+		docker.from_env().images.remove("my.docker.registry/alpine:latest")
+
+	def test_run_by_id_is_not_allowed_when_not_all_resolved_images_are_authorized(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		docker.from_env().images.get("alpine:latest").tag("my.docker.registry/alpine", "latest")
+		self.setup_with_registries("docker.io", "--inspect-on-run --inspect-match all")
+		self.assertIn("All the image tags and digests must be authorized", self.docker_run_denial(self.image_id("alpine:latest")))
+		docker.from_env().images.remove("my.docker.registry/alpine:latest")
+		self.docker_run_is_allowed(self.image_id("alpine:latest"))
+
+	def plugin_log_lines(self, marker):
+		log = check_output(["journalctl", "-u", "img-authz-plugin", "--no-pager", "-o", "cat"])
+		return [line for line in log.splitlines() if marker in line]
+
+	def test_docker_client_transport_is_configured_from_options(self):
+		self.setup_with_registries("docker.io", "--docker-max-idle-conns 4 --docker-idle-timeout 45s --docker-keepalive 10s")
+		self.assertIn("Docker client connections: 4 idle, closed after 45s - keep-alive 10s", self.plugin_log_lines("Docker client connections:")[-1])
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_docker_client_transport_has_defaults(self):
+		self.setup_with_registries("docker.io")
+		self.assertIn("Docker client connections: 10 idle, closed after 1m30s - keep-alive 30s", self.plugin_log_lines("Docker client connections:")[-1])
+
+	def test_docker_tls_certificates_require_a_tcp_docker_host(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--docker-tls-ca", "/etc/ssl/certs/ca-certificates.crt"], stderr=STDOUT)
+
+	def test_docker_tls_client_certificate_requires_its_key(self):
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--dump-policy", "--host", "tcp://127.0.0.1:2376", "--docker-tls-cert", "/tmp/img-authz-client.pem"], stderr=STDOUT)
+		self.assertIn("must be set together", failure.exception.output)
+
+	def test_log_lines_of_a_request_share_the_correlation_id(self):
+		self.setup_with_registries("library", "--debug")
+		self.docker_pull_is_allowed("alpine:latest")
+		request_id = self.plugin_log_lines("[REQUEST] pull docker.io/library/alpine:latest")[-1].split("[")[1].split("]")[0]
+		self.assertIn("[%s] [ALLOWED] Registry: docker.io"%request_id, self.plugin_log_lines("[ALLOWED] Registry: docker.io")[-1])
+
+	def plugin_metrics(self):
+		return urllib2.urlopen("http://127.0.0.1:9323/metrics").read().splitlines()
+
+	def test_metrics_count_decisions_with_bounded_registry_labels(self):
+		self.setup_with_registries("docker.io", "--metrics-addr 127.0.0.1:9323")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+		self.docker_pull_is_denied("other.docker.registry/alpine:latest")
+		metrics = self.plugin_metrics()
+		self.assertIn('img_authz_decisions_total{command="pull",registry="docker.io",decision="allowed"} 1', metrics)
+		self.assertIn('img_authz_decisions_total{command="pull",registry="other",decision="denied"} 2', metrics)
+		decisions = [line for line in metrics if line.startswith("img_authz_decisions_total{")]
+		self.assertEqual(len(decisions), 2)
+
+	def test_metrics_include_build_info(self):
+		self.setup_with_registries("docker.io", "--metrics-addr 127.0.0.1:9323")
+		build_info = [line for line in self.plugin_metrics() if line.startswith("img_authz_build_info{")]
+		self.assertEqual(len(build_info), 1)
+		self.assertIn('version="', build_info[0])
+		self.assertTrue(build_info[0].endswith(" 1"))
+
+	def test_metrics_observe_request_latencies_per_path(self):
+		self.setup_with_registries("docker.io", "--metrics-addr 127.0.0.1:9323 --max-layers 50 --decision-cache-ttl 1m")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_allowed("alpine:latest")
+		metrics = self.plugin_metrics()
+		self.assertIn("# TYPE img_authz_request_duration_seconds histogram", metrics)
+		self.assertIn('img_authz_request_duration_seconds_count{path="evaluated"} 1', metrics)
+		self.assertIn('img_authz_request_duration_seconds_count{path="cached"} 1', metrics)
+		self.assertIn('img_authz_request_duration_seconds_bucket{path="cached",le="+Inf"} 1', metrics)
+
+	def test_decision_summaries_count_decisions_per_registry(self):
+		call(["rm", "-f", "/tmp/img-authz-summary.jsonl"])
+		self.setup_with_registries("docker.io", "--summary-interval 2s --summary-file /tmp/img-authz-summary.jsonl")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+		call(["sleep", "3"])
+		summaries = [json.loads(line) for line in open("/tmp/img-authz-summary.jsonl").read().splitlines()]
+		registries = [registry for summary in summaries for registry in summary["registries"]]
+		self.assertIn({"registry": "docker.io", "allowed": 1, "denied": 0}, registries)
+		self.assertIn({"registry": "other", "allowed": 0, "denied": 1}, registries)
+
+	def test_decision_summaries_are_logged_without_file(self):
+		self.setup_with_registries("docker.io", "--summary-interval 2s --summary-mode accumulate")
+		self.docker_pull_is_allowed("alpine:latest")
+		call(["sleep", "3"])
+		self.assertIn("[SUMMARY] docker.io: 1 allowed, 0 denied", self.plugin_log_lines("[SUMMARY] docker.io:")[-1])
+
+	def test_logged_request_bodies_are_redacted(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--debug --log-bodies")
+		docker.from_env().containers.run("alpine:latest", "true", environment=["PASSWORD=hunter2"])
+		body = self.plugin_log_lines("[BODY]")[-1]
+		self.assertIn("PASSWORD=<redacted>", body)
+		self.assertNotIn("hunter2", body)
+
+	def test_logged_request_bodies_are_size_capped(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--debug --log-bodies")
+		self.docker_run("alpine:latest", {"description": "x" * 10000})
+		body = self.plugin_log_lines("[BODY]")[-1]
+		self.assertIn("bytes truncated", body)
+		self.assertLess(len(body), 5000)
+
+	def test_decisions_are_logged_to_syslog_with_tag(self):
+		self.setup_with_registries("docker.io", "--syslog also --syslog-facility local0 --syslog-tag img-authz-test")
+		self.docker_pull_is_allowed("alpine:latest")
+		log = check_output(["journalctl", "-t", "img-authz-test", "--no-pager", "-o", "cat"])
+		self.assertIn("[ALLOWED] Registry: docker.io", log)
+
+	def test_invalid_syslog_facility_is_rejected(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--syslog", "only", "--syslog-facility", "bogus"])
+
+	def test_startup_lists_policy_entries(self):
+		output = check_output(["./img-authz-plugin", "--dump-policy", "--registry", "docker.io", "--image", "docker.io/library/alpine"], stderr=STDOUT)
+		self.assertIn("Authorized registry: docker.io", output)
+		self.assertIn("Authorized image: docker.io/library/alpine", output)
+
+	def test_quiet_startup_logs_counts_only(self):
+		output = check_output(["./img-authz-plugin", "--dump-policy", "--quiet-startup", "--registry", "docker.io", "--image", "docker.io/library/alpine"], stderr=STDOUT)
+		self.assertNotIn("Authorized registry:", output)
+		self.assertNotIn("Authorized image:", output)
+		self.assertNotIn("Always allowed image:", output)
+		self.assertIn("No. of authorized registries:  1", output)
+		self.assertIn("No. of authorized images:  1", output)
+		self.assertIn("Plugin Version:", output)
+
+	def test_quiet_startup_logs_decisions(self):
+		self.setup_with_registries("docker.io", "--quiet-startup")
+		self.docker_pull_is_allowed("alpine:latest")
+		log = check_output(["journalctl", "-u", "img-authz-plugin", "--no-pager", "-o", "cat"])
+		self.assertIn("[ALLOWED] Registry: docker.io", log)
+
+	def plugin_status(self, token):
+		request = urllib2.Request("http://127.0.0.1:9323/status", headers={"Authorization": "Bearer %s"%token})
+		return json.load(urllib2.urlopen(request))
+
+	def test_status_reports_recorded_decisions(self):
+		self.setup_with_registries("library", "--metrics-addr 127.0.0.1:9323 --admin-token s3cr3t")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+		status = self.plugin_status("s3cr3t")
+		self.assertEqual(status["allowed"], 1)
+		self.assertEqual(status["denied"], 1)
+		self.assertEqual(status["rules"]["registries"], 1)
+		self.assertEqual([decision["image"] for decision in status["decisions"]],
+			["docker.io/library/alpine:latest", "my.docker.registry/alpine:latest"])
+		self.assertEqual([decision["allowed"] for decision in status["decisions"]], [True, False])
+
+	def test_status_requires_admin_token(self):
+		self.setup_with_registries("library", "--metrics-addr 127.0.0.1:9323 --admin-token s3cr3t")
+		with self.assertRaises(urllib2.HTTPError):
+			self.plugin_status("wrong")
+
+	def export_policy(self, token):
+		request = urllib2.Request("http://127.0.0.1:9323/policy/export", headers={"Authorization": "Bearer %s"%token})
+		return json.load(urllib2.urlopen(request))
+
+	def test_policy_export_matches_live_policy(self):
+		call(["rm", "-f", "/tmp/img-authz-export.json"])
+		self.setup_with_registries("my.docker.registry,docker.io", "--metrics-addr 127.0.0.1:9323 --admin-token s3cr3t --policy-export /tmp/img-authz-export.json")
+		exported = self.export_policy("s3cr3t")
+		with open("/tmp/img-authz-export.json") as export_file:
+			self.assertEqual(json.load(export_file), exported)
+		live = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--registry", "my.docker.registry", "--registry", "docker.io"]))
+		self.assertEqual(exported["registries"], live["registries"])
+
+	def test_policy_export_requires_admin_token(self):
+		call(["rm", "-f", "/tmp/img-authz-export.json"])
+		self.setup_with_registries("docker.io", "--metrics-addr 127.0.0.1:9323 --admin-token s3cr3t --policy-export /tmp/img-authz-export.json")
+		with self.assertRaises(urllib2.HTTPError):
+			self.export_policy("wrong")
+		self.assertEqual(call(["test", "-e", "/tmp/img-authz-export.json"]), 1)
+
+	def test_policy_is_exported_on_sigusr1(self):
+		call(["rm", "-f", "/tmp/img-authz-export.json"])
+		self.setup_with_registries("docker.io", "--policy-export /tmp/img-authz-export.json")
+		call(["systemctl", "kill", "--signal", "SIGUSR1", "img-authz-plugin"])
+		call(["sleep", "1"])
+		with open("/tmp/img-authz-export.json") as export_file:
+			self.assertEqual(json.load(export_file)["registries"], ["docker.io"])
+
+	def audit_log_records(self, path):
+		with open(path) as audit_log:
+			return [json.loads(line) for line in audit_log]
+
+	def test_audit_log_contains_all_decisions_after_shutdown(self):
+		call(["rm", "-f", "/tmp/img-authz-audit.log"])
+		self.setup_with_registries("docker.io", "--audit-log /tmp/img-authz-audit.log")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+		self.docker_pull_is_allowed("busybox:latest")
+		call(["systemctl", "stop", "img-authz-plugin"])
+		records = self.audit_log_records("/tmp/img-authz-audit.log")
+		call(["systemctl", "start", "img-authz-plugin"])
+		self.assertEqual([record["image"] for record in records],
+			["docker.io/library/alpine:latest", "my.docker.registry/alpine:latest", "docker.io/library/busybox:latest"])
+		self.assertEqual([record["allowed"] for record in records], [True, False, True])
+
+	def test_audit_log_is_appended_across_restarts(self):
+		call(["rm", "-f", "/tmp/img-authz-audit.log"])
+		self.setup_with_registries("docker.io", "--audit-log /tmp/img-authz-audit.log")
+		self.docker_pull_is_allowed("alpine:latest")
+		call(["systemctl", "restart", "img-authz-plugin"])
+		self.docker_pull_is_allowed("alpine:latest")
+		call(["systemctl", "stop", "img-authz-plugin"])
+		records = self.audit_log_records("/tmp/img-authz-audit.log")
+		call(["systemctl", "start", "img-authz-plugin"])
+		self.assertEqual(len(records), 2)
+
+	def test_decisions_are_notified_to_stdout(self):
+		self.setup_with_registries("docker.io", "--notify stdout")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+		event = json.loads(self.plugin_log_lines('"image":"my.docker.registry/alpine:latest"')[-1])
+		self.assertEqual(event["command"], "pull")
+		self.assertFalse(event["allowed"])
+		self.assertIn("not authorized", event["reason"])
+
+	def test_decisions_are_posted_to_the_webhook(self):
+		events = []
+		class WebhookHandler(BaseHTTPServer.BaseHTTPRequestHandler):
+			def do_POST(self):
+				events.append(json.loads(self.rfile.read(int(self.headers["Content-Length"]))))
+				self.send_response(204)
+				self.end_headers()
+		server = BaseHTTPServer.HTTPServer(("127.0.0.1", 9324), WebhookHandler)
+		thread = threading.Thread(target=server.handle_request)
+		thread.start()
+		self.setup_with_registries("docker.io", "--notify webhook=http://127.0.0.1:9324/decisions")
+		self.docker_pull_is_allowed("alpine:latest")
+		thread.join(10)
+		server.server_close()
+		self.assertEqual([(event["image"], event["allowed"]) for event in events], [("docker.io/library/alpine:latest", True)])
+
+	def test_invalid_notifier_is_rejected(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--notify", "pager"])
+
+	def test_decisions_are_streamed_to_http_sink(self):
+		events = []
+		class StreamHandler(BaseHTTPServer.BaseHTTPRequestHandler):
+			def do_POST(self):
+				body = self.rfile.read(int(self.headers["Content-Length"]))
+				events.extend([json.loads(line) for line in body.splitlines()])
+				self.send_response(204)
+				self.end_headers()
+		server = BaseHTTPServer.HTTPServer(("127.0.0.1", 9325), StreamHandler)
+		thread = threading.Thread(target=server.handle_request)
+		thread.start()
+		self.setup_with_registries("docker.io", "--metrics-addr 127.0.0.1:9323 --decision-stream http://127.0.0.1:9325/decisions")
+		self.docker_pull_is_allowed("alpine:latest")
+		thread.join(10)
+		server.server_close()
+		self.assertEqual([(event["image"], event["allowed"]) for event in events], [("docker.io/library/alpine:latest", True)])
+		self.assertIn('img_authz_stream_events_total{sink="http",result="published"} 1', self.plugin_metrics())
+
+	def test_failed_stream_does_not_affect_decisions(self):
+		self.setup_with_registries("docker.io", "--decision-stream nats://127.0.0.1:1/img-authz.decisions")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+
+	def test_unsupported_decision_stream_is_rejected(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--decision-stream", "kafka://broker:9092/decisions"], stderr=STDOUT)
+
+	def write_assert_file(self, content):
+		with open("/tmp/img-authz-assertions.csv", "w") as assert_file:
+			assert_file.write(content)
+		return "/tmp/img-authz-assertions.csv"
+
+	def test_matching_assert_file_passes(self):
+		path = self.write_assert_file("# Official images only\nalpine:3.19,allow\nmy.docker.registry/alpine:latest,deny\n")
+		output = check_output(["./img-authz-plugin", "--registry", "docker.io", "--assert-file", path])
+		self.assertIn("2 of 2 assertions passed", output)
+		self.assertNotIn("FAIL", output)
+
+	def test_mismatching_assert_file_fails_with_the_mismatches(self):
+		path = self.write_assert_file("alpine:3.19,deny\nmy.docker.registry/alpine:latest,deny\n")
+		with self.assertRaises(CalledProcessError) as failure:
+			check_output(["./img-authz-plugin", "--registry", "docker.io", "--assert-file", path])
+		self.assertEqual(failure.exception.returncode, 1)
+		self.assertIn("FAIL line 1: alpine:3.19: expected deny, got allow", failure.exception.output)
+		self.assertIn("1 of 2 assertions passed", failure.exception.output)
+
+	def test_invalid_assert_file_is_rejected(self):
+		path = self.write_assert_file("alpine:3.19,maybe\n")
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--registry", "docker.io", "--assert-file", path], stderr=STDOUT)
+
+	def test_recorded_requests_replay_to_the_same_decisions(self):
+		call(["rm", "-f", "/tmp/img-authz-trace.jsonl"])
+		self.setup_with_registries("docker.io", "--record /tmp/img-authz-trace.jsonl")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("my.docker.registry/alpine:latest")
+		self.docker_run_is_denied("my.docker.registry/alpine:latest")
+		call(["systemctl", "stop", "img-authz-plugin"])
+		call(["systemctl", "start", "img-authz-plugin"])
+		replayed = [json.loads(line) for line in check_output(["./img-authz-plugin", "--registry", "docker.io",
+			"--replay", "/tmp/img-authz-trace.jsonl"]).splitlines()]
+		registry_commands = [decision for decision in replayed if "/images/create" in decision["uri"] or "/containers/create" in decision["uri"]]
+		self.assertEqual([decision["allowed"] for decision in registry_commands], [True, False, False])
+		self.assertIn("cannot pull image my.docker.registry/alpine", registry_commands[1]["msg"])
+
+	def test_recorded_requests_are_redacted(self):
+		call(["rm", "-f", "/tmp/img-authz-trace.jsonl"])
+		self.setup_with_registries("docker.io", "--record /tmp/img-authz-trace.jsonl")
+		call(["docker", "create", "-e", "PASSWORD=hunter2", "alpine:latest"])
+		call(["systemctl", "stop", "img-authz-plugin"])
+		call(["systemctl", "start", "img-authz-plugin"])
+		with open("/tmp/img-authz-trace.jsonl") as trace:
+			recorded = trace.read()
+		self.assertIn("PASSWORD=<redacted>", recorded)
+		self.assertNotIn("hunter2", recorded)
+
+	def test_shutdown_logs_decision_totals(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		call(["systemctl", "stop", "img-authz-plugin"])
+		call(["systemctl", "start", "img-authz-plugin"])
+		self.assertIn("1 allowed and 0 denied registry commands", self.plugin_log_lines("[SHUTDOWN] Plugin stopped")[-1])
+
+	def test_pull_is_not_denied_when_registry_port_is_authorized(self):
+		self.setup_with_registries("my.docker.registry:5000")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("my.docker.registry:5000/app:latest"))
+
+	def test_pull_is_not_allowed_when_other_port_of_registry_host_is_authorized(self):
+		self.setup_with_registries("my.docker.registry:5000")
+		self.assertIn("docker pull denied", self.docker_pull_denial("my.docker.registry:5001/app:latest"))
+
+	def test_pull_is_not_denied_when_registry_host_matches_any_port(self):
+		self.setup_with_registries("my.docker.registry", "--any-registry-port")
+		self.assertNotIn("docker pull denied", self.docker_pull_denial("my.docker.registry:5001/app:latest"))
+
+	def image_digest_reference(self, image):
+		client = docker.from_env()
+		return client.images.get(image).attrs["RepoDigests"][0]
+
+	def test_pull_by_approved_digest_is_allowed_when_image_is_pinned(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		digest = self.image_digest_reference("alpine:latest").split("@")[1]
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine@sha256:%s --image docker.io/library/alpine@%s"%("0" * 64, digest))
+		self.docker_pull_is_allowed("alpine@%s"%digest)
+
+	def test_pull_by_other_digest_is_not_allowed_when_image_is_pinned(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine@sha256:%s"%("0" * 64))
+		self.assertIn("is not approved for the image", self.docker_pull_denial("alpine@sha256:%s"%("1" * 64)))
+
+	def platform_manifest_digest(self, image):
+		manifests = json.loads(check_output(["docker", "manifest", "inspect", image]))["manifests"]
+		return [manifest["digest"] for manifest in manifests if manifest["platform"]["os"] == "linux" and manifest["platform"]["architecture"] == "amd64"][0]
+
+	def test_pull_by_list_digest_is_allowed_when_platform_digest_is_pinned(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		list_digest = self.image_digest_reference("alpine:latest").split("@")[1]
+		platform_digest = self.platform_manifest_digest("alpine:latest")
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine@%s"%platform_digest)
+		self.assertIn("is not approved for the image", self.docker_pull_denial("alpine@%s"%list_digest))
+		self.setup_with_registries("docker.io", "--match-platform-digests --image docker.io/library/alpine@%s"%platform_digest)
+		self.docker_pull_is_allowed("alpine@%s"%list_digest)
+
+	def test_pull_by_list_digest_is_not_allowed_when_platform_digest_is_not_pinned(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		list_digest = self.image_digest_reference("alpine:latest").split("@")[1]
+		self.setup_with_registries("docker.io", "--match-platform-digests --image docker.io/library/alpine@sha256:%s"%("0" * 64))
+		self.assertIn("is not approved for the image", self.docker_pull_denial("alpine@%s"%list_digest))
+
+	def test_pull_by_tag_is_not_allowed_when_image_is_pinned(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine@sha256:%s"%("0" * 64))
+		self.assertIn("must be referenced by digest", self.docker_pull_denial("alpine:latest"))
+
+	def test_pull_of_other_image_is_not_affected_by_pinned_image(self):
+		self.setup_with_registries("docker.io", "--image docker.io/library/alpine@sha256:%s --image docker.io/library/busybox"%("0" * 64))
+		self.docker_pull_is_allowed("busybox:latest")
+
+	def test_run_by_digest_is_allowed_when_image_is_authorized(self):
+		self.setup_with_registries("library", "--image library/alpine")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_run_is_allowed(self.image_digest_reference("alpine:latest"))
+
+	def test_run_by_digest_is_not_allowed_when_digest_is_denied(self):
+		self.setup_with_registries("library")
+		self.docker_pull_is_allowed("alpine:latest")
+		digest = self.image_digest_reference("alpine:latest").split("@")[1]
+		self.setup_with_registries("library", "--deny-image library/alpine@%s"%digest)
+		self.docker_run_is_denied("alpine@%s"%digest)
+
+	def write_policy_file(self, policy):
+		with open("/tmp/img-authz-policy.json", "w") as policy_file:
+			json.dump(policy, policy_file)
+
+	def test_policy_file_settings_apply_unless_set_on_the_command_line(self):
+		self.write_policy_file({"registries": ["docker.io"], "settings": {
+			"on-error": "allow",
+			"require-explicit-tag": True,
+			"restrict-commit": "deny",
+			"rate-limit": 100,
+			"rate-limit-interval": "30s",
+			"mirror-prefix": ["mirror.gcr.io=docker.io"],
+			"require-mirror": True,
+			"any-registry-port": True}})
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--config", "/tmp/img-authz-policy.json",
+			"--restrict-commit", "allowlist"]))
+		self.assertEqual(policy["onError"], "allow")
+		self.assertEqual(policy["requireExplicitTag"], True)
+		self.assertEqual(policy["restrictCommit"], "allowlist")
+		self.assertEqual(policy["rateLimit"], 100)
+		self.assertEqual(policy["mirrorPrefixes"], ["mirror.gcr.io=docker.io"])
+		self.assertEqual(policy["requireMirror"], True)
+		self.assertEqual(policy["anyRegistryPort"], True)
+
+	def test_policy_file_with_unknown_setting_is_rejected(self):
+		self.write_policy_file({"settings": {"require-everything": True}})
+		self.assertIn("invalid setting require-everything: unknown option", self.dump_policy_file_error("/tmp/img-authz-policy.json"))
+
+	def sign_policy_file(self, signed_file):
+		call(["openssl", "ecparam", "-name", "prime256v1", "-genkey", "-noout", "-out", "/tmp/img-authz-policy.key"])
+		call(["openssl", "ec", "-in", "/tmp/img-authz-policy.key", "-pubout", "-out", "/tmp/img-authz-policy.pub"])
+		call(["openssl", "dgst", "-sha256", "-sign", "/tmp/img-authz-policy.key", "-out", "/tmp/img-authz-policy.sig", signed_file])
+
+	def dump_signed_policy(self):
+		return json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--config", "/tmp/img-authz-policy.json",
+			"--policy-pubkey", "/tmp/img-authz-policy.pub", "--policy-sig", "/tmp/img-authz-policy.sig"]))
+
+	def test_policy_file_with_valid_signature_is_loaded(self):
+		self.write_policy_file({"registries": ["my.docker.registry"]})
+		self.sign_policy_file("/tmp/img-authz-policy.json")
+		self.assertEqual(self.dump_signed_policy()["registries"], ["my.docker.registry"])
+
+	def test_policy_file_with_invalid_signature_is_not_loaded(self):
+		self.write_policy_file({"registries": ["my.docker.registry"]})
+		self.sign_policy_file("/tmp/img-authz-policy.json")
+		self.write_policy_file({"registries": ["evil.docker.registry"]})
+		with self.assertRaises(CalledProcessError):
+			self.dump_signed_policy()
+
+	def test_policy_file_with_missing_signature_is_not_loaded(self):
+		self.write_policy_file({"registries": ["my.docker.registry"]})
+		self.sign_policy_file("/tmp/img-authz-policy.json")
+		call(["rm", "-f", "/tmp/img-authz-policy.sig"])
+		with self.assertRaises(CalledProcessError):
+			self.dump_signed_policy()
+
+	def generate_provenance_key(self):
+		call(["openssl", "ecparam", "-name", "prime256v1", "-genkey", "-noout", "-out", "/tmp/img-authz-provenance.key"])
+		call(["openssl", "ec", "-in", "/tmp/img-authz-provenance.key", "-pubout", "-out", "/tmp/img-authz-provenance.pub"])
+
+	def test_pull_is_not_allowed_without_provenance_attestation(self):
+		self.generate_provenance_key()
+		self.setup_with_registries("docker.io", "--require-provenance --provenance-pubkey /tmp/img-authz-provenance.pub --trusted-builder https://github.com/slsa-framework/*")
+		self.assertIn("no acceptable provenance", self.docker_pull_denial("alpine:latest"))
+
+	def test_pull_is_allowed_without_provenance_when_not_required(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_plugin_does_not_start_when_provenance_has_no_trusted_builder(self):
+		self.generate_provenance_key()
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--require-provenance", "--provenance-pubkey", "/tmp/img-authz-provenance.pub"])
+
+	def test_unsigned_image_of_unauthorized_registry_is_not_allowed_with_signed_by(self):
+		self.generate_provenance_key()
+		self.setup_with_registries("my.docker.registry", "--signed-by release=/tmp/img-authz-provenance.pub")
+		self.assertIn("You can only use docker images from the following authorized registries", self.docker_pull_denial("alpine:latest"))
+
+	def test_image_of_authorized_registry_is_allowed_without_signature_with_signed_by(self):
+		self.generate_provenance_key()
+		self.setup_with_registries("docker.io", "--signed-by release=/tmp/img-authz-provenance.pub")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_signed_by_identities_are_in_the_policy_dump(self):
+		self.generate_provenance_key()
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--signed-by", "release=/tmp/img-authz-provenance.pub"]))
+		self.assertEqual(policy["signedBy"], ["release=/tmp/img-authz-provenance.pub"])
+
+	def test_plugin_does_not_start_with_invalid_signed_by_key(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--signed-by", "release=/tmp/img-authz-missing.pub"])
+
+	def test_plugin_does_not_start_without_provenance_key(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--require-provenance", "--trusted-builder", "https://github.com/slsa-framework/*"])
+
+	def test_pull_is_allowed_when_image_has_sbom_attestation(self):
+		self.setup_with_registries("docker.io", "--require-sbom")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_image_has_no_sbom(self):
+		self.setup_with_registries("docker.io", "--require-sbom --sbom-help-url https://example.com/sbom")
+		denial = self.docker_pull_denial("alpine:3.10")
+		self.assertIn("no published SBOM", denial)
+		self.assertIn("https://example.com/sbom", denial)
+
+	def test_pull_follows_on_error_when_sbom_service_is_unreachable(self):
+		self.setup_with_registries("docker.io", "--require-sbom --sbom-service http://127.0.0.1:1/sbom --on-error deny")
+		self.assertIn("SBOM lookup failed", self.docker_pull_denial("alpine:latest"))
+
+	def test_pull_is_allowed_when_image_has_required_label(self):
+		self.setup_with_registries("docker.io", "--require-label maintainer")
+		self.docker_pull_is_allowed("nginx:latest")
+
+	def test_pull_is_not_allowed_when_image_lacks_required_label(self):
+		self.setup_with_registries("docker.io", "--require-label maintainer --require-label team=payments")
+		self.assertIn("missing the required labels: maintainer, team=payments", self.docker_pull_denial("alpine:latest"))
+
+	def test_pull_follows_on_error_when_labels_cannot_be_fetched(self):
+		self.setup_with_registries("127.0.0.1:1", "--require-label maintainer --on-error deny")
+		self.assertIn("label lookup failed", self.docker_pull_denial("127.0.0.1:1/app:latest"))
+
+	def write_cache_manifest(self, content):
+		with open("/tmp/img-authz-cache-manifest.txt", "w") as manifest_file:
+			manifest_file.write(content)
+
+	def test_pull_is_allowed_when_image_is_in_cache_manifest(self):
+		self.write_cache_manifest("# approved images\ndocker.io/library/alpine:latest\n")
+		self.setup_with_registries(None, "--cache-manifest /tmp/img-authz-cache-manifest.txt")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_image_is_not_in_cache_manifest(self):
+		self.write_cache_manifest("docker.io/library/alpine:latest\n")
+		self.setup_with_registries("docker.io", "--cache-manifest /tmp/img-authz-cache-manifest.txt")
+		self.assertIn("is not in the cache manifest", self.docker_pull_denial("busybox:latest"))
+
+	def test_pull_follows_reloaded_cache_manifest(self):
+		self.write_cache_manifest("docker.io/library/alpine:latest\n")
+		self.setup_with_registries(None, "--cache-manifest /tmp/img-authz-cache-manifest.txt")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.write_cache_manifest("docker.io/library/busybox:latest\n")
+		call(["systemctl", "reload", "img-authz-plugin"])
+		self.docker_pull_is_denied("alpine:latest")
+		self.docker_pull_is_allowed("busybox:latest")
+
+	def setup_with_policy_backend(self, registries, images, options=""):
+		call(["redis-cli", "DEL", "img-authz:registries", "img-authz:images"])
+		for registry in registries:
+			call(["redis-cli", "SADD", "img-authz:registries", registry])
+		for image in images:
+			call(["redis-cli", "SADD", "img-authz:images", image])
+		self.setup_with_registries(None, "--policy-backend redis://127.0.0.1:6379 --policy-backend-ttl 0 " + options)
+
+	def test_pull_is_allowed_when_registry_is_in_policy_backend(self):
+		self.setup_with_policy_backend(["docker.io"], [])
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_is_not_allowed_when_registry_is_not_in_policy_backend(self):
+		self.setup_with_policy_backend(["my.docker.registry"], [])
+		self.docker_pull_is_denied("alpine:latest")
+
+	def test_pull_is_allowed_when_image_is_in_policy_backend(self):
+		self.setup_with_policy_backend(["docker.io"], ["docker.io/library/alpine"])
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_denied("busybox:latest")
+
+	def test_pull_follows_policy_backend_changes(self):
+		self.setup_with_policy_backend([], [])
+		self.docker_pull_is_denied("alpine:latest")
+		call(["redis-cli", "SADD", "img-authz:registries", "docker.io"])
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_follows_on_error_when_policy_backend_is_unreachable(self):
+		self.setup_with_registries(None, "--policy-backend redis://127.0.0.1:1 --on-error deny")
+		self.assertIn("policy backend unavailable", self.docker_pull_denial("alpine:latest"))
+		self.setup_with_registries(None, "--policy-backend redis://127.0.0.1:1 --on-error allow")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def serve_remote_policy(self, allowed, requests):
+		class RemotePolicyHandler(BaseHTTPServer.BaseHTTPRequestHandler):
+			def do_GET(self):
+				image = urlparse.parse_qs(urlparse.urlparse(self.path).query)["image"][0]
+				body = json.dumps({"allow": image in allowed, "reason": "not in the inventory"})
+				self.send_response(200)
+				self.end_headers()
+				self.wfile.write(body)
+		server = BaseHTTPServer.HTTPServer(("127.0.0.1", 9325), RemotePolicyHandler)
+		thread = threading.Thread(target=lambda: [server.handle_request() for _ in range(requests)])
+		thread.start()
+		return server, thread
+
+	def test_pull_is_decided_by_remote_policy(self):
+		server, thread = self.serve_remote_policy(["docker.io/library/busybox:latest"], 2)
+		self.setup_with_registries("my.docker.registry", "--remote-policy http://127.0.0.1:9325/decide")
+		self.docker_pull_is_allowed("busybox:latest")
+		self.assertIn("not authorized by the remote policy: not in the inventory", self.docker_pull_denial("alpine:latest"))
+		thread.join(10)
+		server.server_close()
+
+	def test_remote_policy_decisions_are_cached(self):
+		server, thread = self.serve_remote_policy(["docker.io/library/busybox:latest"], 1)
+		self.setup_with_registries("my.docker.registry", "--remote-policy http://127.0.0.1:9325/decide --remote-policy-ttl 1m")
+		self.docker_pull_is_allowed("busybox:latest")
+		thread.join(10)
+		server.server_close()
+		self.docker_pull_is_allowed("busybox:latest")
+
+	def test_pull_follows_on_error_when_remote_policy_is_unreachable(self):
+		self.setup_with_registries("my.docker.registry", "--remote-policy http://127.0.0.1:1/decide --on-error deny")
+		self.assertIn("remote policy query failed", self.docker_pull_denial("alpine:latest"))
+		self.setup_with_registries("my.docker.registry", "--remote-policy http://127.0.0.1:1/decide --on-error allow")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_dump_policy_redacts_policy_backend_password(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--policy-backend", "redis://:secret@kv.example:6379/0"]))
+		self.assertEqual(policy["policyBackend"], "redis://:xxxxx@kv.example:6379/0")
+
+	def raw_container_create(self, body):
+		return check_output(["curl", "-s", "--unix-socket", "/var/run/docker.sock", "-H", "Content-Type: application/json",
+			"-X", "POST", "--data-binary", body, "http://localhost/containers/create"])
+
+	def raw_image_create(self, query):
+		return check_output(["curl", "-s", "--unix-socket", "/var/run/docker.sock", "-X", "POST", "http://localhost/images/create?" + query])
+
+	def test_pull_of_oci_reference_with_transport_is_authorized_as_its_image(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("cannot pull image docker.io/library/alpine.", self.raw_image_create("fromImage=docker://alpine&tag=latest"))
+		self.assertIn("cannot pull image ghcr.io/team/app.", self.raw_image_create("fromImage=oci://ghcr.io/team/app&tag=1.0"))
+
+	def test_pull_of_oci_reference_with_qualifiers_is_authorized_as_its_image(self):
+		self.setup_with_registries("my.docker.registry")
+		self.assertIn("cannot pull image ghcr.io/team/app.", self.raw_image_create("fromImage=ghcr.io/team/app%3A1.0%3Fplatform%3Dlinux/amd64"))
+		self.assertIn("cannot pull image ghcr.io/team/app.", self.raw_image_create("fromImage=ghcr.io/team/app%3A1.0%23annotation"))
+
+	def test_pull_with_unparseable_url_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		self.assertIn("the request URL cannot be parsed", self.raw_image_create("fromImage=alpine%zz&tag=latest"))
+		self.assertIn("The image reference is invalid: contains the disallowed character U+000A", self.raw_image_create("fromImage=alpine%0A&tag=latest"))
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_with_unparseable_url_is_allowed_when_configured(self):
+		self.setup_with_registries("docker.io", "--on-unparseable allow")
+		self.assertNotIn("the request URL cannot be parsed", self.raw_image_create("fromImage=alpine%zz&tag=latest"))
+		self.assertIn("[ALLOWED] Unparseable request URL:", self.plugin_log_lines("Unparseable request URL:")[-1])
+
+	def test_invalid_unparseable_behavior_is_rejected(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--on-unparseable", "maybe"], stderr=STDOUT)
+
+	def test_pull_from_insecure_registry_is_not_allowed(self):
+		# The docker daemon treats the registries of 127.0.0.0/8 as insecure by default
+		self.setup_with_registries("localhost:5000,127.0.0.1:5000", "--deny-insecure-registry")
+		self.assertIn("The registry localhost:5000 is configured as insecure on the docker daemon", self.docker_pull_denial("localhost:5000/alpine:latest"))
+		self.assertIn("The registry 127.0.0.1:5000 is configured as insecure on the docker daemon", self.docker_pull_denial("127.0.0.1:5000/alpine:latest"))
+
+	def test_pull_from_secure_registry_is_allowed_when_insecure_registries_are_denied(self):
+		self.setup_with_registries("docker.io", "--deny-insecure-registry")
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_from_insecure_registry_is_allowed_by_default(self):
+		self.setup_with_registries("localhost:5000")
+		self.assertNotIn("insecure", self.docker_pull_denial("localhost:5000/alpine:latest"))
+
+	def test_pull_from_local_registry_is_not_allowed_when_denied(self):
+		self.setup_with_registries("docker.io", "--deny-local-registries")
+		self.assertIn("The registry 127.0.0.1:2375 is a local address of the docker host", self.docker_pull_denial("127.0.0.1:2375/probe:latest"))
+		self.assertIn("The registry localhost:2375 is a local address of the docker host", self.docker_pull_denial("localhost:2375/probe:latest"))
+		self.assertIn("The registry [::1]:5000 is a local address of the docker host", self.docker_pull_denial("[::1]:5000/probe:latest"))
+		self.assertIn("The registry 169.254.169.254 is a local address of the docker host", self.docker_pull_denial("169.254.169.254/latest:latest"))
+		self.assertIn("The registry [fe80::1]:5000 is a local address of the docker host", self.docker_pull_denial("[fe80::1]:5000/probe:latest"))
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_from_local_registry_is_allowed_when_exact_entry(self):
+		self.setup_with_registries("localhost:5000", "--deny-local-registries")
+		self.assertNotIn("local address", self.docker_pull_denial("localhost:5000/alpine:latest"))
+
+	def test_pull_of_all_tags_is_not_allowed_when_denied(self):
+		self.setup_with_registries("docker.io", "--deny-all-tags")
+		self.assertIn("Pulling all the tags of a repository is denied", self.raw_image_create("fromImage=alpine"))
+		self.assertIn("Pulling all the tags of a repository is denied", self.raw_image_create("fromImage=docker.io/library/alpine&tag="))
+
+	def test_pull_of_a_tag_is_allowed_when_all_tags_are_denied(self):
+		self.setup_with_registries("docker.io", "--deny-all-tags")
+		self.assertNotIn("docker pull denied", self.raw_image_create("fromImage=alpine&tag=latest"))
+		self.docker_pull_is_allowed("alpine:latest")
+
+	def test_pull_of_all_tags_is_not_denied_by_default(self):
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--registry", "docker.io"]))
+		self.assertEqual(policy["denyAllTags"], False)
+
+	def raw_distribution(self, image):
+		return check_output(["curl", "-s", "--unix-socket", "/var/run/docker.sock", "http://localhost/distribution/%s/json"%image])
+
+	def test_distribution_query_of_unauthorized_registry_is_not_allowed_when_restricted(self):
+		self.setup_with_registries("docker.io", "--restrict-distribution")
+		self.assertIn("docker image query denied: cannot query image my.docker.registry/alpine.", self.raw_distribution("my.docker.registry/alpine:latest"))
+
+	def test_distribution_query_of_authorized_image_is_allowed_when_restricted(self):
+		self.setup_with_registries("docker.io", "--restrict-distribution")
+		self.assertNotIn("docker image query denied", self.raw_distribution("alpine:latest"))
+
+	def test_distribution_query_of_denied_image_is_not_allowed_when_restricted(self):
+		self.setup_with_registries("docker.io", "--restrict-distribution --deny-image docker.io/library/busybox")
+		self.assertIn("The image is denied", self.raw_distribution("busybox:latest"))
+
+	def test_distribution_query_is_allowed_by_default(self):
+		self.setup_with_registries("docker.io")
+		self.assertNotIn("docker image query denied", self.raw_distribution("my.docker.registry/alpine:latest"))
+
+	def docker_command_denial(self, command):
+		try:
+			check_output(command, stderr=STDOUT)
+		except CalledProcessError, exception:
+			return exception.output
+		return ""
+
+	def test_container_update_is_logged_and_allowed_by_default(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		container = check_output(["docker", "run", "-d", "alpine:latest", "sleep", "60"]).strip()
+		self.assertEqual(self.docker_command_denial(["docker", "update", "--memory", "64m", "--memory-swap", "128m", container]), "")
+		self.assertIn("Memory", self.plugin_log_lines("[ALLOWED] Container update: " + container)[-1])
+		check_output(["docker", "rm", "-f", container])
+
+	def test_denied_container_mutations_are_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		container = check_output(["docker", "run", "-d", "alpine:latest", "sleep", "60"]).strip()
+		self.setup_with_registries("docker.io", "--deny-mutation update --deny-mutation privileged-exec")
+		self.assertIn("docker update denied: cannot alter container " + container, self.docker_command_denial(["docker", "update", "--memory", "64m", "--memory-swap", "128m", container]))
+		self.assertIn("Running privileged commands in containers is not allowed", self.docker_command_denial(["docker", "exec", "--privileged", container, "true"]))
+		self.assertEqual(self.docker_command_denial(["docker", "exec", container, "true"]), "")
+		check_output(["docker", "rm", "-f", container])
+
+	def test_plugin_does_not_start_with_unknown_mutation(self):
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--deny-mutation", "restart"], stderr=STDOUT)
+
+	def docker_save_denial(self, *images):
+		try:
+			check_output(["docker", "save", "-o", "/tmp/img-authz-save.tar"] + list(images), stderr=STDOUT)
+		except CalledProcessError, exception:
+			return exception.output
+		return ""
+
+	def test_save_is_allowed_by_default(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.assertEqual(self.docker_save_denial("alpine:latest"), "")
+
+	def test_save_of_confidential_image_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.setup_with_registries("docker.io", "--restrict-save docker.io/library/alpine")
+		self.assertIn("docker save denied: cannot save image docker.io/library/alpine.", self.docker_save_denial("alpine:latest"))
+		self.assertIn("is confidential and cannot be saved", self.docker_save_denial(self.image_id("alpine:latest")))
+
+	def test_save_of_several_images_is_not_allowed_when_any_is_confidential(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.docker_pull_is_allowed("busybox:latest")
+		self.setup_with_registries("docker.io", "--restrict-save docker.io/library/busybox")
+		self.assertIn("docker.io/library/busybox is confidential", self.docker_save_denial("alpine:latest", "busybox:latest"))
+		self.assertEqual(self.docker_save_denial("alpine:latest"), "")
+
+	def test_save_of_image_from_confidential_registry_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		self.docker_pull_is_allowed("alpine:latest")
+		check_output(["docker", "tag", "alpine:latest", "my.docker.registry/team/app:latest"])
+		self.setup_with_registries("docker.io", "--restrict-save my.docker.registry")
+		self.assertIn("my.docker.registry/team/app is confidential", self.docker_save_denial("alpine:latest"))
+		check_output(["docker", "rmi", "my.docker.registry/team/app:latest"])
+
+	def test_pull_for_allowed_os_is_allowed(self):
+		self.setup_with_registries("docker.io", "--allowed-os linux")
+		self.assertNotIn("is not allowed", self.raw_image_create("fromImage=alpine&tag=latest&platform=linux/amd64"))
+		self.assertNotIn("is not allowed", self.raw_image_create("fromImage=alpine&tag=latest"))
+
+	def test_pull_for_other_os_is_not_allowed(self):
+		self.setup_with_registries("docker.io", "--allowed-os linux")
+		self.assertIn("The OS windows is not allowed, only: linux", self.raw_image_create("fromImage=alpine&tag=latest&platform=windows/amd64"))
+		self.setup_with_registries("docker.io", "--allowed-os windows")
+		self.assertIn("The OS linux is not allowed, only: windows", self.raw_image_create("fromImage=alpine&tag=latest&platform=linux/amd64"))
+
+	def test_pull_and_run_of_equivalent_references_are_decided_alike(self):
+		self.setup_with_registries("docker.io", "--allowed-os linux --image docker.io/library/alpine:3")
+		for image, tag, platform in [("alpine", "3", "linux/amd64"), ("alpine", "3", "Linux/x86_64"), ("alpine", "latest", "linux/amd64"), ("alpine", "3", "windows/amd64")]:
+			pull = self.raw_image_create("fromImage=%s&tag=%s&platform=%s"%(image, tag, platform))
+			run = check_output(["curl", "-s", "--unix-socket", "/var/run/docker.sock", "-H", "Content-Type: application/json",
+				"-X", "POST", "--data-binary", json.dumps({"Image": image + ":" + tag}), "http://localhost/containers/create?platform=" + platform])
+			self.assertEqual(" denied: " in pull, " denied: " in run)
+
+	def test_run_of_reference_with_control_characters_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		denial = self.raw_container_create(json.dumps({"Image": "alpine\x1b[31m:latest"}))
+		self.assertIn("The image reference is invalid: contains the disallowed character U+001B", denial)
+		self.assertIn('"alpine\\x1b[31m:latest"', self.plugin_log_lines("[DENIED] Invalid image reference:")[-1])
+
+	def test_run_of_reference_with_bidirectional_override_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		denial = self.raw_container_create(json.dumps({"Image": u"evil.io/\u202eapp:latest"}))
+		self.assertIn("contains the disallowed character U+202E", denial)
+
+	def test_pull_of_very_long_reference_is_not_allowed(self):
+		self.setup_with_registries("docker.io")
+		self.assertIn("The image reference is invalid: longer than 1024 characters", self.raw_image_create("fromImage=" + "a" * 1100 + "&tag=latest"))
+
+	def test_pull_of_long_valid_reference_is_not_denied_as_invalid(self):
+		self.setup_with_registries("docker.io")
+		self.assertNotIn("The image reference is invalid", self.raw_image_create("fromImage=team/" + "a" * 200 + "&tag=" + "1" * 128))
+
+	def test_run_with_truncated_body_is_not_allowed(self):
+		self.setup_with_registries("my.docker.registry", "--on-error deny")
+		self.assertIn("unparseable request body", self.raw_container_create('{"Image": "alpine:latest", "Labels": {'))
+
+	def test_run_with_empty_body_is_not_allowed(self):
+		self.setup_with_registries("docker.io", "--on-error deny")
+		self.assertIn("empty request body", self.raw_container_create(""))
+
+	def test_run_without_image_is_not_allowed(self):
+		self.setup_with_registries("docker.io", "--on-error deny")
+		self.assertIn("request body without an image", self.raw_container_create('{"Cmd": ["echo", "from container"]}'))
+
+	def test_run_with_truncated_body_follows_on_error_allow(self):
+		self.setup_with_registries("my.docker.registry", "--on-error allow")
+		self.assertNotIn("unparseable request body", self.raw_container_create('{"Image": "alpine:latest", "Labels": {'))
+
+	def write_policy_dir_file(self, name, content):
+		call(["mkdir", "-p", "/tmp/img-authz-policy.d"])
+		with open("/tmp/img-authz-policy.d/%s"%name, "w") as policy_file:
+			policy_file.write(content)
+
+	def test_policy_dir_files_are_merged_in_name_order(self):
+		call(["rm", "-rf", "/tmp/img-authz-policy.d"])
+		self.write_policy_dir_file("10-team-a.json", json.dumps({"registries": ["team-a.docker.registry"], "images": ["team-a.docker.registry/app"]}))
+		self.write_policy_dir_file("20-team-b.yaml", "registries:\n  - team-b.docker.registry\ndeniedImages:\n  - '*:latest'\n")
+		self.write_policy_dir_file("30-override.yml", "override: true\nimages:\n  - team-b.docker.registry/app\n")
+		self.write_policy_dir_file("README.txt", "not a policy file")
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--registry", "docker.io",
+			"--config-dir", "/tmp/img-authz-policy.d"]))
+		self.assertEqual(policy["registries"], ["docker.io", "team-a.docker.registry", "team-b.docker.registry"])
+		self.assertEqual(policy["images"], ["team-b.docker.registry/app"])
+		self.assertEqual(policy["deniedImages"], ["*:latest"])
+
+	def test_policy_dump_lists_the_rule_sources(self):
+		call(["rm", "-rf", "/tmp/img-authz-policy.d"])
+		self.write_policy_file({"registries": ["docker.io", "my.docker.registry"]})
+		self.write_policy_dir_file("10-team-a.json", json.dumps({"images": ["team-a.docker.registry/app"]}))
+		self.write_policy_dir_file("20-override.yaml", "override: true\nimages:\n  - team-b.docker.registry/app\n")
+		policy = json.loads(check_output(["./img-authz-plugin", "--dump-policy", "--registry", "docker.io",
+			"--config", "/tmp/img-authz-policy.json", "--config-dir", "/tmp/img-authz-policy.d"]))
+		self.assertEqual(policy["sources"]["registries"]["docker.io"], ["command line", "/tmp/img-authz-policy.json"])
+		self.assertEqual(policy["sources"]["registries"]["my.docker.registry"], ["/tmp/img-authz-policy.json"])
+		self.assertEqual(policy["sources"]["images"], {"team-b.docker.registry/app": ["/tmp/img-authz-policy.d/20-override.yaml"]})
+
+	def dump_policy_file_error(self, path):
+		try:
+			check_output(["./img-authz-plugin", "--dump-policy", "--config", path], stderr=STDOUT)
+		except CalledProcessError, error:
+			return error.output
+		return ""
+
+	def test_valid_policy_file_passes_schema_validation(self):
+		self.write_policy_file({"registries": ["my.docker.registry"], "deniedHostMounts": ["/var/run/docker.sock"], "override": True})
+		self.assertEqual(self.dump_policy_file_error("/tmp/img-authz-policy.json"), "")
+
+	def test_policy_file_with_unknown_field_is_rejected(self):
+		self.write_policy_file({"registry": ["my.docker.registry"]})
+		self.assertIn('$: unknown field "registry"', self.dump_policy_file_error("/tmp/img-authz-policy.json"))
+
+	def test_policy_file_with_misspelled_field_is_rejected(self):
+		self.write_policy_file({"registries": ["my.docker.registry"], "deniedImage": ["*:latest"]})
+		self.assertIn('$: unknown field "deniedImage"', self.dump_policy_file_error("/tmp/img-authz-policy.json"))
+
+	def test_policy_file_with_string_instead_of_list_is_rejected(self):
+		self.write_policy_file({"registries": "my.docker.registry"})
+		self.assertIn("$.registries: expected array, got string", self.dump_policy_file_error("/tmp/img-authz-policy.json"))
+
+	def test_policy_file_with_wrong_entry_type_is_rejected(self):
+		self.write_policy_file({"registries": ["my.docker.registry", 5000]})
+		self.assertIn("$.registries[1]: expected string, got integer", self.dump_policy_file_error("/tmp/img-authz-policy.json"))
+
+	def test_yaml_policy_file_with_unknown_field_is_rejected(self):
+		call(["rm", "-rf", "/tmp/img-authz-policy.d"])
+		self.write_policy_dir_file("10-typo.yaml", "registries:\n  - my.docker.registry\nimage:\n  - my.docker.registry/app\n")
+		with self.assertRaises(CalledProcessError):
+			check_output(["./img-authz-plugin", "--dump-policy", "--config-dir", "/tmp/img-authz-policy.d"])
+
+	def test_pull_follows_reloaded_policy_file(self):
+		self.write_policy_file({"registries": ["library"]})
+		self.setup_with_registries(None, "--config /tmp/img-authz-policy.json")
+		self.docker_pull_is_allowed("alpine:latest")
+		self.write_policy_file({"registries": ["my.docker.registry"]})
+		call(["systemctl", "reload", "img-authz-plugin"])
+		self.docker_pull_is_denied("alpine:latest")
+
 
 # Start the tests
 suite = unittest.TestLoader().loadTestsFromTestCase(TestAuthorizationPlugin)